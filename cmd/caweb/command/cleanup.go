@@ -0,0 +1,120 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/momeni/clean-arch/pkg/adapter/proc"
+	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
+	"github.com/spf13/cobra"
+)
+
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Remove the leftovers of failed migrations",
+	Long: `Remove the leftovers of failed migrations from the database which
+is described by the config file (passed by the -c flag) and from the
+file system. A crashed migration may leave the fdwX_Y, migN, and stgN
+schema, the fpsX_Y foreign servers (and their user mappings), the
+.migrated file of the config file, and the temporary passwords files
+(such as the .pgpass.new file) behind. They are removed and listed,
+using the admin role. The --dry-run flag asks to list them without any
+removal (it is recommended to review them before the actual removal).
+The --output flag chooses between the text and json formats.
+
+The cleanup holds the same database lock and .lock file (next to the
+config file) which are held by migrations, so it fails while a
+migration is running. If the .migrated file belongs to a migration
+which has filled the database and so may be resumed by running the
+same migration command again, nothing is removed. In this
+case, either resume the migration or remove the .migrated file first.
+The temporary passwords files are only removed if both of the admin and
+normal roles can connect to the database (since they could be needed).`,
+	RunE: cleanup,
+	Args: cobra.NoArgs,
+}
+
+var (
+	cleanupDryRun bool
+	cleanupOutput string
+)
+
+func cleanup(_ *cobra.Command, _ []string) error {
+	ctx := context.Background()
+	switch cleanupOutput {
+	case "text", "json":
+	default:
+		return fmt.Errorf("unsupported output format %q", cleanupOutput)
+	}
+	if len(cfgPaths) != 1 {
+		return fmt.Errorf(
+			"expected one target config file path, got %q", cfgPaths,
+		)
+	}
+	targetCfgPath := cfgPaths[0]
+	ss, err := loadConfigFile(ctx, targetCfgPath)
+	if err != nil {
+		return fmt.Errorf("loading %q config file: %w", targetCfgPath, err)
+	}
+	cuc := migrationuc.NewCleanup(
+		ss, targetCfgPath, loadConfigFile,
+	).SetProcChecker(proc.Checker{})
+	run, verb := cuc.Cleanup, "removed"
+	if cleanupDryRun {
+		run, verb = cuc.Plan, "would remove"
+	}
+	plan, err := run(ctx)
+	if err != nil {
+		return fmt.Errorf("cleaning up leftovers: %w", err)
+	}
+	return printCleanupPlan(plan, verb)
+}
+
+// isEmptyCleanupPlan returns true if the `plan` has nothing to remove.
+func isEmptyCleanupPlan(plan *migrationuc.CleanupPlan) bool {
+	return len(plan.Schemas)+len(plan.Servers)+len(plan.Files) == 0
+}
+
+// printCleanupPlan prints the `plan` leftovers in the format which is
+// chosen by the --output flag, prefixing the text lines by the `verb`.
+func printCleanupPlan(plan *migrationuc.CleanupPlan, verb string) error {
+	switch cleanupOutput {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plan); err != nil {
+			return fmt.Errorf("encoding cleanup plan: %w", err)
+		}
+		return nil
+	case "text":
+	default:
+		return fmt.Errorf("unsupported output format %q", cleanupOutput)
+	}
+	if isEmptyCleanupPlan(plan) {
+		fmt.Println("no leftovers were found")
+		return nil
+	}
+	fmt.Printf("%s schemas: %s\n", verb, listOrNone(plan.Schemas))
+	fmt.Printf("%s servers: %s\n", verb, listOrNone(plan.Servers))
+	fmt.Printf("%s files: %s\n", verb, listOrNone(plan.Files))
+	return nil
+}
+
+func init() {
+	cleanupCmd.Flags().BoolVar(
+		&cleanupDryRun, "dry-run", false,
+		"list the leftovers without removing them",
+	)
+	cleanupCmd.Flags().StringVarP(
+		&cleanupOutput, "output", "o", "text",
+		"cleanup output format: text or json",
+	)
+	dbCmd.AddCommand(cleanupCmd)
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config"
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Configuration file management actions",
+	Long: `Configuration file management actions can be chosen by
+sub-commands. The validate action reports all problems of a config file
+(with their line numbers) without starting the web server, the show
+action prints the effective configuration settings, the migrate action
+converts a config file to another version without connecting to any
+database, the diff action compares two config files semantically, and
+the schema action prints the JSON Schema of a config file version.`,
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [CONFIG...]",
+	Short: "Validate a configuration file and report all its problems",
+	Long: `Validate a configuration file and report all its problems,
+so they may be fixed at once. The config file path may be passed as an
+argument, otherwise, the -c flag (or its default value) is used.
+Multiple paths are treated as a base config file and its overlays, so
+they are merged (with their included files) and then validated. In that
+case, the line numbers are not reported because they belong to the
+merged contents instead of any one of the files.
+The versions of the config file are loaded first, so its format can be
+known, and then each setting is decoded and checked by the same rules
+which are used when starting the web server (including the min/max
+boundaries and constraints of the mutable settings, after overriding
+them by the CAWEB_* environment variables).
+Problems are printed as FILE:LINE: PATH: MESSAGE lines and unknown keys
+are reported as warnings. The exit code is non-zero if any problem
+(beyond the warnings) was found.`,
+	RunE: validate,
+	Args: cobra.ArbitraryArgs,
+	// problems are reported already and usage is not relevant to them
+	SilenceUsage: true,
+}
+
+var showCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration settings",
+	Long: `Print the effective configuration settings which are read
+from the config file (passed by the -c flag), overridden by the CAWEB_*
+environment variables, and the mutable settings which are stored in the
+database (if it is reachable). The database passwords directory, the
+password source file and command, the encryption key file, and the
+secret settings are redacted. Settings which are taken from
+environment variables are annotated by comments.`,
+	RunE: show,
+	Args: cobra.NoArgs,
+}
+
+var (
+	migrateTo  string
+	migrateRef string
+)
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate --to <VERSION> <SRC-CONFIG> <DST-CONFIG>",
+	Short: "Migrate a configuration file to another version offline",
+	Long: `Migrate a configuration file to another version offline, i.e.,
+without connecting to any database. The source config file is loaded and
+converted upwards or downwards (one major version at a time) until its
+format matches the version which is given by the --to flag, and then
+it is written into the destination config file path (atomically).
+The target version must be the latest known minor and patch version of
+its major version.
+The database schema version and connection information, and the mutable
+settings which are stored in the database, are not changed. The source
+file comments are preserved for settings which keep their yaml keys.
+Optionally, a reference config file of the target version may be passed
+by the --reference flag. In that case, the migrated settings are merged
+with it, so settings which had no counterpart in the source version take
+their values from the reference file (while the database connection
+information and comments are taken from the reference file too).`,
+	RunE: migrateConfig,
+	Args: cobra.ExactArgs(2),
+}
+
+func migrateConfig(_ *cobra.Command, args []string) error {
+	ctx := context.Background()
+	var dstVer model.SemVer
+	if err := dstVer.UnmarshalText([]byte(migrateTo)); err != nil {
+		return fmt.Errorf("parsing --to version %q: %w", migrateTo, err)
+	}
+	mig, err := config.LoadMigrator(args[0])
+	if err != nil {
+		return fmt.Errorf("config.LoadMigrator(%q): %w", args[0], err)
+	}
+	var refSettings migrationuc.Settings
+	if migrateRef != "" {
+		refSettings, err = loadConfigFile(ctx, migrateRef)
+		if err != nil {
+			return fmt.Errorf(
+				"loading %q reference config file: %w", migrateRef, err,
+			)
+		}
+	}
+	mcuc := migrationuc.NewMigrateConfig(
+		mig, dstVer, refSettings, args[1],
+	)
+	if err := mcuc.Migrate(ctx); err != nil {
+		return fmt.Errorf("migrating config file: %w", err)
+	}
+	return nil
+}
+
+var diffMajor uint
+
+var configDiffCmd = &cobra.Command{
+	Use:   "diff <CONFIG-1> <CONFIG-2>",
+	Short: "Compare the settings of two config files semantically",
+	Long: `Compare the settings of two config files semantically, even if
+they have different versions. Both files are migrated to a common major
+version (the newer one by default, or the one which is given by the
+--major flag) with no database connection, and then their settings are
+compared one by one. Each difference is printed on one line:
+
+  + PATH: VALUE           setting is only present in CONFIG-2
+  - PATH: VALUE           setting is only present in CONFIG-1
+  ~ PATH: OLD -> NEW      setting has different values
+  ! FILE: PATH: VALUE     setting of FILE is lost by down-migration
+
+The versions settings are compared as written in the files. Settings
+which are lost are detected by migrating the downwards migrated settings
+upwards again and checking which settings could not survive the trip.`,
+	RunE: diffConfigs,
+	Args: cobra.ExactArgs(2),
+}
+
+func diffConfigs(_ *cobra.Command, args []string) error {
+	ctx := context.Background()
+	var migs [2]repo.Migrator[migrationuc.Settings]
+	for i, path := range args {
+		mig, err := config.LoadMigrator(path)
+		if err != nil {
+			return fmt.Errorf("config.LoadMigrator(%q): %w", path, err)
+		}
+		migs[i] = mig
+	}
+	dcuc := migrationuc.NewDiffConfig(
+		migs[0], migs[1], diffMajor, config.NewMigrator,
+	)
+	changes, err := dcuc.Diff(ctx)
+	if err != nil {
+		return fmt.Errorf("comparing config files: %w", err)
+	}
+	for _, c := range changes {
+		switch c.Kind {
+		case migrationuc.Added:
+			fmt.Printf("+ %s: %s\n", c.Path, c.New)
+		case migrationuc.Removed:
+			fmt.Printf("- %s: %s\n", c.Path, c.Old)
+		case migrationuc.Changed:
+			fmt.Printf("~ %s: %s -> %s\n", c.Path, c.Old, c.New)
+		case migrationuc.LostFirst, migrationuc.LostSecond:
+			path := args[0]
+			if c.Kind == migrationuc.LostSecond {
+				path = args[1]
+			}
+			fmt.Printf("! %s: %s: %s\n", path, c.Path, c.Old)
+		}
+	}
+	return nil
+}
+
+var (
+	schemaVersion string
+	schemaSamples []string
+)
+
+// defaultSchemaSamples lists the sample config files of this repository
+// which describe the settings of their versions by their comments.
+var defaultSchemaSamples = []string{
+	"configs/sample-config.yaml",
+	"configs/sample-dst-config.yaml",
+	"configs/sample-src-config.yaml",
+	"configs/sample-v2-config.yaml",
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema --version <X.Y>",
+	Short: "Print the JSON Schema of a config file version",
+	Long: `Print the JSON Schema of a config file version, so editors and
+CI pipelines may validate the config files of that version. The schema
+is generated from the yaml keys of the settings of that version, their
+types (e.g., the boundary values and constraints of mutable settings)
+and acceptable values (e.g., the database auth-method), while the
+comments of the sample config files (passed by the repeatable --sample
+flag) describe the settings. Samples of other major versions are
+ignored, so all sample files may be passed. By default, the sample
+config files of the configs directory are used if they exist.`,
+	RunE: printSchema,
+	Args: cobra.NoArgs,
+}
+
+func printSchema(cmd *cobra.Command, _ []string) error {
+	var major, minor uint
+	_, err := fmt.Sscanf(schemaVersion+"\n", "%d.%d\n", &major, &minor)
+	if err != nil {
+		return fmt.Errorf(
+			"parsing --version %q as X.Y: %w", schemaVersion, err,
+		)
+	}
+	paths := schemaSamples
+	explicit := cmd.Flags().Changed("sample")
+	if !explicit {
+		paths = defaultSchemaSamples
+	}
+	var samples [][]byte
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			samples = append(samples, data)
+		case explicit || !errors.Is(err, fs.ErrNotExist):
+			return fmt.Errorf("reading sample config file: %w", err)
+		}
+	}
+	s, err := config.Schema(major, minor, samples...)
+	if err != nil {
+		return fmt.Errorf("generating schema: %w", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return fmt.Errorf("encoding schema: %w", err)
+	}
+	return nil
+}
+
+func validate(_ *cobra.Command, args []string) error {
+	paths := cfgPaths
+	if len(args) != 0 {
+		paths = args
+	}
+	path := strings.Join(paths, "+")
+	data, files, err := config.ReadFiles(paths...)
+	if err != nil {
+		return fmt.Errorf("reading config files: %w", err)
+	}
+	failed := false
+	for _, p := range config.Validate(data) {
+		failed = failed || !p.Warning
+		if len(files) > 1 {
+			p.Line = 0 // line numbers of the merged contents
+		}
+		fmt.Printf("%s:%s\n", path, p)
+	}
+	if failed {
+		return fmt.Errorf("config file %q is not valid", path)
+	}
+	return nil
+}
+
+func show(_ *cobra.Command, _ []string) error {
+	ctx := context.Background()
+	c, ok, err := config.LoadFromDB(ctx, cfgPaths...)
+	if !ok {
+		return fmt.Errorf("config.LoadFromDB(%q): %w", cfgPaths, err)
+	}
+	if err != nil {
+		fmt.Fprintf(
+			os.Stderr,
+			"database settings are not included: %v\n", err,
+		)
+	}
+	b, err := config.Show(c)
+	if err != nil {
+		return fmt.Errorf("config.Show: %w", err)
+	}
+	fmt.Print(string(b))
+	return nil
+}
+
+func init() {
+	configCmd.AddCommand(validateCmd)
+	configCmd.AddCommand(showCmd)
+	configMigrateCmd.Flags().StringVar(
+		&migrateTo, "to", "", "target config version",
+	)
+	configMigrateCmd.Flags().StringVar(
+		&migrateRef, "reference", "",
+		"reference config file of the target version",
+	)
+	_ = configMigrateCmd.MarkFlagRequired("to")
+	configCmd.AddCommand(configMigrateCmd)
+	configDiffCmd.Flags().UintVar(
+		&diffMajor, "major", 0, "common major version for comparison",
+	)
+	configCmd.AddCommand(configDiffCmd)
+	configSchemaCmd.Flags().StringVar(
+		&schemaVersion, "version", "", "config version as X.Y",
+	)
+	configSchemaCmd.Flags().StringArrayVar(
+		&schemaSamples, "sample", nil,
+		"sample config file for descriptions (may be repeated)",
+	)
+	_ = configSchemaCmd.MarkFlagRequired("version")
+	configCmd.AddCommand(configSchemaCmd)
+	rootCmd.AddCommand(configCmd)
+}
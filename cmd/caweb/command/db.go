@@ -13,7 +13,9 @@ var dbCmd = &cobra.Command{
 	Long: `Database management actions can be chosen by sub-commands.
 For fresh installation in a development or production environment,
 the init-dev or init-prod may be used and for upgrade or downgrade
-from an existing installation, the migrate may be used.`,
+from an existing installation, the migrate may be used. The status
+may be used for examining an existing installation and the cleanup
+may be used for removing the leftovers of failed migrations.`,
 }
 
 func init() {
@@ -35,9 +35,9 @@ will not be modified and an error will be reported.`,
 
 func initProd(_ *cobra.Command, _ []string) error {
 	ctx := context.Background()
-	mig, err := config.LoadMigrator(cfgPath)
+	mig, err := config.LoadMigrator(cfgPaths...)
 	if err != nil {
-		return fmt.Errorf("config.LoadMigrator(%q): %w", cfgPath, err)
+		return fmt.Errorf("config.LoadMigrator(%q): %w", cfgPaths, err)
 	}
 	err = mig.Load(ctx)
 	if err != nil {
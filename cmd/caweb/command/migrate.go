@@ -7,9 +7,15 @@ package command
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/momeni/clean-arch/pkg/adapter/config"
+	"github.com/momeni/clean-arch/pkg/adapter/proc"
+	"github.com/momeni/clean-arch/pkg/core/repo"
 	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
 	"github.com/spf13/cobra"
 )
@@ -32,7 +38,10 @@ The configuration file format is converted similarly (based on the
 semantic version of the source and destination configuration files).
 Third (optional) argument (which is passed by the -c flag) indicates
 the path of the target configuration file. This is the path which will
-be overwritten.
+be overwritten, so it may not be repeated for overlay files. However,
+the source and destination config files may include other files and
+the migrated target file will contain their merged settings (with the
+comments of the destination config file and its included files).
 
 The semantic versions of the source and destination configuration files
 and database schema indicate that an upgrade or downgrade is asked.
@@ -48,16 +57,81 @@ major version are equal to Y' and Z' respectively, relevant tables of
 version X.Y'.Z' will be created in the cawebX schema in the destination
 database (other temporary schema may be created and dropped during the
 migration in the destination database; all accesses to the source
-database are read-only).`,
+database are read-only).
+
+The --plan flag asks for a preview of the migration. It loads the source
+and destination settings (reading the source database settings, if any)
+and prints the config versions chain, the schema versions, the foreign
+server and schema names, the load mode, the database roles, the renewed
+passwords, and if a .migrated file of an old attempt would be resumed,
+without changing any database or file. The --output flag chooses
+between the text and json formats of the plan.
+
+The --verify flag asks to verify the migrated data before committing
+the destination database. Each table is compared with its source view
+(which reads from the source database, or from its copy if the tables
+were copied by the copy loader) by the number of rows and an
+order-independent hash of the rows contents. The results are written
+into the report file (passed by the --verify-report flag, defaulting to
+the target config file path with a .verification.json suffix) and the
+migration is aborted if any table mismatches.
+
+The migration holds an advisory lock in the destination database and
+a lock file next to the target config file (with a .lock suffix), so
+concurrent migrations (and cleanups) of the same database or target
+config file fail with an error which names the holder of the lock.
+
+The --loader flag selects how the source tables are loaded into the
+destination database. The fdw loader imports them as foreign tables
+using the postgres_fdw extension, so the destination database server
+must have that extension and must be able to connect to the source
+database server. The copy loader reads them over a normal connection
+(from this process) and streams them into regular tables using the COPY
+statements, so it works in managed environments or when the database
+runs in a container which cannot reach the source server. The auto
+loader (default) tries the fdw loader and falls back to the copy loader
+if the fdw loader fails. The --fetch-size flag sets the number of rows
+which are fetched by each round trip of the fdw loader foreign tables
+(100 rows by default).
+
+By default, all tables are settled in the destination database by one
+transaction. The --batch-size flag asks to copy each table in batches
+of that many rows (in the primary key order), each batch in its own
+transaction, and the --parallel flag sets the number of tables which
+are copied concurrently (it requires the --batch-size flag). The
+progress of each table is logged after each batch. The tables are
+filled in a stgX staging schema and are moved into the cawebX schema
+by the last transaction, so they appear there atomically. A failed
+batched migration drops its committed schema, but if the process
+crashes, they are left behind and must be removed by the db cleanup
+command before trying again. Since batches read the source database in
+distinct transactions, it should not be changed during a batched
+migration (which can be checked by the --verify flag).`,
 	RunE: migrate,
 	Args: cobra.ExactArgs(2),
 }
 
-func migrate(_ *cobra.Command, args []string) error {
+var (
+	migratePlan         bool
+	migrateOutput       string
+	migrateVerify       bool
+	migrateVerifyReport string
+	migrateLoader       string
+	migrateFetchSize    int
+	migrateBatchSize    int
+	migrateParallel     int
+)
+
+func migrate(cmd *cobra.Command, args []string) error {
 	ctx := context.Background()
-	srcCfgPath := args[0]    // read-only source configs and DB
-	dstCfgPath := args[1]    // default configs and destination DB
-	targetCfgPath := cfgPath // destination path to be overwritten
+	if len(cfgPaths) != 1 {
+		return fmt.Errorf(
+			"expected one target config file path, got %q", cfgPaths,
+		)
+	}
+	srcCfgPath := args[0]        // read-only source configs and DB
+	dstCfgPath := args[1]        // default configs and destination DB
+	targetCfgPath := cfgPaths[0] // destination path to be overwritten
 	mig, err := config.LoadSrcMigrator(srcCfgPath)
 	if err != nil {
 		return fmt.Errorf(
@@ -68,9 +142,45 @@ func migrate(_ *cobra.Command, args []string) error {
 	if err != nil {
 		return fmt.Errorf("loading %q config file: %w", dstCfgPath, err)
 	}
+	lm := repo.LoadMode(migrateLoader)
+	switch lm {
+	case repo.AutoLoad, repo.FDWLoad, repo.CopyLoad:
+	default:
+		return fmt.Errorf("unsupported loader %q", migrateLoader)
+	}
+	if migrateBatchSize < 0 || migrateFetchSize < 0 {
+		return errors.New("batch and fetch sizes may not be negative")
+	}
+	if migrateParallel < 1 {
+		return fmt.Errorf(
+			"parallelism must be at least one, got %d", migrateParallel,
+		)
+	}
+	if migrateBatchSize == 0 && cmd.Flags().Changed("parallel") {
+		return errors.New("--parallel flag requires the --batch-size flag")
+	}
 	muc := migrationuc.NewMigrateDB(
 		mig, dstSettings, targetCfgPath, loadConfigFile,
+	).SetLoadMode(lm).SetFetchSize(migrateFetchSize).SetProcChecker(
+		proc.Checker{},
 	)
+	if migrateBatchSize > 0 {
+		muc.EnableBatching(migrateBatchSize, migrateParallel)
+	}
+	if migrateVerify {
+		reportPath := migrateVerifyReport
+		if reportPath == "" {
+			reportPath = targetCfgPath + ".verification.json"
+		}
+		muc.EnableVerification(reportPath)
+	}
+	if migratePlan {
+		plan, err := muc.Plan(ctx)
+		if err != nil {
+			return fmt.Errorf("planning DB migration: %w", err)
+		}
+		return printPlan(plan)
+	}
 	err = muc.Migrate(ctx)
 	if err != nil {
 		return fmt.Errorf("migrating DB: %w", err)
@@ -78,6 +188,86 @@ func migrate(_ *cobra.Command, args []string) error {
 	return nil
 }
 
+// printPlan prints the `plan` migration plan in the format which is
+// chosen by the --output flag.
+func printPlan(plan *migrationuc.MigrationPlan) error {
+	switch migrateOutput {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plan); err != nil {
+			return fmt.Errorf("encoding plan: %w", err)
+		}
+		return nil
+	case "text":
+	default:
+		return fmt.Errorf("unsupported output format %q", migrateOutput)
+	}
+	vers := make([]string, len(plan.ConfigVersions))
+	for i, v := range plan.ConfigVersions {
+		vers[i] = v.String()
+	}
+	if len(vers) != 0 {
+		fmt.Printf("config versions: %s\n", strings.Join(vers, " -> "))
+	}
+	kind := "multi-database"
+	if plan.SameDatabase {
+		kind = "uni-database"
+	}
+	fmt.Printf(
+		"schema versions: %s -> %s (%s migration)\n",
+		plan.SrcSchemaVersion, plan.DstSchemaVersion, kind,
+	)
+	if !plan.SameDatabase {
+		fmt.Printf("foreign server: %s\n", plan.ForeignServer)
+		fmt.Printf("foreign schema: %s\n", plan.ForeignSchema)
+		fmt.Printf("load mode: %s\n", plan.LoadMode)
+		if plan.FetchSize > 0 {
+			fmt.Printf("fetch size: %d rows\n", plan.FetchSize)
+		}
+		fmt.Printf(
+			"migration schemas: %s (dropped at the end)\n",
+			strings.Join(plan.MigrationSchemas, ", "),
+		)
+		if plan.StagingSchema != "" {
+			fmt.Printf(
+				"staging schema: %s (dropped at the end)\n",
+				plan.StagingSchema,
+			)
+		}
+		fmt.Printf("final schema: %s\n", plan.FinalSchema)
+		if plan.BatchSize > 0 {
+			fmt.Printf(
+				"batched settlement: %d rows per batch, %d parallel tables\n",
+				plan.BatchSize, plan.Parallelism,
+			)
+		}
+	}
+	for _, r := range plan.Roles {
+		action := "must exist"
+		if r.Create {
+			action = "created if missing"
+		}
+		fmt.Printf("role: %s (%s)\n", r.Role, action)
+	}
+	if len(plan.Passwords) != 0 {
+		roles := make([]string, len(plan.Passwords))
+		for i, r := range plan.Passwords {
+			roles[i] = string(r)
+		}
+		fmt.Printf("renewed passwords: %s\n", strings.Join(roles, ", "))
+	}
+	if plan.PersistSettings {
+		fmt.Println("mutable settings: stored in the destination database")
+	}
+	fmt.Printf(
+		"migrated file: %s (resume: %s)\n",
+		plan.MigratedFile, plan.Resume,
+	)
+	fmt.Printf("target config file: %s\n", plan.TargetConfigFile)
+	return nil
+}
+
 func loadConfigFile(
 	ctx context.Context, path string,
 ) (migrationuc.Settings, error) {
@@ -97,5 +287,38 @@ func loadConfigFile(
 }
 
 func init() {
+	migrateCmd.Flags().BoolVar(
+		&migratePlan, "plan", false,
+		"print the migration plan without migrating",
+	)
+	migrateCmd.Flags().StringVarP(
+		&migrateOutput, "output", "o", "text",
+		"plan output format: text or json",
+	)
+	migrateCmd.Flags().BoolVar(
+		&migrateVerify, "verify", false,
+		"verify the migrated data before committing the destination DB",
+	)
+	migrateCmd.Flags().StringVar(
+		&migrateVerifyReport, "verify-report", "",
+		"verification report path (default: <target>.verification.json)",
+	)
+	migrateCmd.Flags().StringVar(
+		&migrateLoader, "loader", string(repo.AutoLoad),
+		"source tables loader: auto, fdw, or copy",
+	)
+	migrateCmd.Flags().IntVar(
+		&migrateFetchSize, "fetch-size", 0,
+		"rows per round trip of the fdw loader (default: 100)",
+	)
+	migrateCmd.Flags().IntVar(
+		&migrateBatchSize, "batch-size", 0,
+		"copy each table in batches of this many rows (0: one transaction);"+
+			" a crashed batched migration requires db cleanup",
+	)
+	migrateCmd.Flags().IntVar(
+		&migrateParallel, "parallel", 1,
+		"number of tables which are copied concurrently in batches",
+	)
 	dbCmd.AddCommand(migrateCmd)
 }
@@ -11,6 +11,13 @@
 // actions for initialization of the database with the development or
 // production suitable data records and the migrate action for
 // converting from one config and database version to another version.
+// The "config" sub-command validates a config file (reporting all of
+// its problems with their line numbers), shows the effective settings,
+// migrates a config file alone (without any database connection), or
+// compares two config files semantically.
+// The -c flag may be repeated in order to pass a base config file and
+// its ordered overlay files (e.g., -c base.yaml -c prod.yaml) which are
+// deep merged, while each file may include other files too.
 //
 //	./caweb [-c /path/of/main/config.yaml]           # start web server
 //	./caweb db init-dev [-c /path/of/main/config.yaml]
@@ -19,21 +26,32 @@
 //	    /path/of/src/config.yaml
 //	    /path/of/dst/config.yaml
 //	    [-c /path/of/main/config.yaml]
+//	./caweb config validate [/path/of/config.yaml...]
+//	./caweb config show [-c /path/of/main/config.yaml]
+//	./caweb config migrate --to <version>
+//	    [--reference /path/of/reference/config.yaml]
+//	    /path/of/src/config.yaml
+//	    /path/of/dst/config.yaml
+//	./caweb config diff [--major N] /path/of/a.yaml /path/of/b.yaml
 package command
 
 import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/momeni/clean-arch/pkg/adapter/config"
 	"github.com/momeni/clean-arch/pkg/adapter/restful/gin"
 	"github.com/momeni/clean-arch/pkg/adapter/restful/gin/routes"
+	"github.com/momeni/clean-arch/pkg/core/log"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 	"github.com/spf13/cobra"
 )
 
-var cfgPath string
+// cfgPaths lists the base config file path and its overlay file paths.
+var cfgPaths []string
 
 var rootCmd = &cobra.Command{
 	Use:   "caweb",
@@ -57,22 +75,37 @@ which can manage versioned database schema and config files.`,
 
 func startWebServer(_ *cobra.Command, _ []string) error {
 	ctx := context.Background()
-	c, err := config.Load(cfgPath)
+	c, err := config.Load(cfgPaths...)
 	if err != nil {
-		return fmt.Errorf("config.Load(%q): %w", cfgPath, err)
+		return fmt.Errorf("config.Load(%q): %w", cfgPaths, err)
 	}
-	fmt.Printf("configs: %v\n", c)
+	log.Info(
+		ctx, "loaded configuration settings",
+		log.String("path", strings.Join(cfgPaths, ", ")),
+		log.String("version", c.Version().String()),
+	)
 	p, err := c.ConnectionPool(ctx, repo.NormalRole)
 	if err != nil {
 		return fmt.Errorf("creating DB pool: %w", err)
 	}
 	defer p.Close()
-	var e *gin.Engine = c.Gin.NewEngine()
+	var e *gin.Engine
+	if e, err = c.NewEngine(); err != nil {
+		return fmt.Errorf("creating Gin engine: %w", err)
+	}
 	if err = routes.Register(ctx, e, p, c); err != nil {
 		return fmt.Errorf("registering routes: %w", err)
 	}
-	if err = e.Run(); err != nil {
-		return fmt.Errorf("running Gin engine: %w", err)
+	l, err := c.Server.Listen()
+	if err != nil {
+		return fmt.Errorf("listening: %w", err)
+	}
+	log.Info(
+		ctx, "serving REST APIs",
+		log.String("address", l.Addr().String()),
+	)
+	if err = c.Server.HTTPServer(e).Serve(l); err != nil {
+		return fmt.Errorf("serving Gin engine: %w", err)
 	}
 	return nil
 }
@@ -91,24 +124,31 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(fixConfigPath)
-	rootCmd.PersistentFlags().StringVarP(
-		&cfgPath, "config", "c", "", "config file path",
+	rootCmd.PersistentFlags().StringArrayVarP(
+		&cfgPaths, "config", "c", nil,
+		"config file path (repeat it for overlay files)",
 	)
 }
 
-// fixConfigPath ensures that cfgPath is set respectively by either the
-// CLI args, the CONFIG_FILE environment variable, or its default value.
+// fixConfigPath ensures that cfgPaths is set respectively by either the
+// CLI args, the CAWEB_CONFIG_FILE (or the older CONFIG_FILE) environment
+// variable, or its default value. The environment variables may list
+// a base config file and its overlay files, separated by the OS path
+// list separator (i.e., a colon character on Unix-like systems).
 // By the way, default value is not necessarily a single path and may
 // check several paths sequentially and take the highest priority one
 // among the existing paths. For example, a user-specific path may take
 // precedence over a file in /etc which is selected over a file in /usr.
 func fixConfigPath() {
-	if cfgPath != "" {
+	if len(cfgPaths) != 0 {
 		return
 	}
-	var found bool
-	if cfgPath, found = os.LookupEnv("CONFIG_FILE"); !found {
-		// the default path should usually be in the /etc directory
-		cfgPath = "configs/sample-config.yaml"
+	for _, v := range []string{"CAWEB_CONFIG_FILE", "CONFIG_FILE"} {
+		if p, found := os.LookupEnv(v); found {
+			cfgPaths = filepath.SplitList(p)
+			return
+		}
 	}
+	// the default path should usually be in the /etc directory
+	cfgPaths = []string{"configs/sample-config.yaml"}
 }
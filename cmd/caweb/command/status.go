@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config"
+	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report the database schema and deployment status",
+	Long: `Report the database schema and deployment status without changing
+the database or the config file. The database connection information
+and the expected schema version are read from the config file and the
+database is examined using the admin role.
+
+The existing cawebN schema are listed with their versions, as detected
+from their tables and columns, and the version of the settings which are
+stored in their settings table. The leftover fdwX_Y, migN, and stgN
+schema and fpsX_Y foreign servers of failed migrations and the roles
+having the configured role name suffix are listed too. Finally, it is
+reported if the config file and the database agree on the schema
+version, i.e., if the cawebX schema (for the X major schema version of
+the config file) exists and its minor version is not older than the
+config file's one.
+The --output flag chooses between the text and json formats.`,
+	RunE: status,
+	Args: cobra.NoArgs,
+}
+
+var statusOutput string
+
+func status(_ *cobra.Command, _ []string) error {
+	ctx := context.Background()
+	mig, err := config.LoadMigrator(cfgPaths...)
+	if err != nil {
+		return fmt.Errorf("config.LoadMigrator(%q): %w", cfgPaths, err)
+	}
+	err = mig.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("mig.Load(): %w", err)
+	}
+	ss, err := mig.Settler(ctx)
+	if err != nil {
+		return fmt.Errorf("mig.Settler(): %w", err)
+	}
+	suc := migrationuc.NewStatus(ss)
+	st, err := suc.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("examining DB status: %w", err)
+	}
+	return printStatus(st)
+}
+
+// printStatus prints the `st` database status in the format which is
+// chosen by the --output flag.
+func printStatus(st *migrationuc.DBStatus) error {
+	switch statusOutput {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(st); err != nil {
+			return fmt.Errorf("encoding status: %w", err)
+		}
+		return nil
+	case "text":
+	default:
+		return fmt.Errorf("unsupported output format %q", statusOutput)
+	}
+	fmt.Printf(
+		"config version: %s (schema version: %s)\n",
+		st.ConfigVersion, st.ConfigSchemaVersion,
+	)
+	if len(st.Schemas) == 0 {
+		fmt.Println("schemas: none")
+	}
+	for _, s := range st.Schemas {
+		ver := "unknown (" + s.VersionError + ")"
+		if s.Version != nil {
+			ver = s.Version.String()
+		}
+		fmt.Printf("schema %s: version %s", s.Name, ver)
+		switch {
+		case s.SettingsVersion != nil:
+			fmt.Printf(", settings version %s", s.SettingsVersion)
+		case s.SettingsError != "":
+			fmt.Printf(", settings unknown (%s)", s.SettingsError)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("leftover schemas: %s\n", listOrNone(st.LeftoverSchemas))
+	fmt.Printf("leftover servers: %s\n", listOrNone(st.LeftoverServers))
+	roles := make([]string, len(st.Roles))
+	for i, r := range st.Roles {
+		roles[i] = string(r)
+	}
+	fmt.Printf("roles: %s\n", listOrNone(roles))
+	fmt.Printf("compatible: %t\n", st.Compatible)
+	return nil
+}
+
+// listOrNone joins the `items` by commas, or returns "none" if there is
+// no item.
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
+
+func init() {
+	statusCmd.Flags().StringVarP(
+		&statusOutput, "output", "o", "text",
+		"status output format: text or json",
+	)
+	dbCmd.AddCommand(statusCmd)
+}
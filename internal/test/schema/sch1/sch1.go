@@ -82,6 +82,37 @@ BEGIN
     ) THEN
         RAISE EXCEPTION 'cannot find the inserted record by PK';
     END IF;
+    IF NOT EXISTS (
+            SELECT 1
+            FROM information_schema.columns
+            WHERE table_schema='caweb1'
+                AND table_name='settings'
+                AND column_name='revision'
+    ) THEN
+        RAISE EXCEPTION 'settings.revision column is missing (v1.3)';
+    END IF;
+    IF NOT EXISTS (
+            SELECT 1
+            FROM information_schema.columns
+            WHERE table_schema='caweb1'
+                AND table_name='settings'
+                AND column_name='constraints'
+    ) THEN
+        RAISE EXCEPTION 'settings.constraints column is missing (v1.3)';
+    END IF;
+    IF to_regclass('caweb1.scheduled_changes') IS NULL THEN
+        RAISE EXCEPTION 'scheduled_changes table is missing (v1.3)';
+    END IF;
+    IF to_regclass('caweb1.schema_migrations') IS NULL THEN
+        RAISE EXCEPTION 'schema_migrations journal table is missing';
+    END IF;
+    IF NOT EXISTS (
+            SELECT 1
+            FROM schema_migrations
+            WHERE state='succeeded'
+    ) THEN
+        RAISE EXCEPTION 'schema_migrations has no succeeded entry';
+    END IF;
 END
 $body$;`)
 		if !a.NoError(err, "schema verification transaction failed") {
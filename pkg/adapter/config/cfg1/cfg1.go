@@ -15,14 +15,13 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/url"
-	"os"
-	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/momeni/clean-arch/pkg/adapter/config/comment"
+	"github.com/momeni/clean-arch/pkg/adapter/config/secrets"
 	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
 	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres"
@@ -82,6 +81,23 @@ type Config struct {
 	Comments *comment.Comment `yaml:"-"`
 }
 
+// Names of the supported database authentication methods, in the same
+// order as the AuthMethods values.
+const (
+	SCRAMSHA1   = "scram-sha-1"
+	SCRAMSHA256 = "scram-sha-256"
+)
+
+// AuthMethods is the settings.Domain of the database authentication
+// methods, so their acceptable values may be listed (e.g., by the JSON
+// Schema of config files) without repeating them.
+type AuthMethods struct{}
+
+// Values lists the supported database authentication methods.
+func (AuthMethods) Values() []string {
+	return []string{SCRAMSHA1, SCRAMSHA256}
+}
+
 // Database contains the database related configuration settings.
 type Database struct {
 	Host    string // domain name or IP address of the DBMS server
@@ -108,6 +124,41 @@ type Database struct {
 	// the NewSchemaRepo method, so Schema repo instances may hash
 	// passwords properly (as expected by the DBMS).
 	hasher scrami.Hasher `yaml:"-"`
+
+	// secrets finds and renews the passwords of database roles. A nil
+	// secrets asks for the .pgpass file of the PassDir directory (see
+	// the UseSecretProvider method).
+	secrets secrets.Provider `yaml:"-"`
+}
+
+// UseSecretProvider asks the `d` Database to find and renew passwords
+// of the database roles using the `p` secret provider. A nil `p` asks
+// for the default secrets.PassDir provider which keeps the passwords in
+// the .pgpass file of the `d.PassDir` directory. This method is useful
+// for the newer configuration versions which can choose among several
+// secret providers (while this version only supports the PassDir).
+func (d *Database) UseSecretProvider(p secrets.Provider) {
+	d.secrets = p
+}
+
+// SecretProvider returns the secret provider of the `d` Database, as
+// configured by the UseSecretProvider method.
+func (d Database) SecretProvider() secrets.Provider {
+	if d.secrets == nil {
+		return secrets.NewPassDir(d.PassDir)
+	}
+	return d.secrets
+}
+
+// secretKey returns the secrets.Key of the `r` role password (adding
+// the `d.RoleSuffix` to the role name).
+func (d Database) secretKey(r repo.Role) secrets.Key {
+	return secrets.Key{
+		Host:   d.Host,
+		Port:   d.Port,
+		DBName: d.Name,
+		Role:   r + d.RoleSuffix,
+	}
 }
 
 // ConnectionPool creates a database connection pool using the
@@ -160,10 +211,13 @@ func (c *Config) NewSchemaRepo() repo.Schema {
 //     major version, so it can persist the target schema version by
 //     creating tables and filling them with contents of the
 //     corresponding views.
-func (c *Config) SchemaMigrator(tx repo.Tx) (
+//
+// The `lo` argument specifies how the source tables are loaded in the
+// first step, see repo.LoadOptions.
+func (c *Config) SchemaMigrator(tx repo.Tx, lo repo.LoadOptions) (
 	repo.Migrator[repo.SchemaSettler], error,
 ) {
-	return c.Database.SchemaMigrator(tx, c.SchemaVersion())
+	return c.Database.SchemaMigrator(tx, c.SchemaVersion(), lo)
 }
 
 // SettingsPersister instantiates a repo.SettingsPersister for the
@@ -184,6 +238,16 @@ func (c *Config) SettingsPersister(tx repo.Tx) (
 	return migration.NewSettingsPersister(tx, c.SchemaVersion())
 }
 
+// SchemaSettler instantiates a repo.SchemaSettler for the database
+// schema version of the `c` Config instance, wrapping the given `tx`
+// transaction argument. Just like the SettingsPersister, it depends on
+// the schema major version alone. It is used for filling the staged
+// tables of a batched settlement in distinct transactions and then
+// moving them into the destination schema.
+func (c *Config) SchemaSettler(tx repo.Tx) (repo.SchemaSettler, error) {
+	return migration.NewSettler(tx, c.SchemaVersion())
+}
+
 // SchemaInitializer creates a repo.SchemaInitializer instance which
 // wraps the given transaction argument and can be used to initialize
 // the database with development or production suitable data. The format
@@ -221,6 +285,29 @@ func (c *Config) RenewPasswords(
 	return c.Database.RenewPasswords(ctx, change, roles...)
 }
 
+// ProbeConnectionPool creates a database connection pool using the
+// connection information which are kept in the `c` settings, without
+// accepting a temporary password (see Database.ProbeConnectionPool).
+func (c *Config) ProbeConnectionPool(
+	ctx context.Context, r repo.Role,
+) (p repo.Pool, staged bool, err error) {
+	p, staged, err = c.Database.ProbeConnectionPool(ctx, r)
+	if err != nil {
+		return nil, false, fmt.Errorf(
+			"%#v.ProbeConnectionPool: %w", c.Database, err,
+		)
+	}
+	return p, staged, nil
+}
+
+// StagedPasswordFiles returns the paths of the temporary files which
+// keep the renewed passwords of the given `roles` until they replace
+// the main passwords, whether or not they exist (see the
+// Database.StagedPasswordFiles method).
+func (c *Config) StagedPasswordFiles(roles ...repo.Role) []string {
+	return c.Database.StagedPasswordFiles(roles...)
+}
+
 // SchemaVersion returns the semantic version of the database schema
 // which its connection information are kept by this Config struct.
 // There is no direct dependency between the configuration file and
@@ -238,48 +325,87 @@ func (c *Config) SetSchemaVersion(sv model.SemVer) {
 
 // ConnectionPool creates a database connection pool using the
 // connection information which are kept in the `d` settings.
-// Initially, the .pgpass file in the d.PassDir folder is checked
-// which should conform with the pgpass format with lines like this:
+// The password of the `r` role is found by the secret provider of `d`
+// (see the SecretProvider method). By default, the .pgpass file in the
+// d.PassDir folder is checked which should conform with the pgpass
+// format with lines like this:
 //
 //	host:port:dbname:role:password
 //
 // If a database connection could be established, created pool and nil
 // error will be returned. Otherwise, passwords might have been updated
-// during a previous incomplete migration operation. So the .pgpass.new
-// file in the same d.PassDir folder is checked too. If a connection
-// could be established successfully, the .pgpass.new will be moved to
-// the .pgpass file, so the .pgpass.new file may be overwritten safely
-// by the subsequent migration operations.
+// during a previous incomplete migration operation. So the other
+// candidate passwords (e.g., the .pgpass.new file in the same d.PassDir
+// folder) are tried too. If a connection could be established using
+// such a candidate, it will be accepted (e.g., the .pgpass.new will be
+// moved to the .pgpass file), so the .pgpass.new file may be overwritten
+// safely by the subsequent migration operations.
 //
 // The `d.RoleSuffix` will be appended to the given `r` role name too.
 func (d Database) ConnectionPool(
 	ctx context.Context, r repo.Role,
 ) (repo.Pool, error) {
-	path := filepath.Join(d.PassDir, ".pgpass")
-	u, err := d.ConnectionURL(r, path)
+	p, _, err := d.connect(ctx, r, true)
 	if err != nil {
-		return nil, fmt.Errorf("using %q pass-file: %w", path, err)
-	}
-	p, err := postgres.NewPool(ctx, u)
-	if err == nil {
-		return p, nil
+		return nil, err
 	}
-	fmt.Printf("failed to connect with %q: %v\n", path, err)
-	newPath := filepath.Join(d.PassDir, ".pgpass.new")
-	fmt.Printf("now, trying to connect with %q\n", newPath)
-	u, err = d.ConnectionURL(r, newPath)
+	return p, nil
+}
+
+// ProbeConnectionPool creates a database connection pool similar to
+// the ConnectionPool method, but never accepts a candidate password
+// (e.g., the .pgpass.new file is not moved to the .pgpass file), so
+// it changes no file. The staged return value is true if the pool was
+// established using a candidate which ConnectionPool would accept.
+func (d Database) ProbeConnectionPool(
+	ctx context.Context, r repo.Role,
+) (p repo.Pool, staged bool, err error) {
+	pool, cand, err := d.connect(ctx, r, false)
 	if err != nil {
-		return nil, fmt.Errorf("using %q pass-file: %w", newPath, err)
+		return nil, false, err
 	}
-	p, err = postgres.NewPool(ctx, u)
+	return pool, cand.Accept != nil, nil
+}
+
+// connect tries the candidate passwords of the `r` role (as found by
+// the secret provider of `d`) in their priority order and returns a
+// connection pool and the first candidate which could establish a
+// connection. If `accept` is true, that candidate is accepted (if
+// required), so an interrupted renewal is finalized, see the
+// ConnectionPool method. The failed attempts are logged as warnings.
+func (d Database) connect(
+	ctx context.Context, r repo.Role, accept bool,
+) (p *postgres.Pool, cand secrets.Candidate, err error) {
+	cands, err := d.SecretProvider().Lookup(ctx, d.secretKey(r))
 	if err != nil {
-		return nil, fmt.Errorf("can use neither pass-file: %w", err)
+		return nil, cand, fmt.Errorf(
+			"looking up the %q password: %w", r, err,
+		)
 	}
-	if err = os.Rename(newPath, path); err != nil {
-		p.Close()
-		return nil, fmt.Errorf("os.Rename: %w", err)
+	for _, cand = range cands {
+		p, err = postgres.NewPool(ctx, d.passwordURL(r, cand.Password))
+		if err != nil {
+			log.Warn(
+				ctx, "failed to connect with a candidate password",
+				log.String("role", string(r)),
+				log.String("source", cand.Source),
+				log.Err("err", err),
+			)
+			continue
+		}
+		if accept && cand.Accept != nil {
+			if err = cand.Accept(); err != nil {
+				p.Close()
+				return nil, cand, fmt.Errorf(
+					"accepting %s: %w", cand.Source, err,
+				)
+			}
+		}
+		return p, cand, nil
 	}
-	return p, nil
+	return nil, secrets.Candidate{}, fmt.Errorf(
+		"can use no candidate %q password", r,
+	)
 }
 
 // ConnectionURL returns the database connection URL embedding the host,
@@ -297,35 +423,29 @@ func (d Database) ConnectionPool(
 // role could be identified, a URL and a nil error will be returned.
 // Otherwise, returned string will be empty and error will describe the
 // wrapped error condition.
+//
+// The `path` file is read regardless of the secret provider of `d`, so
+// the SecretProvider method should be preferred for finding passwords.
 func (d Database) ConnectionURL(
 	r repo.Role, path string,
 ) (string, error) {
-	passLines, err := os.ReadFile(path)
+	pass, err := secrets.ReadPassFile(path, d.secretKey(r))
 	if err != nil {
-		return "", fmt.Errorf("reading pass-file: %w", err)
-	}
-	r = r + d.RoleSuffix
-	prfx := fmt.Sprintf("%s:%d:%s:%s:", d.Host, d.Port, d.Name, r)
-	var pass string
-	for _, line := range strings.Split(string(passLines), "\n") {
-		if line == "" || line[0] == '#' {
-			continue
-		}
-		if strings.HasPrefix(line, prfx) {
-			pass = line[len(prfx):]
-			break
-		}
-	}
-	if pass == "" {
-		return "", fmt.Errorf("no matching password line")
+		return "", err
 	}
+	return d.passwordURL(r, pass), nil
+}
+
+// passwordURL returns the database connection URL of the `r` role
+// (adding the `d.RoleSuffix` to its name) with the `pass` password.
+func (d Database) passwordURL(r repo.Role, pass string) string {
 	u := url.URL{
 		Scheme: "postgresql",
-		User:   url.UserPassword(string(r), pass),
+		User:   url.UserPassword(string(r+d.RoleSuffix), pass),
 		Host:   fmt.Sprintf("%s:%d", d.Host, d.Port),
 		Path:   d.Name,
 	}
-	return u.String(), nil
+	return u.String()
 }
 
 // ConnectionInfo returns the host, port, and database name of the
@@ -371,34 +491,70 @@ func (d Database) NewSchemaRepo() repo.Schema {
 //     major version, so it can persist the target schema version by
 //     creating tables and filling them with contents of the
 //     corresponding views.
-func (d Database) SchemaMigrator(tx repo.Tx, srcDBVer model.SemVer) (
-	repo.Migrator[repo.SchemaSettler], error,
-) {
-	path := filepath.Join(d.PassDir, ".pgpass")
-	u, err := d.ConnectionURL(repo.NormalRole, path)
+//
+// The `lo` argument specifies how the source tables are loaded in the
+// first step, see repo.LoadOptions.
+//
+// The candidate passwords of the normal role are tried similar to the
+// ConnectionPool method, so the first password which can establish a
+// connection to the source database is used for loading its tables.
+func (d Database) SchemaMigrator(
+	tx repo.Tx, srcDBVer model.SemVer, lo repo.LoadOptions,
+) (repo.Migrator[repo.SchemaSettler], error) {
+	r := repo.NormalRole
+	p, cand, err := d.connect(context.Background(), r, true)
 	if err != nil {
-		return nil, fmt.Errorf("using %q pass-file: %w", path, err)
+		return nil, err
+	}
+	p.Close()
+	url := d.passwordURL(r, cand.Password)
+	return migration.New(tx, srcDBVer, url, lo)
+}
+
+// StagedPasswordFiles returns the paths of the temporary files which
+// keep the renewed passwords of the given `roles` until they replace
+// the main passwords (e.g., the .pgpass.new file in the `d.PassDir`
+// directory), whether or not they exist. If the secret provider of `d`
+// keeps no such files (e.g., it reads the passwords from environment
+// variables), nil is returned.
+//
+// The `d.RoleSuffix` will be appended to the given role names too.
+func (d Database) StagedPasswordFiles(roles ...repo.Role) []string {
+	stgr, ok := d.SecretProvider().(secrets.Stager)
+	if !ok {
+		return nil
+	}
+	keys := make([]secrets.Key, len(roles))
+	for i, r := range roles {
+		keys[i] = d.secretKey(r)
 	}
-	return migration.New(tx, srcDBVer, u)
+	return stgr.StagedFiles(keys...)
 }
 
 // RenewPasswords generates new secure passwords for the given roles
-// and after recording them in a temporary file (i.e., .pgpass.new file
-// in the `d.PassDir` directory), will use the `change` function in
-// order to update the passwords of those `roles` in the database too.
+// and after recording them temporarily by the secret provider of `d`
+// (e.g., in the .pgpass.new file in the `d.PassDir` directory), will
+// use the `change` function in order to update the passwords of those
+// `roles` in the database too.
 // The `change` function argument should perform the update operation
 // in a transaction which may or may not be committed when the
 // RenewPasswords function returns. In case of a successful commitment,
-// the temporary passwords file should be moved over the main passwords
-// file (i.e., .pgpass file in the `d.PassDir` directory). Keeping the
-// .pgpass file up-to-date, makes it possible to use ConnectionPool
-// method again (both if the passwords are or are not updated
-// successfully). This final file movement can be performed using the
-// returned finalizer function.
+// the temporary passwords should replace the main passwords (e.g., by
+// moving the .pgpass.new file over the .pgpass file in the `d.PassDir`
+// directory). Keeping the passwords up-to-date, makes it possible to
+// use ConnectionPool method again (both if the passwords are or are not
+// updated successfully). This final replacement can be performed using
+// the returned finalizer function.
+//
+// If the secret provider is read-only (e.g., it reads the passwords
+// from environment variables), no password is generated. Instead, the
+// current passwords of `roles` are looked up and are passed to the
+// `change` function, so roles of a new database take the provided
+// passwords, and the returned finalizer does nothing.
 //
 // The `d.RoleSuffix` will be appended to the given role names too.
 // The `change` function must add the same suffix to `roles` roles names
-// in order to remain consistent with the in-file recorded information.
+// in order to remain consistent with the recorded information.
 func (d Database) RenewPasswords(
 	ctx context.Context,
 	change func(
@@ -410,25 +566,35 @@ func (d Database) RenewPasswords(
 	b := make([]byte, 16) // 128 bits
 	enc := base64.RawStdEncoding
 	p := make([]byte, enc.EncodedLen(len(b))) // for each password
-	prfx := fmt.Sprintf("%s:%d:%s", d.Host, d.Port, d.Name)
-	lines := make([]string, len(passwords))
+	creds := make([]secrets.Credential, len(roles))
 	for i, r := range roles {
 		if _, err = rand.Read(b); err != nil {
 			return nil, fmt.Errorf("rand.Read for i=%d: %w", i, err)
 		}
 		enc.Encode(p, b)
 		passwords[i] = string(p)
-		r = r + d.RoleSuffix
-		lines[i] = fmt.Sprintf("%s:%s:%s\n", prfx, r, passwords[i])
-	}
-	orgPath := filepath.Join(d.PassDir, ".pgpass")
-	newPath := filepath.Join(d.PassDir, ".pgpass.new")
-	finalizer = func() error {
-		return os.Rename(newPath, orgPath)
+		creds[i] = secrets.Credential{
+			Key: d.secretKey(r), Password: passwords[i],
+		}
 	}
-	err = os.WriteFile(newPath, []byte(strings.Join(lines, "")), 0o600)
-	if err != nil {
-		return nil, fmt.Errorf("writing %q file: %w", newPath, err)
+	sp := d.SecretProvider()
+	finalizer, err = sp.Renew(ctx, creds)
+	switch {
+	case errors.Is(err, secrets.ErrReadOnly):
+		for i, r := range roles {
+			cands, err := sp.Lookup(ctx, creds[i].Key)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"looking up the %q password: %w", r, err,
+				)
+			}
+			passwords[i] = cands[0].Password
+		}
+		finalizer = func() error {
+			return nil
+		}
+	case err != nil:
+		return nil, fmt.Errorf("renewing passwords: %w", err)
 	}
 	if err = change(ctx, roles, passwords); err != nil {
 		return nil, fmt.Errorf("passwords change callback: %w", err)
@@ -443,12 +609,12 @@ func (d Database) RenewPasswords(
 // instead of a non-reference receiver (in contrast to other methods).
 func (d *Database) ValidateAndNormalize() error {
 	switch am := d.AuthMethod; am {
-	case "scram-sha-1":
+	case SCRAMSHA1:
 		d.hasher = scram.SHA1()
 	case "":
-		d.AuthMethod = "scram-sha-256"
+		d.AuthMethod = SCRAMSHA256
 		fallthrough
-	case "scram-sha-256":
+	case SCRAMSHA256:
 		d.hasher = scram.SHA256()
 	default:
 		return fmt.Errorf(
@@ -473,6 +639,12 @@ type Gin struct {
 // NewEngine instantiates a new gin-gonic engine instance based on
 // the `g` settings.
 func (g Gin) NewEngine() *gin.Engine {
+	return gin.New(g.Middlewares()...)
+}
+
+// Middlewares lists the gin-gonic middlewares which are enabled by the
+// `g` settings, in their registration order.
+func (g Gin) Middlewares() []gin.HandlerFunc {
 	middlewares := make([]gin.HandlerFunc, 0, 2)
 	if *g.Logger {
 		middlewares = append(middlewares, gin.Logger())
@@ -480,7 +652,7 @@ func (g Gin) NewEngine() *gin.Engine {
 	if *g.Recovery {
 		middlewares = append(middlewares, gin.Recovery())
 	}
-	return gin.New(middlewares...)
+	return middlewares
 }
 
 // Usecases contains the configuration settings for all use cases.
@@ -556,7 +728,7 @@ func Load(data []byte) (*Config, error) {
 	if err := c.ValidateAndNormalize(); err != nil {
 		return nil, fmt.Errorf("validating configs: %w", err)
 	}
-	cmnts, err := comment.LoadFrom(n.Content[0])
+	cmnts, err := comment.LoadFrom(n)
 	if err != nil {
 		return nil, fmt.Errorf("parsing comments: %w", err)
 	}
@@ -587,6 +759,24 @@ func Load(data []byte) (*Config, error) {
 // return value). Any errors will be returned as the last return value.
 func LoadFromDB(ctx context.Context, data []byte) (
 	*Config, bool, error,
+) {
+	return loadFromDB(ctx, data, false)
+}
+
+// ProbeFromDB is similar to the LoadFromDB function, but it does not
+// accept a temporary password while connecting to the database (see
+// the settings.ProbeFromDB function), so it changes no file. It may be
+// used for loading the source settings of a migration plan.
+func ProbeFromDB(ctx context.Context, data []byte) (
+	*Config, bool, error,
+) {
+	return loadFromDB(ctx, data, true)
+}
+
+// loadFromDB implements the LoadFromDB and ProbeFromDB functions.
+// If `probe` is true, a temporary password is not accepted.
+func loadFromDB(ctx context.Context, data []byte, probe bool) (
+	*Config, bool, error,
 ) {
 	c := &Config{}
 	if err := yaml.Unmarshal(data, c); err != nil {
@@ -600,9 +790,16 @@ func LoadFromDB(ctx context.Context, data []byte) (
 	if err := c.Database.ValidateAndNormalize(); err != nil {
 		return nil, false, fmt.Errorf("validating DB settings: %w", err)
 	}
-	dbErr := settings.LoadFromDB(ctx, c)
+	// cfg1 has no policies section, so the former clamping behavior
+	// is kept for the database settings
+	var dbErr error
+	if probe {
+		dbErr = settings.ProbeFromDB(ctx, c, settings.Clamp)
+	} else {
+		dbErr = settings.LoadFromDB(ctx, c, settings.Clamp)
+	}
 	if dbErr != nil {
-		dbErr = fmt.Errorf("settings.LoadFromDB: %w", dbErr)
+		dbErr = fmt.Errorf("loading settings from DB: %w", dbErr)
 	}
 	err := c.ValidateAndNormalize()
 	switch {
@@ -755,11 +952,13 @@ func (c *Config) Dereference() *Config {
 
 // Clone creates a new instance of Config and initializes its fields
 // based on the `c` fields. Pointers are renewed too, so changes in
-// the returned Config instance and `c` stay independent.
+// the returned Config instance and `c` stay independent. The Comments
+// are shared because they are never modified after being loaded.
 func (c *Config) Clone() *Config {
 	cc := &Config{
 		Database: c.Database,
 		Vers:     c.Vers,
+		Comments: c.Comments,
 	}
 	settings.OverwriteUnconditionally(&cc.Gin.Logger, c.Gin.Logger)
 	settings.OverwriteUnconditionally(&cc.Gin.Recovery, c.Gin.Recovery)
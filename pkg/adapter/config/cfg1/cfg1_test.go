@@ -25,6 +25,10 @@ import (
 // some runtime error).
 var _ settings.Config[*cfg1.Config, cfg1.Serializable] = (*cfg1.Config)(nil)
 
+// Similarly, the out of range errors must implement the BoundsError
+// interface, so the bounds policies can be enforced uniformly.
+var _ settings.BoundsError = (*cfg1.OutOfBoundsSettingsError)(nil)
+
 func ExampleMarshalYAML() {
 	d, l, r := settings.Duration(time.Hour), true, true
 	minb := settings.Duration(time.Second)
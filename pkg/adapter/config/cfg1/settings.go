@@ -175,6 +175,28 @@ func (e *OutOfBoundsSettingsError) Error() string {
 func (e *OutOfBoundsSettingsError) IsBoundsError() {
 }
 
+// Violations lists the range violations of `e` with their paths in the
+// Serializable struct, implementing the settings.BoundsError interface.
+func (e *OutOfBoundsSettingsError) Violations() []settings.Violation {
+	var vs []settings.Violation
+	if oore := e.Cars.OldParkingDelay; oore != nil {
+		vs = append(vs, settings.Violation{
+			Field: "cars.old_parking_delay", Err: oore,
+		})
+	}
+	return vs
+}
+
+// Restore reverts the adjustment of all out of range settings of `e`,
+// implementing the settings.BoundsError interface. Since there is no
+// declared constraint in this version, it never fails.
+func (e *OutOfBoundsSettingsError) Restore() error {
+	if oore := e.Cars.OldParkingDelay; oore != nil {
+		oore.Restore()
+	}
+	return nil
+}
+
 // Mutate updates this Config instance using the given Serializable
 // instance which provides the mutable settings values.
 // The given Serializable instance may contain mutable & invisible
@@ -219,7 +241,9 @@ func (c *Config) Mutate(s Serializable) error {
 // Serializable creates and returns an instance of *Serializable
 // in order to report the mutable settings, based on this Config
 // instance. The Immutable pointer will be nil in the returned object.
-func (c *Config) Serializable() *Serializable {
+// The returned error is always nil, since this version has no secret
+// settings.
+func (c *Config) Serializable() (*Serializable, error) {
 	s := &Serializable{
 		Version: c.Version(),
 		Settings: Settings{
@@ -232,7 +256,7 @@ func (c *Config) Serializable() *Serializable {
 		&s.Settings.Visible.Cars.OldParkingDelay,
 		c.Usecases.Cars.OldParkingDelay,
 	)
-	return s
+	return s, nil
 }
 
 // Visible creates and fills an instance of Visible struct with the
@@ -294,3 +318,24 @@ func (c *Config) Bounds() (minb, maxb *Serializable) {
 	)
 	return minb, maxb
 }
+
+// components is the registry of independently configured components
+// of the cfg1.Config struct. All mutable settings of this version are
+// owned by the model.AppComponent component which is serialized using
+// the Serializable struct. Managed use cases which need to keep their
+// settings in a separate row (with their own format version) should
+// register their component here.
+var components = []settings.Component[*Config]{
+	settings.NewComponent(
+		model.AppComponent,
+		(*Config).Serializable, (*Config).Bounds, (*Config).Mutate,
+	),
+}
+
+// Components returns the registry of components which keep their
+// mutable settings in distinct rows of the settings table. The returned
+// registry only depends on the Config type, hence, this method can be
+// called with a nil instance too.
+func (c *Config) Components() []settings.Component[*Config] {
+	return components
+}
@@ -13,6 +13,7 @@ package cfg2
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -21,7 +22,6 @@ import (
 	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
 	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration"
-	"github.com/momeni/clean-arch/pkg/core/log"
 	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 	"github.com/momeni/clean-arch/pkg/core/usecase/appuc"
@@ -31,9 +31,15 @@ import (
 
 // These constants define the major, minor, and patch version of the
 // configuration settings which are supported by the Config struct.
+// The v2.2 format adds the encryption and bounds-policies sections,
+// the api-key-of-old-parking-method setting, and the constraints of
+// the mutable settings to the v2.1 format. Since they are optional,
+// v2.1 files are loaded as is (and are written as v2.2 files by the
+// MergeConfig method), while older binaries reject v2.2 files instead
+// of ignoring those settings silently.
 const (
 	Major = 2
-	Minor = 1
+	Minor = 2
 	Patch = 0
 )
 
@@ -54,6 +60,15 @@ type Config struct {
 	Gin      cfg1.Gin      // Gin-Gonic instantiation settings
 	Usecases Usecases      // Supported use cases configuration settings
 
+	// Encryption contains the key file path which is used for sealing
+	// the secret settings before storing them in the database.
+	Encryption Encryption
+
+	// Policies specifies how the out of range settings should be
+	// treated, separately for settings which are written by the REST
+	// APIs, loaded from the database, or migrated from another version.
+	Policies settings.Policies `yaml:"bounds-policies"`
+
 	// Vers contains the configuration file and database schema version
 	// strings corresponding to this Config instance and its Database
 	// target.
@@ -123,10 +138,13 @@ func (c *Config) NewSchemaRepo() repo.Schema {
 //     major version, so it can persist the target schema version by
 //     creating tables and filling them with contents of the
 //     corresponding views.
-func (c *Config) SchemaMigrator(tx repo.Tx) (
+//
+// The `lo` argument specifies how the source tables are loaded in the
+// first step, see repo.LoadOptions.
+func (c *Config) SchemaMigrator(tx repo.Tx, lo repo.LoadOptions) (
 	repo.Migrator[repo.SchemaSettler], error,
 ) {
-	return c.Database.SchemaMigrator(tx, c.SchemaVersion())
+	return c.Database.SchemaMigrator(tx, c.SchemaVersion(), lo)
 }
 
 // SettingsPersister instantiates a repo.SettingsPersister for the
@@ -147,6 +165,16 @@ func (c *Config) SettingsPersister(tx repo.Tx) (
 	return migration.NewSettingsPersister(tx, c.SchemaVersion())
 }
 
+// SchemaSettler instantiates a repo.SchemaSettler for the database
+// schema version of the `c` Config instance, wrapping the given `tx`
+// transaction argument. Just like the SettingsPersister, it depends on
+// the schema major version alone. It is used for filling the staged
+// tables of a batched settlement in distinct transactions and then
+// moving them into the destination schema.
+func (c *Config) SchemaSettler(tx repo.Tx) (repo.SchemaSettler, error) {
+	return migration.NewSettler(tx, c.SchemaVersion())
+}
+
 // SchemaInitializer creates a repo.SchemaInitializer instance which
 // wraps the given transaction argument and can be used to initialize
 // the database with development or production suitable data. The format
@@ -184,6 +212,29 @@ func (c *Config) RenewPasswords(
 	return c.Database.RenewPasswords(ctx, change, roles...)
 }
 
+// ProbeConnectionPool creates a database connection pool using the
+// connection information which are kept in the `c` settings, without
+// accepting a temporary password (see Database.ProbeConnectionPool).
+func (c *Config) ProbeConnectionPool(
+	ctx context.Context, r repo.Role,
+) (p repo.Pool, staged bool, err error) {
+	p, staged, err = c.Database.ProbeConnectionPool(ctx, r)
+	if err != nil {
+		return nil, false, fmt.Errorf(
+			"%#v.ProbeConnectionPool: %w", c.Database, err,
+		)
+	}
+	return p, staged, nil
+}
+
+// StagedPasswordFiles returns the paths of the temporary files which
+// keep the renewed passwords of the given `roles` until they replace
+// the main passwords, whether or not they exist (see the
+// Database.StagedPasswordFiles method).
+func (c *Config) StagedPasswordFiles(roles ...repo.Role) []string {
+	return c.Database.StagedPasswordFiles(roles...)
+}
+
 // SchemaVersion returns the semantic version of the database schema
 // which its connection information are kept by this Config struct.
 // There is no direct dependency between the configuration file and
@@ -247,6 +298,24 @@ type Cars struct {
 	// for the DelayOfOPM setting.
 	// A missing value indicates that there is no upper bound.
 	MaxDelayOfOPM *settings.Duration `yaml:"delay-of-old-parking-method-maximum"`
+	// DelayOfOPMConstraints declares further restrictions for the
+	// DelayOfOPM setting, such as its acceptable values or a step.
+	// A missing value indicates that there is no such restriction.
+	DelayOfOPMConstraints *settings.Constraints[settings.Duration] `yaml:"delay-of-old-parking-method-constraints"`
+	// APIKeyOfOPM is the secret API key of the old parking method.
+	// It is a write-only setting, so it is never reported to
+	// end-users and is encrypted by the Encryption key before being
+	// stored in the database. The cars use case does not present it
+	// yet (since the old parking method is simulated by a delay), so
+	// it is only validated, persisted, and migrated.
+	// A missing value indicates that there is no API key.
+	APIKeyOfOPM *settings.Secret `yaml:"api-key-of-old-parking-method"`
+	// APIKeyOfOPMConstraints declares the restrictions of the
+	// APIKeyOfOPM setting, such as its pattern or length. Since the
+	// constraints are not secret themselves, the enum constraint is
+	// not allowed for this setting.
+	// A missing value indicates that there is no such restriction.
+	APIKeyOfOPMConstraints *settings.Constraints[settings.Secret] `yaml:"api-key-of-old-parking-method-constraints"`
 }
 
 // NewUseCase instantiates a new cars use case based on the settings
@@ -270,36 +339,49 @@ func (c Cars) NewUseCase(
 // the major version which is reported by data settings must match
 // with number 2 which is the major version of this config package).
 //
-// If some settings should be overridden by environment variables,
-// this method is the proper place for that replacement. However, if
-// settings should be overridden by some information from the database,
-// they must not be replaced here because the Load method provides
-// those settings which are fixed by each execution (while the database
-// contents may change continually and their loading must be performed
-// by a separate method, such as LoadFromDB).
+// Settings are not overridden by environment variables because this
+// version is only loaded by the migrators (see the cfg3.LoadWithEnv
+// function). Also, if settings should be overridden by some information
+// from the database, they must not be replaced here because the Load
+// method provides those settings which are fixed by each execution
+// (while the database contents may change continually and their loading
+// must be performed by a separate method, such as LoadFromDB).
 func Load(data []byte) (*Config, error) {
+	c, n, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ValidateAndNormalize(); err != nil {
+		return nil, fmt.Errorf("validating configs: %w", err)
+	}
+	cmnts, err := comment.LoadFrom(n)
+	if err != nil {
+		return nil, fmt.Errorf("parsing comments: %w", err)
+	}
+	c.Comments = cmnts
+	return c, nil
+}
+
+// parse unmarshals the data byte slice as a yaml document node and
+// decodes it as a Config instance. The yaml document node is returned
+// too, so its comments may be parsed. Settings are not overridden by
+// the environment variables because this version is only loaded by the
+// migrators which must read the configuration file contents as-is.
+func parse(data []byte) (*Config, *yaml.Node, error) {
 	n := &yaml.Node{}
 	if err := yaml.Unmarshal(data, n); err != nil {
-		return nil, fmt.Errorf("unmarshalling yaml: %w", err)
+		return nil, nil, fmt.Errorf("unmarshalling yaml: %w", err)
 	}
 	if l := len(n.Content); l != 1 {
-		return nil, fmt.Errorf(
+		return nil, nil, fmt.Errorf(
 			"found %d children nodes, instead of 1 mapping child", l,
 		)
 	}
 	c := &Config{}
 	if err := n.Decode(c); err != nil {
-		return nil, fmt.Errorf("decoding yaml node: %w", err)
+		return nil, nil, fmt.Errorf("decoding yaml node: %w", err)
 	}
-	if err := c.ValidateAndNormalize(); err != nil {
-		return nil, fmt.Errorf("validating configs: %w", err)
-	}
-	cmnts, err := comment.LoadFrom(n.Content[0])
-	if err != nil {
-		return nil, fmt.Errorf("parsing comments: %w", err)
-	}
-	c.Comments = cmnts
-	return c, nil
+	return c, n, nil
 }
 
 // LoadFromDB parses the given data byte slice and loads a Config
@@ -326,9 +408,27 @@ func Load(data []byte) (*Config, error) {
 func LoadFromDB(ctx context.Context, data []byte) (
 	*Config, bool, error,
 ) {
-	c := &Config{}
-	if err := yaml.Unmarshal(data, c); err != nil {
-		return nil, false, fmt.Errorf("unmarshalling yaml: %w", err)
+	return loadFromDB(ctx, data, false)
+}
+
+// ProbeFromDB is similar to the LoadFromDB function, but it does not
+// accept a temporary password while connecting to the database (see
+// the settings.ProbeFromDB function), so it changes no file. It may be
+// used for loading the source settings of a migration plan.
+func ProbeFromDB(ctx context.Context, data []byte) (
+	*Config, bool, error,
+) {
+	return loadFromDB(ctx, data, true)
+}
+
+// loadFromDB implements the LoadFromDB and ProbeFromDB functions.
+// If `probe` is true, a temporary password is not accepted.
+func loadFromDB(ctx context.Context, data []byte, probe bool) (
+	*Config, bool, error,
+) {
+	c, _, err := parse(data)
+	if err != nil {
+		return nil, false, err
 	}
 	if err := c.Vers.Validate(Major, Minor); err != nil {
 		return nil, false, fmt.Errorf(
@@ -338,11 +438,24 @@ func LoadFromDB(ctx context.Context, data []byte) (
 	if err := c.Database.ValidateAndNormalize(); err != nil {
 		return nil, false, fmt.Errorf("validating DB settings: %w", err)
 	}
-	dbErr := settings.LoadFromDB(ctx, c)
+	if err := c.Encryption.ValidateAndNormalize(); err != nil {
+		return nil, false, fmt.Errorf(
+			"validating encryption settings: %w", err,
+		)
+	}
+	if err := c.Policies.ValidateAndNormalize(); err != nil {
+		return nil, false, fmt.Errorf("validating policies: %w", err)
+	}
+	var dbErr error
+	if probe {
+		dbErr = settings.ProbeFromDB(ctx, c, c.Policies.DB)
+	} else {
+		dbErr = settings.LoadFromDB(ctx, c, c.Policies.DB)
+	}
 	if dbErr != nil {
-		dbErr = fmt.Errorf("settings.LoadFromDB: %w", dbErr)
+		dbErr = fmt.Errorf("loading settings from DB: %w", dbErr)
 	}
-	err := c.ValidateAndNormalize()
+	err = c.ValidateAndNormalize()
 	switch {
 	case err != nil && dbErr != nil:
 		return nil, false, fmt.Errorf(
@@ -364,7 +477,8 @@ func LoadFromDB(ctx context.Context, data []byte) (
 func (c *Config) ValidateAndNormalize() error {
 	if err := c.Vers.Validate(Major, Minor); err != nil {
 		return fmt.Errorf(
-			"expecting version v%d.%d: %w", Major, Minor, err,
+			"expecting version v%d.%d: %w", Major, Minor,
+			&settings.PathError{Path: "versions.config", Err: err},
 		)
 	}
 	settings.Nil2Zero(&c.Gin.Logger)
@@ -372,7 +486,44 @@ func (c *Config) ValidateAndNormalize() error {
 	// No need to check for c.Usecases.Cars.DelayOfOPM == nil
 	// because it has no default in adapters layer.
 	if err := c.Database.ValidateAndNormalize(); err != nil {
-		return fmt.Errorf("validating database settings: %w", err)
+		return fmt.Errorf(
+			"validating database settings: %w",
+			&settings.PathError{Path: "database", Err: err},
+		)
+	}
+	if err := c.Encryption.ValidateAndNormalize(); err != nil {
+		return fmt.Errorf(
+			"validating encryption settings: %w",
+			&settings.PathError{Path: "encryption.key-file", Err: err},
+		)
+	}
+	if err := c.Policies.ValidateAndNormalize(); err != nil {
+		return fmt.Errorf(
+			"validating policies: %w",
+			&settings.PathError{Path: "bounds-policies", Err: err},
+		)
+	}
+	if k := c.Usecases.Cars.APIKeyOfOPM; k != nil && *k == "" {
+		c.Usecases.Cars.APIKeyOfOPM = nil
+	}
+	if c.Usecases.Cars.APIKeyOfOPM != nil && !c.Encryption.HasKey() {
+		return &settings.PathError{
+			Path: settingPaths[FieldAPIKeyOfOPM],
+			Err: errors.New(
+				"secret settings require an encryption key-file",
+			),
+		}
+	}
+	if err := c.validateConstraints(); err != nil {
+		return fmt.Errorf("validating constraints: %w", err)
+	}
+	if vs := c.verifyConstraints(); len(vs) > 0 {
+		for i, v := range vs {
+			if p, ok := settingPaths[v.Field]; ok {
+				vs[i].Err = &settings.PathError{Path: p, Err: v.Err}
+			}
+		}
+		return &settings.ConstraintsError{Violations: vs}
 	}
 	if err := settings.VerifyRange(
 		&c.Usecases.Cars.DelayOfOPM,
@@ -384,7 +535,9 @@ func (c *Config) ValidateAndNormalize() error {
 			err.Value,
 			c.Usecases.Cars.MinDelayOfOPM,
 			c.Usecases.Cars.MaxDelayOfOPM,
-			err,
+			&settings.PathError{
+				Path: settingPaths[FieldDelayOfOPM], Err: err,
+			},
 		)
 	}
 	return nil
@@ -397,14 +550,27 @@ func (c *Config) ValidateAndNormalize() error {
 // their default serialization format is acceptable, otherwise, they
 // will be serialized manually using the Marshal method and their
 // target primitive types will be used in the Marshalled struct.
+//
+// The secret settings (e.g., the APIKeyOfOPM) are not included in the
+// Marshalled struct, so they may not be written out in plaintext. They
+// are carried by the database settings row during a migration
+// operation instead, where they are opened using the source Encryption
+// key while loading the source settings and are sealed again using
+// the target Encryption key (see the MergeConfig method) before being
+// persisted in the destination database.
 type Marshalled struct {
-	Database cfg1.Database
-	Gin      cfg1.Gin
-	Usecases struct {
+	Database   cfg1.Database
+	Gin        cfg1.Gin
+	Encryption Encryption        `yaml:",omitempty"`
+	Policies   settings.Policies `yaml:"bounds-policies,omitempty"`
+	Usecases   struct {
 		Cars struct {
 			Delay    *string `yaml:"delay-of-old-parking-method,omitempty"`
 			MinDelay *string `yaml:"delay-of-old-parking-method-minimum,omitempty"`
 			MaxDelay *string `yaml:"delay-of-old-parking-method-maximum,omitempty"`
+
+			DelayConstraints  *settings.Constraints[settings.Duration] `yaml:"delay-of-old-parking-method-constraints,omitempty"`
+			APIKeyConstraints *settings.Constraints[settings.Secret]   `yaml:"api-key-of-old-parking-method-constraints,omitempty"`
 		}
 	}
 	Vers *vers.Marshalled `yaml:",inline"`
@@ -451,9 +617,13 @@ func (c *Config) Marshal() *Marshalled {
 	m := &Marshalled{}
 	m.Database = c.Database
 	m.Gin = c.Gin
+	m.Encryption = Encryption{KeyFile: c.Encryption.KeyFile}
+	m.Policies = c.Policies
 	m.Usecases.Cars.Delay = c.Usecases.Cars.DelayOfOPM.Marshal()
 	m.Usecases.Cars.MinDelay = c.Usecases.Cars.MinDelayOfOPM.Marshal()
 	m.Usecases.Cars.MaxDelay = c.Usecases.Cars.MaxDelayOfOPM.Marshal()
+	m.Usecases.Cars.DelayConstraints = c.Usecases.Cars.DelayOfOPMConstraints
+	m.Usecases.Cars.APIKeyConstraints = c.Usecases.Cars.APIKeyOfOPMConstraints
 	m.Vers = c.Vers.Marshal()
 	return m
 }
@@ -491,11 +661,15 @@ func (c *Config) Dereference() *Config {
 
 // Clone creates a new instance of Config and initializes its fields
 // based on the `c` fields. Pointers are renewed too, so changes in
-// the returned Config instance and `c` stay independent.
+// the returned Config instance and `c` stay independent. The Comments
+// are shared because they are never modified after being loaded.
 func (c *Config) Clone() *Config {
 	cc := &Config{
-		Database: c.Database,
-		Vers:     c.Vers,
+		Database:   c.Database,
+		Encryption: c.Encryption,
+		Policies:   c.Policies,
+		Vers:       c.Vers,
+		Comments:   c.Comments,
 	}
 	settings.OverwriteUnconditionally(&cc.Gin.Logger, c.Gin.Logger)
 	settings.OverwriteUnconditionally(&cc.Gin.Recovery, c.Gin.Recovery)
@@ -508,6 +682,17 @@ func (c *Config) Clone() *Config {
 	settings.OverwriteUnconditionally(
 		&cc.Usecases.Cars.MaxDelayOfOPM, c.Usecases.Cars.MaxDelayOfOPM,
 	)
+	settings.OverwriteUnconditionally(
+		&cc.Usecases.Cars.APIKeyOfOPM, c.Usecases.Cars.APIKeyOfOPM,
+	)
+	settings.OverwriteUnconditionally(
+		&cc.Usecases.Cars.DelayOfOPMConstraints,
+		c.Usecases.Cars.DelayOfOPMConstraints,
+	)
+	settings.OverwriteUnconditionally(
+		&cc.Usecases.Cars.APIKeyOfOPMConstraints,
+		c.Usecases.Cars.APIKeyOfOPMConstraints,
+	)
 	return cc
 }
 
@@ -524,10 +709,22 @@ func (c *Config) Clone() *Config {
 // Similarly, the boundary values are copied from the `c2` because the
 // target boundary values should be respected after migration. By the
 // way, settings may fail to fit in the expected range of boundary
-// values. In this case, they will take the nearest (minimum/maximum)
-// value and the violated boundaries will be logged as warning.
+// values. In this case, the migration policy of `c2` (which is copied
+// into `c` alongside other policies) decides if they should take the
+// nearest (minimum/maximum) value or keep their original value (while
+// the violated boundaries will be logged as warning), or if they should
+// be rejected as a *settings.RejectedSettingsError error.
+// The constraints are copied from the `c2` too, but since a violated
+// constraint cannot be fixed by adjusting the setting value, it is
+// reported as a *settings.ConstraintsError error.
+// The Encryption settings are also copied from the `c2` instance, so
+// secret settings (which are kept in plaintext in memory after being
+// opened with the source key) will be re-encrypted with the target key
+// when they are serialized and persisted in the target database.
 func (c *Config) MergeConfig(ctx context.Context, c2 *Config) error {
 	c.Database = c2.Database
+	c.Encryption = c2.Encryption
+	c.Policies = c2.Policies
 	settings.OverwriteNil(&c.Gin.Logger, c2.Gin.Logger)
 	settings.OverwriteNil(&c.Gin.Recovery, c2.Gin.Recovery)
 	settings.OverwriteNil(
@@ -539,19 +736,43 @@ func (c *Config) MergeConfig(ctx context.Context, c2 *Config) error {
 	settings.OverwriteUnconditionally(
 		&c.Usecases.Cars.MaxDelayOfOPM, c2.Usecases.Cars.MaxDelayOfOPM,
 	)
+	settings.OverwriteNil(
+		&c.Usecases.Cars.APIKeyOfOPM, c2.Usecases.Cars.APIKeyOfOPM,
+	)
+	if c.Usecases.Cars.APIKeyOfOPM != nil && !c.Encryption.HasKey() {
+		return errors.New(
+			"secret settings require an encryption key-file in target",
+		)
+	}
+	settings.OverwriteUnconditionally(
+		&c.Usecases.Cars.DelayOfOPMConstraints,
+		c2.Usecases.Cars.DelayOfOPMConstraints,
+	)
+	settings.OverwriteUnconditionally(
+		&c.Usecases.Cars.APIKeyOfOPMConstraints,
+		c2.Usecases.Cars.APIKeyOfOPMConstraints,
+	)
+	boundsErr := &OutOfBoundsSettingsError{verify: c.verifyConstraints}
+	hasBoundsErr := false
 	if err := settings.VerifyRange(
 		&c.Usecases.Cars.DelayOfOPM,
 		c.Usecases.Cars.MinDelayOfOPM,
 		c.Usecases.Cars.MaxDelayOfOPM,
 	); err != nil {
-		log.Warn(
-			ctx,
-			"delay of opm is adjusted by boundary values",
-			log.Valuer("value", err.Value),
-			log.Valuer("minb", c.Usecases.Cars.MinDelayOfOPM),
-			log.Valuer("maxb", c.Usecases.Cars.MaxDelayOfOPM),
-			log.Err("violation", err),
+		boundsErr.Cars.DelayOfOPM = err
+		hasBoundsErr = true
+	}
+	if hasBoundsErr {
+		err := c.Policies.Migration.Enforce(
+			ctx, model.AppComponent, boundsErr,
+			"migrated settings are out of range",
 		)
+		if err != nil {
+			return err
+		}
+	}
+	if vs := c.verifyConstraints(); len(vs) > 0 {
+		return &settings.ConstraintsError{Violations: vs}
 	}
 	c.Vers.Versions.Config = model.SemVer{Major, Minor, Patch}
 	sv, err := migration.LatestVersion(c2.SchemaVersion())
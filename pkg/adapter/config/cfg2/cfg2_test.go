@@ -26,6 +26,10 @@ import (
 // some runtime error).
 var _ settings.Config[*cfg2.Config, cfg2.Serializable] = (*cfg2.Config)(nil)
 
+// Similarly, the out of range errors must implement the BoundsError
+// interface, so the bounds policies can be enforced uniformly.
+var _ settings.BoundsError = (*cfg2.OutOfBoundsSettingsError)(nil)
+
 func ExampleMarshalYAML() {
 	d, l, r := settings.Duration(time.Hour), true, true
 	minb := settings.Duration(time.Second)
@@ -0,0 +1,140 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cfg2
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+	"github.com/momeni/clean-arch/pkg/core/model"
+)
+
+// These constants are the paths of settings in the Serializable struct
+// (having the json names of nested fields separated by a dot character)
+// which are used for reporting the constraint violations.
+const (
+	// FieldDelayOfOPM is the path of the old parking method delay.
+	FieldDelayOfOPM = "cars.delay_of_opm"
+
+	// FieldAPIKeyOfOPM is the path of the old parking method API key.
+	FieldAPIKeyOfOPM = "secrets.cars.api_key_of_opm"
+)
+
+// settingPaths maps the paths of settings in the Serializable struct
+// (such as the FieldDelayOfOPM constant) to their paths in the
+// configuration file, so their validation errors can be attributed to
+// their lines in that file (see the settings.PathError type).
+var settingPaths = map[string]string{
+	FieldDelayOfOPM:  "usecases.cars.delay-of-old-parking-method",
+	FieldAPIKeyOfOPM: "usecases.cars.api-key-of-old-parking-method",
+}
+
+// Constraints contains the declared constraints of settings beyond
+// their minimum and maximum boundary values, including the per-field
+// constraints (which are taken from the configuration file) and the
+// cross-field rules (which are declared by the later versions, such as
+// the cfg3 package). It is
+// serialized as json and persisted alongside the boundary values,
+// so other components may read and respect them too.
+//
+// Although rules are verified by their Go functions, only their field
+// paths and messages are serialized, so they may be presented to
+// end-users or other components.
+type Constraints struct {
+	// Version indicates the format version of this Constraints and is
+	// equal to the Config struct version.
+	Version model.SemVer `json:"version"`
+
+	// Cars contains the constraints of the cars use cases settings.
+	Cars struct {
+		// DelayOfOPM restricts the old parking method delay.
+		DelayOfOPM *settings.Constraints[settings.Duration] `json:"delay_of_opm,omitempty"`
+
+		// APIKeyOfOPM restricts the old parking method API key.
+		APIKeyOfOPM *settings.Constraints[settings.Secret] `json:"api_key_of_opm,omitempty"`
+	} `json:"cars"`
+
+	// Rules describes the cross-field rules. It is always empty in the
+	// major version 2 because its settings do not depend on each other,
+	// but it is kept because this format is reused by later versions.
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// Rule describes a cross-field rule for serialization purposes.
+type Rule struct {
+	// Fields lists the paths of the participating settings.
+	Fields []string `json:"fields"`
+
+	// Message describes the expectation of this rule.
+	Message string `json:"message"`
+}
+
+// Constraints creates and returns an instance of *Constraints in order
+// to report the declared constraints of settings, as obtained from this
+// Config instance. No cross-field rule is declared in this version.
+func (c *Config) Constraints() *Constraints {
+	cs := &Constraints{
+		Version: c.Version(),
+	}
+	settings.OverwriteUnconditionally(
+		&cs.Cars.DelayOfOPM, c.Usecases.Cars.DelayOfOPMConstraints,
+	)
+	settings.OverwriteUnconditionally(
+		&cs.Cars.APIKeyOfOPM, c.Usecases.Cars.APIKeyOfOPMConstraints,
+	)
+	return cs
+}
+
+// validateConstraints ensures that the declared constraints of the
+// settings are consistent. The enum constraint is not acceptable for
+// secret settings because constraints are not secret themselves and an
+// enum would reveal the acceptable secrets.
+func (c *Config) validateConstraints() error {
+	cars := &c.Usecases.Cars
+	delayPath := settingPaths[FieldDelayOfOPM] + "-constraints"
+	if err := cars.DelayOfOPMConstraints.Validate(); err != nil {
+		return fmt.Errorf(
+			"delay of opm constraints: %w",
+			&settings.PathError{Path: delayPath, Err: err},
+		)
+	}
+	keyPath := settingPaths[FieldAPIKeyOfOPM] + "-constraints"
+	if err := cars.APIKeyOfOPMConstraints.Validate(); err != nil {
+		return fmt.Errorf(
+			"api key of opm constraints: %w",
+			&settings.PathError{Path: keyPath, Err: err},
+		)
+	}
+	if cs := cars.APIKeyOfOPMConstraints; cs != nil && len(cs.Enum) > 0 {
+		return &settings.PathError{
+			Path: keyPath + ".enum",
+			Err: errors.New(
+				"api key of opm constraints: enum is not allowed",
+			),
+		}
+	}
+	return nil
+}
+
+// verifyConstraints checks the settings of this Config instance against
+// their declared constraints, returning all violations (or nil if all
+// constraints are satisfied).
+func (c *Config) verifyConstraints() []settings.Violation {
+	var vs []settings.Violation
+	cars := &c.Usecases.Cars
+	if err := cars.DelayOfOPMConstraints.Verify(cars.DelayOfOPM); err != nil {
+		vs = append(vs, settings.Violation{
+			Field: FieldDelayOfOPM, Err: err,
+		})
+	}
+	if err := cars.APIKeyOfOPMConstraints.Verify(cars.APIKeyOfOPM); err != nil {
+		vs = append(vs, settings.Violation{
+			Field: FieldAPIKeyOfOPM, Err: err,
+		})
+	}
+	return vs
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cfg2
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+)
+
+// Encryption contains the settings which are required for encrypting
+// the secret (mutable & invisible) settings at rest. Secret settings
+// are kept in plaintext in memory (as settings.Secret values), but they
+// are sealed whenever they are serialized for storage in the database
+// (see the Serializable method) and are opened whenever they are read
+// back (see the Mutate method).
+type Encryption struct {
+	// KeyFile is the path of a local file which contains the base64
+	// encoded symmetric key for encryption of the secret settings.
+	// It may be left empty if no secret setting is configured, however,
+	// the secret settings may not be set (neither in the configuration
+	// file, nor by the REST API) without a key file.
+	KeyFile string `yaml:"key-file,omitempty"`
+
+	cipher *settings.Cipher // loaded from KeyFile, or nil if it is empty
+}
+
+// ValidateAndNormalize loads the symmetric key from the KeyFile (if it
+// is not empty), so secret settings may be sealed and opened afterwards.
+func (e *Encryption) ValidateAndNormalize() error {
+	if e.KeyFile == "" {
+		e.cipher = nil
+		return nil
+	}
+	c, err := settings.LoadCipher(e.KeyFile)
+	if err != nil {
+		return fmt.Errorf("loading key file %q: %w", e.KeyFile, err)
+	}
+	e.cipher = c
+	return nil
+}
+
+// HasKey reports if a symmetric key is loaded, so secret settings may
+// be sealed and opened using `e`.
+func (e *Encryption) HasKey() bool {
+	return e.cipher != nil
+}
+
+// Seal encrypts the `s` secret setting. A nil `s` is sealed as nil, so
+// an uninitialized secret setting can be stored in the database too.
+// An error is returned if `s` is not nil but no key is loaded.
+func (e *Encryption) Seal(s *settings.Secret) (*settings.Sealed, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if e.cipher == nil {
+		return nil, errors.New("no encryption key-file is configured")
+	}
+	sealed, err := e.cipher.Seal(*s)
+	if err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+// Open decrypts the `s` sealed secret setting. A nil `s` is opened as
+// nil. An error is returned if `s` is not nil but no key is loaded, or
+// it is not sealed using the same key.
+func (e *Encryption) Open(s *settings.Sealed) (*settings.Secret, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if e.cipher == nil {
+		return nil, errors.New("no encryption key-file is configured")
+	}
+	secret, err := e.cipher.Open(*s)
+	if err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
@@ -99,10 +99,35 @@ type Serializable struct {
 //
 // Therefore, all fields must have pointer types, although some of them
 // must be always non-nil (or they will poppulate an invalid value).
+//
+// The mutable & invisible settings are kept in the Secrets field in
+// their sealed (encrypted) form. A nil Secrets asks the Mutate method
+// to keep the current secret settings, so boundary values (which are
+// not meaningful for secrets) and older serialized settings (which had
+// no secrets) can be applied without clearing them. A non-nil Secrets
+// overwrites all secret settings, following the above discussion.
 type Settings struct {
+	// Secrets contains the sealed secret settings, or nil.
+	// It precedes the embedded Visible struct because the go-json
+	// encoder fails to omit a nil field which follows the embedded
+	// Immutable pointer.
+	Secrets *Secrets `json:"secrets,omitempty"`
+
 	Visible
 }
 
+// Secrets contains the mutable & invisible (write-only) settings in
+// their sealed form. Secrets are sealed and opened using the key which
+// is configured in the Encryption settings of a Config instance, so
+// they are never stored in plaintext in the database.
+type Secrets struct {
+	// Cars represents the secret settings for the Cars use cases.
+	Cars struct {
+		// APIKeyOfOPM is the sealed old parking method API key.
+		APIKeyOfOPM *settings.Sealed `json:"api_key_of_opm"`
+	} `json:"cars"`
+}
+
 // Visible contains settings which are visible by end-users.
 // These settings may be mutable or immutable. The immutable & visible
 // settings are managed by the embedded Immutable struct. When it is
@@ -161,12 +186,18 @@ type OutOfBoundsSettingsError struct {
 		// with regards to the old parking method delay.
 		DelayOfOPM *settings.OutOfRangeError[settings.Duration]
 	}
+
+	// verify checks the settings against their declared constraints,
+	// so they can be checked again after being restored.
+	verify func() []settings.Violation
 }
 
 // Error implements error interface and encodes whole of this
 // OutOfBoundsSettingsError instance as an error string.
 func (e *OutOfBoundsSettingsError) Error() string {
-	return fmt.Sprintf("cfg2.Config settings are out of bounds: %#v", e)
+	return fmt.Sprintf(
+		"cfg2.Config settings are out of bounds: %#v", e.Cars,
+	)
 }
 
 // IsBoundsError implements the settings.BoundsError interface and
@@ -175,6 +206,37 @@ func (e *OutOfBoundsSettingsError) Error() string {
 func (e *OutOfBoundsSettingsError) IsBoundsError() {
 }
 
+// Violations lists the range violations of `e` with their field paths
+// (similar to the constraint violations), so they may be reported with
+// the violated constraints together. It implements the
+// settings.BoundsError interface.
+func (e *OutOfBoundsSettingsError) Violations() []settings.Violation {
+	var vs []settings.Violation
+	if oore := e.Cars.DelayOfOPM; oore != nil {
+		vs = append(vs, settings.Violation{
+			Field: FieldDelayOfOPM, Err: oore,
+		})
+	}
+	return vs
+}
+
+// Restore reverts the adjustment of all out of range settings of `e`,
+// implementing the settings.BoundsError interface. The restored values
+// are verified against their declared constraints and the cross-field
+// rules, returning a *settings.ConstraintsError if they are violated.
+func (e *OutOfBoundsSettingsError) Restore() error {
+	if oore := e.Cars.DelayOfOPM; oore != nil {
+		oore.Restore()
+	}
+	if e.verify == nil {
+		return nil
+	}
+	if vs := e.verify(); len(vs) > 0 {
+		return &settings.ConstraintsError{Violations: vs}
+	}
+	return nil
+}
+
 // Mutate updates this Config instance using the given Serializable
 // instance which provides the mutable settings values.
 // The given Serializable instance may contain mutable & invisible
@@ -190,6 +252,18 @@ func (e *OutOfBoundsSettingsError) IsBoundsError() {
 // crossed over, that boundary value itself will be used as the new
 // value of that setting. In this scenario, returned error will have
 // the *OutOfBoundsSettingsError type.
+//
+// If the provided values (after the above adjustment) violate their
+// declared constraints or the cross-field rules, a
+// *settings.ConstraintsError will be returned instead, listing all
+// violations (including the boundary values violations). A constraint
+// violation cannot be fixed automatically, so caller must treat it as
+// a failure. In this case, this Config instance is left unchanged.
+//
+// The sealed secret settings are opened using the Encryption key of
+// this Config instance. If they cannot be opened (e.g., because they
+// were sealed with another key), an error is returned and this Config
+// instance is left unchanged.
 func (c *Config) Mutate(s Serializable) error {
 	if s.Settings.Visible.Immutable != nil {
 		return errors.New("immutable settings must not be set")
@@ -197,10 +271,21 @@ func (c *Config) Mutate(s Serializable) error {
 	if v1 := c.Version(); v1 != s.Version {
 		return &cerr.MismatchingSemVerError{v1, s.Version}
 	}
+	// The mutated settings are kept by fresh pointers, so a shallow
+	// copy suffices for restoring them if any constraint is violated.
+	old := c.Usecases.Cars
+	if s.Settings.Secrets != nil {
+		key, err := c.Encryption.Open(s.Settings.Secrets.Cars.APIKeyOfOPM)
+		if err != nil {
+			return fmt.Errorf("opening api key of opm: %w", err)
+		}
+		c.Usecases.Cars.APIKeyOfOPM = key
+	}
 	settings.OverwriteUnconditionally(
 		&c.Usecases.Cars.DelayOfOPM, s.Settings.Visible.Cars.DelayOfOPM,
 	)
-	boundsErr, hasBoundsErr := &OutOfBoundsSettingsError{}, false
+	boundsErr := &OutOfBoundsSettingsError{verify: c.verifyConstraints}
+	hasBoundsErr := false
 	if err := settings.VerifyRange(
 		&c.Usecases.Cars.DelayOfOPM,
 		c.Usecases.Cars.MinDelayOfOPM,
@@ -209,6 +294,11 @@ func (c *Config) Mutate(s Serializable) error {
 		boundsErr.Cars.DelayOfOPM = err
 		hasBoundsErr = true
 	}
+	if vs := c.verifyConstraints(); len(vs) > 0 {
+		c.Usecases.Cars = old
+		vs = append(vs, boundsErr.Violations()...)
+		return &settings.ConstraintsError{Violations: vs}
+	}
 	if hasBoundsErr {
 		return boundsErr
 	}
@@ -218,19 +308,30 @@ func (c *Config) Mutate(s Serializable) error {
 // Serializable creates and returns an instance of *Serializable
 // in order to report the mutable settings, based on this Config
 // instance. The Immutable pointer will be nil in the returned object.
-func (c *Config) Serializable() *Serializable {
+// The secret settings are sealed using the Encryption key of this
+// Config instance and the Secrets pointer will be non-nil, so they
+// are persisted (and possibly cleared) explicitly. If they cannot be
+// sealed (e.g., because the random number generator fails), an error
+// will be returned.
+func (c *Config) Serializable() (*Serializable, error) {
 	s := &Serializable{
 		Version: c.Version(),
 		Settings: Settings{
 			Visible: Visible{
 				Immutable: nil,
 			},
+			Secrets: &Secrets{},
 		},
 	}
 	settings.OverwriteUnconditionally(
 		&s.Settings.Visible.Cars.DelayOfOPM, c.Usecases.Cars.DelayOfOPM,
 	)
-	return s
+	key, err := c.Encryption.Seal(c.Usecases.Cars.APIKeyOfOPM)
+	if err != nil {
+		return nil, fmt.Errorf("sealing api key of opm: %w", err)
+	}
+	s.Settings.Secrets.Cars.APIKeyOfOPM = key
+	return s, nil
 }
 
 // Visible creates and fills an instance of Visible struct with the
@@ -243,6 +344,7 @@ func (c *Config) Serializable() *Serializable {
 // updating a Config instance. However, it is not required in the
 // migration use cases as they deal with mutable settings which are
 // exposed by the Serializable method.
+// The secret settings are never included in the returned object.
 func (c *Config) Visible() *Visible {
 	// The panic on nil-dereference of c.Gin.Logger is fine because
 	// after a call to the ValidateAndNormalize method, Logger must be
@@ -265,6 +367,8 @@ func (c *Config) Visible() *Visible {
 // The boundary values may be reported for both of the mutable and
 // immutable settings (as they have an informational purpose).
 // All boundary values are obtained from this Config instance.
+// The secret settings have no boundary values, so the Secrets pointer
+// will be nil in both of the returned objects.
 func (c *Config) Bounds() (minb, maxb *Serializable) {
 	minb = &Serializable{
 		Version: c.Version(),
@@ -292,3 +396,26 @@ func (c *Config) Bounds() (minb, maxb *Serializable) {
 	)
 	return minb, maxb
 }
+
+// components is the registry of independently configured components
+// of the cfg2.Config struct. All mutable settings of this version are
+// owned by the model.AppComponent component which is serialized using
+// the Serializable struct and declares its constraints using the
+// Constraints struct. Managed use cases which need to keep their
+// settings in a separate row (with their own format version) should
+// register their component here.
+var components = []settings.Component[*Config]{
+	settings.NewConstrainedComponent(
+		model.AppComponent,
+		(*Config).Serializable, (*Config).Bounds,
+		(*Config).Constraints, (*Config).Mutate,
+	),
+}
+
+// Components returns the registry of components which keep their
+// mutable settings in distinct rows of the settings table. The returned
+// registry only depends on the Config type, hence, this method can be
+// called with a nil instance too.
+func (c *Config) Components() []settings.Component[*Config] {
+	return components
+}
@@ -6,13 +6,19 @@
 package cfg2_test
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
 	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
 	"github.com/momeni/clean-arch/pkg/core/model"
+	"gopkg.in/yaml.v3"
 )
 
 func ExampleJSONSerialization() {
@@ -40,3 +46,210 @@ func ExampleJSONSerializationWithNilDuration() {
 	// <nil>
 	// {"version":"4.1.5","cars":{"delay_of_opm":null}}
 }
+
+func ExampleConfig_Components() {
+	d := settings.Duration(time.Hour)
+	minb := settings.Duration(time.Second)
+	maxb := settings.Duration(5 * time.Hour)
+	c := &cfg2.Config{}
+	c.Vers.Versions.Config = cfg2.Version
+	c.Usecases.Cars.DelayOfOPM = &d
+	c.Usecases.Cars.MinDelayOfOPM = &minb
+	c.Usecases.Cars.MaxDelayOfOPM = &maxb
+	for _, comp := range c.Components() {
+		ms, lb, ub, cons, err := comp.Serialize(c)
+		fmt.Println(comp.Name(), err)
+		fmt.Println(string(ms))
+		fmt.Println(string(lb))
+		fmt.Println(string(ub))
+		fmt.Println(string(cons))
+		c2 := c.Clone()
+		c2.Usecases.Cars.DelayOfOPM = nil
+		err = comp.Deserialize(c2, []byte(
+			`{"version":"2.2.0","cars":{"delay_of_opm":"9h"}}`,
+		))
+		fmt.Println(err != nil, *c2.Usecases.Cars.DelayOfOPM.Marshal())
+	}
+	// Output:
+	// caweb <nil>
+	// {"version":"2.2.0","secrets":{"cars":{"api_key_of_opm":null}},"cars":{"delay_of_opm":"1h"}}
+	// {"version":"2.2.0","cars":{"delay_of_opm":"1s"},"logger":null}
+	// {"version":"2.2.0","cars":{"delay_of_opm":"5h"},"logger":null}
+	// {"version":"2.2.0","cars":{}}
+	// true 5h
+}
+
+func ExampleConfig_Serializable_secrets() {
+	dir, err := os.MkdirTemp("", "cfg2-example-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "settings.key")
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	if err := os.WriteFile(keyFile, []byte(key+"\n"), 0o600); err != nil {
+		panic(err)
+	}
+	apiKey := settings.Secret("tHeKeY")
+	c := &cfg2.Config{}
+	c.Vers.Versions.Config = cfg2.Version
+	c.Usecases.Cars.APIKeyOfOPM = &apiKey
+	fmt.Println(c.ValidateAndNormalize() != nil)
+	c.Encryption.KeyFile = keyFile
+	fmt.Println(c.ValidateAndNormalize())
+	fmt.Printf("%v %#v\n", apiKey, apiKey)
+
+	s, err := c.Serializable()
+	fmt.Println(err)
+	b, err := json.Marshal(s)
+	fmt.Println(err, strings.Contains(string(b), "tHeKeY"))
+	fmt.Println(c.Visible().Immutable != nil)
+
+	c2 := c.Clone()
+	c2.Usecases.Cars.APIKeyOfOPM = nil
+	fmt.Println(c2.Mutate(*s), string(*c2.Usecases.Cars.APIKeyOfOPM))
+	s.Settings.Secrets = nil
+	fmt.Println(c2.Mutate(*s), string(*c2.Usecases.Cars.APIKeyOfOPM))
+	// Output:
+	// true
+	// <nil>
+	// ****** "******"
+	// <nil>
+	// <nil> false
+	// true
+	// <nil> tHeKeY
+	// <nil> tHeKeY
+}
+
+func ExampleConfig_Constraints() {
+	delayConstraints := &settings.Constraints[settings.Duration]{}
+	err := yaml.Unmarshal([]byte(`
+enum: [2s, 4s, 1m]
+multiple-of: 2s
+`), delayConstraints)
+	fmt.Println(err)
+	keyConstraints := &settings.Constraints[settings.Secret]{}
+	err = yaml.Unmarshal([]byte(`
+pattern: "[a-z]+"
+min-length: 4
+`), keyConstraints)
+	fmt.Println(err)
+
+	d := settings.Duration(4 * time.Second)
+	maxb := settings.Duration(time.Minute)
+	c := &cfg2.Config{}
+	c.Vers.Versions.Config = cfg2.Version
+	c.Usecases.Cars.DelayOfOPM = &d
+	c.Usecases.Cars.MaxDelayOfOPM = &maxb
+	c.Usecases.Cars.DelayOfOPMConstraints = delayConstraints
+	c.Usecases.Cars.APIKeyOfOPMConstraints = keyConstraints
+	b, err := json.Marshal(c.Constraints())
+	fmt.Println(err)
+	fmt.Println(string(b))
+
+	cc := c.Clone()
+	for _, delay := range []string{`"2s"`, `"3s"`, `"1m"`} {
+		err = cc.Mutate(cfg2.Serializable{
+			Version: cfg2.Version,
+			Settings: cfg2.Settings{
+				Visible: cfg2.Visible{
+					Cars: struct {
+						DelayOfOPM *settings.Duration `json:"delay_of_opm"`
+					}{DelayOfOPM: mustDuration(delay)},
+				},
+			},
+		})
+		fmt.Println(delay, err, time.Duration(*cc.Usecases.Cars.DelayOfOPM))
+	}
+	// Output:
+	// <nil>
+	// <nil>
+	// <nil>
+	// {"version":"2.2.0","cars":{"delay_of_opm":{"enum":["2s","4s","1m"],"multiple_of":"2s"},"api_key_of_opm":{"pattern":"[a-z]+","min_length":4}}}
+	// "2s" <nil> 2s
+	// "3s" settings violate constraints: cars.delay_of_opm: must be one of [2s, 4s, 1m] 2s
+	// "1m" <nil> 1m0s
+}
+
+func mustDuration(s string) *settings.Duration {
+	d := new(settings.Duration)
+	if err := json.Unmarshal([]byte(s), d); err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func ExampleConfig_Mutate_policies() {
+	ps := settings.Policies{API: settings.Warn}
+	fmt.Println(ps.ValidateAndNormalize(), ps.API, ps.DB, ps.Migration)
+	ps.DB = "ignore"
+	fmt.Println(ps.ValidateAndNormalize())
+
+	d := settings.Duration(2 * time.Second)
+	minb := settings.Duration(time.Second)
+	maxb := settings.Duration(10 * time.Second)
+	c := &cfg2.Config{}
+	c.Vers.Versions.Config = cfg2.Version
+	c.Usecases.Cars.DelayOfOPM = &d
+	c.Usecases.Cars.MinDelayOfOPM = &minb
+	c.Usecases.Cars.MaxDelayOfOPM = &maxb
+	for _, p := range []settings.Policy{
+		settings.Clamp, settings.Warn, settings.Reject,
+	} {
+		cc := c.Clone()
+		err := cc.Mutate(cfg2.Serializable{
+			Version: cfg2.Version,
+			Settings: cfg2.Settings{
+				Visible: cfg2.Visible{
+					Cars: struct {
+						DelayOfOPM *settings.Duration `json:"delay_of_opm"`
+					}{DelayOfOPM: mustDuration(`"20s"`)},
+				},
+			},
+		})
+		err = p.Enforce(
+			context.Background(), model.AppComponent, err,
+			"settings are out of range",
+		)
+		fmt.Println(p, time.Duration(*cc.Usecases.Cars.DelayOfOPM), err)
+	}
+	// Output:
+	// <nil> warn clamp clamp
+	// db policy: unknown policy "ignore" (expected clamp, reject, or warn)
+	// clamp 10s <nil>
+	// warn 20s <nil>
+	// reject 10s "caweb" settings are out of range: cars.delay_of_opm: value is greater than max
+}
+
+func ExampleConfig_Mutate_warnConstraints() {
+	d := settings.Duration(2 * time.Second)
+	maxb := settings.Duration(10 * time.Second)
+	c := &cfg2.Config{}
+	c.Vers.Versions.Config = cfg2.Version
+	c.Usecases.Cars.DelayOfOPM = &d
+	c.Usecases.Cars.MaxDelayOfOPM = &maxb
+	c.Usecases.Cars.DelayOfOPMConstraints = &settings.Constraints[settings.Duration]{
+		Enum: []settings.Duration{d, maxb},
+	}
+	for _, p := range []settings.Policy{settings.Clamp, settings.Warn} {
+		cc := c.Clone()
+		err := cc.Mutate(cfg2.Serializable{
+			Version: cfg2.Version,
+			Settings: cfg2.Settings{
+				Visible: cfg2.Visible{
+					Cars: struct {
+						DelayOfOPM *settings.Duration `json:"delay_of_opm"`
+					}{DelayOfOPM: mustDuration(`"20s"`)},
+				},
+			},
+		})
+		err = p.Enforce(
+			context.Background(), model.AppComponent, err,
+			"settings are out of range",
+		)
+		fmt.Println(p, err)
+	}
+	// Output:
+	// clamp <nil>
+	// warn settings violate constraints: cars.delay_of_opm: must be one of [2s, 10s]
+}
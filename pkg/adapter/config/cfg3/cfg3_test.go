@@ -0,0 +1,243 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cfg3_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+	"github.com/momeni/clean-arch/pkg/adapter/config/up/upmig2"
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"gopkg.in/yaml.v3"
+)
+
+// This conversion ensures that *cfg3.Config implements the generic
+// settings.Config interface (see the cfg2 package tests).
+var _ settings.Config[*cfg3.Config, cfg3.Serializable] = (*cfg3.Config)(nil)
+
+// Similarly, the out of range errors must implement the BoundsError
+// interface, so the bounds policies can be enforced uniformly.
+var _ settings.BoundsError = (*cfg3.OutOfBoundsSettingsError)(nil)
+
+func ExampleServer_ValidateAndNormalize() {
+	for _, data := range []string{
+		"{}",
+		"{unix-socket: /run/caweb.sock, trusted-proxies: [], mode: test}",
+		"{address: ':80', unix-socket: /run/caweb.sock}",
+		"{address: localhost}",
+		"{trusted-proxies: [10.0.0.0/8, proxy.local]}",
+		"{idle-timeout: -1s}",
+		"{mode: production}",
+	} {
+		s := cfg3.Server{}
+		if err := yaml.Unmarshal([]byte(data), &s); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if err := s.ValidateAndNormalize(); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		b, err := yaml.Marshal(s.Marshal())
+		fmt.Print(string(b), err, "\n")
+	}
+	// Output:
+	// address: :8080
+	// trusted-proxies:
+	//     - 127.0.0.1
+	// <nil>
+	// unix-socket: /run/caweb.sock
+	// trusted-proxies: []
+	// mode: test
+	// <nil>
+	// address and unix-socket are mutually exclusive
+	// parsing address "localhost": address localhost: missing port in address
+	// trusted proxy "proxy.local" is neither an IP nor a CIDR range
+	// idle-timeout may not be negative
+	// unknown value "production" (expected one of debug, release, test)
+}
+
+func ExampleDatabase_ValidateAndNormalize() {
+	for _, data := range []string{
+		"{name: caweb1_3_0, pass-dir: /var/lib/caweb}",
+		"{name: caweb1_3_0, password-source: {kind: env}}",
+		"{password-source: {kind: file, path: '/run/secrets/{role}'}}",
+		"{password-source: {kind: file, path: /run/secrets/db}}",
+		"{password-source: {kind: command}}",
+		"{password-source: {kind: env, path: '/run/secrets/{role}'}}",
+		"{password-source: {kind: vault}}",
+	} {
+		d := cfg3.Database{}
+		if err := yaml.Unmarshal([]byte(data), &d); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if err := d.ValidateAndNormalize(); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		b, err := yaml.Marshal(d)
+		fmt.Printf("%s%v %T\n", b, err, d.SecretProvider())
+	}
+	// Output:
+	// host: ""
+	// port: 0
+	// name: caweb1_3_0
+	// pass-dir: /var/lib/caweb
+	// auth-method: scram-sha-256
+	// <nil> *secrets.PassDir
+	// host: ""
+	// port: 0
+	// name: caweb1_3_0
+	// pass-dir: ""
+	// auth-method: scram-sha-256
+	// password-source:
+	//     kind: env
+	//     variable: CAWEB_DB_PASSWORD_{ROLE}
+	// <nil> *secrets.Env
+	// host: ""
+	// port: 0
+	// name: ""
+	// pass-dir: ""
+	// auth-method: scram-sha-256
+	// password-source:
+	//     kind: file
+	//     path: /run/secrets/{role}
+	// <nil> *secrets.Files
+	// password-source: path "/run/secrets/db" has no {role} or {ROLE} placeholder
+	// password-source: command is required for the command kind
+	// password-source: path may not be set for the env kind
+	// unknown value "vault" (expected one of pgpass, env, file, command)
+}
+
+// writeKeyFile writes a key file filled by the `b` byte in the `dir`
+// directory and returns its path.
+func writeKeyFile(dir string, b byte) string {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = b
+	}
+	path := filepath.Join(dir, fmt.Sprintf("key-%d", b))
+	data := base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		panic(err)
+	}
+	return path
+}
+
+func ExampleConfig_MergeConfig_secrets() {
+	dir, err := os.MkdirTemp("", "cfg3-example-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	srcKey, dstKey := writeKeyFile(dir, 1), writeKeyFile(dir, 2)
+	apiKey := settings.Secret("tHeKeY")
+	src := &cfg2.Config{}
+	src.Encryption.KeyFile = srcKey
+	src.Usecases.Cars.APIKeyOfOPM = &apiKey
+	fmt.Println(src.Encryption.ValidateAndNormalize())
+
+	m, err := (&upmig2.Migrator{Config: src}).MigrateUp(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	c := m.Settler()
+	dst := &cfg3.Config{}
+	dst.Encryption.KeyFile = dstKey
+	dst.Vers.Versions.Database = model.SemVer{1, 3, 0}
+	fmt.Println(dst.Encryption.ValidateAndNormalize())
+	fmt.Println(c.MergeConfig(context.Background(), dst))
+	s, err := c.Serializable()
+	fmt.Println(err)
+
+	for _, keyFile := range []string{srcKey, dstKey} {
+		cc := c.Clone()
+		cc.Usecases.Cars.APIKeyOfOPM = nil
+		cc.Encryption.KeyFile = keyFile
+		if err := cc.Encryption.ValidateAndNormalize(); err != nil {
+			panic(err)
+		}
+		if err := cc.Mutate(*s); err != nil {
+			fmt.Println("opening with", filepath.Base(keyFile), "failed")
+			continue
+		}
+		fmt.Println(string(*cc.Usecases.Cars.APIKeyOfOPM))
+	}
+	// Output:
+	// <nil>
+	// <nil>
+	// <nil>
+	// <nil>
+	// opening with key-1 failed
+	// tHeKeY
+}
+
+func ExampleConfig_Mutate_rules() {
+	d := settings.Duration(2 * time.Second)
+	wt := settings.Duration(10 * time.Second)
+	c := &cfg3.Config{}
+	c.Vers.Versions.Config = cfg3.Version
+	c.Usecases.Cars.DelayOfOPM = &d
+	c.Server.WriteTimeout = &wt
+	b, err := json.Marshal(c.Constraints().Rules)
+	fmt.Println(err)
+	fmt.Println(string(b))
+
+	for _, delay := range []string{"5s", "10s", "8s"} {
+		s := cfg3.Serializable{}
+		err := json.Unmarshal([]byte(fmt.Sprintf(
+			`{"version":"%s","cars":{"delay_of_opm":"%s"}}`,
+			cfg3.Version, delay,
+		)), &s)
+		if err != nil {
+			panic(err)
+		}
+		err = c.Mutate(s)
+		fmt.Println(delay, err, time.Duration(*c.Usecases.Cars.DelayOfOPM))
+	}
+	// Output:
+	// <nil>
+	// [{"fields":["cars.delay_of_opm"],"message":"delay of opm must be less than the server write-timeout"}]
+	// 5s <nil> 5s
+	// 10s settings violate constraints: cars.delay_of_opm: delay of opm must be less than the server write-timeout 5s
+	// 8s <nil> 8s
+}
+
+func ExampleServer_Listen() {
+	dir, err := os.MkdirTemp("", "cfg3-example-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "caweb.sock")
+	s := cfg3.Server{UnixSocket: &p}
+	l, err := s.Listen()
+	fmt.Println(err)
+	_, err = s.Listen()
+	fmt.Println(err != nil)
+	// keep the socket file, as if the process was killed
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	fmt.Println(l.Close())
+	l, err = s.Listen()
+	fmt.Println(err)
+	fmt.Println(l.Close())
+	// Output:
+	// <nil>
+	// true
+	// <nil>
+	// <nil>
+	// <nil>
+}
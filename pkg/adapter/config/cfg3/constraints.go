@@ -0,0 +1,134 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cfg3
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+)
+
+// These constants are the paths of settings in the Serializable struct
+// (having the json names of nested fields separated by a dot character)
+// which are used for reporting the constraint violations. They did not
+// change since the major version 2.
+const (
+	// FieldDelayOfOPM is the path of the old parking method delay.
+	FieldDelayOfOPM = cfg2.FieldDelayOfOPM
+
+	// FieldAPIKeyOfOPM is the path of the old parking method API key.
+	FieldAPIKeyOfOPM = cfg2.FieldAPIKeyOfOPM
+)
+
+// settingPaths maps the paths of settings in the Serializable struct
+// (such as the FieldDelayOfOPM constant) to their paths in the
+// configuration file, so their validation errors can be attributed to
+// their lines in that file (see the settings.PathError type).
+var settingPaths = map[string]string{
+	FieldDelayOfOPM:  "usecases.cars.delay-of-old-parking-method",
+	FieldAPIKeyOfOPM: "usecases.cars.api-key-of-old-parking-method",
+}
+
+// Constraints contains the declared constraints of settings beyond
+// their minimum and maximum boundary values (see cfg2.Constraints).
+// Since the constrained settings did not change since the major
+// version 2, its format is reused, while its Version field reports
+// the version of this package Config struct.
+type Constraints = cfg2.Constraints
+
+// Rule describes a cross-field rule for serialization purposes.
+type Rule = cfg2.Rule
+
+// rules is the registry of cross-field rules of the cfg3.Config struct.
+// Rules are verified whenever the Config is loaded, mutated, or merged
+// and their Fields must refer to the Serializable struct paths (like
+// the FieldDelayOfOPM constant). The immutable settings (such as the
+// server timeouts) may participate in a rule, but they are not listed
+// in its Fields because they cannot be changed by API callers.
+var rules = []settings.Rule[*Config]{
+	{
+		Fields:  []string{FieldDelayOfOPM},
+		Message: "delay of opm must be less than the server write-timeout",
+		Holds: func(c *Config) bool {
+			d, wt := c.Usecases.Cars.DelayOfOPM, c.Server.WriteTimeout
+			return d == nil || wt == nil || *wt == 0 || *d < *wt
+		},
+	},
+}
+
+// Constraints creates and returns an instance of *Constraints in order
+// to report the declared constraints of settings, as obtained from this
+// Config instance, and the cross-field rules of this version.
+func (c *Config) Constraints() *Constraints {
+	cs := &Constraints{
+		Version: c.Version(),
+	}
+	settings.OverwriteUnconditionally(
+		&cs.Cars.DelayOfOPM, c.Usecases.Cars.DelayOfOPMConstraints,
+	)
+	settings.OverwriteUnconditionally(
+		&cs.Cars.APIKeyOfOPM, c.Usecases.Cars.APIKeyOfOPMConstraints,
+	)
+	for _, r := range rules {
+		cs.Rules = append(cs.Rules, Rule{
+			Fields:  r.Fields,
+			Message: r.Message,
+		})
+	}
+	return cs
+}
+
+// validateConstraints ensures that the declared constraints of the
+// settings are consistent. The enum constraint is not acceptable for
+// secret settings because constraints are not secret themselves and an
+// enum would reveal the acceptable secrets.
+func (c *Config) validateConstraints() error {
+	cars := &c.Usecases.Cars
+	delayPath := settingPaths[FieldDelayOfOPM] + "-constraints"
+	if err := cars.DelayOfOPMConstraints.Validate(); err != nil {
+		return fmt.Errorf(
+			"delay of opm constraints: %w",
+			&settings.PathError{Path: delayPath, Err: err},
+		)
+	}
+	keyPath := settingPaths[FieldAPIKeyOfOPM] + "-constraints"
+	if err := cars.APIKeyOfOPMConstraints.Validate(); err != nil {
+		return fmt.Errorf(
+			"api key of opm constraints: %w",
+			&settings.PathError{Path: keyPath, Err: err},
+		)
+	}
+	if cs := cars.APIKeyOfOPMConstraints; cs != nil && len(cs.Enum) > 0 {
+		return &settings.PathError{
+			Path: keyPath + ".enum",
+			Err: errors.New(
+				"api key of opm constraints: enum is not allowed",
+			),
+		}
+	}
+	return nil
+}
+
+// verifyConstraints checks the settings of this Config instance against
+// their declared constraints and the cross-field rules, returning all
+// violations (or nil if all constraints are satisfied).
+func (c *Config) verifyConstraints() []settings.Violation {
+	var vs []settings.Violation
+	cars := &c.Usecases.Cars
+	if err := cars.DelayOfOPMConstraints.Verify(cars.DelayOfOPM); err != nil {
+		vs = append(vs, settings.Violation{
+			Field: FieldDelayOfOPM, Err: err,
+		})
+	}
+	if err := cars.APIKeyOfOPMConstraints.Verify(cars.APIKeyOfOPM); err != nil {
+		vs = append(vs, settings.Violation{
+			Field: FieldAPIKeyOfOPM, Err: err,
+		})
+	}
+	return append(vs, settings.VerifyRules(c, rules)...)
+}
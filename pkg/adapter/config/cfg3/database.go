@@ -0,0 +1,146 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cfg3
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg1"
+	"github.com/momeni/clean-arch/pkg/adapter/config/secrets"
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+)
+
+// DefaultPasswordVariable is the template of the environment variable
+// names which keep the database passwords when the env password source
+// is chosen without a variable setting. The {ROLE} placeholder is
+// replaced by the upper-case role name, e.g., CAWEB_DB_PASSWORD_ADMIN.
+const DefaultPasswordVariable = "CAWEB_DB_PASSWORD_{ROLE}"
+
+// Names of the supported password sources, in the same order as the
+// PasswordSources values.
+const (
+	PgPassSource  = "pgpass"
+	EnvSource     = "env"
+	FileSource    = "file"
+	CommandSource = "command"
+)
+
+// PasswordSources is the settings.Domain of the password source kinds,
+// so the kind setting can be represented as a settings.Enum value.
+type PasswordSources struct{}
+
+// Values lists the acceptable password source kinds.
+func (PasswordSources) Values() []string {
+	return []string{PgPassSource, EnvSource, FileSource, CommandSource}
+}
+
+// Database contains the database related configuration settings. It
+// extends the cfg1.Database settings (which are inlined in the same
+// yaml mapping) by the PasswordSource setting, so the database role
+// passwords may be obtained from other sources than the .pgpass file.
+type Database struct {
+	cfg1.Database `yaml:",inline"`
+
+	// PasswordSource chooses where the passwords of database roles are
+	// kept. A nil PasswordSource keeps them in the .pgpass file of the
+	// PassDir directory, as the older configuration versions did.
+	PasswordSource *PasswordSource `yaml:"password-source,omitempty"`
+}
+
+// PasswordSource describes the secret provider which finds (and maybe
+// renews) the database role passwords. The Variable, Path, and Command
+// settings are templates which are expanded for each role, replacing
+// the {host}, {port}, {database}, {role}, and {ROLE} placeholders (see
+// the secrets package). Each setting may only be used by its own Kind.
+type PasswordSource struct {
+	// Kind is one of the pgpass, env, file, or command values. The
+	// pgpass kind (which is the default) keeps passwords in the .pgpass
+	// file of the database pass-dir directory.
+	Kind *settings.Enum[PasswordSources] `yaml:"kind"`
+
+	// Variable is the environment variable name template of the env
+	// kind. It defaults to the DefaultPasswordVariable.
+	Variable string `yaml:"variable,omitempty"`
+
+	// Path is the file path template of the file kind, one file per
+	// password, e.g., /run/secrets/caweb-db-{role}. It must contain the
+	// {role} or {ROLE} placeholder, so roles take distinct files.
+	Path string `yaml:"path,omitempty"`
+
+	// Command is the command name and arguments templates of the
+	// command kind. That command is run (without a shell) for each
+	// role and its stdout is taken as the password.
+	Command []string `yaml:"command,omitempty"`
+}
+
+// ValidateAndNormalize validates the database settings, including the
+// password source, and returns an error if they were not acceptable.
+// Thereafter, it configures the embedded cfg1.Database instance to use
+// the chosen secret provider for finding and renewing the passwords.
+func (d *Database) ValidateAndNormalize() error {
+	if err := d.Database.ValidateAndNormalize(); err != nil {
+		return err
+	}
+	if d.PasswordSource == nil {
+		d.UseSecretProvider(nil)
+		return nil
+	}
+	p, err := d.PasswordSource.provider(d.PassDir)
+	if err != nil {
+		return fmt.Errorf("password-source: %w", err)
+	}
+	d.UseSecretProvider(p)
+	return nil
+}
+
+// provider validates the `ps` settings and creates its secret provider.
+// The `passDir` is used by the pgpass kind.
+func (ps *PasswordSource) provider(passDir string) (
+	secrets.Provider, error,
+) {
+	kind := PgPassSource
+	if ps.Kind != nil {
+		kind = ps.Kind.Text()
+	}
+	for name, used := range map[string]bool{
+		"variable": ps.Variable != "" && kind != EnvSource,
+		"path":     ps.Path != "" && kind != FileSource,
+		"command":  len(ps.Command) != 0 && kind != CommandSource,
+	} {
+		if used {
+			return nil, fmt.Errorf(
+				"%s may not be set for the %s kind", name, kind,
+			)
+		}
+	}
+	switch kind {
+	case EnvSource:
+		if ps.Variable == "" {
+			ps.Variable = DefaultPasswordVariable
+		}
+		return secrets.NewEnv(ps.Variable), nil
+	case FileSource:
+		switch {
+		case ps.Path == "":
+			return nil, errors.New("path is required for the file kind")
+		case !strings.Contains(ps.Path, "{role}") &&
+			!strings.Contains(ps.Path, "{ROLE}"):
+			return nil, fmt.Errorf(
+				"path %q has no {role} or {ROLE} placeholder", ps.Path,
+			)
+		}
+		return secrets.NewFiles(ps.Path), nil
+	case CommandSource:
+		if len(ps.Command) == 0 || ps.Command[0] == "" {
+			return nil, errors.New("command is required for the command kind")
+		}
+		return secrets.NewCommand(ps.Command...), nil
+	default:
+		return secrets.NewPassDir(passDir), nil
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cfg3
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+	"github.com/momeni/clean-arch/pkg/adapter/restful/gin"
+)
+
+// DefaultAddress is the TCP address which the web server listens on
+// when neither of the address and unix-socket settings are configured.
+// Unlike the gin-gonic Run method, the PORT environment variable is not
+// consulted (the CAWEB_SERVER_ADDRESS variable may be used instead).
+const DefaultAddress = ":8080"
+
+// DefaultTrustedProxies lists the reverse proxies which are trusted
+// when the trusted-proxies setting is not configured. An explicitly
+// empty list (i.e., `trusted-proxies: []`) trusts no proxy.
+var DefaultTrustedProxies = []string{"127.0.0.1"}
+
+// GinModes is the settings.Domain of the gin-gonic modes, so the mode
+// setting can be represented as a settings.Enum[GinModes] value.
+type GinModes struct{}
+
+// Values lists the acceptable gin-gonic modes.
+func (GinModes) Values() []string {
+	return []string{gin.DebugMode, gin.ReleaseMode, gin.TestMode}
+}
+
+// Server contains the web server listener settings. Fields are defined
+// as pointers (or a slice), so it is possible to detect if they are or
+// are not initialized. After migrating from an older configuration
+// settings version, they are left uninitialized because the older
+// versions had no server section and so they may be filled by their
+// default values using the MergeConfig method.
+type Server struct {
+	// Address is the TCP host:port address which the web server listens
+	// on, e.g., 127.0.0.1:8080 or :8080 (for all network interfaces).
+	// It defaults to the DefaultAddress if UnixSocket is not set and
+	// may not be set alongside the UnixSocket.
+	Address *string `yaml:"address"`
+
+	// UnixSocket is the path of a unix domain socket file which the web
+	// server listens on, instead of listening on a TCP Address. If a
+	// stale socket file exists at this path (e.g., left by a former
+	// execution which was not terminated gracefully), it will be
+	// removed, while a socket which accepts connections is kept.
+	UnixSocket *string `yaml:"unix-socket"`
+
+	// TrustedProxies lists the IP addresses or CIDR ranges of reverse
+	// proxies which are trusted to report the client IP addresses
+	// (e.g., using the X-Forwarded-For header). A nil slice is replaced
+	// by the DefaultTrustedProxies, while an empty slice trusts none.
+	TrustedProxies []string `yaml:"trusted-proxies"`
+
+	// Mode is the gin-gonic mode, i.e., debug, release, or test.
+	// A nil Mode keeps the gin-gonic default mode which is taken from
+	// the GIN_MODE environment variable (or is debug by default).
+	Mode *settings.Enum[GinModes] `yaml:"mode"`
+
+	// ReadTimeout is the maximum duration for reading an entire request
+	// (including its body). A nil or zero ReadTimeout means no timeout.
+	ReadTimeout *settings.Duration `yaml:"read-timeout"`
+
+	// WriteTimeout is the maximum duration before timing out the writes
+	// of a response. A nil or zero WriteTimeout means no timeout.
+	WriteTimeout *settings.Duration `yaml:"write-timeout"`
+
+	// IdleTimeout is the maximum duration to wait for the next request
+	// when keep-alives are enabled. A nil or zero IdleTimeout means that
+	// the ReadTimeout is used instead.
+	IdleTimeout *settings.Duration `yaml:"idle-timeout"`
+}
+
+// ValidateAndNormalize validates the server settings, returning an
+// error if they were not acceptable, and fills the Address and
+// TrustedProxies settings by their default values if they were left
+// uninitialized.
+func (s *Server) ValidateAndNormalize() error {
+	if p := s.UnixSocket; p != nil && *p == "" {
+		s.UnixSocket = nil
+	}
+	if a := s.Address; a != nil && *a == "" {
+		s.Address = nil
+	}
+	switch {
+	case s.UnixSocket != nil && s.Address != nil:
+		return errors.New("address and unix-socket are mutually exclusive")
+	case s.UnixSocket == nil && s.Address == nil:
+		a := DefaultAddress
+		s.Address = &a
+	}
+	if a := s.Address; a != nil {
+		if _, _, err := net.SplitHostPort(*a); err != nil {
+			return fmt.Errorf("parsing address %q: %w", *a, err)
+		}
+	}
+	if s.TrustedProxies == nil {
+		s.TrustedProxies = append([]string{}, DefaultTrustedProxies...)
+	}
+	for _, p := range s.TrustedProxies {
+		if net.ParseIP(p) != nil {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(p); err != nil {
+			return fmt.Errorf(
+				"trusted proxy %q is neither an IP nor a CIDR range", p,
+			)
+		}
+	}
+	for name, d := range map[string]*settings.Duration{
+		"read-timeout":  s.ReadTimeout,
+		"write-timeout": s.WriteTimeout,
+		"idle-timeout":  s.IdleTimeout,
+	} {
+		if d != nil && *d < 0 {
+			return fmt.Errorf("%s may not be negative", name)
+		}
+	}
+	return nil
+}
+
+// Clone creates a new instance of Server and initializes its fields
+// based on the `s` fields. Pointers and the TrustedProxies slice are
+// renewed too, so changes in the returned Server instance and `s` stay
+// independent.
+func (s Server) Clone() Server {
+	cs := Server{}
+	settings.OverwriteUnconditionally(&cs.Address, s.Address)
+	settings.OverwriteUnconditionally(&cs.UnixSocket, s.UnixSocket)
+	if s.TrustedProxies != nil {
+		cs.TrustedProxies = append([]string{}, s.TrustedProxies...)
+	}
+	settings.OverwriteUnconditionally(&cs.Mode, s.Mode)
+	settings.OverwriteUnconditionally(&cs.ReadTimeout, s.ReadTimeout)
+	settings.OverwriteUnconditionally(&cs.WriteTimeout, s.WriteTimeout)
+	settings.OverwriteUnconditionally(&cs.IdleTimeout, s.IdleTimeout)
+	return cs
+}
+
+// Merge overwrites all fields of `s` which are not initialized (and
+// have nil value) with their corresponding values from the `s2` arg.
+func (s *Server) Merge(s2 Server) {
+	if s.Address == nil && s.UnixSocket == nil {
+		settings.OverwriteNil(&s.Address, s2.Address)
+		settings.OverwriteNil(&s.UnixSocket, s2.UnixSocket)
+	}
+	if s.TrustedProxies == nil && s2.TrustedProxies != nil {
+		s.TrustedProxies = append([]string{}, s2.TrustedProxies...)
+	}
+	settings.OverwriteNil(&s.Mode, s2.Mode)
+	settings.OverwriteNil(&s.ReadTimeout, s2.ReadTimeout)
+	settings.OverwriteNil(&s.WriteTimeout, s2.WriteTimeout)
+	settings.OverwriteNil(&s.IdleTimeout, s2.IdleTimeout)
+}
+
+// MarshalledServer is the counterpart of the Server struct in the
+// Marshalled struct. Its uninitialized settings are omitted, while an
+// explicitly empty TrustedProxies slice is kept (as `[]`) because it
+// has a distinct meaning from a missing slice.
+type MarshalledServer struct {
+	Address        *string   `yaml:"address,omitempty"`
+	UnixSocket     *string   `yaml:"unix-socket,omitempty"`
+	TrustedProxies *[]string `yaml:"trusted-proxies,omitempty"`
+	Mode           *string   `yaml:"mode,omitempty"`
+	ReadTimeout    *string   `yaml:"read-timeout,omitempty"`
+	WriteTimeout   *string   `yaml:"write-timeout,omitempty"`
+	IdleTimeout    *string   `yaml:"idle-timeout,omitempty"`
+}
+
+// Marshal creates an instance of the MarshalledServer struct and fills
+// it with the `s` Server instance contents.
+func (s Server) Marshal() MarshalledServer {
+	m := MarshalledServer{
+		Address:      s.Address,
+		UnixSocket:   s.UnixSocket,
+		Mode:         s.Mode.Marshal(),
+		ReadTimeout:  s.ReadTimeout.Marshal(),
+		WriteTimeout: s.WriteTimeout.Marshal(),
+		IdleTimeout:  s.IdleTimeout.Marshal(),
+	}
+	if s.TrustedProxies != nil {
+		tp := s.TrustedProxies
+		m.TrustedProxies = &tp
+	}
+	return m
+}
+
+// NewEngine sets the gin-gonic mode (if it is configured) and then
+// instantiates a new gin-gonic engine instance, trusting the
+// TrustedProxies and registering the given middlewares.
+func (s Server) NewEngine(
+	middlewares ...gin.HandlerFunc,
+) (*gin.Engine, error) {
+	if m := s.Mode; m != nil {
+		gin.SetMode(m.Text())
+	}
+	return gin.NewWithTrustedProxies(s.TrustedProxies, middlewares...)
+}
+
+// Listen creates a listener for the UnixSocket path (if it is set) or
+// the TCP Address otherwise. An existing socket file is removed only
+// if it is stale, i.e., no process accepts connections on it anymore,
+// so a running caweb instance is not silently detached from its socket.
+func (s Server) Listen() (net.Listener, error) {
+	if p := s.UnixSocket; p != nil {
+		if err := removeStaleSocket(*p); err != nil {
+			return nil, err
+		}
+		return net.Listen("unix", *p)
+	}
+	return net.Listen("tcp", *s.Address)
+}
+
+// removeStaleSocket removes the `path` unix domain socket file if it
+// exists and refuses connections. Other files are kept, so net.Listen
+// may report them. If a connection can be established, an error is
+// returned because another process is listening on that socket.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	conn, err := net.Dial("unix", path)
+	switch {
+	case err == nil:
+		_ = conn.Close()
+		return fmt.Errorf("socket %q is in use by another process", path)
+	case !errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Errorf("probing socket %q: %w", path, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("removing stale socket: %w", err)
+	}
+	return nil
+}
+
+// HTTPServer creates an http.Server which serves the `h` handler (e.g.,
+// a gin-gonic engine) and respects the configured timeouts.
+func (s Server) HTTPServer(h http.Handler) *http.Server {
+	srv := &http.Server{Handler: h}
+	if d := s.ReadTimeout; d != nil {
+		srv.ReadTimeout = time.Duration(*d)
+	}
+	if d := s.WriteTimeout; d != nil {
+		srv.WriteTimeout = time.Duration(*d)
+	}
+	if d := s.IdleTimeout; d != nil {
+		srv.IdleTimeout = time.Duration(*d)
+	}
+	return srv
+}
@@ -0,0 +1,320 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package cfg3
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+	"github.com/momeni/clean-arch/pkg/core/cerr"
+	"github.com/momeni/clean-arch/pkg/core/model"
+)
+
+// Serializable embeds the Settings in addition to a Version field,
+// so it can be serialized and stored in the database, while the Version
+// field may be consulted during its deserialization in order to ensure
+// that it belongs to the same configuration format version.
+// The Serializable and the main Config struct are versioned together.
+// The nested Immutable pointer must be nil because the Serializable
+// is supposed to carry the mutable settings which are acceptable to be
+// queried from the database and may be passed to the Mutate method.
+//
+// Serializable also can represent the minimum and maximum boundary
+// values for settings. Because all mutable and immutable settings can
+// have boundary values potentially, all fields may have a value in
+// this use case. The version of the main settings and its boundary
+// values (i.e., three instances of this struct) must be the same.
+type Serializable struct {
+	// Version indicates the format version of this Serializable and
+	// is equal to the Config struct version. Although its value is
+	// known from the Serializable type, but we have to store it as a
+	// field in order to find it out during the deserialization and
+	// application phase (by the Mutate method).
+	// Therefore, the embedded Settings struct is enough at runtime.
+	Version model.SemVer `json:"version"`
+
+	Settings
+}
+
+// Settings contains all kinds of mutable and immutable settings, as
+// documented by the cfg2.Settings struct. The mutable settings and the
+// visible immutable settings did not change since the major version 2
+// (because the server section is immutable and invisible), so their
+// structs are reused. If a future minor version of this package needs
+// to add a setting to them, these type aliases should be replaced by
+// local struct definitions.
+type Settings = cfg2.Settings
+
+// Secrets contains the mutable & invisible (write-only) settings in
+// their sealed form (see cfg2.Secrets).
+type Secrets = cfg2.Secrets
+
+// Visible contains settings which are visible by end-users (see
+// cfg2.Visible).
+type Visible = cfg2.Visible
+
+// Immutable contains settings which are immutable, but are visible by
+// end-users (see cfg2.Immutable).
+type Immutable = cfg2.Immutable
+
+// OutOfBoundsSettingsError has the same structure as the Serializable
+// struct and its embedded structs with three differences:
+//  1. Fields are listed directly in OutOfBoundsSettingsError struct
+//     instead of being categorized based on their immutability and
+//     visibility status, because all of those settings may have an
+//     out of range error and all such errors should be reported
+//     together,
+//  2. Only that subset of fields is included which may observe a
+//     *settings.OutOfRangeError[T] error, because if other errors could
+//     happen, they would be reported by higher priority and they would
+//     obstruct the mutation request, while an OutOfBoundsSettingsError
+//     is returned by the Mutate method only when the caller is free
+//     to decide if error should be fatal or treated as a warning,
+//  3. The type of all included fields is *settings.OutOfRangeError[T]
+//     for different T types, where T is the actual type of that field
+//     from the Serializable struct.
+type OutOfBoundsSettingsError struct {
+	// Cars contains errors related to the cars use cases.
+	Cars struct {
+		// DelayOfOPM indicates the range violation error (if any)
+		// with regards to the old parking method delay.
+		DelayOfOPM *settings.OutOfRangeError[settings.Duration]
+	}
+
+	// verify checks the settings against their declared constraints,
+	// so they can be checked again after being restored.
+	verify func() []settings.Violation
+}
+
+// Error implements error interface and encodes whole of this
+// OutOfBoundsSettingsError instance as an error string.
+func (e *OutOfBoundsSettingsError) Error() string {
+	return fmt.Sprintf(
+		"cfg3.Config settings are out of bounds: %#v", e.Cars,
+	)
+}
+
+// IsBoundsError implements the settings.BoundsError interface and
+// so marks the *OutOfBoundsSettingsError as a boundary values violation
+// error.
+func (e *OutOfBoundsSettingsError) IsBoundsError() {
+}
+
+// Violations lists the range violations of `e` with their field paths
+// (similar to the constraint violations), so they may be reported with
+// the violated constraints together. It implements the
+// settings.BoundsError interface.
+func (e *OutOfBoundsSettingsError) Violations() []settings.Violation {
+	var vs []settings.Violation
+	if oore := e.Cars.DelayOfOPM; oore != nil {
+		vs = append(vs, settings.Violation{
+			Field: FieldDelayOfOPM, Err: oore,
+		})
+	}
+	return vs
+}
+
+// Restore reverts the adjustment of all out of range settings of `e`,
+// implementing the settings.BoundsError interface. The restored values
+// are verified against their declared constraints and the cross-field
+// rules, returning a *settings.ConstraintsError if they are violated.
+func (e *OutOfBoundsSettingsError) Restore() error {
+	if oore := e.Cars.DelayOfOPM; oore != nil {
+		oore.Restore()
+	}
+	if e.verify == nil {
+		return nil
+	}
+	if vs := e.verify(); len(vs) > 0 {
+		return &settings.ConstraintsError{Violations: vs}
+	}
+	return nil
+}
+
+// Mutate updates this Config instance using the given Serializable
+// instance which provides the mutable settings values.
+// The given Serializable instance may contain mutable & invisible
+// settings (write-only) and mutable & visible settings (read-write),
+// but it may not contain the immutable settings (i.e., the Immutable
+// pointer must be nil). The provided Serializable instance is not
+// updated itself, hence, a non-pointer variable is suitable.
+//
+// If provided values do not respect the expected boundary values, an
+// error will be returned, indicating that which settings were out of
+// bound, however, this type of error does not prevent this Config
+// instance to be updated. When a minimum/maximum boundary value is
+// crossed over, that boundary value itself will be used as the new
+// value of that setting. In this scenario, returned error will have
+// the *OutOfBoundsSettingsError type.
+//
+// If the provided values (after the above adjustment) violate their
+// declared constraints or the cross-field rules, a
+// *settings.ConstraintsError will be returned instead, listing all
+// violations (including the boundary values violations). A constraint
+// violation cannot be fixed automatically, so caller must treat it as
+// a failure. In this case, this Config instance is left unchanged.
+//
+// The sealed secret settings are opened using the Encryption key of
+// this Config instance. If they cannot be opened (e.g., because they
+// were sealed with another key), an error is returned and this Config
+// instance is left unchanged.
+func (c *Config) Mutate(s Serializable) error {
+	if s.Settings.Visible.Immutable != nil {
+		return errors.New("immutable settings must not be set")
+	}
+	if v1 := c.Version(); v1 != s.Version {
+		return &cerr.MismatchingSemVerError{v1, s.Version}
+	}
+	// The mutated settings are kept by fresh pointers, so a shallow
+	// copy suffices for restoring them if any constraint is violated.
+	old := c.Usecases.Cars
+	if s.Settings.Secrets != nil {
+		key, err := c.Encryption.Open(s.Settings.Secrets.Cars.APIKeyOfOPM)
+		if err != nil {
+			return fmt.Errorf("opening api key of opm: %w", err)
+		}
+		c.Usecases.Cars.APIKeyOfOPM = key
+	}
+	settings.OverwriteUnconditionally(
+		&c.Usecases.Cars.DelayOfOPM, s.Settings.Visible.Cars.DelayOfOPM,
+	)
+	boundsErr := &OutOfBoundsSettingsError{verify: c.verifyConstraints}
+	hasBoundsErr := false
+	if err := settings.VerifyRange(
+		&c.Usecases.Cars.DelayOfOPM,
+		c.Usecases.Cars.MinDelayOfOPM,
+		c.Usecases.Cars.MaxDelayOfOPM,
+	); err != nil {
+		boundsErr.Cars.DelayOfOPM = err
+		hasBoundsErr = true
+	}
+	if vs := c.verifyConstraints(); len(vs) > 0 {
+		c.Usecases.Cars = old
+		vs = append(vs, boundsErr.Violations()...)
+		return &settings.ConstraintsError{Violations: vs}
+	}
+	if hasBoundsErr {
+		return boundsErr
+	}
+	return nil
+}
+
+// Serializable creates and returns an instance of *Serializable
+// in order to report the mutable settings, based on this Config
+// instance. The Immutable pointer will be nil in the returned object.
+// The secret settings are sealed using the Encryption key of this
+// Config instance and the Secrets pointer will be non-nil, so they
+// are persisted (and possibly cleared) explicitly. If they cannot be
+// sealed (e.g., because the random number generator fails), an error
+// will be returned.
+func (c *Config) Serializable() (*Serializable, error) {
+	s := &Serializable{
+		Version: c.Version(),
+		Settings: Settings{
+			Visible: Visible{
+				Immutable: nil,
+			},
+			Secrets: &Secrets{},
+		},
+	}
+	settings.OverwriteUnconditionally(
+		&s.Settings.Visible.Cars.DelayOfOPM, c.Usecases.Cars.DelayOfOPM,
+	)
+	key, err := c.Encryption.Seal(c.Usecases.Cars.APIKeyOfOPM)
+	if err != nil {
+		return nil, fmt.Errorf("sealing api key of opm: %w", err)
+	}
+	s.Settings.Secrets.Cars.APIKeyOfOPM = key
+	return s, nil
+}
+
+// Visible creates and fills an instance of Visible struct with the
+// mutable and immutable settings which can be queried by end-users.
+// That is, the Immutable pointer will be non-nil in the returned
+// object. Despite the Mutate and Serializable methods, the Visible
+// method is not included in the pkg/adapter/config/settings.Config
+// generic interface because it is only useful in the adapters layer
+// where a repository package may query the visible settings after
+// updating a Config instance. However, it is not required in the
+// migration use cases as they deal with mutable settings which are
+// exposed by the Serializable method.
+// The secret settings are never included in the returned object.
+func (c *Config) Visible() *Visible {
+	// The panic on nil-dereference of c.Gin.Logger is fine because
+	// after a call to the ValidateAndNormalize method, Logger must be
+	// non-nil (in absence of programming errors).
+	l := *c.Gin.Logger
+	v := &Visible{
+		Immutable: &Immutable{
+			Logger: &l,
+		},
+	}
+	settings.OverwriteUnconditionally(
+		&v.Cars.DelayOfOPM, c.Usecases.Cars.DelayOfOPM,
+	)
+	return v
+}
+
+// Bounds creates and returns two instances of *Serializable in order to
+// report the minimum and maximum boundary values for those settings
+// which their lower/upper limits should be restricted.
+// The boundary values may be reported for both of the mutable and
+// immutable settings (as they have an informational purpose).
+// All boundary values are obtained from this Config instance.
+// The secret settings have no boundary values, so the Secrets pointer
+// will be nil in both of the returned objects.
+func (c *Config) Bounds() (minb, maxb *Serializable) {
+	minb = &Serializable{
+		Version: c.Version(),
+		Settings: Settings{
+			Visible: Visible{
+				Immutable: &Immutable{},
+			},
+		},
+	}
+	settings.OverwriteUnconditionally(
+		&minb.Settings.Visible.Cars.DelayOfOPM,
+		c.Usecases.Cars.MinDelayOfOPM,
+	)
+	maxb = &Serializable{
+		Version: c.Version(),
+		Settings: Settings{
+			Visible: Visible{
+				Immutable: &Immutable{},
+			},
+		},
+	}
+	settings.OverwriteUnconditionally(
+		&maxb.Settings.Visible.Cars.DelayOfOPM,
+		c.Usecases.Cars.MaxDelayOfOPM,
+	)
+	return minb, maxb
+}
+
+// components is the registry of independently configured components
+// of the cfg3.Config struct. All mutable settings of this version are
+// owned by the model.AppComponent component which is serialized using
+// the Serializable struct and declares its constraints using the
+// Constraints struct. Managed use cases which need to keep their
+// settings in a separate row (with their own format version) should
+// register their component here.
+var components = []settings.Component[*Config]{
+	settings.NewConstrainedComponent(
+		model.AppComponent,
+		(*Config).Serializable, (*Config).Bounds,
+		(*Config).Constraints, (*Config).Mutate,
+	),
+}
+
+// Components returns the registry of components which keep their
+// mutable settings in distinct rows of the settings table. The returned
+// registry only depends on the Config type, hence, this method can be
+// called with a nil instance too.
+func (c *Config) Components() []settings.Component[*Config] {
+	return components
+}
@@ -4,55 +4,110 @@
 // file, You can obtain one at https://mozilla.org/MPL/2.0/.
 
 // Package comment provides facilities for parsing comments out of a
-// YAML sequence or mapping node recursively, keeping them as a Comment
-// struct instance, and merging them in another YAML parsed node, so
-// comments may be preserved when that YAML node is serialized again.
+// YAML document, sequence, or mapping node recursively, keeping them as
+// a Comment struct instance, and merging them in another YAML parsed
+// node, so comments may be preserved when that YAML node is serialized
+// again. The head, line (inline), and foot comments of keys and values
+// are preserved, alongside two formatting hints, namely the blank lines
+// which separate groups of settings and the original order of mapping
+// keys. Comments of renamed keys may be carried using the Rename method.
 package comment
 
 import (
 	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
-// Comment struct contains the head-comments for a sequence or mapping
-// yaml node instance.
+// Comment struct contains the comments for a document, sequence, or
+// mapping yaml node instance.
 type Comment struct {
 	m *Map // comments for a mapping yaml node (or nil otherwise)
 	s *Seq // comments for a sequence yaml node (or nil otherwise)
+
+	node Lines // comments of the sequence or mapping node itself
+	doc  Lines // comments of the document node (if it was loaded)
+}
+
+// Lines contains the comments which are attached to one yaml node.
+// The Head comment is written in the lines right before that node,
+// the Line comment is written at the end of its line, and the Foot
+// comment is written in the lines right after that node (and its
+// children).
+type Lines struct {
+	Head, Line, Foot string
+}
+
+// linesOf returns the comments of the `n` yaml node.
+func linesOf(n *yaml.Node) Lines {
+	return Lines{Head: n.HeadComment, Line: n.LineComment, Foot: n.FootComment}
+}
+
+// saveInto writes the `l` comments into the `n` yaml node.
+func (l Lines) saveInto(n *yaml.Node) {
+	n.HeadComment, n.LineComment, n.FootComment = l.Head, l.Line, l.Foot
 }
 
-// Map struct contains head-comments which are written right before
-// each mapping key element. If those keys are mapped to some other
-// sequence or mapping yaml nodes themselves, they may be parsed as
-// nested Comment instances. This Map struct also contains a mapping
-// from the key elements to their nested Comment instances (if any).
+// entry contains the comments of a mapping key and its value (or a
+// sequence item which has no key). The value comments are only restored
+// if the value kind is not changed, e.g., a line comment of a scalar
+// value is not moved into a mapping value. The blank flag indicates
+// that a blank line was written before the head comment of this entry,
+// so groups of settings may stay separated.
+type entry struct {
+	key, value Lines
+	kind       yaml.Kind // kind of the value node
+	blank      bool      // whether a blank line precedes the entry
+}
+
+// Map struct contains the comments of each mapping key and its value.
+// If those keys are mapped to some other sequence or mapping yaml nodes
+// themselves, they may be parsed as nested Comment instances. This Map
+// struct also contains a mapping from the key elements to their nested
+// Comment instances (if any) and the original order of keys.
 type Map struct {
-	keyComments map[string]string   // key name -> its head comment
-	mapComments map[string]*Comment // key name -> its inner comments
-}
-
-// Seq struct contains head-comments which are written right before
-// each sequence element. If those elements contain some other sequence
-// or mapping yaml nodes themselves, they may be parsed as nested
-// Comment instances. This Seq struct also contains a slice of *Comment
-// instances which indicates relevant nested comments. If some of the
-// sequence members need a nested Comment instance and some of them do
-// not need it, the slice of *Comment instances can contain nil elements
-// jumping over the missing *Comment instances.
+	entries map[string]*entry   // key name -> its comments
+	nested  map[string]*Comment // key name -> its inner comments
+	order   []string            // key names in their original order
+}
+
+// Seq struct contains the comments of each sequence element. If those
+// elements contain some other sequence or mapping yaml nodes themselves,
+// they may be parsed as nested Comment instances. This Seq struct also
+// contains a slice of *Comment instances which indicates relevant nested
+// comments. If some of the sequence members need a nested Comment
+// instance and some of them do not need it, the slice of *Comment
+// instances can contain nil elements jumping over the missing *Comment
+// instances.
 type Seq struct {
-	topComments []string   // comments on top-level items
-	seqComments []*Comment // sequence of nil or nested comments
+	entries []entry    // comments of items
+	nested  []*Comment // sequence of nil or nested comments
 }
 
-// LoadFrom expects a yaml node with the sequence or mapping kind,
-// iterates over its keys, and loads their HeadComment strings in the
-// created Comment instance. If contained values had sequence or mapping
-// kinds themselves, they will be loaded recursively into new Comment
-// instances and kept in returned Comment, so they can be saved again.
+// LoadFrom expects a yaml node with the document, sequence, or mapping
+// kind, iterates over its keys, and loads their comments in the created
+// Comment instance. If contained values had sequence or mapping kinds
+// themselves, they will be loaded recursively into new Comment instances
+// and kept in returned Comment, so they can be saved again. A document
+// node must contain one sequence or mapping node and its own comments
+// (e.g., a comment which is separated from the first key by a blank
+// line) are loaded too.
 func LoadFrom(n *yaml.Node) (*Comment, error) {
 	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) != 1 {
+			return nil, errors.New("document must have one child node")
+		}
+		c, err := LoadFrom(n.Content[0])
+		if err != nil {
+			return nil, err
+		}
+		c.doc = linesOf(n)
+		return c, nil
 	case yaml.SequenceNode:
 		return loadSeq(n)
 	case yaml.MappingNode:
@@ -64,10 +119,17 @@ func LoadFrom(n *yaml.Node) (*Comment, error) {
 
 func loadSeq(n *yaml.Node) (*Comment, error) {
 	c := &Comment{
-		s: &Seq{},
+		s:    &Seq{},
+		node: linesOf(n),
 	}
+	prevEnd := 0
 	for i, cn := range n.Content {
-		c.s.topComments = append(c.s.topComments, cn.HeadComment)
+		c.s.entries = append(c.s.entries, entry{
+			value: linesOf(cn),
+			kind:  cn.Kind,
+			blank: i > 0 && cn.Line-countLines(cn.HeadComment) > prevEnd+1,
+		})
+		prevEnd = endLine(cn) + countLines(cn.FootComment)
 		switch cn.Kind {
 		case yaml.SequenceNode, yaml.MappingNode:
 			nested, err := LoadFrom(cn)
@@ -76,9 +138,9 @@ func loadSeq(n *yaml.Node) (*Comment, error) {
 					"loading nested comments for i=%d: %w", i, err,
 				)
 			}
-			c.s.seqComments = append(c.s.seqComments, nested)
+			c.s.nested = append(c.s.nested, nested)
 		default:
-			c.s.seqComments = append(c.s.seqComments, nil)
+			c.s.nested = append(c.s.nested, nil)
 		}
 	}
 	return c, nil
@@ -87,42 +149,111 @@ func loadSeq(n *yaml.Node) (*Comment, error) {
 func loadMap(n *yaml.Node) (*Comment, error) {
 	c := &Comment{
 		m: &Map{
-			keyComments: make(map[string]string),
-			mapComments: make(map[string]*Comment),
+			entries: make(map[string]*entry),
+			nested:  make(map[string]*Comment),
 		},
+		node: linesOf(n),
 	}
-	var key string
-	for i, cn := range n.Content {
-		switch i % 2 {
-		case 0:
-			key = cn.Value
-			c.m.keyComments[key] = cn.HeadComment
-		case 1:
-			switch cn.Kind {
-			case yaml.SequenceNode, yaml.MappingNode:
-				nested, err := LoadFrom(cn)
-				if err != nil {
-					return nil, fmt.Errorf(
-						"loading nested comments from %q key: %w",
-						key, err,
-					)
-				}
-				c.m.mapComments[key] = nested
+	prevEnd := 0
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		kn, vn := n.Content[i], n.Content[i+1]
+		key := kn.Value
+		c.m.order = append(c.m.order, key)
+		c.m.entries[key] = &entry{
+			key:   linesOf(kn),
+			value: linesOf(vn),
+			kind:  vn.Kind,
+			blank: i > 0 && kn.Line-countLines(kn.HeadComment) > prevEnd+1,
+		}
+		prevEnd = max(
+			endLine(kn)+countLines(kn.FootComment),
+			endLine(vn)+countLines(vn.FootComment),
+		)
+		switch vn.Kind {
+		case yaml.SequenceNode, yaml.MappingNode:
+			nested, err := LoadFrom(vn)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"loading nested comments from %q key: %w", key, err,
+				)
 			}
+			c.m.nested[key] = nested
 		}
 	}
 	return c, nil
 }
 
+// endsWithFoot reports if one of the `nodes` siblings (i.e., a key and
+// its value, or a sequence item) has a foot comment. The yaml emitter
+// separates such a foot comment from the next sibling entry by an empty
+// line, so an entry which follows them must not add another empty line.
+// The foot comments of their descendants are not considered because
+// the emitter does not add an empty line after them.
+func endsWithFoot(nodes ...*yaml.Node) bool {
+	for _, n := range nodes {
+		if n.FootComment != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// countLines returns the number of lines of the `cmnt` comment.
+func countLines(cmnt string) int {
+	if cmnt == "" {
+		return 0
+	}
+	return strings.Count(cmnt, "\n") + 1
+}
+
+// endLine returns the last line number of the `n` node and its children,
+// including the foot comments of its children, so the blank lines after
+// it can be detected.
+func endLine(n *yaml.Node) int {
+	end := n.Line
+	if n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		end += strings.Count(strings.TrimSuffix(n.Value, "\n"), "\n") + 1
+	}
+	for _, cn := range n.Content {
+		end = max(end, endLine(cn)+countLines(cn.FootComment))
+	}
+	return end
+}
+
 // SaveInto saves comments which are recorded in the `c` instance
 // into the given `n` yaml node. The `n` argument is expected to have
-// a sequence or mapping kind. If its contained values had sequence or
-// mapping kinds themselves, they will be checked recursively too (if
-// they had some corresponding comments among the `c` nested comments).
+// a document, sequence, or mapping kind. If its contained values had
+// sequence or mapping kinds themselves, they will be checked recursively
+// too (if they had some corresponding comments among the `c` nested
+// comments). The keys of mapping nodes are reordered, so those keys
+// which were loaded by the LoadFrom function keep their original order,
+// while other keys follow the key which preceded them in `n`.
+// If `c` was loaded from a document node, but `n` is its mapping or
+// sequence child node, the document comments are saved into `n` itself.
 func (c *Comment) SaveInto(n *yaml.Node) error {
 	if c == nil {
 		return nil // there is no comments to be saved
 	}
+	if n.Kind == yaml.DocumentNode {
+		if len(n.Content) != 1 {
+			return errors.New("document must have one child node")
+		}
+		if err := c.saveInto(n.Content[0], Lines{}); err != nil {
+			return err
+		}
+		c.doc.saveInto(n)
+		return nil
+	}
+	return c.saveInto(n, c.doc)
+}
+
+// saveInto saves the `c` comments into the `n` mapping or sequence node
+// and merges the `doc` document comments with the `n` node comments.
+// The document head comment is separated from the `n` contents by an
+// empty line. Since yaml emitter prints the head comment of a mapping
+// node without such an empty line, the `doc` head comment is prepended
+// to the head comment of the first key when possible.
+func (c *Comment) saveInto(n *yaml.Node, doc Lines) error {
 	switch k := n.Kind; k {
 	case yaml.SequenceNode:
 		if c.s == nil {
@@ -141,16 +272,44 @@ func (c *Comment) SaveInto(n *yaml.Node) error {
 	default:
 		return fmt.Errorf("expected a mapping or sequence (kind=%d)", k)
 	}
+	l := c.node
+	switch {
+	case doc.Head == "":
+	case l.Head == "" && n.Kind == yaml.MappingNode && len(n.Content) > 0:
+		k := n.Content[0]
+		k.HeadComment = doc.Head + "\n\n" + k.HeadComment
+	case l.Head == "":
+		l.Head = doc.Head
+	}
+	if l.Foot == "" {
+		l.Foot = doc.Foot
+	}
+	l.saveInto(n)
 	return nil
 }
 
+// headComment returns the head comment of the `e` entry, preceded by
+// a newline if a blank line should be written before it.
+func (e *entry) headComment(head string) string {
+	if e.blank {
+		return "\n" + head
+	}
+	return head
+}
+
 func (s *Seq) saveInto(n *yaml.Node) error {
 	for i, cn := range n.Content {
-		if i >= len(s.topComments) {
+		if i >= len(s.entries) {
 			return nil
 		}
-		cn.HeadComment = s.topComments[i]
-		nested := s.seqComments[i]
+		e := &s.entries[i]
+		if cn.Kind == e.kind {
+			e.value.saveInto(cn)
+		}
+		if i == 0 || !endsWithFoot(n.Content[i-1]) {
+			cn.HeadComment = e.headComment(cn.HeadComment)
+		}
+		nested := s.nested[i]
 		if nested == nil {
 			continue
 		}
@@ -164,23 +323,215 @@ func (s *Seq) saveInto(n *yaml.Node) error {
 }
 
 func (m *Map) saveInto(n *yaml.Node) error {
-	var key string
-	for i, cn := range n.Content {
-		switch i % 2 {
-		case 0:
-			key = cn.Value
-			cn.HeadComment = m.keyComments[key]
-		case 1:
-			nested, exists := m.mapComments[key]
-			if !exists {
-				continue
+	m.reorder(n)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		kn, vn := n.Content[i], n.Content[i+1]
+		key := kn.Value
+		e, exists := m.entries[key]
+		if !exists {
+			continue
+		}
+		e.key.saveInto(kn)
+		if vn.Kind == e.kind {
+			e.value.saveInto(vn)
+		}
+		if i > 0 && !endsWithFoot(n.Content[i-2:i]...) {
+			kn.HeadComment = e.headComment(kn.HeadComment)
+		}
+		nested, exists := m.nested[key]
+		if !exists {
+			continue
+		}
+		if err := nested.SaveInto(vn); err != nil {
+			return fmt.Errorf(
+				"saving nested comments into %q key: %w", key, err,
+			)
+		}
+	}
+	m.moveFoot(n)
+	return nil
+}
+
+// moveFoot moves the foot comments of the originally last key of the
+// `n` mapping node to its current last key, if some keys were added
+// after it. Such a foot comment belongs to the end of that mapping, so
+// it must not separate the added keys from the original ones.
+func (m *Map) moveFoot(n *yaml.Node) {
+	l := len(n.Content)
+	if len(m.order) == 0 || l < 4 {
+		return
+	}
+	lastKey := m.order[len(m.order)-1]
+	i := l - 2
+	for i >= 0 && n.Content[i].Value != lastKey {
+		i -= 2
+	}
+	if i < 0 || i == l-2 {
+		return
+	}
+	kn, vn := n.Content[i], n.Content[i+1]
+	var feet []string
+	for _, foot := range []string{
+		kn.FootComment, vn.FootComment, n.Content[l-2].FootComment,
+	} {
+		if foot != "" {
+			feet = append(feet, foot)
+		}
+	}
+	kn.FootComment, vn.FootComment = "", ""
+	n.Content[l-2].FootComment = strings.Join(feet, "\n")
+}
+
+// reorder sorts the key/value pairs of the `n` mapping node, so the
+// keys which are listed in `m.order` take their original order. Each
+// other key stays right after the key which precedes it in `n`, or at
+// the beginning of `n` if no known key precedes it.
+func (m *Map) reorder(n *yaml.Node) {
+	rank := make(map[string]int, len(m.order))
+	for i, key := range m.order {
+		rank[key] = i
+	}
+	type group struct {
+		rank  int
+		nodes []*yaml.Node
+	}
+	groups := []group{{rank: -1}}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if r, found := rank[n.Content[i].Value]; found {
+			groups = append(groups, group{rank: r})
+		}
+		g := &groups[len(groups)-1]
+		g.nodes = append(g.nodes, n.Content[i], n.Content[i+1])
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].rank < groups[j].rank
+	})
+	n.Content = n.Content[:0]
+	for _, g := range groups {
+		n.Content = append(n.Content, g.nodes...)
+	}
+}
+
+// Rename returns a copy of the `c` comments which has moved the comments
+// of the keys which are listed in the `renames` map to their new keys.
+// Both keys of each `renames` item are paths of mapping keys which are
+// separated by dots, e.g., "usecases.cars.old-parking-method-delay".
+// A renamed key takes the original position of its old key if they
+// have the same parent mapping. Otherwise, it is appended to its new
+// parent mapping (which is created if it did not have any comments).
+// Renames are applied in the lexicographical order of their old keys and
+// missing old keys are ignored, so migrators may declare their renames
+// without checking which settings are present in each config file.
+// The `c` instance is not modified because loaded comments are shared
+// among the cloned config instances.
+func (c *Comment) Rename(renames map[string]string) *Comment {
+	if c == nil || len(renames) == 0 {
+		return c
+	}
+	cc := c.clone()
+	olds := make([]string, 0, len(renames))
+	for old := range renames {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	for _, old := range olds {
+		cc.rename(
+			strings.Split(old, "."), strings.Split(renames[old], "."),
+		)
+	}
+	return cc
+}
+
+// rename moves the comments of the `from` keys path to the `to` keys
+// path in the `c` comments (which must be cloned already).
+func (c *Comment) rename(from, to []string) {
+	src := c.find(from[:len(from)-1], false)
+	if src == nil {
+		return
+	}
+	oldKey, newKey := from[len(from)-1], to[len(to)-1]
+	e, exists := src.entries[oldKey]
+	if !exists {
+		return
+	}
+	nested := src.nested[oldKey]
+	i := slices.Index(src.order, oldKey)
+	delete(src.entries, oldKey)
+	delete(src.nested, oldKey)
+	src.order = slices.Delete(src.order, i, i+1)
+	dst := c.find(to[:len(to)-1], true)
+	if dst == nil {
+		return // new parent is not a mapping
+	}
+	if dst == src {
+		dst.order = slices.Insert(dst.order, i, newKey)
+	} else {
+		dst.order = append(dst.order, newKey)
+	}
+	dst.entries[newKey] = e
+	if nested != nil {
+		dst.nested[newKey] = nested
+	}
+}
+
+// find returns the comments of the mapping which is found by following
+// the `keys` path from `c`. If some mappings are missing on that path,
+// they are created if `create` is true, otherwise, nil is returned.
+// A nil Map is returned if the path crosses a sequence.
+func (c *Comment) find(keys []string, create bool) *Map {
+	m := c.m
+	for _, key := range keys {
+		if m == nil {
+			return nil
+		}
+		nested, exists := m.nested[key]
+		if !exists {
+			if !create {
+				return nil
 			}
-			if err := nested.SaveInto(cn); err != nil {
-				return fmt.Errorf(
-					"saving nested comments into %q key: %w", key, err,
-				)
+			nested = &Comment{m: &Map{
+				entries: make(map[string]*entry),
+				nested:  make(map[string]*Comment),
+			}}
+			m.nested[key] = nested
+			if _, exists := m.entries[key]; !exists {
+				m.entries[key] = &entry{kind: yaml.MappingNode}
+				m.order = append(m.order, key)
 			}
 		}
+		m = nested.m
 	}
-	return nil
+	return m
+}
+
+// clone returns a deep copy of the `c` comments.
+func (c *Comment) clone() *Comment {
+	if c == nil {
+		return nil
+	}
+	cc := &Comment{node: c.node, doc: c.doc}
+	if c.m != nil {
+		cc.m = &Map{
+			entries: make(map[string]*entry, len(c.m.entries)),
+			nested:  make(map[string]*Comment, len(c.m.nested)),
+			order:   slices.Clone(c.m.order),
+		}
+		for key, e := range c.m.entries {
+			ce := *e
+			cc.m.entries[key] = &ce
+		}
+		for key, nested := range c.m.nested {
+			cc.m.nested[key] = nested.clone()
+		}
+	}
+	if c.s != nil {
+		cc.s = &Seq{
+			entries: slices.Clone(c.s.entries),
+			nested:  make([]*Comment, len(c.s.nested)),
+		}
+		for i, nested := range c.s.nested {
+			cc.s.nested[i] = nested.clone()
+		}
+	}
+	return cc
 }
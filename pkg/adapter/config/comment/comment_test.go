@@ -0,0 +1,93 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package comment_test
+
+import (
+	"fmt"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/comment"
+	"gopkg.in/yaml.v3"
+)
+
+const commented = `# settings of the example
+
+# first group
+name: example # the name
+tags:
+    - a # first tag
+    - b
+
+# second group
+old-delay: 15s # tuned for slow clients
+nested:
+    x: 1
+    # foot of nested
+`
+
+type settings struct {
+	Nested struct {
+		X int `yaml:"x"`
+		Z int `yaml:"z"`
+	} `yaml:"nested"`
+	Name  string   `yaml:"name"`
+	Delay string   `yaml:"new-delay"`
+	Tags  []string `yaml:"tags"`
+}
+
+func ExampleComment_SaveInto() {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(commented), doc); err != nil {
+		panic(err)
+	}
+	c, err := comment.LoadFrom(doc)
+	if err != nil {
+		panic(err)
+	}
+	s := settings{Name: "example", Delay: "15s", Tags: []string{"a", "b"}}
+	s.Nested.X, s.Nested.Z = 1, 2
+	for _, c := range []*comment.Comment{
+		c, c.Rename(map[string]string{"old-delay": "new-delay"}),
+	} {
+		n := &yaml.Node{}
+		if err := n.Encode(s); err != nil {
+			panic(err)
+		}
+		if err := c.SaveInto(n); err != nil {
+			panic(err)
+		}
+		b, err := yaml.Marshal(n)
+		fmt.Printf("%s%v\n", b, err)
+	}
+	// Output:
+	// # settings of the example
+	//
+	// # first group
+	// name: example # the name
+	// new-delay: 15s
+	// tags:
+	//     - a # first tag
+	//     - b
+	// nested:
+	//     x: 1
+	//     z: 2
+	//     # foot of nested
+	// <nil>
+	// # settings of the example
+	//
+	// # first group
+	// name: example # the name
+	// tags:
+	//     - a # first tag
+	//     - b
+	//
+	// # second group
+	// new-delay: 15s # tuned for slow clients
+	// nested:
+	//     x: 1
+	//     z: 2
+	//     # foot of nested
+	// <nil>
+}
@@ -19,23 +19,27 @@ package config
 
 import (
 	"fmt"
-	"os"
 
-	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/env"
 	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres"
 )
 
 // Load function loads, validates, and normalizes the configuration
 // file and returns its settings as an instance of the Config struct.
-// Given path must belong to a configuration file which conforms with
-// the latest known configuration settings format.
+// Given paths must belong to a base configuration file and its overlay
+// files (if any) which are composed by the ReadFiles function and
+// conform with the latest known configuration settings format.
 // The corresponding database schema version must also match with the
 // latest known database schema version.
-func Load(path string) (*cfg2.Config, error) {
-	data, err := os.ReadFile(path)
+// Settings may be overridden by the CAWEB_* environment variables (see
+// the env package), unlike the configuration files which are loaded by
+// the migrators.
+func Load(paths ...string) (*cfg3.Config, error) {
+	data, _, err := ReadFiles(paths...)
 	if err != nil {
-		return nil, fmt.Errorf("reading config file: %w", err)
+		return nil, err
 	}
 	v, err := vers.Load(data)
 	if err != nil {
@@ -43,7 +47,7 @@ func Load(path string) (*cfg2.Config, error) {
 	}
 	vc := v.Versions
 	switch {
-	case vc.Config != cfg2.Version:
+	case vc.Config != cfg3.Version:
 		return nil, fmt.Errorf(
 			"unexpected config version: %s", vc.Config.String(),
 		)
@@ -53,9 +57,9 @@ func Load(path string) (*cfg2.Config, error) {
 			vc.Database.String(),
 		)
 	}
-	c, err := cfg2.Load(data)
+	c, err := cfg3.LoadWithEnv(data, env.LookupOS)
 	if err != nil {
-		return nil, fmt.Errorf("loading cfg2.Config: %w", err)
+		return nil, fmt.Errorf("loading cfg3.Config: %w", err)
 	}
 	return c, nil
 }
@@ -0,0 +1,49 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/env"
+)
+
+// TestLoadOverridesByEnv checks that the runtime configuration is
+// overridden by the CAWEB_* environment variables, while the cfg3.Load
+// function (which is used by the migrators) keeps the file contents.
+func TestLoadOverridesByEnv(t *testing.T) {
+	t.Setenv("CAWEB_DATABASE_PORT", "6543")
+	path := filepath.Join("testdata", "cfg3.yaml")
+	c, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("loading %q: %v", path, err)
+	}
+	if p := c.Database.Port; p != 6543 {
+		t.Errorf("runtime port is %d, expected 6543", p)
+	}
+	if src := c.Sources["database.port"]; src != env.Env {
+		t.Errorf("runtime port source is %q, expected %q", src, env.Env)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading %q: %v", path, err)
+	}
+	c, err = cfg3.Load(data)
+	if err != nil {
+		t.Fatalf("cfg3.Load(%q): %v", path, err)
+	}
+	if p := c.Database.Port; p != 5456 {
+		t.Errorf("file port is %d, expected 5456", p)
+	}
+	if c.Sources != nil {
+		t.Errorf("file sources are %v, expected nil", c.Sources)
+	}
+}
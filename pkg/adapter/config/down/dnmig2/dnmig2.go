@@ -24,6 +24,7 @@ import (
 	"github.com/momeni/clean-arch/pkg/adapter/config/down/dnmig1"
 	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
 	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
+	"github.com/momeni/clean-arch/pkg/core/log"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
 )
@@ -91,6 +92,26 @@ func (a Adapter) MigrateDown(ctx context.Context) (
 	return dnmig1.Adapt(m), nil
 }
 
+// Renames maps the paths of the yaml keys which are renamed by the
+// downwards migration to their old paths in the major version 1, so
+// their comments can be carried by the comment.Comment Rename method.
+var Renames = map[string]string{
+	v2DelayPath:    v1DelayPath,
+	v2MinDelayPath: v1MinDelayPath,
+	v2MaxDelayPath: v1MaxDelayPath,
+}
+
+// These constants are the yaml paths of the old parking method delay
+// setting and its boundary values in the major versions 1 and 2.
+const (
+	v1DelayPath    = "usecases.cars.old-parking-method-delay"
+	v1MinDelayPath = "usecases.cars.old-parking-method-delay-minimum"
+	v1MaxDelayPath = "usecases.cars.old-parking-method-delay-maximum"
+	v2DelayPath    = "usecases.cars.delay-of-old-parking-method"
+	v2MinDelayPath = "usecases.cars.delay-of-old-parking-method-minimum"
+	v2MaxDelayPath = "usecases.cars.delay-of-old-parking-method-maximum"
+)
+
 // Migrator is a downwards Config migrator for *cfg2.Config instances.
 // It wraps a Config struct (with major version 2) and implements
 // the repo.Settler and pkg/adapter/config/settings.DownMigrator
@@ -102,11 +123,15 @@ type Migrator struct {
 // MigrateDown creates a *cfg1.Config instance and fills it with the
 // settings which are kept in `m.Config` fields. Any field which its
 // value may not be computed based on the settings which are available
-// in this version will be left uninitialized. The computed Config
-// instance with major version 1 will be wrapped by its corresponding
-// downwards migrator before being returned.
+// in this version will be left uninitialized. The comments are carried
+// too, so settings which keep their yaml keys also keep their comments,
+// while comments of the renamed keys are moved based on the Renames.
+// The computed Config instance with major version 1 will be wrapped
+// by its corresponding downwards migrator before being returned.
+// The secret settings have no counterpart in the major version 1, so
+// they are dropped (with a warning) during the downwards migration.
 func (m *Migrator) MigrateDown(
-	_ context.Context,
+	ctx context.Context,
 ) (*dnmig1.Migrator, error) {
 	c := &cfg1.Config{
 		Database: m.Config.Database,
@@ -116,6 +141,7 @@ func (m *Migrator) MigrateDown(
 				Config:   cfg1.Version,
 			},
 		},
+		Comments: m.Config.Comments.Rename(Renames),
 	}
 	settings.OverwriteNil(&c.Gin.Logger, m.Config.Gin.Logger)
 	settings.OverwriteNil(&c.Gin.Recovery, m.Config.Gin.Recovery)
@@ -123,6 +149,20 @@ func (m *Migrator) MigrateDown(
 		&c.Usecases.Cars.OldParkingDelay,
 		m.Config.Usecases.Cars.DelayOfOPM,
 	)
+	settings.OverwriteNil(
+		&c.Usecases.Cars.MinOldParkingDelay,
+		m.Config.Usecases.Cars.MinDelayOfOPM,
+	)
+	settings.OverwriteNil(
+		&c.Usecases.Cars.MaxOldParkingDelay,
+		m.Config.Usecases.Cars.MaxDelayOfOPM,
+	)
+	if m.Config.Usecases.Cars.APIKeyOfOPM != nil {
+		log.Warn(
+			ctx, "secret api key of opm is dropped by downwards migration",
+			log.Valuer("api-key", m.Config.Usecases.Cars.APIKeyOfOPM),
+		)
+	}
 	return &dnmig1.Migrator{c}, nil
 }
 
@@ -0,0 +1,157 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package dnmig3 provides configuration file settings downwards
+// Migrator for settings major version 3 and its Adapter type for the
+// version-independent repo.DownMigrator[migrationuc.Settings]
+// interface.
+//
+// This package provides the main logic for converting settings with
+// major version 3 format to major version 2.
+//
+// The settings.DownMigrator generic interface is employed in order to
+// ensure that this version-specific implementation uses consistent
+// types as its method return types.
+package dnmig3
+
+import (
+	"context"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/down/dnmig2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
+	"github.com/momeni/clean-arch/pkg/core/log"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
+)
+
+// These type aliases specify the underlying Config struct (with major
+// version 3) as C and the parameterized settings.DownMigrator interface
+// which is supposed to be implemented by the Migrator struct as Type.
+// The Type uses the *dnmig2.Migrator as its older/downer counterpart.
+type (
+	// C is the underlying Config type
+	C = *cfg3.Config
+	// S is the Serializable struct type containing mutable settings
+	S = cfg3.Serializable
+	// Type is implemented by the Migrator type
+	Type = settings.DownMigrator[C, S, *dnmig2.Migrator]
+)
+
+// Adapter wraps and adapts an instance of Type in order to provide
+// the repo.DownMigrator[migrationuc.Settings] interface.
+type Adapter struct {
+	T Type
+}
+
+// NewDnMig creates a Migrator struct wrapping the given Config instance
+// and then uses the Adapt function in order to adapt it to the version
+// independent repo.DownMigrator[migrationuc.Settings] interface.
+//
+// The Migrator struct is exported and users which need a concrete type
+// can create it directly and wrap the `c` instance. This helper New
+// function is provided in order to combine these two steps (of creation
+// and adaptation) together.
+func NewDnMig(c *cfg3.Config) repo.DownMigrator[migrationuc.Settings] {
+	m := &Migrator{c}
+	return Adapt(m)
+}
+
+// Adapt creates an instance of Adapter struct wraping the `m` argument.
+// Because Adapter expects to wrap a Type instance, it asserts that
+// Migrator struct implements the Type interface, its implementation is
+// correct (considering the expected return types), and provides the
+// repo.DownMigrator[migrationuc.Settings] interface.
+func Adapt(m *Migrator) repo.DownMigrator[migrationuc.Settings] {
+	return Adapter{m}
+}
+
+// Settler calls the wrapped Type Settler method, obtains a C instance,
+// and wraps it by settings.Adapter[C, S] in order to expose an instance
+// of migrationuc.Settings interface.
+func (a Adapter) Settler() migrationuc.Settings {
+	c := a.T.Settler()
+	return settings.Adapter[C, S]{c}
+}
+
+// MigrateDown calls the wrapped Type MigrateDown method, obtains the
+// next downwards migrator object, and adapts it to the version
+// independent repo.DownMigrator[migrationuc.Settings] interface using
+// the Adapt function.
+func (a Adapter) MigrateDown(ctx context.Context) (
+	repo.DownMigrator[migrationuc.Settings], error,
+) {
+	m, err := a.T.MigrateDown(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return dnmig2.Adapt(m), nil
+}
+
+// Migrator is a downwards Config migrator for *cfg3.Config instances.
+// It wraps a Config struct (with major version 3) and implements
+// the repo.Settler and pkg/adapter/config/settings.DownMigrator
+// generic interfaces.
+type Migrator struct {
+	*cfg3.Config
+}
+
+// MigrateDown creates a *cfg2.Config instance and fills it with the
+// settings which are kept in `m.Config` fields. All settings of the
+// major version 2 are kept with the same format and yaml keys in this
+// version, so they are copied (and renewed, so both Config instances
+// stay independent) as they are. The comments are carried too.
+// The computed Config instance with major version 2 will be wrapped
+// by its corresponding downwards migrator before being returned.
+// The server settings have no counterpart in the major version 2, so
+// they are dropped during the downwards migration and the web server
+// will listen on its default address again.
+// Similarly, the database password-source setting is dropped, so the
+// passwords are expected to be found in the .pgpass file again. If a
+// non-default password source was configured, a warning is logged.
+// The secret settings are copied in plaintext (as they were opened by
+// the source Encryption key), so they are sealed using the target key
+// after the MergeConfig method replaces the Encryption settings.
+func (m *Migrator) MigrateDown(
+	ctx context.Context,
+) (*dnmig2.Migrator, error) {
+	cc := m.Config.Clone()
+	if ps := cc.Database.PasswordSource; ps != nil &&
+		ps.Kind != nil && ps.Kind.Text() != cfg3.PgPassSource {
+		log.Warn(
+			ctx, "database password-source is dropped by downwards migration",
+			log.String("kind", ps.Kind.Text()),
+		)
+	}
+	db := cc.Database.Database
+	db.UseSecretProvider(nil)
+	c := &cfg2.Config{
+		Database:   db,
+		Gin:        cc.Gin,
+		Usecases:   cc.Usecases,
+		Encryption: cc.Encryption,
+		Policies:   cc.Policies,
+		Vers: vers.Config{
+			Versions: vers.Versions{
+				Database: cc.Vers.Versions.Database,
+				Config:   cfg2.Version,
+			},
+		},
+		Comments: cc.Comments,
+	}
+	return &dnmig2.Migrator{c}, nil
+}
+
+// Settler returns the wrapped Config object. After migrating from a
+// source Config version downwards and reaching to an ultimate version,
+// this method reveals the final migrated Config object.
+// This object may have some uninitialized settings too. The MergeConfig
+// method may be used in order to fill them from another Config instance
+// containing the default settings for major version 3.
+func (m Migrator) Settler() *cfg3.Config {
+	return m.Config
+}
@@ -0,0 +1,348 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package env provides an overriding layer which allows environment
+// variables to replace the settings of a parsed YAML configuration file
+// before it is decoded into a cfgN.Config struct. Each setting is
+// addressed by its path of yaml keys, so CAWEB_DATABASE_HOST overrides
+// the host key of the database mapping. The acceptable paths are taken
+// from the yaml tags of the Config struct (see the Leaves function),
+// so settings which are missing from the configuration file may be
+// overridden too and an override is type-checked by the same yaml and
+// text unmarshalers which are used for decoding the file itself.
+//
+// The Override function also reports the final source of every setting
+// (the configuration file, an environment variable, or its default
+// value), so the effective configuration can be explained to operators.
+package env
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix is prepended to the names of all environment variables which
+// may override the configuration settings, so they do not collide with
+// other environment variables (such as HOME or PATH).
+const Prefix = "CAWEB"
+
+// LookupFunc looks up the `key` environment variable and returns its
+// value, also reporting if that variable was present at all. The
+// os.LookupEnv function is the default implementation, while tests may
+// provide alternative implementations instead of changing the process
+// environment variables.
+type LookupFunc func(key string) (string, bool)
+
+// LookupOS is the LookupFunc which reads the process environment.
+var LookupOS LookupFunc = os.LookupEnv
+
+// Source indicates where the effective value of a setting came from.
+type Source string
+
+// These constants list the possible sources of settings.
+const (
+	// File indicates that a setting was read from the YAML file.
+	File Source = "file"
+
+	// Env indicates that a setting was overridden by an environment
+	// variable.
+	Env Source = "env"
+
+	// Default indicates that a setting was not given, so it takes its
+	// default value (which may be assigned by a ValidateAndNormalize
+	// method or may be the zero value of its type).
+	Default Source = "default"
+)
+
+// Sources maps the path of each setting (having its yaml keys separated
+// by a dot character, e.g., "database.host") to its Source.
+type Sources map[string]Source
+
+// Paths returns the paths of all settings in `s` in a sorted order, so
+// they can be reported deterministically.
+func (s Sources) Paths() []string {
+	paths := make([]string, 0, len(s))
+	for p := range s {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Leaf describes one overridable setting of a configuration struct.
+type Leaf struct {
+	// Keys lists the yaml keys from the root mapping to this setting.
+	Keys []string
+
+	// Type is the Go type of this setting (e.g., *settings.Duration)
+	// which is used for type-checking the overriding values.
+	Type reflect.Type
+}
+
+// Path returns the yaml keys of `l` separated by a dot character.
+func (l Leaf) Path() string {
+	return strings.Join(l.Keys, ".")
+}
+
+// Var returns the name of the environment variable which overrides the
+// `l` setting. It consists of the Prefix and the yaml keys in upper
+// case, separated by underscores (while dashes are replaced by
+// underscores too), e.g., CAWEB_DATABASE_PASS_DIR for pass-dir.
+func (l Leaf) Var() string {
+	parts := append([]string{Prefix}, l.Keys...)
+	s := strings.ToUpper(strings.Join(parts, "_"))
+	return strings.ReplaceAll(s, "-", "_")
+}
+
+var (
+	yamlUnmarshaler = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	textUnmarshaler = reflect.TypeOf(
+		(*encoding.TextUnmarshaler)(nil),
+	).Elem()
+)
+
+// Leaves lists the overridable settings of the `t` struct type (or
+// a pointer to it) by walking over its exported fields recursively,
+// following the naming rules of the yaml package. That is, the key of
+// a field is taken from its yaml tag or is its lowercased name, fields
+// with a "-" tag are ignored, and fields with an ",inline" flag are
+// flattened. Types which implement the yaml.Unmarshaler or the
+// encoding.TextUnmarshaler interfaces, slices, maps, and primitive types
+// are leaves, while other structs are traversed recursively.
+// The top-level keys which are listed in `skip` are not traversed.
+// An error is returned if two leaves are mapped to the same environment
+// variable name (e.g., because of the "a-b" and "a_b" keys).
+func Leaves(t reflect.Type, skip ...string) ([]Leaf, error) {
+	var leaves []Leaf
+	walk(t, nil, &leaves)
+	vars := make(map[string]string, len(leaves))
+	filtered := leaves[:0]
+	for _, l := range leaves {
+		if contains(skip, l.Keys[0]) {
+			continue
+		}
+		v := l.Var()
+		if p, found := vars[v]; found {
+			return nil, fmt.Errorf(
+				"%q and %q are both mapped to %s", p, l.Path(), v,
+			)
+		}
+		vars[v] = l.Path()
+		filtered = append(filtered, l)
+	}
+	return filtered, nil
+}
+
+func contains(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func walk(t reflect.Type, keys []string, leaves *[]Leaf) {
+	et := t
+	for et.Kind() == reflect.Pointer {
+		et = et.Elem()
+	}
+	pt := reflect.PointerTo(et)
+	if et.Kind() != reflect.Struct ||
+		pt.Implements(yamlUnmarshaler) ||
+		pt.Implements(textUnmarshaler) {
+		*leaves = append(*leaves, Leaf{
+			Keys: append([]string(nil), keys...),
+			Type: t,
+		})
+		return
+	}
+	for i := 0; i < et.NumField(); i++ {
+		f := et.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, flags, _ := strings.Cut(tag, ",")
+		if strings.Contains(flags, "inline") {
+			walk(f.Type, keys, leaves)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		walk(f.Type, append(keys, name), leaves)
+	}
+}
+
+// Override replaces the settings of the `doc` YAML document node (as
+// parsed by the yaml.Unmarshal function) with the values of their
+// environment variables (as found by the `lookup` function), so the
+// updated `doc` may be decoded into a `t` struct. The overridable
+// settings and their environment variable names are computed by the
+// Leaves function (ignoring the `skip` top-level keys).
+//
+// Each overriding value is type-checked by decoding it into a new
+// instance of its setting type, so an unacceptable value is reported
+// with the name of its environment variable. Slices and maps should be
+// given in the YAML flow syntax (e.g., "[1s, 2s]"), while other values
+// are taken as plain scalars. Missing mapping nodes are created, so
+// settings which are not mentioned by the YAML file can be overridden.
+//
+// The returned Sources reports the source of all settings, including
+// those which are not overridden.
+func Override(
+	doc *yaml.Node, t reflect.Type, lookup LookupFunc, skip ...string,
+) (Sources, error) {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 {
+		return nil, errors.New("expected a yaml document node")
+	}
+	leaves, err := Leaves(t, skip...)
+	if err != nil {
+		return nil, fmt.Errorf("listing settings: %w", err)
+	}
+	root := doc.Content[0]
+	srcs := make(Sources, len(leaves))
+	for _, l := range leaves {
+		v, found := lookup(l.Var())
+		if !found {
+			srcs[l.Path()] = Default
+			if n := Find(root, l.Keys); n != nil && n.ShortTag() != "!!null" {
+				srcs[l.Path()] = File
+			}
+			continue
+		}
+		n, err := valueNode(l, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l.Var(), err)
+		}
+		if err := set(root, l.Keys, n); err != nil {
+			return nil, fmt.Errorf("%s: %w", l.Var(), err)
+		}
+		srcs[l.Path()] = Env
+	}
+	return srcs, nil
+}
+
+// valueNode converts the `v` environment variable value into a yaml
+// node and ensures that it can be decoded as the setting type of `l`.
+func valueNode(l Leaf, v string) (*yaml.Node, error) {
+	et := l.Type
+	for et.Kind() == reflect.Pointer {
+		et = et.Elem()
+	}
+	n := &yaml.Node{Kind: yaml.ScalarNode, Value: v}
+	switch {
+	case et.Kind() == reflect.Slice || et.Kind() == reflect.Map:
+		doc := &yaml.Node{}
+		if err := yaml.Unmarshal([]byte(v), doc); err != nil {
+			return nil, fmt.Errorf("parsing yaml: %w", err)
+		}
+		if len(doc.Content) != 1 {
+			return nil, errors.New("expected a yaml sequence or mapping")
+		}
+		n = doc.Content[0]
+	case et.Kind() == reflect.String:
+		// strings are kept as-is, even if they look like a bool or null
+		n.Tag = "!!str"
+	}
+	if err := n.Decode(reflect.New(l.Type).Interface()); err != nil {
+		return nil, typeError(err)
+	}
+	return n, nil
+}
+
+// typeError drops the line numbers from the messages of the `err` error
+// if it is a *yaml.TypeError, because they refer to the lines of an
+// environment variable value (or line 0 for scalars) instead of the
+// configuration file. Its caller reports the variable name instead.
+func typeError(err error) error {
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		return err
+	}
+	msgs := make([]string, 0, len(te.Errors))
+	for _, m := range te.Errors {
+		if prefix, rest, found := strings.Cut(m, ": "); found &&
+			strings.HasPrefix(prefix, "line ") {
+			m = rest
+		}
+		msgs = append(msgs, m)
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// Find returns the value node of the `keys` path in the `root` mapping
+// node, or nil if that path does not exist. It may be used for finding
+// the line number of a setting, given its Leaf.Keys or its dot separated
+// path (after being split), in a parsed configuration file.
+func Find(root *yaml.Node, keys []string) *yaml.Node {
+	n := root
+	for _, k := range keys {
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == k {
+				next = n.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return n
+}
+
+// set stores the `v` value node as the value of the `keys` path in the
+// `root` mapping node, creating the missing mapping nodes (or replacing
+// the null nodes, e.g., an empty "encryption:" key) as required.
+func set(root *yaml.Node, keys []string, v *yaml.Node) error {
+	n := root
+	for i, k := range keys {
+		if n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null" {
+			*n = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		if n.Kind != yaml.MappingNode {
+			return fmt.Errorf(
+				"%q is not a mapping", strings.Join(keys[:i], "."),
+			)
+		}
+		var next *yaml.Node
+		for j := 0; j+1 < len(n.Content); j += 2 {
+			if n.Content[j].Value == k {
+				next = n.Content[j+1]
+				break
+			}
+		}
+		if next == nil {
+			next = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+			n.Content = append(n.Content, &yaml.Node{
+				Kind: yaml.ScalarNode, Tag: "!!str", Value: k,
+			}, next)
+		}
+		if i == len(keys)-1 {
+			v.HeadComment = next.HeadComment
+			v.LineComment = next.LineComment
+			*next = *v
+			return nil
+		}
+		n = next
+	}
+	return nil
+}
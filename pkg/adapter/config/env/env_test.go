@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package env_test
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/env"
+	"gopkg.in/yaml.v3"
+)
+
+const sampleConfig = `
+database:
+    host: 127.0.0.1
+    # port of the DBMS
+    port: 5456
+    name: caweb1_3_0
+gin:
+    logger: true
+encryption:
+usecases:
+    cars:
+        delay-of-old-parking-method: 15s
+versions:
+    database: 1.3.0
+    config: 2.2.0
+`
+
+func lookupIn(m map[string]string) env.LookupFunc {
+	return func(key string) (string, bool) {
+		v, ok := m[key]
+		return v, ok
+	}
+}
+
+func ExampleOverride() {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(sampleConfig), doc); err != nil {
+		panic(err)
+	}
+	srcs, err := env.Override(
+		doc, reflect.TypeOf(cfg2.Config{}), lookupIn(map[string]string{
+			"CAWEB_DATABASE_PORT":       "6543",
+			"CAWEB_DATABASE_PASS_DIR":   "null",
+			"CAWEB_GIN_LOGGER":          "false",
+			"CAWEB_ENCRYPTION_KEY_FILE": "/run/secrets/settings.key",
+			"CAWEB_BOUNDS_POLICIES_API": "warn",
+			"CAWEB_VERSIONS_CONFIG":     "1.0.0",
+			"CAWEB_USECASES_CARS_DELAY_OF_OLD_PARKING_METHOD_CONSTRAINTS_ENUM": "[1s, 15s]",
+		}), "versions",
+	)
+	fmt.Println(err)
+	c := &cfg2.Config{}
+	fmt.Println(doc.Decode(c))
+	fmt.Println(c.Database.Host, c.Database.Port, c.Database.PassDir)
+	fmt.Println(*c.Gin.Logger, c.Encryption.KeyFile, c.Policies.API)
+	fmt.Println(c.Usecases.Cars.DelayOfOPMConstraints.Enum)
+	fmt.Println(c.Vers.Versions.Config)
+	for _, p := range []string{
+		"database.host", "database.port", "database.role-suffix",
+		"encryption.key-file", "usecases.cars.delay-of-old-parking-method",
+	} {
+		fmt.Println(p, srcs[p])
+	}
+	_, found := srcs["versions.config"]
+	fmt.Println(found)
+	b, err := yaml.Marshal(doc.Content[0].Content[1])
+	fmt.Print(string(b), err, "\n")
+
+	for _, kv := range [][2]string{
+		{"CAWEB_DATABASE_PORT", "abc"},
+		{"CAWEB_USECASES_CARS_DELAY_OF_OLD_PARKING_METHOD", "15"},
+		{"CAWEB_BOUNDS_POLICIES", "clamp"},
+	} {
+		doc := &yaml.Node{}
+		if err := yaml.Unmarshal([]byte(sampleConfig), doc); err != nil {
+			panic(err)
+		}
+		_, err := env.Override(
+			doc, reflect.TypeOf(cfg2.Config{}),
+			lookupIn(map[string]string{kv[0]: kv[1]}), "versions",
+		)
+		fmt.Println(err)
+	}
+	// Output:
+	// <nil>
+	// <nil>
+	// 127.0.0.1 6543 null
+	// false /run/secrets/settings.key warn
+	// [1000000000 15000000000]
+	// 2.2.0
+	// database.host file
+	// database.port env
+	// database.role-suffix default
+	// encryption.key-file env
+	// usecases.cars.delay-of-old-parking-method file
+	// false
+	// host: 127.0.0.1
+	// # port of the DBMS
+	// port: 6543
+	// name: caweb1_3_0
+	// pass-dir: "null"
+	// <nil>
+	// CAWEB_DATABASE_PORT: cannot unmarshal !!str `abc` into int
+	// CAWEB_USECASES_CARS_DELAY_OF_OLD_PARKING_METHOD: time: missing unit in duration "15"
+	// <nil>
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config_test
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg1"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// TestMigrationRoundTrips migrates the testdata configuration files
+// upwards and downwards (without any database connection) and compares
+// the results with their golden files. The v1 file has line and foot
+// comments and a renamed setting (alongside its renamed boundary
+// values), so it checks that comments survive the migrations and the
+// renaming of their keys (while its round-trip
+// adds the default auth-method setting). Since the major version 2 has
+// no setting which is missing in the major version 3, a v2 file must
+// be reproduced after a round-trip, while the server settings of a v3
+// file are lost by a round-trip.
+// Run `go test -run TestMigrationRoundTrips -update` in order to write
+// the golden files again after an intentional format change.
+func TestMigrationRoundTrips(t *testing.T) {
+	for _, tc := range []struct {
+		src       string
+		ver, back model.SemVer
+		identical bool
+	}{
+		{"cfg1", cfg2.Version, cfg1.Version, false},
+		{"cfg2", cfg3.Version, cfg2.Version, true},
+		{"cfg3", cfg2.Version, cfg3.Version, false},
+	} {
+		t.Run(tc.src, func(t *testing.T) {
+			src := filepath.Join("testdata", tc.src+".yaml")
+			there := filepath.Join("testdata", tc.src+"-there.golden.yaml")
+			back := filepath.Join("testdata", tc.src+"-back.golden.yaml")
+			got := migrateGolden(t, src, tc.ver, there)
+			got = migrateGolden(t, there, tc.back, back)
+			want, err := os.ReadFile(src)
+			if err != nil {
+				t.Fatalf("reading %q: %v", src, err)
+			}
+			if same := bytes.Equal(got, want); same != tc.identical {
+				t.Errorf(
+					"round-trip of %q reproduced it: %v, expected: %v",
+					src, same, tc.identical,
+				)
+			}
+		})
+	}
+}
+
+// migrateGolden migrates the `src` configuration file to the `ver`
+// version, compares the result with the `golden` file (or writes it
+// if the -update flag is given), and returns the migrated contents.
+func migrateGolden(
+	t *testing.T, src string, ver model.SemVer, golden string,
+) []byte {
+	t.Helper()
+	mig, err := config.LoadMigrator(src)
+	if err != nil {
+		t.Fatalf("loading migrator of %q: %v", src, err)
+	}
+	dst := filepath.Join(t.TempDir(), "dst.yaml")
+	mcuc := migrationuc.NewMigrateConfig(mig, ver, nil, dst)
+	if err := mcuc.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrating %q to %s: %v", src, ver.String(), err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("reading migrated %q: %v", src, err)
+	}
+	if *update {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatalf("updating %q: %v", golden, err)
+		}
+		return got
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("reading %q: %v", golden, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf(
+			"migrating %q to %s:\ngot:\n%s\nwant:\n%s",
+			src, ver.String(), got, want,
+		)
+	}
+	return got
+}
+
+// TestMigrationIgnoresEnv migrates the testdata configuration files
+// while some CAWEB_* environment variables are set, ensuring that the
+// migrated files are produced from the file contents only. Otherwise,
+// the environment of the migrating process would be persisted in them.
+func TestMigrationIgnoresEnv(t *testing.T) {
+	if *update {
+		t.Skip("golden files are updated by TestMigrationRoundTrips")
+	}
+	envs := map[string]string{
+		"CAWEB_DATABASE_HOST": "db.example.com",
+		"CAWEB_GIN_LOGGER":    "false",
+		"CAWEB_USECASES_CARS_DELAY_OF_OLD_PARKING_METHOD": "3s",
+		"CAWEB_USECASES_CARS_OLD_PARKING_METHOD_DELAY":    "3s",
+	}
+	for k, v := range envs {
+		t.Setenv(k, v)
+	}
+	for _, tc := range []struct {
+		src string
+		ver model.SemVer
+	}{
+		{"cfg1", cfg2.Version},
+		{"cfg2", cfg3.Version},
+		{"cfg3", cfg2.Version},
+	} {
+		t.Run(tc.src, func(t *testing.T) {
+			src := filepath.Join("testdata", tc.src+".yaml")
+			there := filepath.Join("testdata", tc.src+"-there.golden.yaml")
+			got := migrateGolden(t, src, tc.ver, there)
+			for _, v := range []string{"db.example.com", "3s"} {
+				if bytes.Contains(got, []byte(v)) {
+					t.Errorf("migrated %q contains %q", src, v)
+				}
+			}
+		})
+	}
+}
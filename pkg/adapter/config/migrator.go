@@ -9,23 +9,26 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 
 	"github.com/momeni/clean-arch/pkg/adapter/config/cfg1"
 	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
 	"github.com/momeni/clean-arch/pkg/adapter/config/down/dnmig1"
 	"github.com/momeni/clean-arch/pkg/adapter/config/down/dnmig2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/down/dnmig3"
 	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
 	"github.com/momeni/clean-arch/pkg/adapter/config/up/upmig1"
 	"github.com/momeni/clean-arch/pkg/adapter/config/up/upmig2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/up/upmig3"
 	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
 )
 
 // LoadSrcMigrator reads a config file which is stored at the given
-// `path` argument, examines its stored contents format version, and
-// creates a migrator object wrapping the read file contents.
+// `paths` argument (a base file and its overlays which are composed by
+// the ReadFiles function), examines its stored contents format version,
+// and creates a migrator object wrapping the read file contents.
 // This function is similar to the LoadMigrator function with this
 // difference that loaded configuration settings may be overridden by
 // their corresponding values from the source database.
@@ -35,12 +38,12 @@ import (
 // version) because changes in the version components should be applied
 // through a migration process and database version may not change
 // without updating the configuration file version at the same time.
-func LoadSrcMigrator(path string) (
+func LoadSrcMigrator(paths ...string) (
 	repo.Migrator[migrationuc.Settings], error,
 ) {
-	data, err := os.ReadFile(path)
+	data, _, err := ReadFiles(paths...)
 	if err != nil {
-		return nil, fmt.Errorf("reading config file: %w", err)
+		return nil, err
 	}
 	v, err := vers.Load(data)
 	if err != nil {
@@ -52,6 +55,7 @@ func LoadSrcMigrator(path string) (
 		return &Migrator[*cfg1.Config, cfg1.Serializable]{
 			data:    data,
 			loader:  cfg1.LoadFromDB,
+			prober:  cfg1.ProbeFromDB,
 			upmiger: upmig1.NewUpMig,
 			dnmiger: dnmig1.NewDnMig,
 		}, nil
@@ -59,17 +63,27 @@ func LoadSrcMigrator(path string) (
 		return &Migrator[*cfg2.Config, cfg2.Serializable]{
 			data:    data,
 			loader:  cfg2.LoadFromDB,
+			prober:  cfg2.ProbeFromDB,
 			upmiger: upmig2.NewUpMig,
 			dnmiger: dnmig2.NewDnMig,
 		}, nil
+	case 3:
+		return &Migrator[*cfg3.Config, cfg3.Serializable]{
+			data:    data,
+			loader:  cfg3.LoadFromDB,
+			prober:  cfg3.ProbeFromDB,
+			upmiger: upmig3.NewUpMig,
+			dnmiger: dnmig3.NewDnMig,
+		}, nil
 	default:
 		return nil, fmt.Errorf("unsupported major version: %d", m)
 	}
 }
 
-// LoadMigrator reads a config file which is stored at the given `path`,
-// examines its stored contents format version, and creates a migrator
-// object wrapping the read file contents.
+// LoadMigrator reads a config file which is stored at the given `paths`
+// (a base file and its overlays which are composed by the ReadFiles
+// function), examines its stored contents format version, and creates
+// a migrator object wrapping the read file contents.
 // A migrator object is able to load a specific configuration settings
 // version (assuming that it follows a supported format version), wrap
 // them by corresponding upwards/downwards migrator objects which can
@@ -84,13 +98,24 @@ func LoadSrcMigrator(path string) (
 // structs) in order to expose a common version-independent interface.
 // This common interface is the repo.Migrator[migrationuc.Settings] and
 // it may be passed to the use cases layer too.
-func LoadMigrator(path string) (
+func LoadMigrator(paths ...string) (
 	repo.Migrator[migrationuc.Settings], error,
 ) {
-	data, err := os.ReadFile(path)
+	data, _, err := ReadFiles(paths...)
 	if err != nil {
-		return nil, fmt.Errorf("reading config file: %w", err)
+		return nil, err
 	}
+	return NewMigrator(data)
+}
+
+// NewMigrator is similar to the LoadMigrator function, but it takes the
+// configuration file contents as the `data` byte slice instead of its
+// path. It is useful for loading the configuration settings which are
+// produced in memory (e.g., after being migrated and marshalled) and
+// have no corresponding file.
+func NewMigrator(data []byte) (
+	repo.Migrator[migrationuc.Settings], error,
+) {
 	v, err := vers.Load(data)
 	if err != nil {
 		return nil, fmt.Errorf("loading versions: %w", err)
@@ -111,6 +136,13 @@ func LoadMigrator(path string) (
 			upmiger: upmig2.NewUpMig,
 			dnmiger: dnmig2.NewDnMig,
 		}, nil
+	case 3:
+		return &Migrator[*cfg3.Config, cfg3.Serializable]{
+			data:    data,
+			loader:  noCtxLoad(cfg3.Load),
+			upmiger: upmig3.NewUpMig,
+			dnmiger: dnmig3.NewDnMig,
+		}, nil
 	default:
 		return nil, fmt.Errorf("unsupported major version: %d", m)
 	}
@@ -148,7 +180,9 @@ func noCtxLoad[C any](
 // C type parameter.
 // The `loader` function may be reified by cfgN.Load functions if
 // no DB overriding is required or by cfgN.LoadFromDB functions if
-// the DB overriding is desired.
+// the DB overriding is desired. In the latter case, a `prober` function
+// (i.e., cfgN.ProbeFromDB) may be taken too, so settings can be loaded
+// without side effects when a migration is only planned.
 //
 // Second, an upwards or downwards migrator object should be created.
 // This step is responsible to migrate from the current minor version
@@ -203,6 +237,7 @@ func noCtxLoad[C any](
 type Migrator[C settings.Config[C, S], S any] struct {
 	data    []byte
 	loader  func(ctx context.Context, data []byte) (C, bool, error)
+	prober  func(ctx context.Context, data []byte) (C, bool, error)
 	upmiger func(c C) repo.UpMigrator[migrationuc.Settings]
 	dnmiger func(c C) repo.DownMigrator[migrationuc.Settings]
 
@@ -228,10 +263,35 @@ type Migrator[C settings.Config[C, S], S any] struct {
 // no database contents overriding was desired), however, a non-nil
 // error will be returned too.
 func (m *Migrator[C, S]) Load(ctx context.Context) error {
+	return m.load(ctx, m.loader)
+}
+
+// Probe is similar to the Load method, but it uses the C prober
+// function (if it was provided during the instantiation of the
+// Migrator[C, S] struct) instead of the loader function. A prober
+// function, such as cfgN.ProbeFromDB functions, must not accept a
+// temporary password while connecting to the database, so Probe
+// changes no file.
+// Without a prober function, the loader function is used, which is
+// expected to cause no side effects itself (e.g., cfgN.Load functions
+// which do not connect to a database). This method implements the
+// repo.Prober interface.
+func (m *Migrator[C, S]) Probe(ctx context.Context) error {
+	if m.prober == nil {
+		return m.load(ctx, m.loader)
+	}
+	return m.load(ctx, m.prober)
+}
+
+// load implements the Load and Probe methods using the given `loader`.
+func (m *Migrator[C, S]) load(
+	ctx context.Context,
+	loader func(ctx context.Context, data []byte) (C, bool, error),
+) error {
 	if m.c != nil {
 		return nil
 	}
-	c, configIsReturned, err := m.loader(ctx, m.data)
+	c, configIsReturned, err := loader(ctx, m.data)
 	if configIsReturned {
 		m.c = &c
 	}
@@ -0,0 +1,168 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config"
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
+)
+
+const cfg1Config = `# caweb settings
+database:
+    host: 127.0.0.1
+    port: 5456
+    name: caweb1_0_0
+    # passwords directory
+    pass-dir: /var/lib/caweb/db/caweb1_0_0
+gin:
+    logger: true
+    recovery: false
+usecases:
+    cars:
+        old-parking-method-delay: 10s
+versions:
+    database: 1.0.0
+    config: 1.0.0
+`
+
+func ExampleLoadMigrator() {
+	ctx := context.Background()
+	dir, err := os.MkdirTemp("", "caweb-config-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src.yaml")
+	if err := os.WriteFile(src, []byte(cfg1Config), 0o644); err != nil {
+		panic(err)
+	}
+	up, down := filepath.Join(dir, "up.yaml"), filepath.Join(dir, "down.yaml")
+	for _, m := range []struct {
+		src, dst string
+		ver      model.SemVer
+	}{
+		{src, up, model.SemVer{2, 2, 0}},
+		{up, down, model.SemVer{1, 1, 0}},
+		{src, filepath.Join(dir, "old.yaml"), model.SemVer{2, 0, 0}},
+	} {
+		mig, err := config.LoadMigrator(m.src)
+		if err != nil {
+			panic(err)
+		}
+		mcuc := migrationuc.NewMigrateConfig(mig, m.ver, nil, m.dst)
+		if err := mcuc.Migrate(ctx); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		b, err := os.ReadFile(m.dst)
+		fmt.Print(string(b), err, "\n")
+	}
+	// Output:
+	// # caweb settings
+	// database:
+	//     host: 127.0.0.1
+	//     port: 5456
+	//     name: caweb1_0_0
+	//     # passwords directory
+	//     pass-dir: /var/lib/caweb/db/caweb1_0_0
+	//     auth-method: scram-sha-256
+	// gin:
+	//     logger: true
+	//     recovery: false
+	// usecases:
+	//     cars:
+	//         delay-of-old-parking-method: 10s
+	// versions:
+	//     database: 1.0.0
+	//     config: 2.2.0
+	// <nil>
+	// # caweb settings
+	// database:
+	//     host: 127.0.0.1
+	//     port: 5456
+	//     name: caweb1_0_0
+	//     # passwords directory
+	//     pass-dir: /var/lib/caweb/db/caweb1_0_0
+	//     auth-method: scram-sha-256
+	// gin:
+	//     logger: true
+	//     recovery: false
+	// usecases:
+	//     cars:
+	//         old-parking-method-delay: 10s
+	// versions:
+	//     database: 1.0.0
+	//     config: 1.1.0
+	// <nil>
+	// settings are migrated to 2.2.0, but 2.0.0 was asked
+}
+
+const cfg2Config = `database:
+    host: 127.0.0.1
+    port: 5457
+    name: caweb1_0_0
+    pass-dir: /var/lib/caweb/db/caweb1_0_0
+gin:
+    logger: true
+bounds-policies:
+    api: warn
+usecases:
+    cars:
+        delay-of-old-parking-method: 20s
+        delay-of-old-parking-method-minimum: 1s
+        delay-of-old-parking-method-constraints:
+            enum: [1s, 20s]
+versions:
+    database: 1.0.0
+    config: 2.2.0
+`
+
+func ExampleNewMigrator() {
+	ctx := context.Background()
+	for _, major := range []uint{0, 1} {
+		mig1, err := config.NewMigrator([]byte(cfg1Config))
+		if err != nil {
+			panic(err)
+		}
+		mig2, err := config.NewMigrator([]byte(cfg2Config))
+		if err != nil {
+			panic(err)
+		}
+		dcuc := migrationuc.NewDiffConfig(
+			mig1, mig2, major, config.NewMigrator,
+		)
+		changes, err := dcuc.Diff(ctx)
+		fmt.Println("major:", major, "error:", err)
+		for _, c := range changes {
+			fmt.Printf("%s %s: %q -> %q\n", c.Kind, c.Path, c.Old, c.New)
+		}
+	}
+	// Output:
+	// major: 0 error: <nil>
+	// + bounds-policies.api: "" -> "warn"
+	// + bounds-policies.db: "" -> "clamp"
+	// + bounds-policies.migration: "" -> "clamp"
+	// ~ database.port: "5456" -> "5457"
+	// ~ usecases.cars.delay-of-old-parking-method: "10s" -> "20s"
+	// + usecases.cars.delay-of-old-parking-method-constraints.enum: "" -> "[1s, 20s]"
+	// + usecases.cars.delay-of-old-parking-method-minimum: "" -> "1s"
+	// ~ versions.config: "1.0.0" -> "2.2.0"
+	// major: 1 error: <nil>
+	// !2 bounds-policies.api: "warn" -> ""
+	// !2 bounds-policies.db: "clamp" -> ""
+	// !2 bounds-policies.migration: "clamp" -> ""
+	// !2 usecases.cars.delay-of-old-parking-method-constraints.enum: "[1s, 20s]" -> ""
+	// ~ database.port: "5456" -> "5457"
+	// ~ usecases.cars.old-parking-method-delay: "10s" -> "20s"
+	// + usecases.cars.old-parking-method-delay-minimum: "" -> "1s"
+	// ~ versions.config: "1.0.0" -> "2.2.0"
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
+	"gopkg.in/yaml.v3"
+)
+
+// IncludeKey is the top-level key of the include directive. Its value
+// may be a path or a list of paths (relative to the directory of the
+// including file) which are merged in their given order as the base of
+// the including file, so the including file contents overlay them.
+const IncludeKey = "include"
+
+// ReadFiles reads the `paths` configuration files and composes them as
+// one configuration file contents. The first path is the base file and
+// the next paths are overlays which are deep merged over it in their
+// given order. Mappings are merged recursively, so an overlay only needs
+// to contain the settings which it wants to change, while other values
+// (including the sequences) are replaced as a whole. The include
+// directive of each file is expanded before merging it (see IncludeKey)
+// and including a file recursively is rejected.
+//
+// Files may omit the versions section (e.g., an overlay file which just
+// changes the database host), but all present versions sections must
+// agree with each other. Otherwise, the settings of one file could be
+// interpreted by the format of another version.
+//
+// The head comments of the merged files are kept in the composed data,
+// so they can be loaded by the comment.LoadFrom function (e.g., in
+// order to be preserved when a migration rewrites the target file).
+// Comments of an overlay replace the comments of the same keys in the
+// base file. If only one file is read (i.e., there was no overlay and
+// include), its contents are returned as they are, so line numbers
+// of the returned data match with that file.
+//
+// The second return value lists all read files in their merge order,
+// so callers may log them or report that line numbers belong to the
+// composed data.
+func ReadFiles(paths ...string) (data []byte, files []string, err error) {
+	if len(paths) == 0 {
+		return nil, nil, errors.New("no config file path is given")
+	}
+	r := &composer{}
+	var doc *yaml.Node
+	for _, p := range paths {
+		n, err := r.read(p)
+		if err != nil {
+			return nil, r.files, err
+		}
+		if doc == nil {
+			doc = n
+			continue
+		}
+		if err := merge(doc, n); err != nil {
+			return nil, r.files, fmt.Errorf("overlaying %q: %w", p, err)
+		}
+	}
+	if len(r.files) == 1 && !r.rewritten {
+		return r.data, r.files, nil
+	}
+	data, err = yaml.Marshal(doc)
+	if err != nil {
+		return nil, r.files, fmt.Errorf("marshalling merged yaml: %w", err)
+	}
+	return data, r.files, nil
+}
+
+// composer reads configuration files and expands their includes while
+// keeping track of the read files. The `stack` lists the absolute paths
+// of those files which are being included, so cycles can be detected.
+type composer struct {
+	files []string // read files in their merge order
+	data  []byte   // contents of the last read file
+	stack []string // absolute paths of the files being read
+
+	// rewritten indicates that an include directive was removed, so
+	// the read data may not be used without being marshalled again.
+	rewritten bool
+}
+
+// read reads the `path` file, expands its include directive, and returns
+// the composed document mapping node.
+func (r *composer) read(path string) (*yaml.Node, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("finding absolute path of %q: %w", path, err)
+	}
+	if slices.Contains(r.stack, abs) {
+		return nil, fmt.Errorf("%q is included recursively", path)
+	}
+	r.stack = append(r.stack, abs)
+	defer func() {
+		r.stack = r.stack[:len(r.stack)-1]
+	}()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	n := &yaml.Node{}
+	if err := yaml.Unmarshal(data, n); err != nil {
+		return nil, fmt.Errorf("unmarshalling %q: %w", path, err)
+	}
+	switch {
+	case len(n.Content) == 0: // an empty file
+		n.Kind = yaml.MappingNode
+		n.Tag = "!!map"
+	case len(n.Content) != 1 || n.Content[0].Kind != yaml.MappingNode:
+		return nil, fmt.Errorf("%q must contain one yaml mapping", path)
+	default:
+		n = n.Content[0]
+	}
+	l := len(n.Content)
+	includes, err := removeIncludes(n)
+	if err != nil {
+		return nil, fmt.Errorf("%q: %w", path, err)
+	}
+	r.rewritten = r.rewritten || len(n.Content) != l
+	var base *yaml.Node
+	for _, inc := range includes {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(path), inc)
+		}
+		in, err := r.read(inc)
+		if err != nil {
+			return nil, fmt.Errorf("including in %q: %w", path, err)
+		}
+		if base == nil {
+			base = in
+			continue
+		}
+		if err := merge(base, in); err != nil {
+			return nil, fmt.Errorf("including %q: %w", inc, err)
+		}
+	}
+	r.files = append(r.files, path)
+	r.data = data
+	if base == nil {
+		return n, nil
+	}
+	if err := merge(base, n); err != nil {
+		return nil, fmt.Errorf(
+			"overlaying %q on its includes: %w", path, err,
+		)
+	}
+	return base, nil
+}
+
+// removeIncludes removes the include directive from the `n` mapping
+// node and returns its paths.
+func removeIncludes(n *yaml.Node) ([]string, error) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value != IncludeKey {
+			continue
+		}
+		v := n.Content[i+1]
+		n.Content = slices.Delete(n.Content, i, i+2)
+		var paths []string
+		switch v.Kind {
+		case yaml.ScalarNode:
+			paths = []string{v.Value}
+		case yaml.SequenceNode:
+			if err := v.Decode(&paths); err != nil {
+				return nil, fmt.Errorf(
+					"decoding %s paths: %w", IncludeKey, err,
+				)
+			}
+		default:
+			return nil, fmt.Errorf(
+				"%s must be a path or a list of paths", IncludeKey,
+			)
+		}
+		return paths, nil
+	}
+	return nil, nil
+}
+
+// merge checks that the `dst` and `src` mapping nodes have no
+// disagreeing versions sections and then deep merges `src` into `dst`.
+func merge(dst, src *yaml.Node) error {
+	vd, err := versions(dst)
+	if err != nil {
+		return fmt.Errorf("loading base versions: %w", err)
+	}
+	vs, err := versions(src)
+	if err != nil {
+		return fmt.Errorf("loading versions: %w", err)
+	}
+	if vd != nil && vs != nil && *vd != *vs {
+		return fmt.Errorf(
+			"versions (config %s, database %s) disagree with the "+
+				"base versions (config %s, database %s)",
+			vs.Config.String(), vs.Database.String(),
+			vd.Config.String(), vd.Database.String(),
+		)
+	}
+	mergeMaps(dst, src)
+	return nil
+}
+
+// versions decodes the versions section of the `n` mapping node, or
+// returns nil if `n` has no versions section.
+func versions(n *yaml.Node) (*vers.Versions, error) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value != "versions" {
+			continue
+		}
+		v := &vers.Versions{}
+		if err := n.Content[i+1].Decode(v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return nil, nil
+}
+
+// mergeMaps deep merges the `src` mapping node into the `dst` mapping
+// node. Keys which are only found in `src` are appended to `dst`, nested
+// mappings are merged recursively, and other values are replaced.
+func mergeMaps(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		sk, sv := src.Content[i], src.Content[i+1]
+		j := 0
+		for ; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value == sk.Value {
+				break
+			}
+		}
+		if j+1 >= len(dst.Content) {
+			dst.Content = append(dst.Content, sk, sv)
+			continue
+		}
+		dk, dv := dst.Content[j], dst.Content[j+1]
+		if sk.HeadComment != "" {
+			dk.HeadComment = sk.HeadComment
+		}
+		if dv.Kind == yaml.MappingNode && sv.Kind == yaml.MappingNode {
+			mergeMaps(dv, sv)
+			continue
+		}
+		dst.Content[j+1] = sv
+	}
+}
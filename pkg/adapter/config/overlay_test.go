@@ -0,0 +1,107 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config"
+)
+
+var overlayFiles = map[string]string{
+	"common.yaml": `# shared gin settings
+gin:
+    logger: true
+    recovery: true
+`,
+	"base.yaml": `include: common.yaml
+# database connection
+database:
+    host: 127.0.0.1
+    port: 5456
+    name: caweb1_3_0
+    pass-dir: /var/lib/caweb/db/caweb1_3_0
+server:
+    trusted-proxies: [127.0.0.1]
+versions:
+    database: 1.3.0
+    config: 3.0.0
+`,
+	"prod.yaml": `# production database server
+database:
+    host: db.example.com
+server:
+    trusted-proxies: [10.0.0.0/8]
+    mode: release
+`,
+	"old.yaml": `versions:
+    database: 1.3.0
+    config: 2.1.0
+`,
+	"loop.yaml": `include: [common.yaml, loop.yaml]
+`,
+}
+
+func ExampleReadFiles() {
+	dir, err := os.MkdirTemp("", "caweb-config-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range overlayFiles {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			panic(err)
+		}
+	}
+	for _, names := range [][]string{
+		{"prod.yaml"},
+		{"base.yaml", "prod.yaml"},
+		{"base.yaml", "old.yaml"},
+		{"loop.yaml"},
+	} {
+		paths := make([]string, len(names))
+		for i, name := range names {
+			paths[i] = filepath.Join(dir, name)
+		}
+		data, files, err := config.ReadFiles(paths...)
+		if err != nil {
+			fmt.Println(strings.ReplaceAll(err.Error(), dir+"/", ""))
+			continue
+		}
+		fmt.Printf("%d files:\n%s", len(files), data)
+	}
+	// Output:
+	// 1 files:
+	// # production database server
+	// database:
+	//     host: db.example.com
+	// server:
+	//     trusted-proxies: [10.0.0.0/8]
+	//     mode: release
+	// 3 files:
+	// # shared gin settings
+	// gin:
+	//     logger: true
+	//     recovery: true
+	// # production database server
+	// database:
+	//     host: db.example.com
+	//     port: 5456
+	//     name: caweb1_3_0
+	//     pass-dir: /var/lib/caweb/db/caweb1_3_0
+	// server:
+	//     trusted-proxies: [10.0.0.0/8]
+	//     mode: release
+	// versions:
+	//     database: 1.3.0
+	//     config: 3.0.0
+	// overlaying "old.yaml": versions (config 2.1.0, database 1.3.0) disagree with the base versions (config 3.0.0, database 1.3.0)
+	// including in "loop.yaml": "loop.yaml" is included recursively
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg1"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/schema"
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
+	"github.com/momeni/clean-arch/pkg/core/model"
+)
+
+// durationPattern matches the time.ParseDuration acceptable strings.
+const durationPattern = `^[-+]?(0|([0-9]*(\.[0-9]*)?(ns|us|µs|μs|ms|s|m|h))+)$`
+
+// schemaTypes describes those leaf types which are encoded as strings
+// in the configuration files, but accept a specific format.
+var schemaTypes = map[reflect.Type]schema.Schema{
+	reflect.TypeOf(settings.Duration(0)): {
+		Type: "string", Pattern: durationPattern,
+	},
+	reflect.TypeOf(model.SemVer{}): {
+		Type: "string", Pattern: `^[0-9]+\.[0-9]+\.[0-9]+$`,
+	},
+	reflect.TypeOf(settings.Policy("")): {
+		Type: "string", Enum: []string{
+			string(settings.Clamp),
+			string(settings.Reject),
+			string(settings.Warn),
+		},
+	},
+	reflect.TypeOf(settings.Pattern{}): {Type: "string", Format: "regex"},
+}
+
+// Schema generates the JSON Schema of the configuration file format
+// which has the `major` and `minor` versions, so editors and CI may
+// validate the configuration files of that version. The schema is
+// generated from the yaml tags of the cfgN.Config struct of that major
+// version (see the schema.Generator type), including the boundary
+// values and constraints of the mutable settings and the acceptable
+// values of enumerated settings. The include directive (IncludeKey) is
+// accepted at the top-level too, so overlay files can be validated.
+//
+// The `samples` are the contents of sample configuration files and
+// their comments are used as the descriptions of settings (see the
+// schema.Descriptions function). Samples of other major versions are
+// ignored, so all sample files may be passed regardless of the version.
+func Schema(major, minor uint, samples ...[]byte) (*schema.Schema, error) {
+	var t reflect.Type
+	var latest uint
+	switch major {
+	case 1:
+		t, latest = reflect.TypeOf(cfg1.Config{}), cfg1.Minor
+	case 2:
+		t, latest = reflect.TypeOf(cfg2.Config{}), cfg2.Minor
+	case 3:
+		t, latest = reflect.TypeOf(cfg3.Config{}), cfg3.Minor
+	default:
+		return nil, fmt.Errorf("unsupported major version: %d", major)
+	}
+	if minor > latest {
+		return nil, fmt.Errorf("unsupported minor version: %d", minor)
+	}
+	descs := make(map[string]string)
+	for i, data := range samples {
+		v, err := vers.Load(data)
+		if err != nil {
+			return nil, fmt.Errorf("loading sample #%d versions: %w", i, err)
+		}
+		if v.Versions.Config[0] != major {
+			continue
+		}
+		d, err := schema.Descriptions(data)
+		if err != nil {
+			return nil, fmt.Errorf("describing by sample #%d: %w", i, err)
+		}
+		for path, desc := range d {
+			descs[path] = desc
+		}
+	}
+	g := &schema.Generator{
+		Types: schemaTypes,
+		Paths: map[string]schema.Schema{
+			"database.auth-method": {
+				Type: "string",
+				Enum: cfg1.AuthMethods{}.Values(),
+			},
+			"versions.config": {
+				Type:    "string",
+				Pattern: fmt.Sprintf(`^%d\.%d\.[0-9]+$`, major, minor),
+			},
+		},
+		Descriptions: descs,
+	}
+	title := fmt.Sprintf("caweb configuration file v%d.%d", major, minor)
+	s, err := g.Generate(t, title)
+	if err != nil {
+		return nil, fmt.Errorf("generating schema: %w", err)
+	}
+	s.Properties[IncludeKey] = &schema.Schema{
+		Description: "path or paths of the base configuration files " +
+			"(relative to this file) which are overlaid by this file",
+		OneOf: []schema.Schema{
+			{Type: "string"},
+			{Type: "array", Items: &schema.Schema{Type: "string"}},
+		},
+	}
+	return s, nil
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package schema generates JSON Schema documents for the configuration
+// file formats, so editors and CI pipelines may validate and complete
+// the YAML configuration files. A schema is generated by walking over
+// the yaml tags of a cfgN.Config struct (following the same naming
+// rules which are used by the env package), so it cannot diverge from
+// the actual format of that version. Since the Go types cannot express
+// everything (e.g., the acceptable values of a string setting or the
+// expected format of a duration), the Generator may be told about the
+// schemas of specific types and setting paths too.
+package schema
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Draft is the JSON Schema dialect which is used by generated schemas.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema document (or one of its subschemas), keeping
+// those keywords which are needed for describing a configuration file.
+// It should be encoded by the encoding/json package.
+type Schema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// Type is the JSON type name, e.g., "object", "string", or
+	// "integer". It is empty for subschemas which use OneOf.
+	Type string `json:"type,omitempty"`
+
+	Enum    []string `json:"enum,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+	Format  string   `json:"format,omitempty"`
+	Items   *Schema  `json:"items,omitempty"`
+	OneOf   []Schema `json:"oneOf,omitempty"`
+
+	// Properties describes the keys of an object (i.e., a mapping).
+	Properties map[string]*Schema `json:"properties,omitempty"`
+
+	// AdditionalProperties is false if an object may not have any key
+	// beyond its Properties, or a *Schema which describes the values
+	// of all keys of a map. It is nil if other keys are accepted.
+	AdditionalProperties any `json:"additionalProperties,omitempty"`
+}
+
+// Enumerable is implemented by types which can only take one of a few
+// string values, such as settings.Enum[D], so their values may be
+// listed by the generated schemas.
+type Enumerable interface {
+	// Values lists the acceptable values in their ascending order.
+	Values() []string
+}
+
+// Generator creates the JSON Schema of configuration struct types.
+// Its zero value generates schemas merely based on the Go types, while
+// its fields may provide more precise schemas for some types or paths.
+type Generator struct {
+	// Types maps leaf types (e.g., settings.Duration) to their schemas.
+	// A pointer to a type is looked up by its element type.
+	Types map[reflect.Type]Schema
+
+	// Paths maps the setting paths (having their yaml keys separated
+	// by a dot character, e.g., "database.auth-method") to schemas
+	// which replace the schemas that were generated for their types.
+	// A description which is generated for a path is kept if the
+	// replacing schema has no description itself.
+	Paths map[string]Schema
+
+	// Descriptions maps the setting paths to their descriptions.
+	// See the Descriptions function.
+	Descriptions map[string]string
+}
+
+var (
+	yamlUnmarshaler = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	textUnmarshaler = reflect.TypeOf(
+		(*encoding.TextUnmarshaler)(nil),
+	).Elem()
+	enumerable = reflect.TypeOf((*Enumerable)(nil)).Elem()
+)
+
+// Generate creates the JSON Schema of the `t` struct type (or a pointer
+// to it), using the `title` as its title. The key of each field is taken
+// from its yaml tag or is its lowercased name, fields with a "-" tag are
+// ignored, and fields with an ",inline" flag are flattened, exactly like
+// the yaml package. Structs are described as objects which may not have
+// unknown keys, while types which implement the encoding.TextUnmarshaler
+// interface are described as strings. An error is returned if some
+// field has a type which may not be described (e.g., a channel or a
+// function) and is not listed in the Types or Paths of g.
+func (g *Generator) Generate(
+	t reflect.Type, title string,
+) (*Schema, error) {
+	s, err := g.walk(t, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.Schema = Draft
+	s.Title = title
+	return s, nil
+}
+
+func (g *Generator) walk(t reflect.Type, keys []string) (*Schema, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	path := strings.Join(keys, ".")
+	if ps, ok := g.Paths[path]; ok {
+		if ps.Description == "" {
+			ps.Description = g.Descriptions[path]
+		}
+		return &ps, nil
+	}
+	s, err := g.typeSchema(t, keys)
+	if err != nil {
+		return nil, err
+	}
+	if desc, ok := g.Descriptions[path]; ok {
+		s.Description = desc
+	}
+	return s, nil
+}
+
+// item returns the schema of the `t` items of a sequence or values of
+// a mapping which is found at the `keys` path. Items have no path of
+// their own, so they are only described by their types.
+func (g *Generator) item(t reflect.Type, keys []string) (*Schema, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return g.typeSchema(t, keys)
+}
+
+func (g *Generator) typeSchema(
+	t reflect.Type, keys []string,
+) (*Schema, error) {
+	if s, ok := g.Types[t]; ok {
+		return &s, nil
+	}
+	pt := reflect.PointerTo(t)
+	switch {
+	case t.Implements(enumerable):
+		e := reflect.Zero(t).Interface().(Enumerable)
+		return &Schema{Type: "string", Enum: e.Values()}, nil
+	case pt.Implements(textUnmarshaler):
+		return &Schema{Type: "string"}, nil
+	case pt.Implements(yamlUnmarshaler):
+		return &Schema{}, nil // accepts anything which it may unmarshal
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}, nil
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := g.item(t.Elem(), keys)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		values, err := g.item(t.Elem(), keys)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Struct:
+		s := &Schema{
+			Type:                 "object",
+			Properties:           make(map[string]*Schema),
+			AdditionalProperties: false,
+		}
+		if err := g.fields(t, keys, s.Properties); err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported %v type at %q", t, keys)
+	}
+}
+
+// fields adds the schemas of the exported fields of the `t` struct type
+// into the `props` properties (recursing into its inline fields).
+func (g *Generator) fields(
+	t reflect.Type, keys []string, props map[string]*Schema,
+) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, flags, _ := strings.Cut(tag, ",")
+		if strings.Contains(flags, "inline") {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if err := g.fields(ft, keys, props); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		ks := append(keys[:len(keys):len(keys)], name)
+		s, err := g.walk(f.Type, ks)
+		if err != nil {
+			return err
+		}
+		props[name] = s
+	}
+	return nil
+}
+
+// Descriptions collects the comments of the `data` YAML document as the
+// descriptions of its settings, keyed by their paths (having their yaml
+// keys separated by a dot character). The head comment of a key is
+// preferred and its end of line comment is used otherwise. The comment
+// lines are joined by spaces, after removing their # characters, so
+// the comments of a sample configuration file can describe the settings
+// in a generated schema.
+func Descriptions(data []byte) (map[string]string, error) {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("unmarshalling yaml: %w", err)
+	}
+	descs := make(map[string]string)
+	if len(doc.Content) == 1 {
+		describe(doc.Content[0], nil, descs)
+	}
+	return descs, nil
+}
+
+func describe(n *yaml.Node, keys []string, descs map[string]string) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		kn, vn := n.Content[i], n.Content[i+1]
+		ks := append(keys[:len(keys):len(keys)], kn.Value)
+		cmnt := kn.HeadComment
+		if cmnt == "" {
+			cmnt = kn.LineComment + vn.LineComment
+		}
+		if d := text(cmnt); d != "" {
+			descs[strings.Join(ks, ".")] = d
+		}
+		describe(vn, ks, descs)
+	}
+}
+
+// text converts the `cmnt` yaml comment lines to a plain text, removing
+// their # characters and joining them by spaces. Only the last comment
+// paragraph is kept, since former paragraphs (which are separated by
+// empty lines) are not specific to the commented setting. A trailing
+// new line indicates that the last paragraph was separated from the
+// setting too (e.g., by a "---" document marker).
+func text(cmnt string) string {
+	paras := strings.Split(cmnt+"\n", "\n\n")
+	lines := strings.Split(paras[len(paras)-1], "\n")
+	for i, l := range lines {
+		l = strings.TrimSpace(l)
+		l = strings.TrimSpace(strings.TrimPrefix(l, "#"))
+		lines[i] = l
+	}
+	return strings.TrimSpace(strings.Join(lines, " "))
+}
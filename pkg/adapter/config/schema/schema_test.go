@@ -0,0 +1,106 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package schema_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/schema"
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+)
+
+type levels struct{}
+
+func (levels) Values() []string {
+	return []string{"debug", "info"}
+}
+
+type sample struct {
+	Server struct {
+		Port    int                    `yaml:"port"`
+		Level   *settings.Enum[levels] `yaml:"log-level"`
+		Proxies []string               `yaml:"trusted-proxies,omitempty"`
+	}
+	Internal string `yaml:"-"`
+}
+
+const sampleYAML = `# server settings
+server:
+    port: 8080 # listening port number
+    # the minimum level of logs
+    # which are printed
+    log-level: info
+`
+
+func ExampleGenerator_Generate() {
+	descs, err := schema.Descriptions([]byte(sampleYAML))
+	if err != nil {
+		panic(err)
+	}
+	g := &schema.Generator{
+		Paths: map[string]schema.Schema{
+			"server.port": {Type: "integer", Format: "port"},
+		},
+		Descriptions: descs,
+	}
+	s, err := g.Generate(reflect.TypeOf(sample{}), "sample")
+	if err != nil {
+		panic(err)
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(b))
+	// Output:
+	// {
+	//   "$schema": "https://json-schema.org/draft/2020-12/schema",
+	//   "title": "sample",
+	//   "type": "object",
+	//   "properties": {
+	//     "server": {
+	//       "description": "server settings",
+	//       "type": "object",
+	//       "properties": {
+	//         "log-level": {
+	//           "description": "the minimum level of logs which are printed",
+	//           "type": "string",
+	//           "enum": [
+	//             "debug",
+	//             "info"
+	//           ]
+	//         },
+	//         "port": {
+	//           "description": "listening port number",
+	//           "type": "integer",
+	//           "format": "port"
+	//         },
+	//         "trusted-proxies": {
+	//           "type": "array",
+	//           "items": {
+	//             "type": "string"
+	//           }
+	//         }
+	//       },
+	//       "additionalProperties": false
+	//     }
+	//   },
+	//   "additionalProperties": false
+	// }
+}
+
+func ExampleGenerator_Generate_unsupported() {
+	type hooks struct {
+		OnStart func() `yaml:"on-start"`
+	}
+	g := &schema.Generator{}
+	_, err := g.Generate(reflect.TypeOf(hooks{}), "hooks")
+	fmt.Println(err)
+	// Output:
+	// unsupported func() type at ["on-start"]
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg1"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/env"
+	"github.com/momeni/clean-arch/pkg/adapter/config/schema"
+)
+
+// TestSchemaCoversMarshalled ensures that the generated schema of each
+// config version accepts every setting which may be written by the
+// Marshalled struct of that version, so migrated config files are not
+// rejected by editors which validate them using that schema.
+func TestSchemaCoversMarshalled(t *testing.T) {
+	for _, tc := range []struct {
+		major, minor uint
+		marshalled   reflect.Type
+	}{
+		{cfg1.Major, cfg1.Minor, reflect.TypeOf(cfg1.Marshalled{})},
+		{cfg2.Major, cfg2.Minor, reflect.TypeOf(cfg2.Marshalled{})},
+		{cfg3.Major, cfg3.Minor, reflect.TypeOf(cfg3.Marshalled{})},
+	} {
+		s, err := config.Schema(tc.major, tc.minor)
+		if err != nil {
+			t.Fatalf("config.Schema(%d, %d): %v", tc.major, tc.minor, err)
+		}
+		leaves, err := env.Leaves(tc.marshalled)
+		if err != nil {
+			t.Fatalf("env.Leaves(%v): %v", tc.marshalled, err)
+		}
+		for _, l := range leaves {
+			if !hasProperty(s, l.Keys) {
+				t.Errorf(
+					"v%d.%d schema has no %q property",
+					tc.major, tc.minor, l.Path(),
+				)
+			}
+		}
+	}
+}
+
+func hasProperty(s *schema.Schema, keys []string) bool {
+	for _, k := range keys {
+		p, found := s.Properties[k]
+		if !found {
+			return false
+		}
+		s = p
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PassDir is a Provider which keeps the passwords in the .pgpass file
+// of the Dir directory, following the pgpass format with lines like
+//
+//	host:port:dbname:role:password
+//
+// while empty or `#`-commented lines are ignored. New passwords are
+// written in the .pgpass.new file of the same directory and are moved
+// over the .pgpass file by the Renew finalizer (or by accepting a
+// .pgpass.new candidate if that finalizer was not called).
+type PassDir struct {
+	Dir string // path of the passwords directory
+}
+
+// NewPassDir creates a PassDir provider for the `dir` directory.
+func NewPassDir(dir string) *PassDir {
+	return &PassDir{Dir: dir}
+}
+
+// Lookup returns the password of `k` from the .pgpass file and then
+// the .pgpass.new file (if they have a matching line), so a connection
+// may be established even if a former renewal was not finalized.
+func (pd *PassDir) Lookup(_ context.Context, k Key) ([]Candidate, error) {
+	path := filepath.Join(pd.Dir, ".pgpass")
+	newPath := path + ".new"
+	var cands []Candidate
+	pass, err := ReadPassFile(path, k)
+	if err != nil {
+		err = fmt.Errorf("using %q pass-file: %w", path, err)
+	} else {
+		cands = append(cands, Candidate{
+			Password: pass,
+			Source:   fmt.Sprintf("%q pass-file", path),
+		})
+	}
+	if pass, newErr := ReadPassFile(newPath, k); newErr == nil {
+		cands = append(cands, Candidate{
+			Password: pass,
+			Source:   fmt.Sprintf("%q pass-file", newPath),
+			Accept: func() error {
+				return os.Rename(newPath, path)
+			},
+		})
+	}
+	if len(cands) == 0 {
+		return nil, err
+	}
+	return cands, nil
+}
+
+// Renew writes the `creds` passwords in the .pgpass.new file and
+// returns a finalizer which moves it over the .pgpass file.
+func (pd *PassDir) Renew(_ context.Context, creds []Credential) (
+	finalizer func() error, err error,
+) {
+	path := filepath.Join(pd.Dir, ".pgpass")
+	newPath := path + ".new"
+	var sb strings.Builder
+	for _, c := range creds {
+		fmt.Fprintf(
+			&sb, "%s:%d:%s:%s:%s\n",
+			c.Host, c.Port, c.DBName, c.Role, c.Password,
+		)
+	}
+	if err := os.WriteFile(newPath, []byte(sb.String()), 0o600); err != nil {
+		return nil, fmt.Errorf("writing %q file: %w", newPath, err)
+	}
+	return func() error {
+		return os.Rename(newPath, path)
+	}, nil
+}
+
+// StagedFiles returns the path of the .pgpass.new file, which keeps
+// the renewed passwords of all keys, implementing the Stager interface.
+func (pd *PassDir) StagedFiles(...Key) []string {
+	return []string{filepath.Join(pd.Dir, ".pgpass.new")}
+}
+
+// ReadPassFile reads the `path` pgpass file and returns the password of
+// its first line which matches with the `k` Key.
+func ReadPassFile(path string, k Key) (string, error) {
+	passLines, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading pass-file: %w", err)
+	}
+	prfx := fmt.Sprintf("%s:%d:%s:%s:", k.Host, k.Port, k.DBName, k.Role)
+	for _, line := range strings.Split(string(passLines), "\n") {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if pass, found := strings.CutPrefix(line, prfx); found {
+			if pass != "" {
+				return pass, nil
+			}
+			break
+		}
+	}
+	return "", errors.New("no matching password line")
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package secrets provides the Provider port which is used by the
+// database configuration settings in order to find the passwords of
+// database roles and renew them, independent of where those passwords
+// are kept. Four implementations are provided:
+//
+//  1. PassDir keeps the passwords in a .pgpass file inside a directory
+//     (and its .pgpass.new counterpart during a renewal),
+//  2. Env reads each password from an environment variable,
+//  3. Files reads each password from a file (one file per secret, like
+//     the Kubernetes or Docker secrets),
+//  4. Command runs an external command and takes its stdout as the
+//     password (e.g., for querying a vault).
+//
+// The Env and Command providers are read-only and their Renew methods
+// return ErrReadOnly, so callers may reuse their current passwords.
+// The Files provider does the same when its files may not be written
+// (e.g., because they are mounted read-only).
+//
+// The Env, Files, and Command providers take templates (of a variable
+// name, a file path, or the command arguments) which are expanded for
+// each Key by replacing the {host}, {port}, {database}, and {role}
+// placeholders with their values from that Key. The {ROLE} placeholder
+// is replaced by the upper-case role name (having its non-alphanumeric
+// characters replaced by underscores), so it can be used in the names
+// of environment variables.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/momeni/clean-arch/pkg/core/repo"
+)
+
+// ErrReadOnly is returned (or wrapped) by the Renew method of providers
+// which may not store new passwords.
+var ErrReadOnly = errors.New("secret provider is read-only")
+
+// Key identifies a database role password.
+type Key struct {
+	Host   string    // domain name or IP address of the DBMS server
+	Port   int       // port number of the DBMS server
+	DBName string    // database name, like caweb1_0_0
+	Role   repo.Role // role name (including its suffix, if any)
+}
+
+// Credential is a password and its identifying Key.
+type Credential struct {
+	Key
+	Password string
+}
+
+// Candidate is a password which may be tried for connecting to the
+// database. A provider may report multiple candidates for one Key, e.g.,
+// because a renewal was interrupted before its finalization.
+type Candidate struct {
+	// Password is the candidate password value.
+	Password string
+
+	// Source describes where the Password was found (without revealing
+	// the Password itself), so failed attempts may be logged.
+	Source string
+
+	// Accept may be nil. Otherwise, it must be called after a database
+	// connection is established using this Candidate, so the provider
+	// may finalize an interrupted renewal.
+	Accept func() error
+}
+
+// Provider is the port which finds and renews the database passwords.
+type Provider interface {
+	// Lookup returns the candidate passwords of the `k` Key in their
+	// priority order. At least one candidate is returned, otherwise, an
+	// error is returned.
+	Lookup(ctx context.Context, k Key) ([]Candidate, error)
+
+	// Renew stores the new passwords of `creds` temporarily and returns
+	// a finalizer function which makes them the current passwords. The
+	// finalizer should be called after the passwords are changed in the
+	// database too. Until then, the Lookup method reports the current
+	// passwords alongside the new ones, so a connection can be made in
+	// both cases. ErrReadOnly is returned if the provider may not store
+	// new passwords.
+	Renew(ctx context.Context, creds []Credential) (
+		finalizer func() error, err error,
+	)
+}
+
+// Stager is implemented by those providers which stage the renewed
+// passwords in temporary files until their Renew finalizers are called
+// (or a staged candidate is accepted). If a renewal is interrupted
+// before its passwords are changed in the database, those files are
+// left behind and may be removed.
+type Stager interface {
+	// StagedFiles returns the paths of the temporary files which may
+	// keep the renewed passwords of the `keys`, whether or not they
+	// exist right now.
+	StagedFiles(keys ...Key) []string
+}
+
+// expand replaces the placeholders of the `tmpl` template with their
+// values from the `k` Key (see the package documentation).
+func expand(tmpl string, k Key) string {
+	role := string(k.Role)
+	upper := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, role)
+	return strings.NewReplacer(
+		"{host}", k.Host,
+		"{port}", strconv.Itoa(k.Port),
+		"{database}", k.DBName,
+		"{role}", role,
+		"{ROLE}", upper,
+	).Replace(tmpl)
+}
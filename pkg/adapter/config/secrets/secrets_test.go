@@ -0,0 +1,164 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package secrets_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/secrets"
+)
+
+var key = secrets.Key{
+	Host: "127.0.0.1", Port: 5456, DBName: "caweb1_3_0", Role: "caweb",
+}
+
+// printer returns a function which prints the candidate passwords and
+// their sources (or the error of a Lookup call), replacing the `dir`
+// temporary directory by DIR, so outputs do not depend on `dir`.
+func printer(dir string) func([]secrets.Candidate, error) {
+	r := strings.NewReplacer(dir, "DIR")
+	if dir == "" {
+		r = strings.NewReplacer()
+	}
+	return func(cands []secrets.Candidate, err error) {
+		if err != nil {
+			fmt.Println("error:", r.Replace(err.Error()))
+			return
+		}
+		for _, c := range cands {
+			fmt.Printf("%s from %s\n", c.Password, r.Replace(c.Source))
+		}
+	}
+}
+
+func ExamplePassDir() {
+	ctx := context.Background()
+	dir, err := os.MkdirTemp("", "caweb-secrets-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	printCandidates := printer(dir)
+	pd := secrets.NewPassDir(dir)
+	printCandidates(pd.Lookup(ctx, key))
+	pass := "# comment\n127.0.0.1:5456:caweb1_3_0:caweb:old-pass\n"
+	err = os.WriteFile(filepath.Join(dir, ".pgpass"), []byte(pass), 0o600)
+	if err != nil {
+		panic(err)
+	}
+	fin, err := pd.Renew(ctx, []secrets.Credential{
+		{Key: key, Password: "new-pass"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	printCandidates(pd.Lookup(ctx, key))
+	if err := fin(); err != nil {
+		panic(err)
+	}
+	printCandidates(pd.Lookup(ctx, key))
+	// Output:
+	// error: using "DIR/.pgpass" pass-file: reading pass-file: open DIR/.pgpass: no such file or directory
+	// old-pass from "DIR/.pgpass" pass-file
+	// new-pass from "DIR/.pgpass.new" pass-file
+	// new-pass from "DIR/.pgpass" pass-file
+}
+
+func ExampleEnv() {
+	ctx := context.Background()
+	printCandidates := printer("")
+	e := secrets.NewEnv("CAWEB_DB_PASSWORD_{ROLE}")
+	e.LookupEnv = func(name string) (string, bool) {
+		if name == "CAWEB_DB_PASSWORD_CAWEB" {
+			return "env-pass", true
+		}
+		return "", false
+	}
+	printCandidates(e.Lookup(ctx, key))
+	printCandidates(e.Lookup(ctx, secrets.Key{Role: "admin"}))
+	_, err := e.Renew(ctx, nil)
+	fmt.Println(errors.Is(err, secrets.ErrReadOnly))
+	// Output:
+	// env-pass from CAWEB_DB_PASSWORD_CAWEB environment variable
+	// error: CAWEB_DB_PASSWORD_ADMIN environment variable is not set
+	// true
+}
+
+func ExampleFiles() {
+	ctx := context.Background()
+	dir, err := os.MkdirTemp("", "caweb-secrets-*")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	printCandidates := printer(dir)
+	f := secrets.NewFiles(filepath.Join(dir, "{database}-{role}"))
+	path := filepath.Join(dir, "caweb1_3_0-caweb")
+	if err := os.WriteFile(path, []byte("file-pass\n"), 0o600); err != nil {
+		panic(err)
+	}
+	if _, err := f.Renew(ctx, []secrets.Credential{
+		{Key: key, Password: "new-pass"},
+	}); err != nil {
+		panic(err)
+	}
+	cands, err := f.Lookup(ctx, key)
+	printCandidates(cands, err)
+	if err := cands[1].Accept(); err != nil {
+		panic(err)
+	}
+	printCandidates(f.Lookup(ctx, key))
+	// Output:
+	// file-pass from "DIR/caweb1_3_0-caweb" secret file
+	// new-pass from "DIR/caweb1_3_0-caweb.new" secret file
+	// new-pass from "DIR/caweb1_3_0-caweb" secret file
+}
+
+func ExampleCommand() {
+	ctx := context.Background()
+	printCandidates := printer("")
+	c := secrets.NewCommand("echo", "{role}@{host}:{port}/{database}")
+	printCandidates(c.Lookup(ctx, key))
+	c = secrets.NewCommand("sh", "-c", "echo no {role} >&2; exit 2")
+	printCandidates(c.Lookup(ctx, key))
+	c = secrets.NewCommand("false")
+	printCandidates(c.Lookup(ctx, key))
+	// Output:
+	// caweb@127.0.0.1:5456/caweb1_3_0 from "echo" command
+	// error: running "sh" command: exit status 2: no caweb
+	// error: running "false" command: exit status 1
+}
+
+// TestFilesRenewReadOnly ensures that the Files provider reports
+// ErrReadOnly when its directory may not be written (like a mounted
+// secret), so the current passwords are reused instead.
+func TestFilesRenewReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root may write in read-only directories")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "caweb1_3_0-caweb")
+	if err := os.WriteFile(path, []byte("file-pass\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(dir, 0o500); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0o700)
+	f := secrets.NewFiles(filepath.Join(dir, "{database}-{role}"))
+	_, err := f.Renew(context.Background(), []secrets.Credential{
+		{Key: key, Password: "new-pass"},
+	})
+	if !errors.Is(err, secrets.ErrReadOnly) {
+		t.Fatalf("Renew returned %v, expected ErrReadOnly", err)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Env is a read-only Provider which reads each password from the
+// environment variable which its name is obtained by expanding the
+// Variable template, e.g., CAWEB_DB_PASSWORD_{ROLE}.
+type Env struct {
+	Variable string // template of the variable names
+
+	// LookupEnv finds the value of an environment variable. It
+	// defaults to the os.LookupEnv function if it is nil.
+	LookupEnv func(name string) (string, bool)
+}
+
+// NewEnv creates an Env provider for the `variable` template which
+// looks up the environment variables of the current process.
+func NewEnv(variable string) *Env {
+	return &Env{Variable: variable, LookupEnv: os.LookupEnv}
+}
+
+// Lookup returns the password of `k` from its environment variable.
+// An error is returned if the variable is not set or is empty.
+func (e *Env) Lookup(_ context.Context, k Key) ([]Candidate, error) {
+	lookup := e.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	name := expand(e.Variable, k)
+	pass, found := lookup(name)
+	if !found || pass == "" {
+		return nil, fmt.Errorf("%s environment variable is not set", name)
+	}
+	return []Candidate{{
+		Password: pass,
+		Source:   name + " environment variable",
+	}}, nil
+}
+
+// Renew returns ErrReadOnly because environment variables of other
+// processes may not be updated.
+func (e *Env) Renew(context.Context, []Credential) (func() error, error) {
+	return nil, ErrReadOnly
+}
+
+// Files is a Provider which keeps each password in a file which its
+// path is obtained by expanding the Path template, e.g.,
+// /run/secrets/{role}. A trailing newline is ignored, so files may be
+// created by `echo` too. New passwords are written in the files with
+// the `.new` suffix and are moved over the main files by the Renew
+// finalizer (or by accepting a `.new` candidate if that finalizer was
+// not called). The Kubernetes or Docker secrets are mounted read-only,
+// so their Renew method returns ErrReadOnly and the current passwords
+// are reused (similar to the Env provider), while they should be
+// managed externally.
+type Files struct {
+	Path string // template of the file paths
+}
+
+// NewFiles creates a Files provider for the `path` template.
+func NewFiles(path string) *Files {
+	return &Files{Path: path}
+}
+
+// Lookup returns the password of `k` from its file and then from its
+// `.new` file (if they exist and are not empty).
+func (f *Files) Lookup(_ context.Context, k Key) ([]Candidate, error) {
+	path := expand(f.Path, k)
+	newPath := path + ".new"
+	var cands []Candidate
+	pass, err := readSecretFile(path)
+	if err == nil {
+		cands = append(cands, Candidate{
+			Password: pass,
+			Source:   fmt.Sprintf("%q secret file", path),
+		})
+	}
+	if pass, newErr := readSecretFile(newPath); newErr == nil {
+		cands = append(cands, Candidate{
+			Password: pass,
+			Source:   fmt.Sprintf("%q secret file", newPath),
+			Accept: func() error {
+				return os.Rename(newPath, path)
+			},
+		})
+	}
+	if len(cands) == 0 {
+		return nil, fmt.Errorf("using %q secret file: %w", path, err)
+	}
+	return cands, nil
+}
+
+// Renew writes the `creds` passwords in their `.new` files and returns
+// a finalizer which moves them over their main files. If a `.new` file
+// may not be written because of a read-only file system or missing
+// permissions (e.g., for a mounted secret), the written `.new` files
+// are removed and an error wrapping ErrReadOnly is returned.
+func (f *Files) Renew(_ context.Context, creds []Credential) (
+	finalizer func() error, err error,
+) {
+	paths := make([]string, len(creds))
+	for i, c := range creds {
+		paths[i] = expand(f.Path, c.Key)
+		newPath := paths[i] + ".new"
+		err := os.WriteFile(newPath, []byte(c.Password+"\n"), 0o600)
+		if err == nil {
+			continue
+		}
+		for _, p := range paths[:i] {
+			_ = os.Remove(p + ".new")
+		}
+		if errors.Is(err, fs.ErrPermission) ||
+			errors.Is(err, syscall.EROFS) {
+			err = fmt.Errorf("%w: %w", ErrReadOnly, err)
+		}
+		return nil, fmt.Errorf("writing %q file: %w", newPath, err)
+	}
+	return func() error {
+		for _, p := range paths {
+			if err := os.Rename(p+".new", p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// StagedFiles returns the paths of the `.new` files of the `keys`,
+// implementing the Stager interface.
+func (f *Files) StagedFiles(keys ...Key) []string {
+	paths := make([]string, len(keys))
+	for i, k := range keys {
+		paths[i] = expand(f.Path, k) + ".new"
+	}
+	return paths
+}
+
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	pass := strings.TrimSuffix(strings.TrimSuffix(string(b), "\n"), "\r")
+	if pass == "" {
+		return "", errors.New("empty secret file")
+	}
+	return pass, nil
+}
+
+// Command is a read-only Provider which runs an external command and
+// takes its stdout (without a trailing newline) as the password. The
+// Args templates are expanded for each Key and the first one names the
+// command itself, e.g., [vault, kv, get, -field={role}, secret/db].
+// The command is not run by a shell, so its arguments need no quoting.
+type Command struct {
+	Args []string // templates of the command name and its arguments
+}
+
+// NewCommand creates a Command provider for the `args` templates.
+func NewCommand(args ...string) *Command {
+	return &Command{Args: args}
+}
+
+// Lookup runs the command for `k` and returns its stdout. An error,
+// including the command stderr, is returned if the command fails or
+// prints nothing.
+func (c *Command) Lookup(ctx context.Context, k Key) ([]Candidate, error) {
+	if len(c.Args) == 0 {
+		return nil, errors.New("no command is configured")
+	}
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = expand(a, k)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("running %q command: %w", args[0], err)
+	}
+	pass := strings.TrimSuffix(stdout.String(), "\n")
+	pass = strings.TrimSuffix(pass, "\r")
+	if pass == "" {
+		return nil, fmt.Errorf("%q command printed no password", args[0])
+	}
+	return []Candidate{{
+		Password: pass,
+		Source:   fmt.Sprintf("%q command", args[0]),
+	}}, nil
+}
+
+// Renew returns ErrReadOnly because passwords may only be read by the
+// command.
+func (c *Command) Renew(context.Context, []Credential) (func() error, error) {
+	return nil, ErrReadOnly
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"regexp"
+	"strconv"
+)
+
+// ByteSize is a number of bytes which can be represented with a binary
+// (e.g., 10MiB) or decimal (e.g., 10MB) unit suffix in the configuration
+// files. Its version-independent counterpart is model.ByteSize which
+// has the same underlying type, so they can be converted to each other.
+type ByteSize int64
+
+// byteUnits lists the supported units of ByteSize, ordered from the
+// largest to the smallest one. The Marshal method prefers the earlier
+// items when two units can represent a size with the same quotient.
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"PiB", 1 << 50}, {"PB", 1e15},
+	{"TiB", 1 << 40}, {"TB", 1e12},
+	{"GiB", 1 << 30}, {"GB", 1e9},
+	{"MiB", 1 << 20}, {"MB", 1e6},
+	{"KiB", 1 << 10}, {"KB", 1e3},
+	{"B", 1},
+}
+
+var byteSizeRegexp = regexp.MustCompile(
+	`^([0-9]+(?:\.[0-9]+)?)\s*([A-Za-z]*)$`,
+)
+
+// UnmarshalText reifies the encoding.TextUnmarshaler interface, so
+// a byte slice (e.g., read from a YAML file) can be decoded as a byte
+// size. The `data` should contain a non-negative number, followed by an
+// optional unit such as B, KB, MB, GB, TB, PB (powers of 1000) or KiB,
+// MiB, GiB, TiB, PiB (powers of 1024). A size without a unit is counted
+// in bytes. The number may be fractional (e.g., 1.5GiB) as long as it
+// represents an integral number of bytes. In absence of errors, a nil
+// error will be returned and only then, `b` receiver will be updated.
+func (b *ByteSize) UnmarshalText(data []byte) error {
+	m := byteSizeRegexp.FindSubmatch(data)
+	if m == nil {
+		return fmt.Errorf("invalid byte size %q", data)
+	}
+	unit := int64(1)
+	if suffix := string(m[2]); suffix != "" {
+		found := false
+		for _, u := range byteUnits {
+			if u.suffix == suffix {
+				unit, found = u.size, true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown byte size unit %q", suffix)
+		}
+	}
+	// The number is parsed as an exact decimal fraction, so sizes such
+	// as 4.1GB are not rejected due to the float64 rounding errors.
+	r, ok := new(big.Rat).SetString(string(m[1]))
+	if !ok {
+		return fmt.Errorf("parsing byte size %q", data)
+	}
+	r.Mul(r, new(big.Rat).SetInt64(unit))
+	if !r.IsInt() {
+		return fmt.Errorf("byte size %q is not a whole number of bytes", data)
+	}
+	n := r.Num()
+	if !n.IsInt64() {
+		return fmt.Errorf("byte size %q is too large", data)
+	}
+	*b = ByteSize(n.Int64())
+	return nil
+}
+
+// Marshal returns a string representation of the `b` byte size, using
+// the largest unit which can represent it exactly, e.g., 10MiB for
+// 10485760 bytes or 1500B for 1500 bytes.
+// If b is nil, nil will be returned, so it can be used by higher-level
+// Marshal methods (similar to the Duration.Marshal method).
+// The returned pointer will refer to a newly allocated string variable.
+func (b *ByteSize) Marshal() *string {
+	if b == nil {
+		return nil
+	}
+	n := int64(*b)
+	suffix, q := "B", n
+	if n != 0 {
+		for _, u := range byteUnits {
+			if n%u.size == 0 && n/u.size < q {
+				suffix, q = u.suffix, n/u.size
+			}
+		}
+	}
+	s := strconv.FormatInt(q, 10) + suffix
+	return &s
+}
+
+// MarshalText implements encoding.TextMarshaler interface and
+// serializes `b` byte size using its Marshal method.
+// This interface is required for json serialization.
+func (b *ByteSize) MarshalText() ([]byte, error) {
+	if s := b.Marshal(); s != nil {
+		return []byte(*s), nil
+	}
+	return nil, errors.New("nil byte size")
+}
+
+// LogValue implements slog.LogValuer and returns an Int64Value if this
+// ByteSize is not nil, otherwise, it returns a StringValue with the
+// constant "nil-byte-size" value.
+func (b *ByteSize) LogValue() slog.Value {
+	if b == nil {
+		return slog.StringValue("nil-byte-size")
+	}
+	return slog.Int64Value(int64(*b))
+}
+
+// Text implements the Constrainable interface and returns the same
+// string representation of `b` as the Marshal method.
+func (b ByteSize) Text() string {
+	return *b.Marshal()
+}
+
+// MultipleOf implements the Stepper[ByteSize] interface and reports if
+// `b` is an integral multiple of the given non-zero `step` size.
+func (b ByteSize) MultipleOf(step ByteSize) bool {
+	return step != 0 && b%step == 0
+}
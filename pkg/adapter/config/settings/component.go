@@ -0,0 +1,183 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package settings
+
+import (
+	"fmt"
+
+	"github.com/goccy/go-json"
+	"github.com/momeni/clean-arch/pkg/core/model"
+)
+
+// Component of C describes one independently configured subsystem of
+// a C configuration type (e.g., *cfg2.Config) which keeps its mutable
+// settings and their boundary values in a distinct row of the settings
+// table. Each component owns a serializable struct (which includes its
+// own format version), so it can be serialized, persisted, and loaded
+// without touching the rows of other components.
+//
+// Every Config[C, S] implementation reports its registry of components
+// using the Components method. The registry is a property of the C type
+// (not its instances), so it is usually kept in a package-level slice.
+type Component[C any] interface {
+	// Name returns the name of this component which is used as the
+	// key of its row in the settings table.
+	Name() model.Component
+
+	// Serialize finds out about the mutable settings of this component
+	// in the `c` configuration instance and serializes them as a json
+	// string. It also serializes the minimum and maximum boundary values
+	// of those settings as two other json strings with the same format.
+	// The declared constraints of those settings are serialized as the
+	// `cons` json string too, or it is nil if this component declares
+	// no constraints.
+	// Returned error (if any) belongs to the json serialization phase.
+	Serialize(c C) (ms, minb, maxb, cons []byte, err error)
+
+	// Deserialize decodes the given `ms` json string (as produced by
+	// the Serialize method) and mutates the `c` configuration instance
+	// accordingly. If the decoded values do not respect the expected
+	// boundary values, they are clamped and an error which implements
+	// the BoundsError interface will be returned (and the caller may
+	// decide to treat it as a warning).
+	Deserialize(c C, ms []byte) error
+}
+
+// component of C and S is the default Component[C] implementation
+// which delegates to the given functions for obtaining an S serializable
+// instance (and its boundary values) from a C configuration instance,
+// and for mutating a C instance using an S instance.
+type component[C, S any] struct {
+	name         model.Component
+	serializable func(C) (*S, error)
+	bounds       func(C) (minb, maxb *S)
+	constraints  func(C) any // may be nil
+	mutate       func(C, S) error
+}
+
+// NewComponent instantiates a Component[C] with the given `name` which
+// uses S as its serializable type. The `serializable`, `bounds`, and
+// `mutate` functions have the same semantic as the Serializable, Bounds,
+// and Mutate methods of the Config[C, S] interface, but they may only
+// deal with the settings which belong to this component. Therefore,
+// the method expressions of a Config[C, S] type may be passed directly
+// when a single component holds all mutable settings, for example:
+//
+//	settings.NewComponent(
+//		model.AppComponent,
+//		(*Config).Serializable, (*Config).Bounds, (*Config).Mutate,
+//	)
+func NewComponent[C, S any](
+	name model.Component,
+	serializable func(C) (*S, error),
+	bounds func(C) (minb, maxb *S),
+	mutate func(C, S) error,
+) Component[C] {
+	return component[C, S]{
+		name:         name,
+		serializable: serializable,
+		bounds:       bounds,
+		mutate:       mutate,
+	}
+}
+
+// NewConstrainedComponent instantiates a Component[C] just like the
+// NewComponent function, but the created component also declares some
+// constraints for its settings. The `constraints` function has the same
+// semantic as the Constraints method of cfg2.Config (and later config
+// versions), returning a K instance which should be serialized and
+// persisted alongside the boundary values. The `mutate` function is
+// expected to verify those constraints and return a *ConstraintsError
+// if they are violated.
+func NewConstrainedComponent[C, S, K any](
+	name model.Component,
+	serializable func(C) (*S, error),
+	bounds func(C) (minb, maxb *S),
+	constraints func(C) *K,
+	mutate func(C, S) error,
+) Component[C] {
+	return component[C, S]{
+		name:         name,
+		serializable: serializable,
+		bounds:       bounds,
+		constraints: func(c C) any {
+			return constraints(c)
+		},
+		mutate: mutate,
+	}
+}
+
+// Name returns the name of `comp` component.
+func (comp component[C, S]) Name() model.Component {
+	return comp.name
+}
+
+// Serialize serializes the mutable settings and boundary values of
+// `comp` component, as taken from the `c` configuration instance.
+func (comp component[C, S]) Serialize(c C) (
+	ms, minb, maxb, cons []byte, err error,
+) {
+	s, err := comp.serializable(c)
+	if err != nil {
+		err = fmt.Errorf("serializing %q settings: %w", comp.name, err)
+		return nil, nil, nil, nil, err
+	}
+	ms, err = json.Marshal(s)
+	if err != nil {
+		err = fmt.Errorf("marshalling %q settings: %w", comp.name, err)
+		return nil, nil, nil, nil, err
+	}
+	lb, ub := comp.bounds(c)
+	minb, err = json.Marshal(lb)
+	if err != nil {
+		err = fmt.Errorf(
+			"marshalling %q minimum bounds: %w", comp.name, err,
+		)
+		return nil, nil, nil, nil, err
+	}
+	maxb, err = json.Marshal(ub)
+	if err != nil {
+		err = fmt.Errorf(
+			"marshalling %q maximum bounds: %w", comp.name, err,
+		)
+		return nil, nil, nil, nil, err
+	}
+	if comp.constraints != nil {
+		cons, err = json.Marshal(comp.constraints(c))
+		if err != nil {
+			err = fmt.Errorf(
+				"marshalling %q constraints: %w", comp.name, err,
+			)
+			return nil, nil, nil, nil, err
+		}
+	}
+	return ms, minb, maxb, cons, nil
+}
+
+// Deserialize decodes the `ms` json string as an S instance and uses
+// it in order to mutate the `c` configuration instance. A BoundsError
+// is returned without wrapping, so it may be detected by errors.As.
+func (comp component[C, S]) Deserialize(c C, ms []byte) error {
+	s := new(S)
+	if err := json.Unmarshal(ms, s); err != nil {
+		return fmt.Errorf("decoding %q settings: %w", comp.name, err)
+	}
+	return comp.mutate(c, *s)
+}
+
+// FindComponent looks up the `name` component in the given registry
+// of `comps` components and returns it. If no such component could be
+// found, a nil Component[C] and false will be returned.
+func FindComponent[C any](
+	comps []Component[C], name model.Component,
+) (Component[C], bool) {
+	for _, comp := range comps {
+		if comp.Name() == name {
+			return comp, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,319 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Constrainable is implemented by the setting types which may be
+// restricted by a Constraints[T] instance. Values must be comparable,
+// so they can be compared with the Enum items, and must provide their
+// textual form which is matched against a Pattern and is measured by
+// the length constraints.
+//
+// Types which may be restricted by a MultipleOf constraint should
+// implement the Stepper[T] interface too.
+type Constrainable interface {
+	comparable
+
+	// Text returns the textual form of the setting value. The textual
+	// form of secret settings is their plaintext, so it must not be
+	// included in logs or error messages.
+	Text() string
+}
+
+// Stepper of T is implemented by setting types which support the
+// MultipleOf constraint, such as numeric types and durations.
+type Stepper[T any] interface {
+	// MultipleOf reports if this value is an integral multiple of
+	// the given `step` value.
+	MultipleOf(step T) bool
+}
+
+// Constraints of T declares the restrictions of a T setting beyond its
+// minimum and maximum boundary values (see VerifyRange). Constraints
+// may be loaded from a configuration file (next to the minimum and
+// maximum boundary values of that setting) and may be serialized as
+// json in order to be stored in the database alongside the boundary
+// values, so other components may read and respect them too.
+//
+// All fields are optional and a nil (or empty) field imposes no
+// restriction. Despite the boundary values, violated constraints may
+// not be fixed by adjusting the setting value, so they are reported as
+// errors and the setting should be rejected.
+type Constraints[T Constrainable] struct {
+	// Enum lists the acceptable values of the setting.
+	Enum []T `yaml:"enum,omitempty" json:"enum,omitempty"`
+
+	// Pattern is a regular expression which must match the whole
+	// textual form of the setting.
+	Pattern *Pattern `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+
+	// MinLength is the inclusive minimum number of characters of the
+	// textual form of the setting.
+	MinLength *int `yaml:"min-length,omitempty" json:"min_length,omitempty"`
+
+	// MaxLength is the inclusive maximum number of characters of the
+	// textual form of the setting.
+	MaxLength *int `yaml:"max-length,omitempty" json:"max_length,omitempty"`
+
+	// MultipleOf asks the setting to be an integral multiple of the
+	// given step value. It may only be used if T implements the
+	// Stepper[T] interface.
+	MultipleOf *T `yaml:"multiple-of,omitempty" json:"multiple_of,omitempty"`
+}
+
+// marshalledConstraints of T is an alternative form of Constraints[T]
+// which keeps its Enum items by pointers. See the MarshalYAML method.
+type marshalledConstraints[T Constrainable] struct {
+	Enum       []*T     `yaml:"enum,omitempty,flow"`
+	Pattern    *Pattern `yaml:"pattern,omitempty"`
+	MinLength  *int     `yaml:"min-length,omitempty"`
+	MaxLength  *int     `yaml:"max-length,omitempty"`
+	MultipleOf *T       `yaml:"multiple-of,omitempty"`
+}
+
+// MarshalYAML implements the yaml.Marshaler interface. The yaml package
+// does not call the pointer receiver methods of the slice items, so the
+// Enum items of types like Duration (which implement the MarshalText
+// method with a pointer receiver) would be encoded by their underlying
+// types (e.g., as nanoseconds). Therefore, `cs` is replaced by another
+// struct which keeps pointers to the Enum items.
+func (cs *Constraints[T]) MarshalYAML() (interface{}, error) {
+	m := &marshalledConstraints[T]{
+		Pattern:    cs.Pattern,
+		MinLength:  cs.MinLength,
+		MaxLength:  cs.MaxLength,
+		MultipleOf: cs.MultipleOf,
+	}
+	for i := range cs.Enum {
+		m.Enum = append(m.Enum, &cs.Enum[i])
+	}
+	return m, nil
+}
+
+// Validate ensures that the `cs` constraints are consistent, so they
+// may be satisfied by some values. A nil `cs` is valid.
+func (cs *Constraints[T]) Validate() error {
+	if cs == nil {
+		return nil
+	}
+	if cs.MinLength != nil && *cs.MinLength < 0 {
+		return fmt.Errorf("min-length (%d) is negative", *cs.MinLength)
+	}
+	if cs.MaxLength != nil && *cs.MaxLength < 0 {
+		return fmt.Errorf("max-length (%d) is negative", *cs.MaxLength)
+	}
+	if cs.MinLength != nil && cs.MaxLength != nil &&
+		*cs.MinLength > *cs.MaxLength {
+		return fmt.Errorf(
+			"min-length (%d) is greater than max-length (%d)",
+			*cs.MinLength, *cs.MaxLength,
+		)
+	}
+	if cs.MultipleOf != nil {
+		var zero T
+		if _, ok := any(zero).(Stepper[T]); !ok {
+			return fmt.Errorf("multiple-of is not supported for %T", zero)
+		}
+		if *cs.MultipleOf == zero {
+			return errors.New("multiple-of must not be zero")
+		}
+	}
+	return nil
+}
+
+// Verify checks the given `value` against the `cs` constraints and
+// returns the first violated constraint as a *ConstraintError. A nil
+// `value` is acceptable because an uninitialized setting is meaningful
+// by itself (similar to the VerifyRange function) and a nil `cs` does
+// not restrict the `value` at all.
+// The returned error message does not include the `value` itself, so
+// it may be reported for secret settings too.
+func (cs *Constraints[T]) Verify(value *T) *ConstraintError {
+	if cs == nil || value == nil {
+		return nil
+	}
+	v := *value
+	if len(cs.Enum) > 0 && !contains(cs.Enum, v) {
+		texts := make([]string, 0, len(cs.Enum))
+		for _, e := range cs.Enum {
+			texts = append(texts, e.Text())
+		}
+		return &ConstraintError{
+			Rule: "enum",
+			Message: fmt.Sprintf(
+				"must be one of [%s]", strings.Join(texts, ", "),
+			),
+		}
+	}
+	text := v.Text()
+	if cs.Pattern != nil && !cs.Pattern.MatchString(text) {
+		return &ConstraintError{
+			Rule:    "pattern",
+			Message: fmt.Sprintf("must match %q pattern", cs.Pattern),
+		}
+	}
+	l := utf8.RuneCountInString(text)
+	if cs.MinLength != nil && l < *cs.MinLength {
+		return &ConstraintError{
+			Rule: "min-length",
+			Message: fmt.Sprintf(
+				"must have at least %d characters", *cs.MinLength,
+			),
+		}
+	}
+	if cs.MaxLength != nil && l > *cs.MaxLength {
+		return &ConstraintError{
+			Rule: "max-length",
+			Message: fmt.Sprintf(
+				"must have at most %d characters", *cs.MaxLength,
+			),
+		}
+	}
+	if cs.MultipleOf != nil {
+		s, ok := any(v).(Stepper[T])
+		if !ok || !s.MultipleOf(*cs.MultipleOf) {
+			return &ConstraintError{
+				Rule: "multiple-of",
+				Message: fmt.Sprintf(
+					"must be a multiple of %s", (*cs.MultipleOf).Text(),
+				),
+			}
+		}
+	}
+	return nil
+}
+
+func contains[T comparable](items []T, v T) bool {
+	for _, item := range items {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ConstraintError indicates that a setting value has violated one of
+// its declared constraints. The Rule identifies the violated constraint
+// using its configuration file key (e.g., "enum" or "multiple-of") and
+// the Message describes the expectation without repeating the value.
+type ConstraintError struct {
+	Rule    string
+	Message string
+}
+
+// Error implements the error interface and returns the Message.
+func (e *ConstraintError) Error() string {
+	return e.Message
+}
+
+// Pattern is a regular expression which must match the whole textual
+// form of a setting. It can be decoded from and encoded to a string
+// (e.g., in YAML or json formats) using the UnmarshalText and the
+// MarshalText methods.
+type Pattern struct {
+	expr string
+	re   *regexp.Regexp
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface,
+// compiling the `data` regular expression, so it is anchored at both
+// ends. In absence of errors, the `p` receiver will be updated.
+func (p *Pattern) UnmarshalText(data []byte) error {
+	expr := string(data)
+	re, err := regexp.Compile(`^(?:` + expr + `)$`)
+	if err != nil {
+		return fmt.Errorf("compiling pattern: %w", err)
+	}
+	p.expr, p.re = expr, re
+	return nil
+}
+
+// MarshalText implements the encoding.TextMarshaler interface and
+// returns the `p` regular expression as it was given originally.
+func (p *Pattern) MarshalText() ([]byte, error) {
+	return []byte(p.expr), nil
+}
+
+// String returns the `p` regular expression as it was given originally.
+func (p *Pattern) String() string {
+	return p.expr
+}
+
+// MatchString reports if `p` matches the whole `s` string.
+func (p *Pattern) MatchString(s string) bool {
+	return p.re.MatchString(s)
+}
+
+// Rule of C is a cross-field constraint which should hold among the
+// settings of a C configuration instance, such as a minimum delay which
+// must be less than a timeout. Rules are declared by each configuration
+// version (similar to its components registry) and are verified using
+// the VerifyRules function.
+type Rule[C any] struct {
+	// Fields lists the paths of the participating settings, having the
+	// json names of nested fields separated by a dot character.
+	Fields []string
+
+	// Message describes the expectation of this rule.
+	Message string
+
+	// Holds reports if this rule is satisfied by the `c` instance.
+	Holds func(c C) bool
+}
+
+// VerifyRules checks the given `rules` against the `c` configuration
+// instance and returns one Violation per participating field of each
+// violated rule.
+func VerifyRules[C any](c C, rules []Rule[C]) []Violation {
+	var violations []Violation
+	for _, r := range rules {
+		if r.Holds(c) {
+			continue
+		}
+		for _, f := range r.Fields {
+			violations = append(violations, Violation{
+				Field: f,
+				Err:   &ConstraintError{Rule: "rule", Message: r.Message},
+			})
+		}
+	}
+	return violations
+}
+
+// Violation associates a violated constraint (or boundary value) error
+// with the path of its setting, having the json names of nested fields
+// separated by a dot character.
+type Violation struct {
+	Field string
+	Err   error
+}
+
+// ConstraintsError lists the violations of the declared constraints of
+// some settings. Despite a BoundsError, the violating settings cannot
+// be adjusted automatically, so a ConstraintsError must be treated as
+// a failure. Other violations (such as the out of range values) which
+// are detected alongside the violated constraints may be included too,
+// so all violations can be reported together.
+type ConstraintsError struct {
+	Violations []Violation
+}
+
+// Error implements the error interface and lists all violations with
+// their field paths.
+func (e *ConstraintsError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", v.Field, v.Err))
+	}
+	return "settings violate constraints: " + strings.Join(msgs, "; ")
+}
@@ -78,3 +78,15 @@ func (d *Duration) LogValue() slog.Value {
 	}
 	return slog.DurationValue(time.Duration(*d))
 }
+
+// Text implements the Constrainable interface and returns the same
+// string representation of `d` as the Marshal method.
+func (d Duration) Text() string {
+	return *d.Marshal()
+}
+
+// MultipleOf implements the Stepper[Duration] interface and reports if
+// `d` is an integral multiple of the given non-zero `step` duration.
+func (d Duration) MultipleOf(step Duration) bool {
+	return step != 0 && d%step == 0
+}
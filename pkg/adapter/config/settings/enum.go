@@ -0,0 +1,128 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Domain describes the acceptable values of an Enum[D] setting. It is
+// usually implemented by an empty struct type, so it can be used as a
+// type parameter without being instantiated, for example:
+//
+//	type LogLevels struct{}
+//
+//	func (LogLevels) Values() []string {
+//		return []string{"debug", "info", "warn", "error"}
+//	}
+//
+// Thereafter, Enum[LogLevels] can hold one of those four values.
+type Domain interface {
+	// Values lists the acceptable values in their ascending order.
+	// The returned slice must not be changed among calls.
+	Values() []string
+}
+
+// Enum of D is a setting which may only take one of the values which
+// are listed by its D Domain type. Each value is represented by its
+// index in that list, so the Enum values are ordered based on their
+// declaration order and the VerifyRange function can restrict them
+// by minimum and maximum boundary values (e.g., the minimum log level).
+// The zero Enum takes the first value of D, so Nil2Zero initializes
+// an enum setting to its first value.
+// Its version-independent counterpart is a plain string which can be
+// converted to an Enum[D] using the ParseEnum function or obtained from
+// an Enum[D] using its Text method.
+type Enum[D Domain] int
+
+// ParseEnum finds the `s` value in the acceptable values of D and
+// returns it as an Enum[D] instance. An error is returned if `s` is not
+// acceptable.
+func ParseEnum[D Domain](s string) (Enum[D], error) {
+	var d D
+	vals := d.Values()
+	for i, v := range vals {
+		if v == s {
+			return Enum[D](i), nil
+		}
+	}
+	return 0, fmt.Errorf(
+		"unknown value %q (expected one of %s)",
+		s, strings.Join(vals, ", "),
+	)
+}
+
+// UnmarshalText reifies the encoding.TextUnmarshaler interface, so
+// a byte slice (e.g., read from a YAML file) can be decoded as an enum
+// value using the ParseEnum function. In absence of errors, a nil error
+// will be returned and only then, `e` receiver will be updated.
+func (e *Enum[D]) UnmarshalText(data []byte) error {
+	v, err := ParseEnum[D](string(data))
+	if err != nil {
+		return err
+	}
+	*e = v
+	return nil
+}
+
+// Marshal returns the string representation of the `e` enum value,
+// as listed by the D Domain type. An out of range index (which may only
+// be created by explicit conversion of an integer) is represented by
+// its index, e.g., #7, so it can be reported.
+// If e is nil, nil will be returned, so it can be used by higher-level
+// Marshal methods (similar to the Duration.Marshal method).
+// The returned pointer will refer to a newly allocated string variable.
+func (e *Enum[D]) Marshal() *string {
+	if e == nil {
+		return nil
+	}
+	var d D
+	vals := d.Values()
+	i := int(*e)
+	s := "#" + strconv.Itoa(i)
+	if i >= 0 && i < len(vals) {
+		s = vals[i]
+	}
+	return &s
+}
+
+// MarshalText implements encoding.TextMarshaler interface and
+// serializes `e` enum value using its Marshal method.
+// This interface is required for json serialization.
+func (e *Enum[D]) MarshalText() ([]byte, error) {
+	if s := e.Marshal(); s != nil {
+		return []byte(*s), nil
+	}
+	return nil, errors.New("nil enum")
+}
+
+// LogValue implements slog.LogValuer and returns a StringValue which
+// contains the `e` enum value if it is not nil, otherwise, it returns
+// the constant "nil-enum" value.
+func (e *Enum[D]) LogValue() slog.Value {
+	if e == nil {
+		return slog.StringValue("nil-enum")
+	}
+	return slog.StringValue(*e.Marshal())
+}
+
+// Text implements the Constrainable interface and returns the same
+// string representation of `e` as the Marshal method.
+func (e Enum[D]) Text() string {
+	return *e.Marshal()
+}
+
+// Values lists the acceptable values of Enum[D], as listed by its D
+// Domain type, so they may be reported without instantiating D (e.g.,
+// by the generated JSON schemas of the configuration files).
+func (Enum[D]) Values() []string {
+	var d D
+	return d.Values()
+}
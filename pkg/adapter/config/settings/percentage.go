@@ -0,0 +1,100 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Percentage is a ratio which is expressed in percents, so 12.5 means
+// 12.5% (i.e., 0.125). It can be represented with or without a trailing
+// % character in the configuration files. Its version-independent
+// counterpart is model.Percentage which has the same underlying type,
+// so they can be converted to each other.
+// Values greater than 100 or less than 0 are acceptable in general,
+// while specific settings may restrict them using their boundary values.
+type Percentage float64
+
+// UnmarshalText reifies the encoding.TextUnmarshaler interface, so
+// a byte slice (e.g., read from a YAML file) can be decoded as a
+// percentage. The `data` should contain a finite number which may be
+// followed by a % character, e.g., 12.5% or 12.5. In absence of errors,
+// a nil error will be returned and only then, `p` receiver will be
+// updated to contain the decoded percentage.
+func (p *Percentage) UnmarshalText(data []byte) error {
+	s := strings.TrimSpace(strings.TrimSuffix(string(data), "%"))
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("parsing percentage %q: %w", data, err)
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return fmt.Errorf("percentage %q is not finite", data)
+	}
+	*p = Percentage(f)
+	return nil
+}
+
+// Marshal returns a string representation of the `p` percentage with
+// a trailing % character, e.g., 12.5%, using the minimum number of
+// digits which are required to represent it exactly.
+// If p is nil, nil will be returned, so it can be used by higher-level
+// Marshal methods (similar to the Duration.Marshal method).
+// The returned pointer will refer to a newly allocated string variable.
+func (p *Percentage) Marshal() *string {
+	if p == nil {
+		return nil
+	}
+	s := strconv.FormatFloat(float64(*p), 'f', -1, 64) + "%"
+	return &s
+}
+
+// MarshalText implements encoding.TextMarshaler interface and
+// serializes `p` percentage using its Marshal method.
+// This interface is required for json serialization.
+func (p *Percentage) MarshalText() ([]byte, error) {
+	if s := p.Marshal(); s != nil {
+		return []byte(*s), nil
+	}
+	return nil, errors.New("nil percentage")
+}
+
+// LogValue implements slog.LogValuer and returns a Float64Value if this
+// Percentage is not nil, otherwise, it returns a StringValue with the
+// constant "nil-percentage" value.
+func (p *Percentage) LogValue() slog.Value {
+	if p == nil {
+		return slog.StringValue("nil-percentage")
+	}
+	return slog.Float64Value(float64(*p))
+}
+
+// Ratio returns the `p` percentage as a fraction, e.g., 0.125 for 12.5%.
+func (p Percentage) Ratio() float64 {
+	return float64(p) / 100
+}
+
+// Text implements the Constrainable interface and returns the same
+// string representation of `p` as the Marshal method.
+func (p Percentage) Text() string {
+	return *p.Marshal()
+}
+
+// MultipleOf implements the Stepper[Percentage] interface and reports
+// if `p` is an integral multiple of the given non-zero `step` value.
+// Since percentages are floating point numbers, a tiny relative error
+// is tolerated, so 0.3% is considered to be a multiple of 0.1%.
+func (p Percentage) MultipleOf(step Percentage) bool {
+	if step == 0 {
+		return false
+	}
+	q := float64(p / step)
+	return math.Abs(q-math.Round(q)) < 1e-9
+}
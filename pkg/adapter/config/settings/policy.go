@@ -0,0 +1,154 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package settings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/momeni/clean-arch/pkg/core/log"
+	"github.com/momeni/clean-arch/pkg/core/model"
+)
+
+// Policy indicates how out of range settings should be treated. When
+// VerifyRange finds a value which is out of its acceptable range, it
+// replaces that value by the nearest boundary value and returns an
+// error. A Policy decides if that adjustment should be kept (Clamp),
+// reverted while the original value is accepted (Warn), or the whole
+// settings should be rejected (Reject). The Clamp and Warn policies
+// log the range violations as warnings.
+type Policy string
+
+// These constants list the supported policies.
+const (
+	// Clamp keeps the adjusted values, so settings fall in their
+	// acceptable range of values.
+	Clamp Policy = "clamp"
+
+	// Reject refuses the settings if any of them is out of range.
+	Reject Policy = "reject"
+
+	// Warn keeps the original (out of range) values as they were given.
+	Warn Policy = "warn"
+)
+
+// Validate ensures that `p` is one of the supported policies.
+func (p Policy) Validate() error {
+	switch p {
+	case Clamp, Reject, Warn:
+		return nil
+	default:
+		return fmt.Errorf(
+			"unknown policy %q (expected clamp, reject, or warn)", p,
+		)
+	}
+}
+
+// Policies specifies the out of range settings Policy of each source
+// of settings separately, so a deployment may reject the out of range
+// settings which are sent by end-users while clamping the settings
+// which were persisted in the database before (e.g., when the boundary
+// values of the configuration file are changed), or vice versa.
+type Policies struct {
+	// API is used for settings which are written using the REST APIs
+	// (including the validation and scheduling of settings changes).
+	// It is Reject by default.
+	API Policy `yaml:"api,omitempty"`
+
+	// DB is used for settings which are loaded from the database,
+	// either when the application starts and reloads its settings or
+	// when a source database is loaded for a migration. It is Clamp
+	// by default.
+	DB Policy `yaml:"db,omitempty"`
+
+	// Migration is used for settings which are migrated from a source
+	// configuration version and should be merged with the destination
+	// configuration boundary values. It is Clamp by default.
+	Migration Policy `yaml:"migration,omitempty"`
+}
+
+// ValidateAndNormalize replaces the empty policies of `ps` with their
+// default values and ensures that all policies are supported.
+func (ps *Policies) ValidateAndNormalize() error {
+	for _, p := range []struct {
+		name string
+		p    *Policy
+		def  Policy
+	}{
+		{"api", &ps.API, Reject},
+		{"db", &ps.DB, Clamp},
+		{"migration", &ps.Migration, Clamp},
+	} {
+		if *p.p == "" {
+			*p.p = p.def
+		}
+		if err := p.p.Validate(); err != nil {
+			return fmt.Errorf("%s policy: %w", p.name, err)
+		}
+	}
+	return nil
+}
+
+// Enforce applies the `p` policy to the `err` error which is returned
+// by a Mutate method (or a Component.Deserialize method) of the `comp`
+// component. If `err` does not implement the BoundsError interface, it
+// is returned as-is. Otherwise, the Reject policy converts it into a
+// *RejectedSettingsError (reporting every out of range setting), while
+// the Clamp and Warn policies log it as a warning (using the `msg`
+// message) and return nil. The Warn policy also restores the original
+// values (see the BoundsError.Restore method), so they take effect,
+// unless they violate the declared constraints. In that case, the
+// *ConstraintsError of the Restore method is returned.
+func (p Policy) Enforce(
+	ctx context.Context, comp model.Component, err error, msg string,
+) error {
+	var boundsErr BoundsError
+	if !errors.As(err, &boundsErr) {
+		return err
+	}
+	if p == Reject {
+		return &RejectedSettingsError{
+			Component:  comp,
+			Violations: boundsErr.Violations(),
+		}
+	}
+	if p == Warn {
+		if err := boundsErr.Restore(); err != nil {
+			return err
+		}
+	}
+	log.Warn(
+		ctx, msg,
+		log.String("component", string(comp)),
+		log.String("policy", string(p)),
+		log.Err("err", err),
+	)
+	return nil
+}
+
+// RejectedSettingsError indicates that some settings of a Component
+// were out of their acceptable range of values and they were rejected
+// because of the Reject policy. All out of range settings are listed,
+// so they can be fixed together.
+type RejectedSettingsError struct {
+	Component  model.Component
+	Violations []Violation
+}
+
+// Error implements the error interface and lists all out of range
+// settings with their field paths.
+func (e *RejectedSettingsError) Error() string {
+	msgs := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", v.Field, v.Err))
+	}
+	return fmt.Sprintf(
+		"%q settings are out of range: %s",
+		e.Component, strings.Join(msgs, "; "),
+	)
+}
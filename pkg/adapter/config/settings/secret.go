@@ -0,0 +1,150 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package settings
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// redacted is the placeholder which is printed instead of the actual
+// value of a Secret instance.
+const redacted = "******"
+
+// sealedPrefix is prepended to the base64 encoded ciphertext of all
+// Sealed secrets, identifying their encryption algorithm, so it may be
+// replaced in future without breaking the older database rows.
+const sealedPrefix = "aes256gcm:"
+
+// KeySize is the size of the symmetric encryption keys in bytes which
+// must be found (base64 encoded) in the key files of LoadCipher.
+const KeySize = 32
+
+// Secret is a specialization of string which holds a write-only (aka
+// mutable & invisible) setting in plaintext. It redacts its value when
+// formatted or logged, so it may not be disclosed accidentally, and
+// must be sealed using a Cipher before being stored in the database.
+type Secret string
+
+// String implements the fmt.Stringer interface and returns a fixed
+// placeholder instead of the `s` secret value.
+func (s Secret) String() string {
+	return redacted
+}
+
+// GoString implements the fmt.GoStringer interface, so the `s` secret
+// value is redacted when formatted using the %#v verb too.
+func (s Secret) GoString() string {
+	return `"` + redacted + `"`
+}
+
+// LogValue implements slog.LogValuer and returns a StringValue with
+// a fixed placeholder instead of the `s` secret value.
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+// Text implements the Constrainable interface and returns the `s`
+// secret plaintext, so it may be verified by a Constraints[Secret].
+// The returned string must not be logged or reported.
+func (s Secret) Text() string {
+	return string(s)
+}
+
+// Sealed is the encrypted form of a Secret, as produced by a Cipher.
+// It contains the sealedPrefix and the base64 encoding of the random
+// nonce which is followed by the authenticated ciphertext, so it can be
+// stored (e.g., in a JSON column) without revealing the secret value.
+type Sealed string
+
+// Cipher seals and opens secrets using the AES-256 block cipher in the
+// Galois/Counter mode (GCM) with a symmetric key which is loaded from
+// a local key file. A Cipher instance is immutable and may be shared.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// LoadCipher reads the `path` key file and creates a Cipher using its
+// contents. The key file must contain KeySize random bytes which are
+// encoded using the standard base64 encoding. Surrounding white spaces
+// are ignored, so a key file may be generated using the following
+// command:
+//
+//	head -c 32 /dev/urandom | base64 > settings.key
+func LoadCipher(path string) (*Cipher, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading key file: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(
+		string(bytes.TrimSpace(b)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("decoding key file: %w", err)
+	}
+	return NewCipher(key)
+}
+
+// NewCipher creates a Cipher using the given `key` which must contain
+// exactly KeySize bytes.
+func NewCipher(key []byte) (*Cipher, error) {
+	if l := len(key); l != KeySize {
+		return nil, fmt.Errorf(
+			"key has %d bytes, instead of %d bytes", l, KeySize,
+		)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes.NewCipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cipher.NewGCM: %w", err)
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal encrypts the `s` secret using a fresh random nonce, so sealing
+// the same secret twice produces distinct Sealed values.
+func (c *Cipher) Seal(s Secret) (Sealed, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("generating nonce: %w", err)
+	}
+	b := c.aead.Seal(nonce, nonce, []byte(s), nil)
+	return Sealed(sealedPrefix + base64.StdEncoding.EncodeToString(b)), nil
+}
+
+// Open decrypts the `s` sealed secret and returns its plaintext.
+// An error is returned if `s` is malformed or it was not sealed by
+// a Cipher having the same key as `c`.
+func (c *Cipher) Open(s Sealed) (Secret, error) {
+	enc, ok := strings.CutPrefix(string(s), sealedPrefix)
+	if !ok {
+		return "", errors.New("unknown sealed secret format")
+	}
+	b, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return "", fmt.Errorf("decoding sealed secret: %w", err)
+	}
+	ns := c.aead.NonceSize()
+	if len(b) < ns {
+		return "", errors.New("sealed secret is too short")
+	}
+	pt, err := c.aead.Open(nil, b[:ns], b[ns:], nil)
+	if err != nil {
+		return "", fmt.Errorf("opening sealed secret: %w", err)
+	}
+	return Secret(pt), nil
+}
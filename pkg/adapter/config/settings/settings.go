@@ -17,9 +17,7 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/goccy/go-json"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration"
-	"github.com/momeni/clean-arch/pkg/core/log"
 	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
@@ -148,7 +146,9 @@ type Config[C, S any] interface {
 
 	// Serializable creates and returns an instance of *S in order to
 	// report the mutable settings, based on this Config[C, S] instance.
-	Serializable() *S
+	// An error is returned if the settings cannot be converted, e.g.,
+	// because a secret setting cannot be sealed.
+	Serializable() (*S, error)
 
 	// Bounds creates and returns two instances of *S in order to
 	// report the minimum and maximum boundary values for those settings
@@ -157,6 +157,15 @@ type Config[C, S any] interface {
 	// immutable settings (as they have an informational purpose).
 	// All boundary values are obtained from this Config[C, S] instance.
 	Bounds() (minb, maxb *S)
+
+	// Components returns the registry of components which keep their
+	// mutable settings in distinct rows of the settings table. The S
+	// serializable type belongs to the model.AppComponent component
+	// which must be included in the returned slice, while other
+	// components may use their own serializable types. Similar to the
+	// MajorVersion method, the returned registry only depends on the C
+	// type and so this method can be called with a nil instance too.
+	Components() []Component[C]
 }
 
 // BoundsError indicates that some of the configuration settings have
@@ -170,6 +179,18 @@ type BoundsError interface {
 	// violation error types can be distinguished from other error
 	// types in a version-independent manner.
 	IsBoundsError()
+
+	// Violations lists the out of range settings with their paths in
+	// the serializable struct of their configuration version.
+	Violations() []Violation
+
+	// Restore reverts the adjustment of all out of range settings, so
+	// they take their original values again (see the Warn policy).
+	// The declared constraints were verified against the adjusted
+	// values, so they are verified against the original values again
+	// and a *ConstraintsError is returned if they are violated. In that
+	// case, the restored values must not be kept.
+	Restore() error
 }
 
 // Adapter of C and S is a generic struct which wraps and adapts an
@@ -218,34 +239,39 @@ func (a Adapter[C, S]) MergeSettings(
 	return a.MergeConfig(ctx, c.Dereference())
 }
 
-// Serialize finds out about the mutable settings of its embedded Config
-// instance using the Serializable method, then tries to serialize it
-// as a json string.
-// It also obtains minimum and maximum boundary values for all mutable
-// and immutable settings using the Bounds method and returns their
-// serialized form as two other json strings.
-// Any returned error belongs to the json serialization phase.
+// Components returns the names of all components which are registered
+// by the embedded Config instance, so the use cases layer may serialize
+// and persist each one of them in a distinct settings row.
+func (a Adapter[C, S]) Components() []model.Component {
+	comps := a.Config.Components()
+	names := make([]model.Component, len(comps))
+	for i, comp := range comps {
+		names[i] = comp.Name()
+	}
+	return names
+}
+
+// Serialize finds out about the mutable settings of the `c` component
+// in its embedded Config instance, then tries to serialize them as a
+// json string.
+// It also obtains minimum and maximum boundary values for the mutable
+// and immutable settings of that component and returns their
+// serialized form as two other json strings. The declared constraints
+// of that component are returned as the `cons` json string (or nil if
+// that component declares no constraints).
+// Any returned error belongs to the json serialization phase or
+// indicates that `c` is not a registered component.
 // This serialization decouples the configuration settings format from
 // the database schema format versions.
-func (a Adapter[C, S]) Serialize() (ms, minb, maxb []byte, err error) {
-	s := a.Config.Serializable()
-	ms, err = json.Marshal(s)
-	if err != nil {
-		err = fmt.Errorf("marshalling settings: %w", err)
-		return nil, nil, nil, err
-	}
-	lb, ub := a.Config.Bounds()
-	minb, err = json.Marshal(lb)
-	if err != nil {
-		err = fmt.Errorf("marshalling minimum bounds: %w", err)
-		return nil, nil, nil, err
-	}
-	maxb, err = json.Marshal(ub)
-	if err != nil {
-		err = fmt.Errorf("marshalling maximum bounds: %w", err)
-		return nil, nil, nil, err
+func (a Adapter[C, S]) Serialize(c model.Component) (
+	ms, minb, maxb, cons []byte, err error,
+) {
+	comp, ok := FindComponent(a.Config.Components(), c)
+	if !ok {
+		err = fmt.Errorf("unknown settings component: %q", c)
+		return nil, nil, nil, nil, err
 	}
-	return ms, minb, maxb, nil
+	return comp.Serialize(a.Config.Dereference())
 }
 
 // UpMigrator of C, S, and U describes the expected interface of a
@@ -369,59 +395,138 @@ type DownMigrator[C Config[C, S], S, D any] interface {
 // LoadFromDB connects to the database, using the connection information
 // from the `c` configuration argument and repo.NormalRole role, queries
 // the database assuming that it has the c.SchemaVersion() version
-// in order to obtain the serialized mutable settings (which must follow
-// the same version is used by `c`). LoadFromDB also deserializes the
-// queried settings in order to obtain an instance of S type and
-// updates the `c` argument in place using its Mutate method.
+// in order to obtain the serialized mutable settings of every component
+// which is registered by `c` (see the Components method). Each settings
+// row must follow the same version which is used by its component in
+// `c`. LoadFromDB also deserializes the queried settings and updates
+// the `c` argument in place using those components.
+// A component without a settings row keeps its configuration file
+// values, unless it is the model.AppComponent which must always have
+// a persisted row.
 // Errors will be returned by proper wrapping.
-// In case of errors, the `c` will remain unchanged.
-// In case of a BoundsError error, the `c` will be updated and that
-// error will be logged as a warning (and a nil error will be returned).
-func LoadFromDB[C, S any](ctx context.Context, c Config[C, S]) error {
+// In case of errors, the `c` may be partially updated, so callers
+// should discard it.
+// In case of a BoundsError error, the `p` policy decides if `c` should
+// keep the adjusted values or the original values (and that error will
+// be logged as a warning), or if the settings must be rejected. In the
+// latter case, all components are deserialized, so a joined error may
+// report every out of range setting (as *RejectedSettingsError items).
+func LoadFromDB[C, S any](
+	ctx context.Context, c Config[C, S], p Policy,
+) error {
+	return loadFromDB(ctx, c, p, false)
+}
+
+// ProbeFromDB is similar to the LoadFromDB function, but it connects
+// to the database using the ProbeConnectionPool method of `c`, so a
+// temporary password is not accepted and no file is changed.
+func ProbeFromDB[C, S any](
+	ctx context.Context, c Config[C, S], p Policy,
+) error {
+	return loadFromDB(ctx, c, p, true)
+}
+
+// loadFromDB implements the LoadFromDB and ProbeFromDB functions.
+// The `probe` argument is passed to the queryMutableSettings function.
+func loadFromDB[C, S any](
+	ctx context.Context, c Config[C, S], p Policy, probe bool,
+) error {
 	dbVer := c.SchemaVersion()
-	ms, err := queryMutableSettings(ctx, c, dbVer)
+	comps := c.Components()
+	rows, err := queryMutableSettings(ctx, c, dbVer, comps, probe)
 	if err != nil {
 		return fmt.Errorf(
 			"querying mutable settings (dbVer=%s): %w", dbVer, err,
 		)
 	}
-	mutableSettings := new(S)
-	if err := json.Unmarshal(ms, mutableSettings); err != nil {
-		return fmt.Errorf("decoding mutable settings: %w", err)
-	}
-	err = c.Mutate(*mutableSettings)
-	var boundsErr BoundsError
-	switch {
-	case errors.As(err, &boundsErr):
-		log.Warn(
-			ctx,
+	cc := c.Dereference()
+	var rejections []error
+	for i, comp := range comps {
+		if rows[i] == nil {
+			continue
+		}
+		err = p.Enforce(
+			ctx, comp.Name(), comp.Deserialize(cc, rows[i]),
 			"settings read from database are out of range",
-			log.Err("err", err),
 		)
-		return nil
-	case err != nil:
-		return fmt.Errorf("mutating settings: %w", err)
+		var rejectedErr *RejectedSettingsError
+		switch {
+		case errors.As(err, &rejectedErr):
+			rejections = append(rejections, err)
+		case err != nil:
+			return fmt.Errorf(
+				"mutating %q settings: %w", comp.Name(), err,
+			)
+		}
 	}
-	return nil
+	return errors.Join(rejections...)
 }
 
+// queryMutableSettings connects to the database which is described by
+// the `c` configuration and loads the serialized settings of the given
+// `comps` components. The i-th returned byte slice belongs to the i-th
+// component and is nil if that component has no settings row (which
+// is only acceptable for components other than model.AppComponent).
+// If `probe` is true, the ProbeConnectionPool method of `c` is used
+// for connecting to the database, otherwise, its ConnectionPool method
+// is used (which may accept a temporary password).
 func queryMutableSettings[C, S any](
-	ctx context.Context, c Config[C, S], dbVer model.SemVer,
-) (mutableSettings []byte, err error) {
-	p, err := c.ConnectionPool(ctx, repo.NormalRole)
+	ctx context.Context,
+	c Config[C, S],
+	dbVer model.SemVer,
+	comps []Component[C],
+	probe bool,
+) (rows [][]byte, err error) {
+	var p repo.Pool
+	if probe {
+		p, _, err = c.ProbeConnectionPool(ctx, repo.NormalRole)
+	} else {
+		p, err = c.ConnectionPool(ctx, repo.NormalRole)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("creating connection pool: %w", err)
 	}
 	defer p.Close()
+	rows = make([][]byte, len(comps))
 	err = p.Conn(ctx, func(ctx context.Context, conn repo.Conn) error {
-		mutableSettings, err = migration.LoadSettings(ctx, conn, dbVer)
-		if err != nil {
-			return fmt.Errorf("migration.LoadSettings: %w", err)
+		for i, comp := range comps {
+			name := comp.Name()
+			ms, err := migration.LoadSettings(ctx, conn, dbVer, name)
+			switch {
+			case errors.Is(err, repo.ErrSettingsNotFound) &&
+				name != model.AppComponent:
+				continue
+			case err != nil:
+				return fmt.Errorf("migration.LoadSettings: %w", err)
+			}
+			rows[i] = ms
 		}
 		return nil
 	})
 	if err != nil {
 		return nil, fmt.Errorf("connection: %w", err)
 	}
-	return mutableSettings, nil
+	return rows, nil
+}
+
+// PathError associates an error with the path of its setting in the
+// configuration file (having its yaml keys separated by a dot character,
+// e.g., "usecases.cars.delay-of-old-parking-method"), so validation
+// tools can report the line number of the problematic setting. Since
+// the path is a metadata for such tools, it is not included in the
+// error message and wrapping an error in a PathError does not change
+// its message.
+type PathError struct {
+	Path string
+	Err  error
+}
+
+// Error returns the message of the wrapped error.
+func (e *PathError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *PathError) Unwrap() error {
+	return e.Err
 }
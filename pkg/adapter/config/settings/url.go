@@ -0,0 +1,99 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+)
+
+// URL is an absolute URL (having a scheme and a host) which is kept in
+// its normalized string form, so it remains comparable and its values
+// can be listed by the Enum item of a Constraints[URL] instance. The
+// Pattern constraint is more useful for restricting URLs (e.g., to the
+// https scheme) because their boundary values are compared in the
+// lexicographical order.
+// Its version-independent counterpart is a plain string which can be
+// converted to a URL using the ParseURL function.
+type URL string
+
+// ParseURL parses the `s` string as an absolute URL and returns it in
+// its normalized form. An error is returned if `s` cannot be parsed or
+// lacks a scheme or host.
+func ParseURL(s string) (URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("parsing url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("url %q is not absolute", u.Redacted())
+	}
+	return URL(u.String()), nil
+}
+
+// UnmarshalText reifies the encoding.TextUnmarshaler interface, so
+// a byte slice (e.g., read from a YAML file) can be decoded as a URL
+// using the ParseURL function. In absence of errors, a nil error will
+// be returned and only then, `u` receiver will be updated.
+func (u *URL) UnmarshalText(data []byte) error {
+	v, err := ParseURL(string(data))
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
+
+// Marshal returns the string representation of the `u` URL.
+// If u is nil, nil will be returned, so it can be used by higher-level
+// Marshal methods (similar to the Duration.Marshal method).
+// The returned pointer will refer to a newly allocated string variable.
+func (u *URL) Marshal() *string {
+	if u == nil {
+		return nil
+	}
+	s := string(*u)
+	return &s
+}
+
+// MarshalText implements encoding.TextMarshaler interface and
+// serializes `u` URL using its Marshal method.
+// This interface is required for json serialization.
+func (u *URL) MarshalText() ([]byte, error) {
+	if s := u.Marshal(); s != nil {
+		return []byte(*s), nil
+	}
+	return nil, errors.New("nil url")
+}
+
+// LogValue implements slog.LogValuer and returns a StringValue which
+// contains the `u` URL (after replacing its password, if any, by xxxxx)
+// if it is not nil, otherwise, it returns the constant "nil-url" value.
+func (u *URL) LogValue() slog.Value {
+	if u == nil {
+		return slog.StringValue("nil-url")
+	}
+	return slog.StringValue(u.Parsed().Redacted())
+}
+
+// Parsed returns the `u` URL as a *url.URL instance.
+// Since `u` was validated when it was parsed, the parsing error is
+// not expected and an empty *url.URL is returned in that case.
+func (u URL) Parsed() *url.URL {
+	pu, err := url.Parse(string(u))
+	if err != nil {
+		return &url.URL{}
+	}
+	return pu
+}
+
+// Text implements the Constrainable interface and returns the same
+// string representation of `u` as the Marshal method.
+func (u URL) Text() string {
+	return string(u)
+}
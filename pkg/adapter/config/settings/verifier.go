@@ -16,6 +16,8 @@ type OutOfRangeError[T cmp.Ordered] struct {
 	Value        *T   // The actual out-of-range value
 	LessThanMin  bool // true if and only if min boundary is violated
 	InvalidRange bool // true if and only if min is greater than max
+
+	target *T // The adjusted setting which may be restored
 }
 
 // Error implements error interface and returns a string reporting that
@@ -31,12 +33,23 @@ func (e *OutOfRangeError[T]) Error() string {
 	}
 }
 
+// Restore reverts the adjustment of the out of range setting, so it
+// takes its original Value again. It is useful when out of range values
+// should be reported, but kept as-is (see the Warn policy). Restore has
+// no effect when the range itself was invalid.
+func (e *OutOfRangeError[T]) Restore() {
+	if e.target != nil {
+		*e.target = *e.Value
+	}
+}
+
 // VerifyRange verifies the given value ensuring that it is either nil
 // or is within the provided minb/maxb boundary values, if the boundary
 // values were given as non-nil values themselves. In case of a wrong
 // value, in addition to the returned error, the value itself will be
 // updated in order to take the minb or maxb value and fall in the
-// acceptable range of values.
+// acceptable range of values. That adjustment may be reverted by the
+// Restore method of the returned error.
 func VerifyRange[T cmp.Ordered](
 	value **T, minb, maxb *T,
 ) *OutOfRangeError[T] {
@@ -49,10 +62,14 @@ func VerifyRange[T cmp.Ordered](
 	switch v := **value; {
 	case minb != nil && v < *minb:
 		**value = *minb
-		return &OutOfRangeError[T]{Value: &v, LessThanMin: true}
+		return &OutOfRangeError[T]{
+			Value: &v, LessThanMin: true, target: *value,
+		}
 	case maxb != nil && v > *maxb:
 		**value = *maxb
-		return &OutOfRangeError[T]{Value: &v, LessThanMin: false}
+		return &OutOfRangeError[T]{
+			Value: &v, LessThanMin: false, target: *value,
+		}
 	}
 	return nil
 }
@@ -0,0 +1,137 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/env"
+	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
+	"github.com/momeni/clean-arch/pkg/adapter/db/postgres"
+	"gopkg.in/yaml.v3"
+)
+
+// Redacted replaces the values of redacted settings in the Show output.
+const Redacted = "******"
+
+// redactedPaths lists the settings which may help an attacker to find
+// the credentials (such as the database passwords or the encryption
+// key of secret settings), so they are redacted by the Show function.
+// The password source command is redacted too, since its arguments may
+// carry tokens.
+var redactedPaths = [][]string{
+	{"database", "pass-dir"},
+	{"database", "password-source", "path"},
+	{"database", "password-source", "command"},
+	{"encryption", "key-file"},
+}
+
+// LoadFromDB function loads the configuration file from the given
+// `paths` (similar to the Load function) and overrides its mutable
+// settings by their values from the database (see the
+// cfg3.LoadFromDBWithEnv function).
+// If the configuration file was valid, but the database could not be
+// reached (or contained unacceptable settings), the loaded settings are
+// returned with true as their ok flag alongside the database error.
+func LoadFromDB(ctx context.Context, paths ...string) (
+	*cfg3.Config, bool, error,
+) {
+	data, _, err := ReadFiles(paths...)
+	if err != nil {
+		return nil, false, err
+	}
+	v, err := vers.Load(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("loading versions: %w", err)
+	}
+	vc := v.Versions
+	switch {
+	case vc.Config != cfg3.Version:
+		return nil, false, fmt.Errorf(
+			"unexpected config version: %s", vc.Config.String(),
+		)
+	case vc.Database != postgres.Version:
+		return nil, false, fmt.Errorf(
+			"unexpected database schema version: %s",
+			vc.Database.String(),
+		)
+	}
+	return cfg3.LoadFromDBWithEnv(ctx, data, env.LookupOS)
+}
+
+// Show serializes the `c` effective configuration settings in the YAML
+// format, so they can be displayed to operators. It differs from the
+// yaml.Marshal function in three aspects. First, paths which may lead
+// to the credentials (i.e., the passwords directory, the file and the
+// command of the password source, and the encryption key file) are
+// redacted. Second, the secret settings which are not
+// marshalled at all (see the cfg3.Marshalled struct) are listed with
+// their redacted values, so operators can see that they are set.
+// Third, settings which were overridden by environment variables (as
+// recorded in the `c.Sources` field) are annotated by a comment naming
+// their variables.
+func Show(c *cfg3.Config) ([]byte, error) {
+	v, err := c.MarshalYAML()
+	if err != nil {
+		return nil, fmt.Errorf("marshalling config: %w", err)
+	}
+	root := v.(*yaml.Node)
+	for _, keys := range redactedPaths {
+		n := env.Find(root, keys)
+		if n != nil && (n.Value != "" || n.Kind == yaml.SequenceNode) {
+			redact(n)
+		}
+	}
+	if c.Usecases.Cars.APIKeyOfOPM != nil {
+		cars := env.Find(root, []string{"usecases", "cars"})
+		if cars != nil && cars.Kind == yaml.MappingNode {
+			n := &yaml.Node{}
+			redact(n)
+			cars.Content = append(cars.Content, &yaml.Node{
+				Kind:  yaml.ScalarNode,
+				Tag:   "!!str",
+				Value: "api-key-of-old-parking-method",
+			}, n)
+		}
+	}
+	for _, p := range c.Sources.Paths() {
+		if c.Sources[p] != env.Env {
+			continue
+		}
+		l := env.Leaf{Keys: strings.Split(p, ".")}
+		if n := env.Find(root, l.Keys); n != nil {
+			if n.Kind != yaml.ScalarNode {
+				// the block style would move the comment to its end
+				n.Style = yaml.FlowStyle
+			}
+			n.LineComment = "from " + l.Var()
+		}
+	}
+	b, err := yaml.Marshal(root)
+	if err != nil {
+		return nil, fmt.Errorf("encoding yaml node: %w", err)
+	}
+	return b, nil
+}
+
+// redact replaces the value of the `n` scalar node by the Redacted
+// constant. If `n` is a sequence node, its items are redacted instead,
+// so the number of items (e.g., command arguments) is still shown.
+func redact(n *yaml.Node) {
+	if n.Kind == yaml.SequenceNode {
+		for _, item := range n.Content {
+			redact(item)
+		}
+		return
+	}
+	n.Kind = yaml.ScalarNode
+	n.Tag = "!!str"
+	n.Value = Redacted
+	n.Style = yaml.SingleQuotedStyle
+}
@@ -90,6 +90,26 @@ func (a Adapter) MigrateUp(ctx context.Context) (
 	return upmig2.Adapt(m), nil
 }
 
+// Renames maps the paths of the yaml keys which are renamed by the
+// upwards migration to their new paths in the major version 2, so their
+// comments can be carried by the comment.Comment Rename method.
+var Renames = map[string]string{
+	v1DelayPath:    v2DelayPath,
+	v1MinDelayPath: v2MinDelayPath,
+	v1MaxDelayPath: v2MaxDelayPath,
+}
+
+// These constants are the yaml paths of the old parking method delay
+// setting and its boundary values in the major versions 1 and 2.
+const (
+	v1DelayPath    = "usecases.cars.old-parking-method-delay"
+	v1MinDelayPath = "usecases.cars.old-parking-method-delay-minimum"
+	v1MaxDelayPath = "usecases.cars.old-parking-method-delay-maximum"
+	v2DelayPath    = "usecases.cars.delay-of-old-parking-method"
+	v2MinDelayPath = "usecases.cars.delay-of-old-parking-method-minimum"
+	v2MaxDelayPath = "usecases.cars.delay-of-old-parking-method-maximum"
+)
+
 // Migrator is an upwards Config migrator for *cfg1.Config instances.
 // It wraps a Config struct (with major version 1) and implements
 // the repo.Settler and pkg/adapter/config/settings.UpMigrator
@@ -101,9 +121,11 @@ type Migrator struct {
 // MigrateUp creates a *cfg2.Config instance and fills it with the
 // settings which are kept in `m.Config` fields. Any field which its
 // value may not be computed based on the settings which are available
-// in this version will be left uninitialized. The computed Config
-// instance with major version 2 will be wrapped by its corresponding
-// upwards migrator before being returned.
+// in this version will be left uninitialized. The comments are carried
+// too, so settings which keep their yaml keys also keep their comments,
+// while comments of the renamed keys are moved based on the Renames.
+// The computed Config instance with major version 2 will be wrapped
+// by its corresponding upwards migrator before being returned.
 func (m *Migrator) MigrateUp(
 	_ context.Context,
 ) (*upmig2.Migrator, error) {
@@ -115,6 +137,7 @@ func (m *Migrator) MigrateUp(
 				Config:   cfg2.Version,
 			},
 		},
+		Comments: m.Config.Comments.Rename(Renames),
 	}
 	settings.OverwriteNil(&c.Gin.Logger, m.Config.Gin.Logger)
 	settings.OverwriteNil(&c.Gin.Recovery, m.Config.Gin.Recovery)
@@ -122,6 +145,14 @@ func (m *Migrator) MigrateUp(
 		&c.Usecases.Cars.DelayOfOPM,
 		m.Config.Usecases.Cars.OldParkingDelay,
 	)
+	settings.OverwriteNil(
+		&c.Usecases.Cars.MinDelayOfOPM,
+		m.Config.Usecases.Cars.MinOldParkingDelay,
+	)
+	settings.OverwriteNil(
+		&c.Usecases.Cars.MaxDelayOfOPM,
+		m.Config.Usecases.Cars.MaxOldParkingDelay,
+	)
 	return &upmig2.Migrator{c}, nil
 }
 
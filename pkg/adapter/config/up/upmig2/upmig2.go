@@ -14,10 +14,12 @@ package upmig2
 
 import (
 	"context"
-	"errors"
 
 	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
 	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+	"github.com/momeni/clean-arch/pkg/adapter/config/up/upmig3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
 )
@@ -25,15 +27,14 @@ import (
 // These type aliases specify the underlying Config struct (with major
 // version 2) as C and the parameterized settings.UpMigrator interface
 // which is supposed to be implemented by the Migrator struct as Type.
-// The Type uses the *Migrator from the same package/version because
-// there is no newer/upper version.
+// The Type uses the *upmig3.Migrator as its newer/upper counterpart.
 type (
 	// C is the underlying Config type.
 	C = *cfg2.Config
 	// S is the Serializable struct type containing mutable settings
 	S = cfg2.Serializable
 	// Type is provided by Migrator type.
-	Type = settings.UpMigrator[C, S, *Migrator]
+	Type = settings.UpMigrator[C, S, *upmig3.Migrator]
 )
 
 // Adapter wraps and adapts an instance of Type in order to provide
@@ -76,8 +77,6 @@ func (a Adapter) Settler() migrationuc.Settings {
 // upwards migrator object, and adapts it to the version-independent
 // repo.UpMigrator[migrationuc.Settings] interface using the Adapt
 // function.
-// Since major version 2 has no upper version, this method always
-// returns an error.
 func (a Adapter) MigrateUp(ctx context.Context) (
 	repo.UpMigrator[migrationuc.Settings], error,
 ) {
@@ -85,7 +84,7 @@ func (a Adapter) MigrateUp(ctx context.Context) (
 	if err != nil {
 		return nil, err
 	}
-	return Adapt(m), nil
+	return upmig3.Adapt(m), nil
 }
 
 // Migrator is an upwards Config migrator for *cfg2.Config instances.
@@ -96,12 +95,39 @@ type Migrator struct {
 	*cfg2.Config
 }
 
-// MigrateUp should migrate the wrapped cfg2.Config instance to its
-// newer version and wrap it by a corresponding upwards migrator.
-// However, since major version 2 has no newer major version, this
-// method always returns an error.
-func (m *Migrator) MigrateUp(_ context.Context) (*Migrator, error) {
-	return nil, errors.New("v2 is the latest settings major version")
+// MigrateUp creates a *cfg3.Config instance and fills it with the
+// settings which are kept in `m.Config` fields. All settings of this
+// version are kept with the same format and yaml keys in the major
+// version 3, so they are copied (and renewed, so both Config instances
+// stay independent) as they are. The comments are carried too.
+// The server settings have no counterpart in this version, so they are
+// left uninitialized (to be filled by the MergeConfig method).
+// The database password-source is left nil too, so passwords are still
+// found in the .pgpass file of the pass-dir directory.
+// The secret settings are copied in plaintext (as they were opened by
+// the source Encryption key), so they are sealed using the target key
+// after the MergeConfig method replaces the Encryption settings.
+// The computed Config instance with major version 3 will be wrapped
+// by its corresponding upwards migrator before being returned.
+func (m *Migrator) MigrateUp(
+	_ context.Context,
+) (*upmig3.Migrator, error) {
+	cc := m.Config.Clone()
+	c := &cfg3.Config{
+		Database:   cfg3.Database{Database: cc.Database},
+		Gin:        cc.Gin,
+		Usecases:   cc.Usecases,
+		Encryption: cc.Encryption,
+		Policies:   cc.Policies,
+		Vers: vers.Config{
+			Versions: vers.Versions{
+				Database: cc.Vers.Versions.Database,
+				Config:   cfg3.Version,
+			},
+		},
+		Comments: cc.Comments,
+	}
+	return &upmig3.Migrator{c}, nil
 }
 
 // Settler returns the wrapped Config object. After migrating from a
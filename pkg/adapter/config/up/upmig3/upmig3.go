@@ -0,0 +1,115 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package upmig3 provides configuration file settings upwards Migrator
+// for settings major version 3 and its Adapter type for the version
+// independent repo.UpMigrator[migrationuc.Settings] interface.
+//
+// The settings.UpMigrator generic interface is employed in order to
+// ensure that this version-specific implementation uses consistent
+// types as its method return types.
+package upmig3
+
+import (
+	"context"
+	"errors"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
+)
+
+// These type aliases specify the underlying Config struct (with major
+// version 3) as C and the parameterized settings.UpMigrator interface
+// which is supposed to be implemented by the Migrator struct as Type.
+// The Type uses the *Migrator from the same package/version because
+// there is no newer/upper version.
+type (
+	// C is the underlying Config type.
+	C = *cfg3.Config
+	// S is the Serializable struct type containing mutable settings
+	S = cfg3.Serializable
+	// Type is provided by Migrator type.
+	Type = settings.UpMigrator[C, S, *Migrator]
+)
+
+// Adapter wraps and adapts an instance of Type in order to provide
+// the repo.UpMigrator[migrationuc.Settings] interface.
+type Adapter struct {
+	T Type
+}
+
+// NewUpMig creates a Migrator struct wrapping the given Config instance
+// and then uses the Adapt function in order to adapt it to the version
+// independent repo.UpMigrator[migrationuc.Settings] interface.
+//
+// The Migrator struct is exported and users which need a concrete type
+// can create it directly and wrap the `c` instance. This helper New
+// function is provided in order to combine these two steps (of creation
+// and adaptation) together.
+func NewUpMig(c *cfg3.Config) repo.UpMigrator[migrationuc.Settings] {
+	m := &Migrator{c}
+	return Adapt(m)
+}
+
+// Adapt creates an instance of Adapter struct wraping the `m` argument.
+// Because Adapter expects to wrap a Type instance, it asserts that
+// Migrator struct implements the Type interface, its implementation is
+// correct (considering the expected return types), and provides the
+// repo.UpMigrator[migrationuc.Settings] interface.
+func Adapt(m *Migrator) repo.UpMigrator[migrationuc.Settings] {
+	return Adapter{m}
+}
+
+// Settler calls the wrapped Type Settler method, obtains a C instance,
+// and wraps it by settings.Adapter[C, S] in order to expose an instance
+// of migrationuc.Settings interface.
+func (a Adapter) Settler() migrationuc.Settings {
+	c := a.T.Settler()
+	return settings.Adapter[C, S]{c}
+}
+
+// MigrateUp calls the wrapped Type MigrateUp method, obtains the next
+// upwards migrator object, and adapts it to the version-independent
+// repo.UpMigrator[migrationuc.Settings] interface using the Adapt
+// function.
+// Since major version 3 has no upper version, this method always
+// returns an error.
+func (a Adapter) MigrateUp(ctx context.Context) (
+	repo.UpMigrator[migrationuc.Settings], error,
+) {
+	m, err := a.T.MigrateUp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Adapt(m), nil
+}
+
+// Migrator is an upwards Config migrator for *cfg3.Config instances.
+// It wraps a Config struct (with major version 3) and implements
+// the repo.Settler and pkg/adapter/config/settings.UpMigrator
+// generic interfaces.
+type Migrator struct {
+	*cfg3.Config
+}
+
+// MigrateUp should migrate the wrapped cfg3.Config instance to its
+// newer version and wrap it by a corresponding upwards migrator.
+// However, since major version 3 has no newer major version, this
+// method always returns an error.
+func (m *Migrator) MigrateUp(_ context.Context) (*Migrator, error) {
+	return nil, errors.New("v3 is the latest settings major version")
+}
+
+// Settler returns the wrapped Config object. After migrating from a
+// source Config version upwards and reaching to an ultimate version,
+// this method reveals the final migrated Config object.
+// This object may have some uninitialized settings too. The MergeConfig
+// method may be used in order to fill them from another Config instance
+// containing the default settings for major version 3.
+func (m *Migrator) Settler() *cfg3.Config {
+	return m.Config
+}
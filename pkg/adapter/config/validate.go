@@ -0,0 +1,284 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg1"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/env"
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem describes one issue which was found in a configuration file
+// by the Validate function.
+type Problem struct {
+	// Line is the 1-based line number of the problematic setting in
+	// the configuration file, or zero if it is not known (e.g., when
+	// a setting is missing or was overridden by an environment
+	// variable).
+	Line int
+
+	// Path is the path of the problematic setting (having its yaml
+	// keys separated by a dot character, e.g., "database.port"), or
+	// an empty string if the problem is not specific to one setting.
+	Path string
+
+	// Message describes the problem.
+	Message string
+
+	// Warning indicates that this problem does not prevent the
+	// configuration file from being loaded (e.g., an unknown key
+	// which will be ignored), but it is likely to be a mistake.
+	Warning bool
+}
+
+// String formats the `p` problem as "LINE: PATH: MESSAGE", omitting its
+// unknown line number or path, and prefixing the warnings by a
+// "warning: " string.
+func (p Problem) String() string {
+	var sb strings.Builder
+	if p.Line > 0 {
+		fmt.Fprintf(&sb, "%d: ", p.Line)
+	}
+	if p.Warning {
+		sb.WriteString("warning: ")
+	}
+	if p.Path != "" {
+		fmt.Fprintf(&sb, "%s: ", p.Path)
+	}
+	sb.WriteString(p.Message)
+	return sb.String()
+}
+
+var (
+	yamlLineRegexp  = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+	typeErrorRegexp = regexp.MustCompile(`^line \d+: `)
+)
+
+// Validate checks the `data` configuration file contents and returns
+// all of the found problems, so they may be fixed at once (instead of
+// fixing them one by one, as reported by the Load function).
+//
+// Validation is performed in three phases. First, `data` is parsed as
+// a YAML document and its versions are loaded (using the vers.Load
+// function), so the expected format of other settings is known. Second,
+// each setting which is present in `data` is decoded individually into
+// its type (as listed by the env.Leaves function), so all malformed
+// settings are reported with their line numbers, while unknown keys are
+// reported as warnings. Third, if no error was found, `data` is loaded
+// by the versioned loader (e.g., cfg3.Load) which overrides settings by
+// the environment variables and runs the ValidateAndNormalize method,
+// checking the semantic rules such as the min/max boundaries and the
+// declared constraints. Errors which carry a settings.PathError are
+// reported with the line number of their setting (and their messages
+// are trimmed to the wrapped error of that PathError).
+//
+// The returned problems are sorted by their discovery order. A nil
+// slice is returned when no problem was found.
+func Validate(data []byte) []Problem {
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return []Problem{syntaxProblem(err)}
+	}
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return []Problem{{
+			Line:    1,
+			Message: "expected a yaml mapping document",
+		}}
+	}
+	root := doc.Content[0]
+	v, err := vers.Load(data)
+	if err != nil {
+		return []Problem{{
+			Line:    line(root, "versions"),
+			Path:    "versions",
+			Message: err.Error(),
+		}}
+	}
+	var t reflect.Type
+	var load func(data []byte) error
+	switch m := v.Versions.Config[0]; m {
+	case 1:
+		t = reflect.TypeOf(cfg1.Config{})
+		load = func(data []byte) error {
+			_, err := cfg1.Load(data)
+			return err
+		}
+	case 2:
+		t = reflect.TypeOf(cfg2.Config{})
+		load = func(data []byte) error {
+			_, err := cfg2.Load(data)
+			return err
+		}
+	case 3:
+		t = reflect.TypeOf(cfg3.Config{})
+		load = func(data []byte) error {
+			_, err := cfg3.Load(data)
+			return err
+		}
+	default:
+		return []Problem{{
+			Line:    line(root, "versions.config"),
+			Path:    "versions.config",
+			Message: fmt.Sprintf("unsupported major version: %d", m),
+		}}
+	}
+	leaves, err := env.Leaves(t)
+	if err != nil {
+		return []Problem{{Message: err.Error()}}
+	}
+	problems := checkLeaves(root, leaves)
+	for _, p := range problems {
+		if !p.Warning {
+			return problems
+		}
+	}
+	if err := load(data); err != nil {
+		problems = append(problems, loadProblems(root, err)...)
+	}
+	return problems
+}
+
+// syntaxProblem converts the `err` error of the yaml.Unmarshal function
+// into a Problem, extracting its line number if possible.
+func syntaxProblem(err error) Problem {
+	m := yamlLineRegexp.FindStringSubmatch(err.Error())
+	if m == nil {
+		return Problem{Message: err.Error()}
+	}
+	n, _ := strconv.Atoi(m[1])
+	return Problem{Line: n, Message: m[2]}
+}
+
+// line returns the line number of the `path` setting in the `root`
+// mapping node, or zero if that setting does not exist.
+func line(root *yaml.Node, path string) int {
+	if n := env.Find(root, strings.Split(path, ".")); n != nil {
+		return n.Line
+	}
+	return 0
+}
+
+// checkLeaves decodes the value of each one of the `leaves` settings
+// which can be found in the `root` mapping node individually, reporting
+// those values which cannot be decoded as errors and the keys of `root`
+// which do not correspond to any known setting as warnings.
+func checkLeaves(root *yaml.Node, leaves []env.Leaf) []Problem {
+	var problems []Problem
+	known := make(map[string]bool, 2*len(leaves))
+	for _, l := range leaves {
+		known[l.Path()] = true
+		for i := 1; i < len(l.Keys); i++ {
+			known[strings.Join(l.Keys[:i], ".")] = false
+		}
+		n := env.Find(root, l.Keys)
+		if n == nil {
+			continue
+		}
+		if err := n.Decode(reflect.New(l.Type).Interface()); err != nil {
+			problems = append(problems, Problem{
+				Line:    n.Line,
+				Path:    l.Path(),
+				Message: decodeMessage(err),
+			})
+		}
+	}
+	return append(problems, unknownKeys(root, "", known)...)
+}
+
+// decodeMessage returns the message of the `err` decoding error,
+// dropping the line numbers from the yaml.TypeError messages because
+// they are reported by the Problem.Line field separately.
+func decodeMessage(err error) string {
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		return err.Error()
+	}
+	msgs := make([]string, len(te.Errors))
+	for i, msg := range te.Errors {
+		msgs[i] = typeErrorRegexp.ReplaceAllString(msg, "")
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// unknownKeys walks over the `n` mapping node (which is found at the
+// `prefix` path) recursively and reports its keys which are not listed
+// in the `known` map as warnings. The `known` map contains true for the
+// settings paths and false for their parent mapping paths, so only the
+// latter ones have to be traversed.
+func unknownKeys(
+	n *yaml.Node, prefix string, known map[string]bool,
+) []Problem {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	var problems []Problem
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k := n.Content[i]
+		path := k.Value
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		leaf, found := known[path]
+		switch {
+		case !found:
+			problems = append(problems, Problem{
+				Line:    k.Line,
+				Path:    path,
+				Message: "unknown setting is ignored",
+				Warning: true,
+			})
+		case !leaf:
+			problems = append(
+				problems, unknownKeys(n.Content[i+1], path, known)...,
+			)
+		}
+	}
+	return problems
+}
+
+// loadProblems converts the `err` error of a versioned loader into
+// a list of problems. Each violation of a settings.ConstraintsError is
+// reported as a separate problem and the settings.PathError instances
+// are used for finding the line numbers of their settings in the `root`
+// mapping node.
+func loadProblems(root *yaml.Node, err error) []Problem {
+	var ce *settings.ConstraintsError
+	if !errors.As(err, &ce) {
+		return []Problem{pathProblem(root, err)}
+	}
+	problems := make([]Problem, 0, len(ce.Violations))
+	for _, v := range ce.Violations {
+		p := pathProblem(root, v.Err)
+		if p.Path == "" {
+			p.Path = v.Field
+		}
+		problems = append(problems, p)
+	}
+	return problems
+}
+
+func pathProblem(root *yaml.Node, err error) Problem {
+	var pe *settings.PathError
+	if !errors.As(err, &pe) {
+		return Problem{Message: err.Error()}
+	}
+	return Problem{
+		Line:    line(root, pe.Path),
+		Path:    pe.Path,
+		Message: pe.Error(),
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package config_test
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/momeni/clean-arch/pkg/adapter/config"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg1"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/env"
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
+	"github.com/momeni/clean-arch/pkg/core/model"
+)
+
+const malformedConfig = `database:
+    host: 127.0.0.1
+    port: fifty
+    name: caweb1_3_0
+    pass-dir: /var/lib/caweb/db
+gin:
+    logger: true
+    colors: true
+usecases:
+    cars:
+        delay-of-old-parking-method: 15
+versions:
+    database: 1.3.0
+    config: 2.2.0
+`
+
+const invalidConfig = `database:
+    host: 127.0.0.1
+    port: 5456
+    name: caweb1_3_0
+    pass-dir: /var/lib/caweb/db
+bounds-policies:
+    api: ignore
+usecases:
+    cars:
+        delay-of-old-parking-method: 15s
+versions:
+    database: 1.3.0
+    config: 2.2.0
+`
+
+const outOfRangeConfig = `database:
+    host: 127.0.0.1
+    port: 5456
+    name: caweb1_3_0
+    pass-dir: /var/lib/caweb/db
+usecases:
+    cars:
+        delay-of-old-parking-method: 1500ms
+        delay-of-old-parking-method-minimum: 1s
+        delay-of-old-parking-method-maximum: 1m
+        delay-of-old-parking-method-constraints:
+            multiple-of: 1s
+versions:
+    database: 1.3.0
+    config: 2.2.0
+`
+
+func ExampleValidate() {
+	for _, data := range []string{
+		"database:\n  host: [\n", malformedConfig, invalidConfig,
+		outOfRangeConfig, "versions:\n    config: 7.0.0\n",
+	} {
+		for _, p := range config.Validate([]byte(data)) {
+			fmt.Println(p)
+		}
+		fmt.Println("--")
+	}
+	// Output:
+	// 2: did not find expected node content
+	// --
+	// 3: database.port: cannot unmarshal !!str `fifty` into int
+	// 11: usecases.cars.delay-of-old-parking-method: time: missing unit in duration "15"
+	// 8: warning: gin.colors: unknown setting is ignored
+	// --
+	// 7: bounds-policies: api policy: unknown policy "ignore" (expected clamp, reject, or warn)
+	// --
+	// 8: usecases.cars.delay-of-old-parking-method: must be a multiple of 1s
+	// --
+	// 2: versions.config: unsupported major version: 7
+	// --
+}
+
+func ExampleShow() {
+	d := settings.Duration(20 * time.Second)
+	k := settings.Secret("the-api-key")
+	addr := "127.0.0.1:8080"
+	rt := settings.Duration(5 * time.Second)
+	c := &cfg3.Config{
+		Database: cfg3.Database{
+			Database: cfg1.Database{
+				Host:    "127.0.0.1",
+				Port:    5456,
+				Name:    "caweb1_3_0",
+				PassDir: "/var/lib/caweb/db/caweb1_3_0",
+			},
+		},
+		Server: cfg3.Server{
+			Address:        &addr,
+			TrustedProxies: []string{"10.0.0.0/8"},
+			ReadTimeout:    &rt,
+		},
+		Encryption: cfg2.Encryption{
+			KeyFile: "/var/lib/caweb/db/caweb1_3_0/settings.key",
+		},
+		Usecases: cfg2.Usecases{
+			Cars: cfg2.Cars{
+				DelayOfOPM:  &d,
+				APIKeyOfOPM: &k,
+			},
+		},
+		Vers: vers.Config{
+			Versions: vers.Versions{
+				Database: model.SemVer{1, 3, 0},
+				Config:   model.SemVer{3, 0, 0},
+			},
+		},
+		Sources: env.Sources{
+			"database.host":                             env.File,
+			"database.port":                             env.Env,
+			"server.trusted-proxies":                    env.Env,
+			"usecases.cars.delay-of-old-parking-method": env.Env,
+		},
+	}
+	b, err := config.Show(c)
+	fmt.Print(string(b), err, "\n")
+	// Output:
+	// database:
+	//     host: 127.0.0.1
+	//     port: 5456 # from CAWEB_DATABASE_PORT
+	//     name: caweb1_3_0
+	//     pass-dir: '******'
+	// gin:
+	//     logger: null
+	//     recovery: null
+	// server:
+	//     address: 127.0.0.1:8080
+	//     trusted-proxies: [10.0.0.0/8] # from CAWEB_SERVER_TRUSTED_PROXIES
+	//     read-timeout: 5s
+	// encryption:
+	//     key-file: '******'
+	// usecases:
+	//     cars:
+	//         delay-of-old-parking-method: 20s # from CAWEB_USECASES_CARS_DELAY_OF_OLD_PARKING_METHOD
+	//         api-key-of-old-parking-method: '******'
+	// versions:
+	//     database: 1.3.0
+	//     config: 3.0.0
+	// <nil>
+}
+
+func ExampleShow_passwordSource() {
+	for _, ps := range []struct {
+		kind, path string
+		command    []string
+	}{
+		{"file", "/run/secrets/caweb-db-{role}", nil},
+		{"command", "", []string{"vault", "read", "-token=s.42", "{role}"}},
+	} {
+		kind, err := settings.ParseEnum[cfg3.PasswordSources](ps.kind)
+		if err != nil {
+			panic(err)
+		}
+		c := &cfg3.Config{
+			Database: cfg3.Database{
+				Database: cfg1.Database{
+					Host: "127.0.0.1",
+					Port: 5456,
+					Name: "caweb1_3_0",
+				},
+				PasswordSource: &cfg3.PasswordSource{
+					Kind:    &kind,
+					Path:    ps.path,
+					Command: ps.command,
+				},
+			},
+		}
+		b, err := config.Show(c)
+		if err != nil {
+			panic(err)
+		}
+		db, _, _ := strings.Cut(string(b), "gin:")
+		fmt.Print(db)
+	}
+	// Output:
+	// database:
+	//     host: 127.0.0.1
+	//     port: 5456
+	//     name: caweb1_3_0
+	//     pass-dir: ""
+	//     password-source:
+	//         kind: file
+	//         path: '******'
+	// database:
+	//     host: 127.0.0.1
+	//     port: 5456
+	//     name: caweb1_3_0
+	//     pass-dir: ""
+	//     password-source:
+	//         kind: command
+	//         command:
+	//             - '******'
+	//             - '******'
+	//             - '******'
+	//             - '******'
+}
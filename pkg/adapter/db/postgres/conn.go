@@ -7,6 +7,7 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 
 	"github.com/momeni/clean-arch/pkg/core/repo"
@@ -35,6 +36,12 @@ type TxHandler = repo.TxHandler
 // In case of errors, the transaction will be rolled back and the
 // error will be returned too.
 func (c *Conn) Tx(ctx context.Context, f TxHandler) (err error) {
+	sc, ok := c.DB.Statement.ConnPool.(*sql.Conn)
+	if !ok {
+		return fmt.Errorf(
+			"unexpected connection type %T", c.DB.Statement.ConnPool,
+		)
+	}
 	tx := c.DB.WithContext(ctx).Begin()
 	if err = tx.Error; err != nil {
 		return fmt.Errorf("begin tx: %w", err)
@@ -62,7 +69,7 @@ func (c *Conn) Tx(ctx context.Context, f TxHandler) (err error) {
 			err = fmt.Errorf("commit: %w", err)
 		}
 	}()
-	tt := &Tx{DB: tx}
+	tt := &Tx{DB: tx, conn: sc}
 	return f(ctx, tt)
 }
 
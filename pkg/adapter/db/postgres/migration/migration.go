@@ -22,6 +22,7 @@ import (
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/sch1v0"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/sch1v1"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/sch1v2"
+	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/sch1v3"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/settle/stlmig1"
 	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
@@ -124,19 +125,23 @@ func NewInitializer(tx repo.Tx, v model.SemVer) (
 // the source database (so it can be used from within the `tx`
 // destination transaction in order to connect to the source database
 // and start accessing it with a FDW link). The caller remains
-// responsible to commit that transaction.
-func New(tx repo.Tx, v model.SemVer, url string) (
+// responsible to commit that transaction. The `lo` load options specify
+// how the source tables are made accessible (i.e., as foreign tables
+// or copied tables) in the destination database, see schi.Load.
+func New(tx repo.Tx, v model.SemVer, url string, lo repo.LoadOptions) (
 	repo.Migrator[repo.SchemaSettler], error,
 ) {
 	switch major := v[0]; major {
 	case 1:
 		switch minor := v[1]; minor {
 		case 0:
-			return sch1v0.New(tx, url), nil
+			return sch1v0.New(tx, url, lo), nil
 		case 1:
-			return sch1v1.New(tx, url), nil
+			return sch1v1.New(tx, url, lo), nil
 		case 2:
-			return sch1v2.New(tx, url), nil
+			return sch1v2.New(tx, url, lo), nil
+		case 3:
+			return sch1v3.New(tx, url, lo), nil
 		default:
 			return nil, fmt.Errorf("unsupported minor: %d", minor)
 		}
@@ -145,23 +150,28 @@ func New(tx repo.Tx, v model.SemVer, url string) (
 	}
 }
 
-// LoadSettings loads the serialized mutable settings from the database
-// using the given `c` connection, assuming that the database schema
-// version is equal with the given `v` argument. Loading depends on
-// both of the major and minor versions just like the migration loading
-// phase.
-func LoadSettings(ctx context.Context, c repo.Conn, v model.SemVer) (
-	[]byte, error,
-) {
+// LoadSettings loads the serialized mutable settings of the `comp`
+// component from the database using the given `c` connection, assuming
+// that the database schema version is equal with the given `v` argument.
+// Loading depends on both of the major and minor versions just like the
+// migration loading phase.
+func LoadSettings(
+	ctx context.Context,
+	c repo.Conn,
+	v model.SemVer,
+	comp model.Component,
+) ([]byte, error) {
 	switch major := v[0]; major {
 	case 1:
 		switch minor := v[1]; minor {
 		case 0:
-			return sch1v0.LoadSettings(ctx, c)
+			return sch1v0.LoadSettings(ctx, c, comp)
 		case 1:
-			return sch1v1.LoadSettings(ctx, c)
+			return sch1v1.LoadSettings(ctx, c, comp)
 		case 2:
-			return sch1v2.LoadSettings(ctx, c)
+			return sch1v2.LoadSettings(ctx, c, comp)
+		case 3:
+			return sch1v3.LoadSettings(ctx, c, comp)
 		default:
 			return nil, fmt.Errorf("unsupported minor: %d", minor)
 		}
@@ -190,3 +200,85 @@ func NewSettingsPersister(tx repo.Tx, v model.SemVer) (
 		return nil, fmt.Errorf("unsupported major: %d", major)
 	}
 }
+
+// NewSettler instantiates a repo.SchemaSettler which wraps the given
+// `tx` transaction, without migrating any source schema. It is useful
+// for continuing a batched settlement in other transactions, after the
+// migration views are prepared (and the staging tables are created) by
+// a settler which was obtained from a migrator and its transaction was
+// committed.
+//
+// Just like NewSettingsPersister, the underlying implementation depends
+// on the schema major version alone, see the stlmigN packages.
+func NewSettler(tx repo.Tx, v model.SemVer) (repo.SchemaSettler, error) {
+	switch major := v[0]; major {
+	case 1:
+		if minor := v[1]; minor > stlmig1.Minor {
+			return nil, fmt.Errorf("unsupported minor: %d", minor)
+		}
+		return stlmig1.New(tx), nil
+	default:
+		return nil, fmt.Errorf("unsupported major: %d", major)
+	}
+}
+
+// DetectVersion examines the tables and columns of the `schema` schema
+// using the `q` queryer and returns the database schema version (having
+// the `major` major version) which they conform with. Each minor version
+// may only add tables or columns, so the newest minor version whose
+// distinctive column exists is detected:
+//
+//   - v1.3 introduced the scheduled_changes table,
+//   - v1.2 introduced the min_bounds column of the settings table,
+//   - v1.1 introduced the parking_mode column of the cars table, and
+//   - v1.0 had the cars table.
+//
+// The patch version does not change the tables, so the Patch constant
+// of the detected schXvY package is reported. If none of the expected
+// tables exist (e.g., because `schema` is empty or does not exist at
+// all), an error will be returned.
+//
+// This function is useful for introspection of a database (e.g., for
+// reporting the status of a deployment), while the migration process
+// trusts the schema version of its configuration files.
+func DetectVersion(
+	ctx context.Context, q repo.Queryer, schema string, major uint,
+) (model.SemVer, error) {
+	rs, err := q.Query(ctx, `SELECT table_name, column_name
+FROM information_schema.columns
+WHERE table_schema=$1`, schema)
+	if err != nil {
+		return model.SemVer{}, fmt.Errorf("querying columns: %w", err)
+	}
+	defer rs.Close()
+	cols := make(map[string]bool)
+	for rs.Next() {
+		var table, column string
+		if err := rs.Scan(&table, &column); err != nil {
+			return model.SemVer{}, fmt.Errorf("scanning column: %w", err)
+		}
+		cols[table+"."+column] = true
+	}
+	if err := rs.Err(); err != nil {
+		return model.SemVer{}, fmt.Errorf("closing result set: %w", err)
+	}
+	switch major {
+	case 1:
+		switch {
+		case cols["scheduled_changes.scid"]:
+			return model.SemVer{1, sch1v3.Minor, sch1v3.Patch}, nil
+		case cols["settings.min_bounds"]:
+			return model.SemVer{1, sch1v2.Minor, sch1v2.Patch}, nil
+		case cols["cars.parking_mode"]:
+			return model.SemVer{1, sch1v1.Minor, sch1v1.Patch}, nil
+		case cols["cars.cid"]:
+			return model.SemVer{1, sch1v0.Minor, sch1v0.Patch}, nil
+		default:
+			return model.SemVer{}, fmt.Errorf(
+				"no tables of v1 were found in %q schema", schema,
+			)
+		}
+	default:
+		return model.SemVer{}, fmt.Errorf("unsupported major: %d", major)
+	}
+}
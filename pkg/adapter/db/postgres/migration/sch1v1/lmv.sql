@@ -9,8 +9,55 @@ CREATE VIEW cars (cid, name, lat, lon, parked, parking_mode)
 AS SELECT cid, name, lat, lon, parked, parking_mode
     FROM fdw1_1.cars;
 
-CREATE VIEW settings (component, config, min_bounds, max_bounds)
+-- The revision and constraints columns are introduced by v1.3, so all
+-- rows begin from their first revision and declare no constraints.
+CREATE VIEW settings (
+    component, config, min_bounds, max_bounds, constraints, revision
+)
 AS SELECT component, config,
         json_object('version': config->>'version'),
-        json_object('version': config->>'version')
+        json_object('version': config->>'version'),
+        NULL::json,
+        1::bigint
     FROM fdw1_1.settings;
+
+-- The scheduled_changes table is introduced by v1.3, so there is no
+-- scheduled change to be migrated.
+CREATE VIEW scheduled_changes (
+    scid, config, effective_at, created_at,
+    state, finished_at, outcome, revision
+)
+AS SELECT NULL::uuid, NULL::json,
+        NULL::timestamp with time zone, NULL::timestamp with time zone,
+        NULL::text, NULL::timestamp with time zone, NULL::text,
+        NULL::bigint
+    WHERE false;
+
+-- The schema_migrations journal is not versioned with the schema, so it
+-- may be missing in databases which were created before its addition.
+DO $$
+BEGIN
+    IF to_regclass('fdw1_1.schema_migrations') IS NOT NULL THEN
+        CREATE VIEW schema_migrations (
+            jid, operation, src_config_version, src_schema_version,
+            dst_config_version, dst_schema_version, steps, os_user,
+            started_at, finished_at, state, outcome
+        )
+        AS SELECT jid, operation, src_config_version, src_schema_version,
+                dst_config_version, dst_schema_version, steps, os_user,
+                started_at, finished_at, state, outcome
+            FROM fdw1_1.schema_migrations;
+    ELSE
+        CREATE VIEW schema_migrations (
+            jid, operation, src_config_version, src_schema_version,
+            dst_config_version, dst_schema_version, steps, os_user,
+            started_at, finished_at, state, outcome
+        )
+        AS SELECT NULL::uuid, NULL::text, NULL::text, NULL::text,
+                NULL::text, NULL::text, NULL::json, NULL::text,
+                NULL::timestamp with time zone,
+                NULL::timestamp with time zone, NULL::text, NULL::text
+            WHERE false;
+    END IF;
+END
+$$;
@@ -74,12 +74,16 @@ type (
 
 // New creates a Migrator struct wrapping the given `tx` transaction
 // from the destination database and `url` URL representing the source
-// database connection information. The created Migrator instance is
+// database connection information. The `lo` load options specify how the
+// Load method makes the source tables accessible in the destination
+// database (see schi.Load). The created Migrator instance is
 // then wrapped by a schi.Adapter in order to adapt its version
 // dependent interface (see Type type alias) to a version independent
 // repo.Migrator[repo.SchemaSettler] interface.
-func New(tx repo.Tx, url string) repo.Migrator[repo.SchemaSettler] {
-	m := &Migrator{tx, url}
+func New(
+	tx repo.Tx, url string, lo repo.LoadOptions,
+) repo.Migrator[repo.SchemaSettler] {
+	m := &Migrator{tx, url, lo}
 	return schi.Adapter[S, U, D]{m}
 }
 
@@ -102,8 +106,9 @@ func New(tx repo.Tx, url string) repo.Migrator[repo.SchemaSettler] {
 // latest supported minor version and returning an instance of the
 // settler object (having the S type) for persisting it.
 type Migrator struct {
-	tx  repo.Tx // an open transaction for the destination database
-	url string  // connection information for the source database
+	tx  repo.Tx          // an open transaction for the destination database
+	url string           // connection information for the source database
+	lo  repo.LoadOptions // how source tables are loaded by Load method
 }
 
 // Settler returns a settler object for the database schema v1 major
@@ -126,17 +131,19 @@ func (s1v1 *Migrator) Settler(
 // Load creates a Foreign Data Wrapper (FDW) link from the destination
 // database to the source database (having the connection information
 // of the source database) and imports the source database schema into
-// a local schema. Thereafter, queries in the destination database
-// transaction may access the source database contents.
+// a local schema, or copies the source tables into that local schema,
+// as asked by the load mode (see schi.Load). Thereafter, queries in the
+// destination database transaction may access the source database
+// contents.
 // This method must be called (and returned without error) before it is
 // possible to call any other method of the Migrator struct.
 func (s1v1 *Migrator) Load(ctx context.Context) error {
-	if err := schi.LoadFDW(
-		ctx, Major, Minor, s1v1.tx, s1v1.url,
+	if err := schi.Load(
+		ctx, Major, Minor, s1v1.tx, s1v1.url, s1v1.lo,
 	); err != nil {
 		return fmt.Errorf(
-			"schi.LoadFDW(major=%d, minor=%d, srcURL=%q): %w",
-			Major, Minor, s1v1.url, err,
+			"schi.Load(major=%d, minor=%d, srcURL=%q, mode=%q): %w",
+			Major, Minor, s1v1.url, s1v1.lo.Mode, err,
 		)
 	}
 	return nil
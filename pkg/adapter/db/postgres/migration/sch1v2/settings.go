@@ -7,18 +7,26 @@ package sch1v2
 
 import (
 	"context"
-	"errors"
 	"fmt"
 
+	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 )
 
-// LoadSettings loads the serialized mutable settings from the database
-// using the given `c` connection, assuming that the database schema
-// version is equal to v1.2 as specified by the Major and Minor consts.
-func LoadSettings(ctx context.Context, c repo.Conn) ([]byte, error) {
+// LoadSettings loads the serialized mutable settings of the `comp`
+// component from the database using the given `c` connection, assuming
+// that the database schema version is equal to v1.2 as specified by
+// the Major and Minor consts.
+// If the `comp` component has no settings row, an error wrapping the
+// repo.ErrSettingsNotFound will be returned, so callers may fall back
+// to the default settings of that component.
+func LoadSettings(
+	ctx context.Context, c repo.Conn, comp model.Component,
+) ([]byte, error) {
 	rs, err := c.Query(
-		ctx, "SELECT config FROM settings WHERE component='caweb'",
+		ctx,
+		"SELECT config FROM settings WHERE component=$1",
+		string(comp),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("querying settings table: %w", err)
@@ -27,7 +35,7 @@ func LoadSettings(ctx context.Context, c repo.Conn) ([]byte, error) {
 	var cfg []byte
 	for rs.Next() {
 		if cfg != nil {
-			return nil, errors.New("more than one caweb settings rows")
+			return nil, fmt.Errorf("more than one %q settings rows", comp)
 		}
 		if err := rs.Scan(&cfg); err != nil {
 			return nil, fmt.Errorf("scanning config column: %w", err)
@@ -37,7 +45,7 @@ func LoadSettings(ctx context.Context, c repo.Conn) ([]byte, error) {
 		return nil, fmt.Errorf("closing result set: %w", err)
 	}
 	if cfg == nil {
-		return nil, errors.New("missing caweb settings row")
+		return nil, fmt.Errorf("%q: %w", comp, repo.ErrSettingsNotFound)
 	}
 	return cfg, nil
 }
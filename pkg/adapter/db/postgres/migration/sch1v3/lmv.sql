@@ -0,0 +1,53 @@
+-- Copyright (c) 2023-2024 Behnam Momeni
+-- This Source Code Form is subject to the terms of the Mozilla Public
+-- License, v. 2.0. If a copy of the MPL was not distributed with this
+-- file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+SET search_path TO mig1;
+
+CREATE VIEW cars (cid, name, lat, lon, parked, parking_mode)
+AS SELECT cid, name, lat, lon, parked, parking_mode
+    FROM fdw1_3.cars;
+
+CREATE VIEW settings (
+    component, config, min_bounds, max_bounds, constraints, revision
+)
+AS SELECT component, config, min_bounds, max_bounds, constraints, revision
+    FROM fdw1_3.settings;
+
+CREATE VIEW scheduled_changes (
+    scid, config, effective_at, created_at,
+    state, finished_at, outcome, revision
+)
+AS SELECT scid, config, effective_at, created_at,
+        state, finished_at, outcome, revision
+    FROM fdw1_3.scheduled_changes;
+
+-- The schema_migrations journal is not versioned with the schema, so it
+-- may be missing in databases which were created before its addition.
+DO $$
+BEGIN
+    IF to_regclass('fdw1_3.schema_migrations') IS NOT NULL THEN
+        CREATE VIEW schema_migrations (
+            jid, operation, src_config_version, src_schema_version,
+            dst_config_version, dst_schema_version, steps, os_user,
+            started_at, finished_at, state, outcome
+        )
+        AS SELECT jid, operation, src_config_version, src_schema_version,
+                dst_config_version, dst_schema_version, steps, os_user,
+                started_at, finished_at, state, outcome
+            FROM fdw1_3.schema_migrations;
+    ELSE
+        CREATE VIEW schema_migrations (
+            jid, operation, src_config_version, src_schema_version,
+            dst_config_version, dst_schema_version, steps, os_user,
+            started_at, finished_at, state, outcome
+        )
+        AS SELECT NULL::uuid, NULL::text, NULL::text, NULL::text,
+                NULL::text, NULL::text, NULL::json, NULL::text,
+                NULL::timestamp with time zone,
+                NULL::timestamp with time zone, NULL::text, NULL::text
+            WHERE false;
+    END IF;
+END
+$$;
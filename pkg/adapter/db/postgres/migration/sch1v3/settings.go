@@ -0,0 +1,91 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package sch1v3
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+)
+
+// LoadSettings loads the serialized mutable settings of the `comp`
+// component from the database using the given `c` connection, assuming
+// that the database schema version is equal to v1.3 as specified by
+// the Major and Minor consts.
+// If the `comp` component has no settings row, an error wrapping the
+// repo.ErrSettingsNotFound will be returned, so callers may fall back
+// to the default settings of that component.
+func LoadSettings(
+	ctx context.Context, c repo.Conn, comp model.Component,
+) ([]byte, error) {
+	rs, err := c.Query(
+		ctx,
+		"SELECT config FROM settings WHERE component=$1",
+		string(comp),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying settings table: %w", err)
+	}
+	defer rs.Close()
+	var cfg []byte
+	for rs.Next() {
+		if cfg != nil {
+			return nil, fmt.Errorf("more than one %q settings rows", comp)
+		}
+		if err := rs.Scan(&cfg); err != nil {
+			return nil, fmt.Errorf("scanning config column: %w", err)
+		}
+	}
+	if err := rs.Err(); err != nil {
+		return nil, fmt.Errorf("closing result set: %w", err)
+	}
+	if cfg == nil {
+		return nil, fmt.Errorf("%q: %w", comp, repo.ErrSettingsNotFound)
+	}
+	return cfg, nil
+}
+
+// LoadRevisedSettings loads the serialized mutable settings of the
+// `comp` component in addition to their revision number from the
+// database using the given `c` connection (or transaction). The
+// revision column is introduced by v1.3 and is incremented whenever the
+// settings row is updated, so it can be used for detection of
+// concurrent updates.
+// If the `comp` component has no settings row, an error wrapping the
+// repo.ErrSettingsNotFound will be returned.
+func LoadRevisedSettings(
+	ctx context.Context, c repo.Queryer, comp model.Component,
+) (cfg []byte, rev int64, err error) {
+	rs, err := c.Query(
+		ctx,
+		"SELECT config, revision FROM settings WHERE component=$1",
+		string(comp),
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("querying settings table: %w", err)
+	}
+	defer rs.Close()
+	for rs.Next() {
+		if cfg != nil {
+			err = fmt.Errorf("more than one %q settings rows", comp)
+			return nil, 0, err
+		}
+		if err := rs.Scan(&cfg, &rev); err != nil {
+			err = fmt.Errorf("scanning config/revision columns: %w", err)
+			return nil, 0, err
+		}
+	}
+	if err := rs.Err(); err != nil {
+		return nil, 0, fmt.Errorf("closing result set: %w", err)
+	}
+	if cfg == nil {
+		err = fmt.Errorf("%q: %w", comp, repo.ErrSettingsNotFound)
+		return nil, 0, err
+	}
+	return cfg, rev, nil
+}
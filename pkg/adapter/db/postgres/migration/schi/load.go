@@ -0,0 +1,94 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package schi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/momeni/clean-arch/pkg/core/log"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+)
+
+// Load fills the fdwN_M local schema with the tables of the cawebN
+// schema of the source database (which is accessible using the srcURL
+// connection URL) from within the `tx` transaction in the destination
+// database, where N and M are the given major and minor semantic
+// schema version numbers. The Mode of the `lo` load options selects the
+// loader:
+//
+//  1. repo.FDWLoad (or an empty mode) calls LoadFDW in order to import
+//     the source tables as foreign tables using postgres_fdw,
+//  2. repo.CopyLoad calls LoadCopy in order to stream the source rows
+//     through this process into regular local tables, and
+//  3. repo.AutoLoad tries LoadFDW in a savepoint and falls back to
+//     the LoadCopy if the postgres_fdw extension is not available or
+//     the destination server cannot reach the source server.
+//
+// The FetchSize of `lo` is passed to the LoadFDW function (if it is
+// called), so the foreign tables fetch that many rows in each round
+// trip. Either way, the fdwN_M schema tables have the same names and
+// columns as their cawebN counterparts, so the schema migrators may
+// read them regardless of the employed loader.
+func Load(
+	ctx context.Context,
+	major, minor uint,
+	tx repo.Tx,
+	srcURL string,
+	lo repo.LoadOptions,
+) error {
+	switch lo.Mode {
+	case repo.FDWLoad, "":
+		return LoadFDW(ctx, major, minor, tx, srcURL, lo.FetchSize)
+	case repo.CopyLoad:
+		return LoadCopy(ctx, major, minor, tx, srcURL)
+	case repo.AutoLoad:
+		return loadAuto(ctx, major, minor, tx, srcURL, lo.FetchSize)
+	default:
+		return fmt.Errorf("unsupported load mode: %q", lo.Mode)
+	}
+}
+
+// loadAuto tries to load the source schema using the LoadFDW and if it
+// fails, rolls back its partial changes (by a savepoint) and retries
+// with the LoadCopy function.
+func loadAuto(
+	ctx context.Context,
+	major, minor uint,
+	tx repo.Tx,
+	srcURL string,
+	fetchSize int,
+) error {
+	const sp = "caweb_load_fdw"
+	if _, err := tx.Exec(ctx, "SAVEPOINT "+sp); err != nil {
+		return fmt.Errorf("creating %q savepoint: %w", sp, err)
+	}
+	fdwErr := LoadFDW(ctx, major, minor, tx, srcURL, fetchSize)
+	if fdwErr == nil {
+		if _, err := tx.Exec(ctx, "RELEASE SAVEPOINT "+sp); err != nil {
+			return fmt.Errorf("releasing %q savepoint: %w", sp, err)
+		}
+		return nil
+	}
+	if _, err := tx.Exec(ctx, "ROLLBACK TO SAVEPOINT "+sp); err != nil {
+		return fmt.Errorf(
+			"LoadFDW: %w, rolling back to %q savepoint: %w",
+			fdwErr, sp, err,
+		)
+	}
+	log.Warn(
+		ctx, "loading source schema by postgres_fdw failed, using COPY",
+		log.Err("err", fdwErr),
+	)
+	if err := LoadCopy(ctx, major, minor, tx, srcURL); err != nil {
+		return errors.Join(
+			fmt.Errorf("LoadFDW: %w", fdwErr),
+			fmt.Errorf("LoadCopy: %w", err),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package schi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/momeni/clean-arch/pkg/core/log"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
+)
+
+// copyFromer is implemented by transactions which can run the COPY
+// FROM STDIN statements, such as the postgres.Tx type.
+type copyFromer interface {
+	CopyFrom(ctx context.Context, r io.Reader, query string) (
+		int64, error,
+	)
+}
+
+// sourceTable describes a table (or view) of the source database
+// which should be copied into the destination database.
+type sourceTable struct {
+	name       string
+	columns    []sourceColumn
+	primaryKey []string // column names, empty if there is no PK
+}
+
+// sourceColumn describes a column of a sourceTable.
+type sourceColumn struct {
+	name    string
+	typ     string // as formatted by the format_type function
+	notNull bool
+}
+
+// LoadCopy connects to a PostgreSQL server using the srcURL connection
+// URL (directly from this process, so it works even when the
+// destination database server has no postgres_fdw extension or cannot
+// reach the source server), lists the tables of the cawebN schema in
+// the source database, creates a regular table with the same name and
+// columns (and NOT NULL constraints) for each one of them in the fdwN_M
+// local schema from within the `tx` transaction, and streams their rows
+// using the COPY statements, where N and M are the given major and
+// minor semantic schema version numbers. After copying the rows of a
+// table, its primary key (if any) is created too, so the batched
+// settlement can seek the rows after a given key through an index
+// (instead of scanning the whole table per batch) and the migrators
+// which join the copied tables are not slowed down in comparison to
+// the LoadFDW function. The source tables are read in
+// a read-only repeatable read transaction, so they are copied from a
+// consistent snapshot.
+// Those created tables must be non-existing and the `tx` transaction
+// must support the COPY FROM statement (as postgres.Tx does when it is
+// begun by a postgres.Conn). Otherwise, an error will be returned.
+//
+// In contrast to LoadFDW, all rows are copied eagerly, so the schema
+// migrators which only read a few rows may take longer. The column
+// types are created as formatted by the source server, hence, the
+// source tables must not use types which are missing in the
+// destination database.
+func LoadCopy(
+	ctx context.Context,
+	major, minor uint,
+	tx repo.Tx,
+	srcURL string,
+) error {
+	cf, ok := tx.(copyFromer)
+	if !ok {
+		return fmt.Errorf("%T transaction does not support COPY", tx)
+	}
+	remoteSchema := migrationuc.SchemaName(major)
+	localSchema := migrationuc.ForeignSchemaName(major, minor)
+	sc, err := pgx.Connect(ctx, srcURL)
+	if err != nil {
+		return fmt.Errorf("connecting to source database: %w", err)
+	}
+	defer sc.Close(ctx)
+	stx, err := sc.BeginTx(ctx, pgx.TxOptions{
+		IsoLevel:   pgx.RepeatableRead,
+		AccessMode: pgx.ReadOnly,
+	})
+	if err != nil {
+		return fmt.Errorf("beginning source transaction: %w", err)
+	}
+	defer stx.Rollback(ctx)
+	tables, err := listSourceTables(ctx, stx, remoteSchema)
+	if err != nil {
+		return fmt.Errorf("listing %q source tables: %w", remoteSchema, err)
+	}
+	if len(tables) == 0 {
+		return fmt.Errorf("source %q schema has no tables", remoteSchema)
+	}
+	for _, t := range tables {
+		local := pgx.Identifier{localSchema, t.name}.Sanitize()
+		if _, err = tx.Exec(ctx, t.createTableSQL(local)); err != nil {
+			return fmt.Errorf("creating %s table: %w", local, err)
+		}
+		remote := pgx.Identifier{remoteSchema, t.name}.Sanitize()
+		n, err := copyTable(ctx, stx, cf, remote, local, t.columnList())
+		if err != nil {
+			return fmt.Errorf(
+				"copying %s table into %s: %w", remote, local, err,
+			)
+		}
+		if len(t.primaryKey) != 0 {
+			_, err = tx.Exec(ctx, t.addPrimaryKeySQL(local))
+			if err != nil {
+				return fmt.Errorf(
+					"adding primary key to %s table: %w", local, err,
+				)
+			}
+		}
+		log.Debug(
+			ctx, "copied source table",
+			log.String("table", remote),
+			log.String("rows", strconv.FormatInt(n, 10)),
+		)
+	}
+	return nil
+}
+
+// listSourceTables lists the tables, views, and foreign tables of the
+// `schema` schema (similar to the IMPORT FOREIGN SCHEMA statement),
+// their columns, and their primary keys (see listPrimaryKeys) using
+// the `stx` source database transaction.
+func listSourceTables(
+	ctx context.Context, stx pgx.Tx, schema string,
+) ([]sourceTable, error) {
+	rows, err := stx.Query(ctx, `SELECT c.relname, a.attname,
+    format_type(a.atttypid, a.atttypmod), a.attnotnull
+FROM pg_catalog.pg_class AS c
+JOIN pg_catalog.pg_namespace AS n ON n.oid=c.relnamespace
+JOIN pg_catalog.pg_attribute AS a ON a.attrelid=c.oid
+WHERE n.nspname=$1 AND c.relkind IN ('r', 'p', 'v', 'm', 'f')
+    AND NOT c.relispartition AND a.attnum>0 AND NOT a.attisdropped
+ORDER BY c.relname, a.attnum`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("querying pg_catalog: %w", err)
+	}
+	defer rows.Close()
+	var tables []sourceTable
+	for rows.Next() {
+		var tn string
+		var col sourceColumn
+		err = rows.Scan(&tn, &col.name, &col.typ, &col.notNull)
+		if err != nil {
+			return nil, fmt.Errorf("scanning column: %w", err)
+		}
+		if len(tables) == 0 || tables[len(tables)-1].name != tn {
+			tables = append(tables, sourceTable{name: tn})
+		}
+		t := &tables[len(tables)-1]
+		t.columns = append(t.columns, col)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating columns: %w", err)
+	}
+	rows.Close()
+	pks, err := listPrimaryKeys(ctx, stx, schema)
+	if err != nil {
+		return nil, fmt.Errorf("listing primary keys: %w", err)
+	}
+	for i := range tables {
+		tables[i].primaryKey = pks[tables[i].name]
+	}
+	return tables, nil
+}
+
+// listPrimaryKeys lists the primary key columns of the tables of the
+// `schema` schema (in their key order) using the `stx` source database
+// transaction. The returned map is keyed by the table names and tables
+// without a primary key are omitted.
+func listPrimaryKeys(
+	ctx context.Context, stx pgx.Tx, schema string,
+) (map[string][]string, error) {
+	rows, err := stx.Query(ctx, `SELECT c.relname, a.attname
+FROM pg_catalog.pg_index AS i
+JOIN pg_catalog.pg_class AS c ON c.oid=i.indrelid
+JOIN pg_catalog.pg_namespace AS n ON n.oid=c.relnamespace
+JOIN pg_catalog.pg_attribute AS a
+    ON a.attrelid=c.oid AND a.attnum=ANY(i.indkey)
+WHERE n.nspname=$1 AND i.indisprimary
+ORDER BY c.relname, array_position(i.indkey::int2[], a.attnum)`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("querying pg_catalog: %w", err)
+	}
+	defer rows.Close()
+	pks := make(map[string][]string)
+	for rows.Next() {
+		var tn, cn string
+		if err = rows.Scan(&tn, &cn); err != nil {
+			return nil, fmt.Errorf("scanning key column: %w", err)
+		}
+		pks[tn] = append(pks[tn], cn)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating key columns: %w", err)
+	}
+	return pks, nil
+}
+
+// createTableSQL returns a CREATE TABLE statement which creates the
+// `local` (sanitized) table with the same columns as this table.
+func (t sourceTable) createTableSQL(local string) string {
+	defs := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		d := pgx.Identifier{c.name}.Sanitize() + " " + c.typ
+		if c.notNull {
+			d += " NOT NULL"
+		}
+		defs = append(defs, d)
+	}
+	return fmt.Sprintf(
+		"CREATE TABLE %s (\n    %s\n)", local, strings.Join(defs, ",\n    "),
+	)
+}
+
+// addPrimaryKeySQL returns an ALTER TABLE statement which adds the
+// primary key of this table to the `local` (sanitized) table.
+// It is executed after the COPY statement, since building the index
+// at once is faster than updating it per row.
+func (t sourceTable) addPrimaryKeySQL(local string) string {
+	names := make([]string, 0, len(t.primaryKey))
+	for _, c := range t.primaryKey {
+		names = append(names, pgx.Identifier{c}.Sanitize())
+	}
+	return fmt.Sprintf(
+		"ALTER TABLE %s ADD PRIMARY KEY (%s)",
+		local, strings.Join(names, ", "),
+	)
+}
+
+// columnList returns the comma-separated sanitized column names.
+func (t sourceTable) columnList() string {
+	names := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		names = append(names, pgx.Identifier{c.name}.Sanitize())
+	}
+	return strings.Join(names, ", ")
+}
+
+// copyTable streams the `cols` columns of the `remote` table from the
+// `stx` source transaction into the `local` table using `cf` with
+// the COPY TO STDOUT and COPY FROM STDIN statements respectively.
+// The source rows are written into a pipe by a distinct goroutine,
+// so they are not buffered in the memory entirely.
+// It returns the number of copied rows.
+func copyTable(
+	ctx context.Context,
+	stx pgx.Tx,
+	cf copyFromer,
+	remote, local, cols string,
+) (int64, error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := stx.Conn().PgConn().CopyTo(ctx, pw, fmt.Sprintf(
+			"COPY (SELECT %s FROM %s) TO STDOUT", cols, remote,
+		))
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	n, err := cf.CopyFrom(ctx, pr, fmt.Sprintf(
+		"COPY %s (%s) FROM STDIN", local, cols,
+	))
+	pr.CloseWithError(err) // unblocks the writer if reading has failed
+	if srcErr := <-done; srcErr != nil {
+		return 0, fmt.Errorf("reading source rows: %w", srcErr)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("writing destination rows: %w", err)
+	}
+	return n, nil
+}
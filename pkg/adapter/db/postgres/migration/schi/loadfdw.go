@@ -28,7 +28,9 @@ import (
 // is required. Ultimately, the cawebN schema from that foreign server
 // will be imported into the fdwN_M local schema, creating the relevant
 // foreign tables, where N and M are the given major and minor semantic
-// schema version numbers.
+// schema version numbers. If `fetchSize` is positive, it is set as the
+// fetch_size option of the foreign server, so the foreign tables fetch
+// that many rows in each round trip (instead of 100 rows by default).
 // All of these items must be non-existing and they must be created by
 // this call of LoadFDW in the `tx` transaction. Otherwise, an error
 // will be returned.
@@ -37,6 +39,7 @@ func LoadFDW(
 	major, minor uint,
 	tx repo.Tx,
 	srcURL string,
+	fetchSize int,
 ) error {
 	dbName, uname, pass, h, p, err := extractSrcURLComponents(srcURL)
 	if err != nil {
@@ -62,13 +65,17 @@ func LoadFDW(
 		// from out of the container using the 127.0.0.1 address.
 		h = "host.containers.internal"
 	}
+	fetchOpt := ""
+	if fetchSize > 0 {
+		fetchOpt = fmt.Sprintf(", fetch_size '%d'", fetchSize)
+	}
 	if _, err = tx.Exec(
 		ctx,
 		fmt.Sprintf(
 			`CREATE SERVER %s
 FOREIGN DATA WRAPPER postgres_fdw
-OPTIONS (host '%s', port '%d', dbname '%s')`,
-			server, escapeQuotes(h), p, escapeQuotes(dbName),
+OPTIONS (host '%s', port '%d', dbname '%s'%s)`,
+			server, escapeQuotes(h), p, escapeQuotes(dbName), fetchOpt,
 		),
 	); err != nil {
 		return fmt.Errorf("creating %q foreign server: %w", server, err)
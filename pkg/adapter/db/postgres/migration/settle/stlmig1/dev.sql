@@ -37,7 +37,7 @@ VALUES (
 INSERT INTO settings (component, config, min_bounds, max_bounds)
 VALUES (
     'caweb',
-    '{"version":"2.1.0","cars":{"delay_of_opm":"2s"}}'::json,
-    '{"version":"2.1.0","cars":{"delay_of_opm":"1s"}}'::json,
-    '{"version":"2.1.0","cars":{"delay_of_opm":"7h"}}'::json
+    '{"version":"2.2.0","cars":{"delay_of_opm":"2s"}}'::json,
+    '{"version":"2.2.0","cars":{"delay_of_opm":"1s"}}'::json,
+    '{"version":"2.2.0","cars":{"delay_of_opm":"7h"}}'::json
 );
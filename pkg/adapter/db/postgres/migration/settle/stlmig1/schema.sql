@@ -30,8 +30,68 @@ CREATE TABLE settings (
     -- minimum boundary values, following the same format as config
     min_bounds json NOT NULL,
     -- maximum boundary values, following the same format as config
-    max_bounds json NOT NULL
+    max_bounds json NOT NULL,
+    -- declared constraints of settings (e.g., enums, patterns, or
+    -- cross-field rules) beyond the min/max boundary values, or NULL if
+    -- the configuration format of that row declares no constraints
+    constraints json,
+    -- revision is incremented whenever the row is updated, so it can
+    -- be used for optimistic concurrency control (i.e., compare and
+    -- swap) when multiple instances try to update settings concurrently
+    revision bigint NOT NULL DEFAULT 1
 );
 
 ALTER TABLE ONLY settings
 ADD CONSTRAINT settings_pkey PRIMARY KEY (component);
+
+CREATE TABLE scheduled_changes (
+    scid uuid NOT NULL,
+    -- mutable settings which should be applied, following the same
+    -- format as the config column of the settings table
+    config json NOT NULL,
+    effective_at timestamp with time zone NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    -- one of pending, applied, failed, or canceled
+    state text NOT NULL DEFAULT 'pending',
+    -- time of applying, failing, or canceling the change
+    finished_at timestamp with time zone,
+    -- error message of a failed change
+    outcome text,
+    -- settings revision which was produced by applying this change
+    revision bigint
+);
+
+ALTER TABLE ONLY scheduled_changes
+ADD CONSTRAINT scheduled_changes_pkey PRIMARY KEY (scid);
+
+CREATE INDEX scheduled_changes_pending_idx
+ON scheduled_changes (effective_at)
+WHERE state = 'pending';
+
+-- The schema_migrations journal records the initialization and migration
+-- operations of this database; it is carried across migrations, so its
+-- rows describe the whole history of the database contents.
+CREATE TABLE schema_migrations (
+    jid uuid NOT NULL,
+    -- one of init-dev, init-prod, or migrate
+    operation text NOT NULL,
+    -- versions of the migration source, or NULL for initializations
+    src_config_version text,
+    src_schema_version text,
+    dst_config_version text NOT NULL,
+    dst_schema_version text NOT NULL,
+    -- array of {"name": ..., "seconds": ...} objects, describing the
+    -- duration of the operation steps in order
+    steps json NOT NULL,
+    -- operating system user which has run the operation
+    os_user text NOT NULL,
+    started_at timestamp with time zone NOT NULL,
+    finished_at timestamp with time zone NOT NULL,
+    -- one of succeeded or failed
+    state text NOT NULL,
+    -- error message of a failed operation
+    outcome text
+);
+
+ALTER TABLE ONLY schema_migrations
+ADD CONSTRAINT schema_migrations_pkey PRIMARY KEY (jid);
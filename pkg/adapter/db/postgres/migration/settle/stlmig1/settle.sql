@@ -9,12 +9,33 @@ INSERT INTO cars (cid, name, lat, lon, parked, parking_mode)
 SELECT cid, name, lat, lon, parked, parking_mode
     FROM mig1.cars;
 
-INSERT INTO settings (component, config, min_bounds, max_bounds)
-SELECT component, config, min_bounds, max_bounds
+INSERT INTO settings (
+    component, config, min_bounds, max_bounds, constraints, revision
+)
+SELECT component, config, min_bounds, max_bounds, constraints, revision
     FROM mig1.settings;
---  WHERE component!='caweb';
---  -- we do not exclude the caweb settings because having that row in
---  -- the database simplifies the SettingsPersister implementation
---  -- as it can always run an UPDATE instead of requiring to run
---  -- an INSERT or UPDATE or INSERT ON CONFLICT DO UPDATE based on
---  -- the database conditions.
+-- All rows are carried, including the rows of those components which
+-- are not known by the target configuration version, so they can be
+-- restored if the settings are migrated back to a version which knows
+-- them. Rows of the known components are upserted by the
+-- SettingsPersister implementation afterwards.
+
+INSERT INTO scheduled_changes (
+    scid, config, effective_at, created_at,
+    state, finished_at, outcome, revision
+)
+SELECT scid, config, effective_at, created_at,
+        state, finished_at, outcome, revision
+    FROM mig1.scheduled_changes;
+
+INSERT INTO schema_migrations (
+    jid, operation, src_config_version, src_schema_version,
+    dst_config_version, dst_schema_version, steps, os_user,
+    started_at, finished_at, state, outcome
+)
+SELECT jid, operation, src_config_version, src_schema_version,
+        dst_config_version, dst_schema_version, steps, os_user,
+        started_at, finished_at, state, outcome
+    FROM mig1.schema_migrations;
+-- The journal is carried, so the entry of this migration is appended
+-- to the source database history by the JournalAppender implementation.
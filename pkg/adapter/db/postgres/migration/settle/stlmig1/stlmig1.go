@@ -27,8 +27,12 @@ package stlmig1
 import (
 	"context"
 	_ "embed"
+	"encoding/json"
 	"fmt"
+	"slices"
 
+	"github.com/google/uuid"
+	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
 )
@@ -39,7 +43,7 @@ import (
 // supported minor version within the Major major version series.
 const (
 	Major = 1
-	Minor = 2
+	Minor = 3
 	Patch = 0
 )
 
@@ -109,6 +113,284 @@ func (sm1 *Settler) SettleSchema(ctx context.Context) error {
 	return nil
 }
 
+// settledTables lists the names of the tables which are filled by the
+// settle.sql file (or by the SettleTableBatch method), in the same
+// order. Their copied and compared columns and their primary keys are
+// not listed, but are read from the catalog (see the tableColumns and
+// tableKey methods), so they always match the schema.sql.
+var settledTables = []string{
+	"cars",
+	"settings",
+	"scheduled_changes",
+	"schema_migrations",
+}
+
+// StageSchema creates major version 1 tables in the caweb1 schema (by
+// the same schema.sql file which is used by SettleSchema) and moves
+// them into the stg1 staging schema, so they may be filled by the
+// SettleTableBatch method in other transactions, while the caweb1
+// schema is kept empty until the CommitStagedSchema method moves them
+// back. The caller is responsible to commit the `sm1` transaction.
+func (sm1 *Settler) StageSchema(ctx context.Context) error {
+	if _, err := sm1.tx.Exec(ctx, schemaDDLStatements); err != nil {
+		return fmt.Errorf(
+			"creating major version %d tables: %w",
+			Major, err,
+		)
+	}
+	schema := migrationuc.SchemaName(Major)
+	stgSchema := migrationuc.StagingSchemaName(Major)
+	if err := sm1.moveTables(ctx, schema, stgSchema); err != nil {
+		return fmt.Errorf("staging major version %d tables: %w", Major, err)
+	}
+	return nil
+}
+
+// SettledTables returns the names of the tables which are filled by the
+// SettleSchema and SettleTableBatch methods, in the settle.sql order.
+func (sm1 *Settler) SettledTables() []string {
+	return append([]string(nil), settledTables...)
+}
+
+// SettleTableBatch copies at most `limit` rows of the `table` view from
+// the mig1 schema into the same table in the stg1 staging schema (which
+// must be created by the StageSchema method beforehand), ordered by its
+// primary key and starting after the `after` key (if it is non-nil).
+// The number of copied rows and the text representation of the key of
+// the last copied row (or nil if no row was copied) are returned.
+// The rows are copied by one INSERT ... SELECT statement, so they do
+// not pass through this Golang process memory.
+func (sm1 *Settler) SettleTableBatch(
+	ctx context.Context, table string, after *string, limit int,
+) (n int64, last *string, err error) {
+	if !slices.Contains(settledTables, table) {
+		return 0, nil, fmt.Errorf("unknown settled table: %q", table)
+	}
+	migSchema := migrationuc.MigrationSchemaName(Major)
+	stgSchema := migrationuc.StagingSchemaName(Major)
+	columns, err := sm1.tableColumns(ctx, stgSchema, table)
+	if err != nil {
+		return 0, nil, err
+	}
+	key, keyType, err := sm1.tableKey(ctx, stgSchema, table)
+	if err != nil {
+		return 0, nil, err
+	}
+	where, args := "", []any{limit}
+	if after != nil {
+		where = fmt.Sprintf(
+			"\nWHERE %s > CAST($2::text AS %s)", key, keyType,
+		)
+		args = append(args, *after)
+	}
+	// Although the schema, table, and column names may not be passed
+	// as parameters, they are all trusted strings.
+	q := fmt.Sprintf(`WITH b AS (
+INSERT INTO %[1]s.%[3]s (%[5]s)
+SELECT %[5]s FROM %[2]s.%[3]s%[6]s
+ORDER BY %[4]s
+LIMIT $1
+RETURNING %[4]s
+)
+SELECT count(*), (array_agg(%[4]s ORDER BY %[4]s DESC))[1]::text FROM b`,
+		stgSchema, migSchema, table, key, columns, where,
+	)
+	rs, err := sm1.tx.Query(ctx, q, args...)
+	if err != nil {
+		return 0, nil, fmt.Errorf(
+			"copying a batch of %s.%s rows: %w", migSchema, table, err,
+		)
+	}
+	defer rs.Close()
+	for rs.Next() {
+		if err := rs.Scan(&n, &last); err != nil {
+			return 0, nil, fmt.Errorf("scanning batch result: %w", err)
+		}
+	}
+	if err := rs.Err(); err != nil {
+		return 0, nil, fmt.Errorf("closing result set: %w", err)
+	}
+	return n, last, nil
+}
+
+// CommitStagedSchema moves the major version 1 tables from the stg1
+// staging schema into the caweb1 schema. Their indices and constraints
+// are moved alongside them. The caller is responsible to commit the
+// `sm1` transaction, so all tables appear in caweb1 atomically.
+func (sm1 *Settler) CommitStagedSchema(ctx context.Context) error {
+	stgSchema := migrationuc.StagingSchemaName(Major)
+	schema := migrationuc.SchemaName(Major)
+	if err := sm1.moveTables(ctx, stgSchema, schema); err != nil {
+		return fmt.Errorf(
+			"moving major version %d tables from %q: %w",
+			Major, stgSchema, err,
+		)
+	}
+	return nil
+}
+
+// moveTables moves all settledTables from the `from` schema into the
+// `to` schema.
+func (sm1 *Settler) moveTables(ctx context.Context, from, to string) error {
+	for _, t := range settledTables {
+		q := fmt.Sprintf("ALTER TABLE %s.%s SET SCHEMA %s", from, t, to)
+		if _, err := sm1.tx.Exec(ctx, q); err != nil {
+			return fmt.Errorf(
+				"moving %s.%s table into %q: %w", from, t, to, err,
+			)
+		}
+	}
+	return nil
+}
+
+// VerifySchema compares each table of the caweb1 schema, which was
+// filled by the SettleSchema method (or by the SettleTableBatch method
+// and then moved by the CommitStagedSchema method), with its
+// corresponding view in the mig1 schema. For each table, the number of
+// rows and the md5 hash of the sorted md5 hashes of the rows (in their
+// text representation) are computed for both sides, so the rows order
+// does not matter.
+// If the source tables were loaded by postgres_fdw, mig1 views read
+// from the source database (through the foreign server), so this
+// comparison also detects rows which were changed in the source
+// database during the migration. If they were copied (see the
+// repo.CopyLoad mode), mig1 views read from those copies, so only the
+// settlement itself is verified.
+func (sm1 *Settler) VerifySchema(
+	ctx context.Context,
+) ([]repo.TableVerification, error) {
+	migSchema := migrationuc.MigrationSchemaName(Major)
+	schema := migrationuc.SchemaName(Major)
+	tvs := make([]repo.TableVerification, 0, len(settledTables))
+	for _, t := range settledTables {
+		columns, err := sm1.tableColumns(ctx, schema, t)
+		if err != nil {
+			return nil, err
+		}
+		tv := repo.TableVerification{Table: t}
+		tv.SourceRows, tv.SourceHash, err = sm1.tableDigest(
+			ctx, migSchema, t, columns,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tv.SettledRows, tv.SettledHash, err = sm1.tableDigest(
+			ctx, schema, t, columns,
+		)
+		if err != nil {
+			return nil, err
+		}
+		tvs = append(tvs, tv)
+	}
+	return tvs, nil
+}
+
+// tableColumns returns the comma-separated (and quoted) column names of
+// the `schema.table` table, in their definition order, as recorded by
+// the pg_attribute catalog.
+func (sm1 *Settler) tableColumns(
+	ctx context.Context, schema, table string,
+) (string, error) {
+	rs, err := sm1.tx.Query(ctx, `SELECT string_agg(
+	quote_ident(attname), ', ' ORDER BY attnum
+)
+FROM pg_attribute
+WHERE attrelid = format('%I.%I', $1::text, $2::text)::regclass
+AND attnum > 0 AND NOT attisdropped`, schema, table)
+	if err != nil {
+		return "", fmt.Errorf(
+			"querying %s.%s columns: %w", schema, table, err,
+		)
+	}
+	defer rs.Close()
+	var columns *string
+	for rs.Next() {
+		if err := rs.Scan(&columns); err != nil {
+			return "", fmt.Errorf(
+				"scanning %s.%s columns: %w", schema, table, err,
+			)
+		}
+	}
+	if err := rs.Err(); err != nil {
+		return "", fmt.Errorf("closing result set: %w", err)
+	}
+	if columns == nil {
+		return "", fmt.Errorf("%s.%s has no column", schema, table)
+	}
+	return *columns, nil
+}
+
+// tableKey returns the (quoted) primary key column name of the
+// `schema.table` table and its type (as formatted by the format_type
+// function), as recorded by the pg_index and pg_attribute catalogs.
+// The keyset pagination of the SettleTableBatch method requires the
+// primary key to have exactly one column.
+func (sm1 *Settler) tableKey(
+	ctx context.Context, schema, table string,
+) (key, keyType string, err error) {
+	rs, err := sm1.tx.Query(ctx, `SELECT quote_ident(a.attname),
+	format_type(a.atttypid, a.atttypmod)
+FROM pg_index AS i
+JOIN pg_attribute AS a ON a.attrelid=i.indrelid AND a.attnum=ANY(i.indkey)
+WHERE i.indrelid = format('%I.%I', $1::text, $2::text)::regclass
+AND i.indisprimary`, schema, table)
+	if err != nil {
+		return "", "", fmt.Errorf(
+			"querying %s.%s primary key: %w", schema, table, err,
+		)
+	}
+	defer rs.Close()
+	n := 0
+	for rs.Next() {
+		if err := rs.Scan(&key, &keyType); err != nil {
+			return "", "", fmt.Errorf(
+				"scanning %s.%s primary key: %w", schema, table, err,
+			)
+		}
+		n++
+	}
+	if err := rs.Err(); err != nil {
+		return "", "", fmt.Errorf("closing result set: %w", err)
+	}
+	if n != 1 {
+		return "", "", fmt.Errorf(
+			"%s.%s has %d primary key columns, expected one",
+			schema, table, n,
+		)
+	}
+	return key, keyType, nil
+}
+
+// tableDigest computes the number of rows and the order-independent
+// hash of the `columns` of the `schema.table` table (or view).
+func (sm1 *Settler) tableDigest(
+	ctx context.Context, schema, table, columns string,
+) (n int64, hash string, err error) {
+	q := fmt.Sprintf(
+		`SELECT count(*), md5(coalesce(string_agg(h, '' ORDER BY h), ''))
+FROM (SELECT md5(t::text) AS h FROM (SELECT %s FROM %s.%s) AS t) AS d`,
+		columns, schema, table,
+	)
+	rs, err := sm1.tx.Query(ctx, q)
+	if err != nil {
+		return 0, "", fmt.Errorf(
+			"querying %s.%s digest: %w", schema, table, err,
+		)
+	}
+	defer rs.Close()
+	for rs.Next() {
+		if err := rs.Scan(&n, &hash); err != nil {
+			return 0, "", fmt.Errorf(
+				"scanning %s.%s digest: %w", schema, table, err,
+			)
+		}
+	}
+	if err := rs.Err(); err != nil {
+		return 0, "", fmt.Errorf("closing result set: %w", err)
+	}
+	return n, hash, nil
+}
+
 // devDataStatements embeds the dev.sql file contents which are
 // supposed to fill database schema tables (which must be created
 // previously) with the development suitable initial data.
@@ -169,28 +451,165 @@ func (sm1 *Settler) MajorVersion() uint {
 }
 
 // PersistSettings persists the given mutableSettings byte slice as the
-// serialized form of the system mutable configuration settings, using
-// the transaction which is hold by `sm1` object. This persistence will
-// take effect whenever the caller could commit its transaction.
+// serialized form of the mutable configuration settings of the `c`
+// component, using the transaction which is hold by `sm1` object.
+// This persistence will take effect whenever the caller could commit
+// its transaction.
 // The minb and maxb are also persisted as the minimum and maximum
-// boundary values for all (mutable and immutable) settings, serialized
-// with the same format which is used for mutableSettings.
+// boundary values for all (mutable and immutable) settings of that
+// component, serialized with the same format which is used for the
+// mutableSettings. The `cons` constraints (or nil) are persisted
+// alongside them too.
+// If the `c` component has no settings row yet (e.g., it is introduced
+// by a newer configuration version than the one which has initialized
+// the database), its row will be inserted. Otherwise, it is updated
+// and its revision is incremented.
 func (sm1 *Settler) PersistSettings(
-	ctx context.Context, mutableSettings, minb, maxb []byte,
+	ctx context.Context,
+	c model.Component,
+	mutableSettings, minb, maxb, cons []byte,
 ) error {
-	switch count, err := sm1.tx.Exec(
+	_, err := sm1.SwapSettings(
+		ctx, c, nil, mutableSettings, minb, maxb, cons,
+	)
+	return err
+}
+
+// SwapSettings persists the given mutableSettings byte slice, the
+// minb and maxb boundary values, and the `cons` constraints as the
+// serialized settings of the `c` component, just like the
+// PersistSettings method, and returns the new revision of that
+// settings row.
+// If the `expected` argument is non-nil, the settings row will be
+// updated only if its current revision is equal to *expected (i.e., a
+// compare and swap operation). Otherwise, an error wrapping the
+// repo.ErrSettingsRevisionMismatch will be returned. The compare and
+// swap operation requires the settings row to exist beforehand. If the
+// `expected` argument is nil, the settings row will be upserted
+// unconditionally.
+func (sm1 *Settler) SwapSettings(
+	ctx context.Context,
+	c model.Component,
+	expected *int64,
+	mutableSettings, minb, maxb, cons []byte,
+) (rev int64, err error) {
+	var rs repo.Rows
+	if expected == nil {
+		rs, err = sm1.tx.Query(
+			ctx,
+			`INSERT INTO settings (
+    component, config, min_bounds, max_bounds, constraints
+)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (component) DO UPDATE
+SET config=EXCLUDED.config,
+    min_bounds=EXCLUDED.min_bounds,
+    max_bounds=EXCLUDED.max_bounds,
+    constraints=EXCLUDED.constraints,
+    revision=settings.revision+1
+RETURNING revision`,
+			string(c), mutableSettings, minb, maxb, cons,
+		)
+	} else {
+		rs, err = sm1.tx.Query(
+			ctx,
+			`UPDATE settings
+SET config=$3, min_bounds=$4, max_bounds=$5, constraints=$6,
+    revision=revision+1
+WHERE component=$1 AND revision=$2
+RETURNING revision`,
+			string(c), *expected, mutableSettings, minb, maxb, cons,
+		)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("updating %q settings row: %w", c, err)
+	}
+	defer rs.Close()
+	found := false
+	for rs.Next() {
+		if err := rs.Scan(&rev); err != nil {
+			return 0, fmt.Errorf("scanning revision column: %w", err)
+		}
+		found = true
+	}
+	if err := rs.Err(); err != nil {
+		return 0, fmt.Errorf("closing result set: %w", err)
+	}
+	if !found {
+		return 0, fmt.Errorf(
+			"expected revision %d of %q: %w",
+			*expected, c, repo.ErrSettingsRevisionMismatch,
+		)
+	}
+	return rev, nil
+}
+
+// AppendJournal appends the `e` entry to the schema_migrations journal
+// table of the caweb1 schema, using the transaction which is hold by
+// `sm1` object, so it will take effect whenever the caller could commit
+// its transaction. A fresh jid is generated for the appended row.
+// If the journal table does not exist (e.g., because the database was
+// initialized before the introduction of the journal), an error wrapping
+// the repo.ErrJournalNotFound will be returned.
+func (sm1 *Settler) AppendJournal(
+	ctx context.Context, e *repo.JournalEntry,
+) error {
+	table := migrationuc.SchemaName(Major) + ".schema_migrations"
+	rs, err := sm1.tx.Query(
+		ctx, `SELECT to_regclass($1) IS NOT NULL`, table,
+	)
+	if err != nil {
+		return fmt.Errorf("querying %q table existence: %w", table, err)
+	}
+	defer rs.Close()
+	found := false
+	for rs.Next() {
+		if err := rs.Scan(&found); err != nil {
+			return fmt.Errorf("scanning table existence: %w", err)
+		}
+	}
+	if err := rs.Err(); err != nil {
+		return fmt.Errorf("closing result set: %w", err)
+	}
+	if !found {
+		return fmt.Errorf("%q: %w", table, repo.ErrJournalNotFound)
+	}
+	steps, err := json.Marshal(e.Steps)
+	if err != nil {
+		return fmt.Errorf("marshaling journal steps: %w", err)
+	}
+	state, outcome := "succeeded", any(nil)
+	if e.Error != "" {
+		state, outcome = "failed", e.Error
+	}
+	// Although the table name may not be passed as a parameter, it is
+	// a trusted string.
+	_, err = sm1.tx.Exec(
 		ctx,
-		`UPDATE settings
-SET config=$1, min_bounds=$2, max_bounds=$3
-WHERE component='caweb'`,
-		mutableSettings,
-		minb,
-		maxb,
-	); {
-	case err != nil:
-		return fmt.Errorf("updating caweb settings row: %w", err)
-	case count != 1:
-		return fmt.Errorf("%d rows are updated instead of 1", count)
+		fmt.Sprintf(`INSERT INTO %s (
+    jid, operation, src_config_version, src_schema_version,
+    dst_config_version, dst_schema_version, steps, os_user,
+    started_at, finished_at, state, outcome
+)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`, table),
+		uuid.New(), e.Operation,
+		optionalVersion(e.SrcConfigVersion),
+		optionalVersion(e.SrcSchemaVersion),
+		e.DstConfigVersion.String(), e.DstSchemaVersion.String(),
+		string(steps), e.OSUser, e.StartedAt, e.FinishedAt,
+		state, outcome,
+	)
+	if err != nil {
+		return fmt.Errorf("inserting into %q: %w", table, err)
 	}
 	return nil
 }
+
+// optionalVersion returns the string form of the `v` version, or nil if
+// `v` is nil, so it can be passed as a nullable query argument.
+func optionalVersion(v *model.SemVer) any {
+	if v == nil {
+		return nil
+	}
+	return v.String()
+}
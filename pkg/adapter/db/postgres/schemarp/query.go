@@ -11,6 +11,8 @@ import (
 	"fmt"
 
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres"
+	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration"
+	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 	"github.com/momeni/clean-arch/pkg/core/scram"
 )
@@ -43,6 +45,82 @@ func DropServerIfExists(
 	return err
 }
 
+// TryLock tries to acquire the `name` session-level advisory lock
+// without waiting, using the hash of `name` as the lock key. The lock is
+// held by the `c` connection until it is released by the Unlock
+// function (or the connection is closed). If another connection holds
+// the lock, false will be returned.
+func TryLock(
+	ctx context.Context, c *postgres.Conn, name string,
+) (locked bool, err error) {
+	rs, err := c.Query(
+		ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, name,
+	)
+	if err != nil {
+		return false, fmt.Errorf("querying: %w", err)
+	}
+	defer rs.Close()
+	for rs.Next() {
+		if err := rs.Scan(&locked); err != nil {
+			return false, fmt.Errorf("scanning: %w", err)
+		}
+	}
+	if err := rs.Err(); err != nil {
+		return false, fmt.Errorf("closing result set: %w", err)
+	}
+	return locked, nil
+}
+
+// Unlock releases the `name` session-level advisory lock which was
+// acquired by the TryLock function using the `c` connection.
+func Unlock(ctx context.Context, c *postgres.Conn, name string) error {
+	_, err := c.Exec(
+		ctx, `SELECT pg_advisory_unlock(hashtext($1))`, name,
+	)
+	return err
+}
+
+// LockHolders lists the sessions which hold the `name` session-level
+// advisory lock (as acquired by the TryLock function) in the current
+// database. Advisory locks with a bigint key are reported by pg_locks
+// with their high and low 32 bits as the classid and objid columns.
+func LockHolders[Q postgres.Queryer](
+	ctx context.Context, q Q, name string,
+) ([]repo.LockHolder, error) {
+	rs, err := q.Query(
+		ctx,
+		`SELECT a.pid, coalesce(a.usename, ''),
+    coalesce(host(a.client_addr), 'local'),
+    coalesce(a.application_name, ''), a.backend_start
+FROM pg_locks l JOIN pg_stat_activity a ON a.pid=l.pid
+WHERE l.locktype='advisory' AND l.granted AND l.objsubid=1
+    AND l.database=(
+        SELECT oid FROM pg_database WHERE datname=current_database()
+    )
+    AND ((l.classid::bigint << 32) | l.objid::bigint)=hashtext($1)::bigint`,
+		name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying: %w", err)
+	}
+	defer rs.Close()
+	var lhs []repo.LockHolder
+	for rs.Next() {
+		var lh repo.LockHolder
+		err := rs.Scan(
+			&lh.PID, &lh.User, &lh.Host, &lh.Application, &lh.Since,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning: %w", err)
+		}
+		lhs = append(lhs, lh)
+	}
+	if err := rs.Err(); err != nil {
+		return nil, fmt.Errorf("closing result set: %w", err)
+	}
+	return lhs, nil
+}
+
 // DropIfExists drops the `schema` schema without cascading if it
 // exists. That is, if `schema` does not exist, a nil error will be
 // returned without any change. And if `schema` exists and is empty,
@@ -253,3 +331,114 @@ func alterRolePass(
 WITH PASSWORD '%s'`, suffixedRole, hashedPassword))
 	return err
 }
+
+// ListSchemas lists the names of all schema of the database in their
+// ascending order, excluding the information_schema and those schema
+// which their names start with "pg_" (i.e., the system schema).
+func ListSchemas[Q postgres.Queryer](
+	ctx context.Context, q Q,
+) ([]string, error) {
+	return queryNames(ctx, q, `SELECT nspname FROM pg_catalog.pg_namespace
+WHERE nspname NOT LIKE 'pg\_%' AND nspname <> 'information_schema'
+ORDER BY nspname`)
+}
+
+// ListServers lists the names of all foreign servers of the database in
+// their ascending order.
+func ListServers[Q postgres.Queryer](
+	ctx context.Context, q Q,
+) ([]string, error) {
+	return queryNames(ctx, q, `SELECT srvname
+FROM pg_catalog.pg_foreign_server
+ORDER BY srvname`)
+}
+
+// ListRoles lists the database roles which their names end with the
+// `roleSuffix` (excluding the "pg_" prefixed system roles) in their
+// ascending order. The `roleSuffix` is removed from the returned role
+// names, so they can be compared with the repo.Role constants.
+// An empty `roleSuffix` lists all non-system roles.
+func ListRoles[Q postgres.Queryer](
+	ctx context.Context, q Q, roleSuffix repo.Role,
+) ([]repo.Role, error) {
+	names, err := queryNames(ctx, q, `SELECT rolname FROM pg_catalog.pg_roles
+WHERE rolname NOT LIKE 'pg\_%'
+    AND right(rolname, length($1)) = $1
+    AND length(rolname) > length($1)
+ORDER BY rolname`, string(roleSuffix))
+	if err != nil {
+		return nil, err
+	}
+	roles := make([]repo.Role, len(names))
+	for i, n := range names {
+		roles[i] = repo.Role(n[:len(n)-len(roleSuffix)])
+	}
+	return roles, nil
+}
+
+// DetectSchemaVersion examines the tables and columns of the `schema`
+// schema and returns the database schema version (having the `major`
+// major version) which they conform with.
+// See the migration.DetectVersion function for details.
+//
+// Caller is responsible to pass a trusted schema name string.
+func DetectSchemaVersion[Q postgres.Queryer](
+	ctx context.Context, q Q, schema string, major uint,
+) (model.SemVer, error) {
+	return migration.DetectVersion(ctx, q, schema, major)
+}
+
+// SettingsVersion returns the format version of the mutable settings
+// of the `comp` component, as stored in the version field of the config
+// column of the settings table in the `schema` schema. This version is
+// stored by all schema versions, since it is a part of the serialized
+// settings (e.g., see the cfg3.Serializable struct). If the `comp`
+// component has no settings row, an error wrapping the
+// repo.ErrSettingsNotFound will be returned.
+//
+// Caller is responsible to pass a trusted schema name string.
+func SettingsVersion[Q postgres.Queryer](
+	ctx context.Context, q Q, schema string, comp model.Component,
+) (v model.SemVer, err error) {
+	// Although the schema name can not be passed as a query parameter,
+	// it is a trusted string.
+	vers, err := queryNames(ctx, q, fmt.Sprintf(`SELECT config->>'version'
+FROM %s.settings
+WHERE component=$1`, schema), string(comp))
+	switch {
+	case err != nil:
+		return v, err
+	case len(vers) == 0:
+		return v, fmt.Errorf("%q: %w", comp, repo.ErrSettingsNotFound)
+	case len(vers) > 1:
+		return v, fmt.Errorf("more than one %q settings rows", comp)
+	}
+	if err = v.UnmarshalText([]byte(vers[0])); err != nil {
+		return v, fmt.Errorf("parsing %q version: %w", vers[0], err)
+	}
+	return v, nil
+}
+
+// queryNames runs the `sql` query with the `args` arguments using the
+// `q` queryer and returns the values of its only (text) column.
+func queryNames[Q postgres.Queryer](
+	ctx context.Context, q Q, sql string, args ...any,
+) ([]string, error) {
+	rs, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying: %w", err)
+	}
+	defer rs.Close()
+	var names []string
+	for rs.Next() {
+		var n string
+		if err := rs.Scan(&n); err != nil {
+			return nil, fmt.Errorf("scanning: %w", err)
+		}
+		names = append(names, n)
+	}
+	if err := rs.Err(); err != nil {
+		return nil, fmt.Errorf("closing result set: %w", err)
+	}
+	return names, nil
+}
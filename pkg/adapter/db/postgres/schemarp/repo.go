@@ -12,6 +12,7 @@ import (
 	"context"
 
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres"
+	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 	"github.com/momeni/clean-arch/pkg/core/scram"
 )
@@ -54,7 +55,7 @@ type connQueryer struct {
 // queries in addition to queries which support connections and
 // transactions.
 //
-// Currently, two operations mandate a connection.
+// Currently, four operations mandate a connection.
 // The InstallFDWExtensionIfMissing creates an extension which will be
 // accessible by roles based on their granted privileges.
 // That installation should be performed independent of a failed or
@@ -69,6 +70,9 @@ type connQueryer struct {
 // other removals are completed (in their transaction or auto-commit
 // transaction) beforehand. By the way, result of this operation can
 // affect multiple user roles.
+// The TryLock and Unlock manage a session-level advisory lock which
+// must be held across several transactions (e.g., during a migration),
+// so they are bound to the connection instead of a transaction.
 func (schema *Repo) Conn(c repo.Conn) repo.SchemaConnQueryer {
 	cc := c.(*postgres.Conn)
 	return connQueryer{Conn: cc, roleSuffix: schema.roleSuffix}
@@ -95,6 +99,29 @@ func (cq connQueryer) DropServerIfExists(
 	return DropServerIfExists(ctx, cq.Conn, serverName)
 }
 
+// TryLock tries to acquire the `name` advisory lock without waiting.
+// The lock is held by this connection until it is released by the
+// Unlock method (or the connection is closed). If another connection
+// holds the lock, false will be returned.
+func (cq connQueryer) TryLock(
+	ctx context.Context, name string,
+) (bool, error) {
+	return TryLock(ctx, cq.Conn, name)
+}
+
+// Unlock releases the `name` advisory lock which was acquired by the
+// TryLock method using this connection.
+func (cq connQueryer) Unlock(ctx context.Context, name string) error {
+	return Unlock(ctx, cq.Conn, name)
+}
+
+// LockHolders lists the sessions which hold the `name` advisory lock.
+func (cq connQueryer) LockHolders(
+	ctx context.Context, name string,
+) ([]repo.LockHolder, error) {
+	return LockHolders(ctx, cq.Conn, name)
+}
+
 // DropIfExists drops the `schema` schema without cascading if it
 // exists. That is, if `schema` does not exist, a nil error will be
 // returned without any change. And if `schema` exists and is empty,
@@ -180,6 +207,45 @@ func (cq connQueryer) GrantFDWUsage(
 	return GrantFDWUsage(ctx, cq.Conn, cq.roleSuffix, role)
 }
 
+// ListSchemas lists the names of all schema of the database in
+// their ascending order, excluding the system schema.
+func (cq connQueryer) ListSchemas(ctx context.Context) ([]string, error) {
+	return ListSchemas(ctx, cq.Conn)
+}
+
+// ListServers lists the names of all foreign servers of the database
+// in their ascending order.
+func (cq connQueryer) ListServers(ctx context.Context) ([]string, error) {
+	return ListServers(ctx, cq.Conn)
+}
+
+// ListRoles lists the database roles which their names end with the
+// role name suffix of this connection queryer, removing that suffix.
+func (cq connQueryer) ListRoles(ctx context.Context) ([]repo.Role, error) {
+	return ListRoles(ctx, cq.Conn, cq.roleSuffix)
+}
+
+// DetectSchemaVersion examines the tables and columns of the `schema`
+// schema and returns the database schema version (having the `major`
+// major version) which they conform with.
+//
+// Caller is responsible to pass a trusted schema name string.
+func (cq connQueryer) DetectSchemaVersion(
+	ctx context.Context, schema string, major uint,
+) (model.SemVer, error) {
+	return DetectSchemaVersion(ctx, cq.Conn, schema, major)
+}
+
+// SettingsVersion returns the format version of the mutable settings
+// of the `comp` component which are stored in the `schema` schema.
+//
+// Caller is responsible to pass a trusted schema name string.
+func (cq connQueryer) SettingsVersion(
+	ctx context.Context, schema string, comp model.Component,
+) (model.SemVer, error) {
+	return SettingsVersion(ctx, cq.Conn, schema, comp)
+}
+
 type txQueryer struct {
 	*postgres.Tx
 
@@ -295,6 +361,45 @@ func (tq txQueryer) GrantFDWUsage(
 	return GrantFDWUsage(ctx, tq.Tx, tq.roleSuffix, role)
 }
 
+// ListSchemas lists the names of all schema of the database in
+// their ascending order, excluding the system schema.
+func (tq txQueryer) ListSchemas(ctx context.Context) ([]string, error) {
+	return ListSchemas(ctx, tq.Tx)
+}
+
+// ListServers lists the names of all foreign servers of the database
+// in their ascending order.
+func (tq txQueryer) ListServers(ctx context.Context) ([]string, error) {
+	return ListServers(ctx, tq.Tx)
+}
+
+// ListRoles lists the database roles which their names end with the
+// role name suffix of this transaction queryer, removing that suffix.
+func (tq txQueryer) ListRoles(ctx context.Context) ([]repo.Role, error) {
+	return ListRoles(ctx, tq.Tx, tq.roleSuffix)
+}
+
+// DetectSchemaVersion examines the tables and columns of the `schema`
+// schema and returns the database schema version (having the `major`
+// major version) which they conform with.
+//
+// Caller is responsible to pass a trusted schema name string.
+func (tq txQueryer) DetectSchemaVersion(
+	ctx context.Context, schema string, major uint,
+) (model.SemVer, error) {
+	return DetectSchemaVersion(ctx, tq.Tx, schema, major)
+}
+
+// SettingsVersion returns the format version of the mutable settings
+// of the `comp` component which are stored in the `schema` schema.
+//
+// Caller is responsible to pass a trusted schema name string.
+func (tq txQueryer) SettingsVersion(
+	ctx context.Context, schema string, comp model.Component,
+) (model.SemVer, error) {
+	return SettingsVersion(ctx, tq.Tx, schema, comp)
+}
+
 // ChangePasswords updates the passwords of the given roles in the
 // current transaction. The roles and passwords slices must have the
 // same number of entries, so they can be used in pair.
@@ -7,27 +7,29 @@ package settingsrp
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
 
-	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/goccy/go-json"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
 	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres"
-	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/sch1v1"
+	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/sch1v3"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/settle/stlmig1"
-	"github.com/momeni/clean-arch/pkg/core/log"
+	"github.com/momeni/clean-arch/pkg/core/cerr"
 	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/momeni/clean-arch/pkg/core/repo"
 	"github.com/momeni/clean-arch/pkg/core/usecase/appuc"
 )
 
-// Fetch queries the mutable settings from the settings repository,
-// deserializes them, merges them into a clone of the baseConfs
-// representing the configuration file and environment variables state,
-// and returns the fresh configuration instance as an appuc.Builder
-// interface in addition to its visible settings (as an instance of the
-// version-independent model.VisibleSettings struct).
+// Fetch queries the mutable settings from the settings repository
+// (one row per registered component), deserializes them, merges them
+// into a clone of the baseConfs representing the configuration file
+// and environment variables state, and returns the fresh configuration
+// instance as an appuc.Builder interface in addition to its visible
+// settings (as an instance of the version-independent
+// model.VisibleSettings struct).
 //
 // The settings boundary values are also returned as `minb` and
 // `maxb` instances (of the version-independent model.Settings
@@ -39,58 +41,128 @@ import (
 // neither updates the database nor verifies it beyound the version
 // of the persisted configuration settings.
 // If the database settings were out of the acceptable range of
-// values, they will take the nearest (minimum or maximum) boundary
-// value and that adjustment will be logged as a warning.
+// values, they are treated based on the DB policy of the baseConfs
+// (see the loadComponents function), so Fetch may fail and report all
+// out of range settings if they have to be rejected.
+//
+// The `rev` revision of the model.AppComponent settings row is returned
+// too. It may be passed to the Update function later, so the settings
+// will be updated only if they are not changed concurrently.
 func Fetch(
-	ctx context.Context, c *postgres.Conn, baseConfs *cfg2.Config,
+	ctx context.Context, c *postgres.Conn, baseConfs *cfg3.Config,
 ) (
 	builder appuc.Builder,
 	vs *model.VisibleSettings,
 	minb, maxb *model.Settings,
+	rev int64,
 	err error,
 ) {
-	b, err := sch1v1.LoadSettings(ctx, c)
-	if err != nil {
-		err = fmt.Errorf("sch1v1.LoadSettings: %w", err)
-		return nil, nil, nil, nil, err
-	}
-	var ser cfg2.Serializable
-	err = json.Unmarshal(b, &ser)
-	if err != nil {
-		err = fmt.Errorf("deserializing json: %w", err)
-		return nil, nil, nil, nil, err
-	}
 	confs := baseConfs.Clone()
-	err = confs.Mutate(ser)
-	var boundsErr settings.BoundsError
-	switch {
-	case errors.As(err, &boundsErr):
-		log.Warn(
-			ctx,
+	if rev, err = loadComponents(ctx, c, confs); err != nil {
+		return nil, nil, nil, nil, 0, err
+	}
+	vs, minb, maxb = adapterToModelVisible(confs)
+	return confs, vs, minb, maxb, rev, nil
+}
+
+// loadComponents queries the settings rows of all components which
+// are registered by cfg3.Config (one row per component), deserializes
+// them, and mutates the `confs` instance accordingly. Components other
+// than the model.AppComponent may have no settings row (e.g., if they
+// were registered after the database was initialized) and will keep
+// their base settings in that case.
+// If the database settings were out of the acceptable range of values,
+// the DB policy of `confs` decides if they should take the nearest
+// (minimum or maximum) boundary value or keep their original values
+// (and that adjustment will be logged as a warning), or if they should
+// be rejected. Rejected settings of all components are reported in one
+// joined error (as *settings.RejectedSettingsError items).
+// The revision of the model.AppComponent settings row is returned as
+// `rev` since it identifies the version of the published settings.
+func loadComponents(
+	ctx context.Context, c *postgres.Conn, confs *cfg3.Config,
+) (rev int64, err error) {
+	var rejections []error
+	for _, comp := range confs.Components() {
+		name := comp.Name()
+		b, r, err := sch1v3.LoadRevisedSettings(ctx, c, name)
+		switch {
+		case errors.Is(err, repo.ErrSettingsNotFound) &&
+			name != model.AppComponent:
+			continue
+		case err != nil:
+			return 0, fmt.Errorf(
+				"sch1v3.LoadRevisedSettings(%q): %w", name, err,
+			)
+		}
+		if name == model.AppComponent {
+			rev = r
+		}
+		err = confs.Policies.DB.Enforce(
+			ctx, name, comp.Deserialize(confs, b),
 			"settings read from database are out of range",
-			log.Err("err", err),
 		)
-	case err != nil:
-		err = fmt.Errorf("confs.Mutate(%#v): %w", ser, err)
-		return nil, nil, nil, nil, err
+		var rejectedErr *settings.RejectedSettingsError
+		switch {
+		case errors.As(err, &rejectedErr):
+			rejections = append(rejections, err)
+		case err != nil:
+			return 0, fmt.Errorf(
+				"deserializing %q settings: %w", name, err,
+			)
+		}
 	}
-	v := confs.Visible()
-	vs = &model.VisibleSettings{
-		ImmutableSettings: &model.ImmutableSettings{
-			Logger: v.Immutable.Logger,
-		},
+	if err = errors.Join(rejections...); err != nil {
+		return 0, fmt.Errorf("rejecting database settings: %w", err)
 	}
-	if doo := v.Cars.DelayOfOPM; doo != nil {
-		t := time.Duration(*doo)
-		vs.ParkingMethod.Delay = &t
+	return rev, nil
+}
+
+// persistComponents serializes the mutable settings and boundary values
+// of all components which are registered by cfg3.Config, taking them
+// from the `confs` instance, and stores each one of them in its own
+// settings row using the `tx` transaction.
+//
+// If the `expected` argument is non-nil, the model.AppComponent row is
+// updated with a compare and swap operation, so it is updated only if
+// its revision is equal to *expected, and otherwise an error wrapping
+// the repo.ErrSettingsRevisionMismatch is returned. Since all rows are
+// updated in the same transaction, the app component revision guards
+// the rows of other components too. The new revision of the app
+// component row is returned as `rev`.
+func persistComponents(
+	ctx context.Context,
+	tx *postgres.Tx,
+	confs *cfg3.Config,
+	expected *int64,
+) (rev int64, err error) {
+	sm1 := stlmig1.New(tx)
+	for _, comp := range confs.Components() {
+		name := comp.Name()
+		b, lbb, ubb, cons, err := comp.Serialize(confs)
+		if err != nil {
+			return 0, fmt.Errorf(
+				"serializing %q settings: %w", name, err,
+			)
+		}
+		var exp *int64
+		if name == model.AppComponent {
+			exp = expected
+		}
+		r, err := sm1.SwapSettings(ctx, name, exp, b, lbb, ubb, cons)
+		if err != nil {
+			return 0, fmt.Errorf(
+				"persisting %q settings: %w", name, err,
+			)
+		}
+		if name == model.AppComponent {
+			rev = r
+		}
 	}
-	lb, ub := confs.Bounds()
-	minb = adapterToModelSettings(lb)
-	maxb = adapterToModelSettings(ub)
-	return confs, vs, minb, maxb, nil
+	return rev, nil
 }
 
-func adapterToModelSettings(s *cfg2.Serializable) *model.Settings {
+func adapterToModelSettings(s *cfg3.Serializable) *model.Settings {
 	ms := &model.Settings{}
 	if doo := s.Settings.Visible.Cars.DelayOfOPM; doo != nil {
 		t := time.Duration(*doo)
@@ -101,8 +173,9 @@ func adapterToModelSettings(s *cfg2.Serializable) *model.Settings {
 
 // Update converts the version-independent mutable model.Settings
 // instance into a version-dependent serializable settings instance
-// for the last supported version, serializes them as JSON, and
-// then stores them in the settings repository. Given mutable settings
+// for the last supported version, serializes them as JSON (one json
+// string per registered component), and then stores them in the
+// settings repository (one row per component). Given mutable settings
 // are also used in order to update a clone of the baseConfs instance.
 // Updated configuration settings will be returned as an instance of
 // the appuc.Builder interface in addition to its visible settings
@@ -111,57 +184,198 @@ func adapterToModelSettings(s *cfg2.Serializable) *model.Settings {
 //
 // The settings boundary values are also returned as `minb` and
 // `maxb` instances (of the version-independent model.Settings
-// struct), taken from the base settings. The argument `s` settings
-// must fall in this acceptable range of values, otherwise, an error
-// will be returned and settings will be kept unchanged.
+// struct), taken from the base settings. If the argument `s` settings
+// do not fall in this acceptable range of values, they are treated based
+// on the API policy of the baseConfs. By default, the Reject policy is
+// used, so an error which is marked by cerr.BadRequest will be returned
+// and settings will be kept unchanged. The Clamp and Warn policies store
+// the adjusted and original values respectively, logging a warning.
+// Violation of the declared constraints of settings (see the
+// settings.Constraints type) is reported as an error which is marked
+// by cerr.BadRequest and lists the violating settings by their json
+// paths in the model.Settings struct.
 // When updating the database with new settings, the boundary values
 // will be serialized and stored alongside them too.
+//
+// If the `expected` argument is non-nil, settings are updated using a
+// compare and swap operation, that is, only if the current revision of
+// the model.AppComponent settings row is equal to *expected. Otherwise,
+// an error wrapping the repo.ErrSettingsRevisionMismatch is returned.
+// A nil `expected` updates the settings unconditionally. In both cases,
+// the new `rev` revision of the settings row is returned.
 func Update(
 	ctx context.Context,
 	tx *postgres.Tx,
-	baseConfs *cfg2.Config,
+	baseConfs *cfg3.Config,
 	s *model.Settings,
+	expected *int64,
 ) (
 	builder appuc.Builder,
 	vs *model.VisibleSettings,
 	minb, maxb *model.Settings,
+	rev int64,
 	err error,
 ) {
-	ser := cfg2.Serializable{
-		Version: cfg2.Version,
-	}
-	if d := s.VisibleSettings.ParkingMethod.Delay; d != nil {
-		t := settings.Duration(*d)
-		ser.Settings.Visible.Cars.DelayOfOPM = &t
-	}
+	ser := modelToSerializable(s)
 	confs := baseConfs.Clone()
-	if err := confs.Mutate(ser); err != nil {
-		// settings.BoundsError instances are handled here too
-		err = fmt.Errorf("confs.Mutate(%#v): %w", ser, err)
-		return nil, nil, nil, nil, err
+	ser.Settings.Secrets, err = sealSecrets(ctx, tx, confs, s)
+	if err != nil {
+		return nil, nil, nil, nil, 0, err
 	}
-	b, err := json.Marshal(ser)
+	err = enforceAPIPolicy(ctx, confs, confs.Mutate(ser))
 	if err != nil {
-		err = fmt.Errorf("serializing json: %w", err)
-		return nil, nil, nil, nil, err
+		var consErr *settings.ConstraintsError
+		if errors.As(err, &consErr) {
+			return nil, nil, nil, nil, 0, constraintsBadRequest(consErr)
+		}
+		err = fmt.Errorf("confs.Mutate(%#v): %w", ser, err)
+		return nil, nil, nil, nil, 0, err
 	}
-	lb, ub := confs.Bounds()
-	lbb, err := json.Marshal(lb)
+	rev, err = persistComponents(ctx, tx, confs, expected)
 	if err != nil {
-		err = fmt.Errorf("serializing lower bounds: %w", err)
-		return nil, nil, nil, nil, err
+		return nil, nil, nil, nil, 0, err
 	}
-	ubb, err := json.Marshal(ub)
+	vs, minb, maxb = adapterToModelVisible(confs)
+	return confs, vs, minb, maxb, rev, nil
+}
+
+// Validate performs the same steps as the Update function, including
+// the conversion of `s` settings into their version-dependent format,
+// mutation of a clone of the baseConfs instance, and storing the
+// serialized settings in the settings repository using the `tx`
+// transaction. However, the boundary values violations do not stop
+// the process and are reported as a list of `violations` instead.
+// Violations of the declared constraints of settings are reported as
+// `violations` too, but since they may not be adjusted automatically,
+// nothing is stored and the returned builder, visible settings, and
+// boundary values will be nil in that case.
+// Each reported violation refers to a setting by its json path in the
+// model.Settings struct (e.g., "parking_method.delay").
+// The returned visible settings and builder reflect the adjusted values
+// (i.e., out of range values are replaced by their nearest boundary
+// value, unless the API policy of the baseConfs is Warn which keeps
+// the original values), so caller may examine the outcome of an update operation
+// without committing it. Caller is responsible to rollback the `tx`
+// transaction in order to discard the stored settings.
+func Validate(
+	ctx context.Context,
+	tx *postgres.Tx,
+	baseConfs *cfg3.Config,
+	s *model.Settings,
+) (
+	builder appuc.Builder,
+	vs *model.VisibleSettings,
+	minb, maxb *model.Settings,
+	violations []model.SettingsViolation,
+	err error,
+) {
+	ser := modelToSerializable(s)
+	confs := baseConfs.Clone()
+	ser.Settings.Secrets, err = sealSecrets(ctx, tx, confs, s)
 	if err != nil {
-		err = fmt.Errorf("serializing upper bounds: %w", err)
-		return nil, nil, nil, nil, err
+		return nil, nil, nil, nil, nil, err
 	}
-	sm1 := stlmig1.New(tx)
-	err = sm1.PersistSettings(ctx, b, lbb, ubb)
+	err = confs.Mutate(ser)
+	var boundsErr *cfg3.OutOfBoundsSettingsError
+	var consErr *settings.ConstraintsError
+	switch {
+	case errors.As(err, &consErr):
+		// confs is not mutated completely, so it may not be persisted
+		return nil, nil, nil, nil, modelViolations(consErr.Violations), nil
+	case errors.As(err, &boundsErr):
+		violations = boundsViolations(boundsErr)
+		if confs.Policies.API != settings.Warn {
+			break
+		}
+		// restored values must satisfy the constraints themselves
+		if errors.As(boundsErr.Restore(), &consErr) {
+			vs := modelViolations(consErr.Violations)
+			return nil, nil, nil, nil, append(vs, violations...), nil
+		}
+	case err != nil:
+		err = fmt.Errorf("confs.Mutate(%#v): %w", ser, err)
+		return nil, nil, nil, nil, nil, err
+	}
+	if _, err = persistComponents(ctx, tx, confs, nil); err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	vs, minb, maxb = adapterToModelVisible(confs)
+	return confs, vs, minb, maxb, violations, nil
+}
+
+// modelToSerializable converts the version-independent `s` settings
+// into an instance of the Serializable struct of the latest supported
+// configuration version. The secret settings are not converted, so
+// the Secrets pointer is left nil (see the sealSecrets function).
+func modelToSerializable(s *model.Settings) cfg3.Serializable {
+	ser := cfg3.Serializable{
+		Version: cfg3.Version,
+	}
+	if d := s.VisibleSettings.ParkingMethod.Delay; d != nil {
+		t := settings.Duration(*d)
+		ser.Settings.Visible.Cars.DelayOfOPM = &t
+	}
+	return ser
+}
+
+// sealSecrets converts the version-independent secret settings of `s`
+// into their sealed version-dependent counterparts, using the encryption
+// key of the `confs` instance. Since end-users cannot query the secret
+// settings, those which are not given in `s` (i.e., have a nil value)
+// keep their current sealed value, as read from the model.AppComponent
+// settings row using the `tx` transaction, and an empty secret clears
+// that setting. If no secret is given and the current settings row has
+// no secrets either, a nil *cfg3.Secrets is returned, so the secrets of
+// the `confs` instance are kept by its Mutate method.
+// Setting a secret while no encryption key is configured is reported
+// as an error which is marked by cerr.BadRequest.
+func sealSecrets(
+	ctx context.Context,
+	tx *postgres.Tx,
+	confs *cfg3.Config,
+	s *model.Settings,
+) (*cfg3.Secrets, error) {
+	b, _, err := sch1v3.LoadRevisedSettings(ctx, tx, model.AppComponent)
 	if err != nil {
-		err = fmt.Errorf("persisting settings: %w", err)
-		return nil, nil, nil, nil, err
+		return nil, fmt.Errorf("sch1v3.LoadRevisedSettings: %w", err)
+	}
+	cur := &cfg3.Serializable{}
+	if err := json.Unmarshal(b, cur); err != nil {
+		return nil, fmt.Errorf("decoding current settings: %w", err)
 	}
+	secrets := cur.Settings.Secrets
+	if s.Secrets == nil {
+		return secrets, nil
+	}
+	if secrets == nil {
+		secrets = &cfg3.Secrets{}
+	}
+	if k := s.Secrets.ParkingMethod.APIKey; k != nil {
+		var key *settings.Secret
+		if *k != "" {
+			sk := settings.Secret(*k)
+			key = &sk
+		}
+		if key != nil && !confs.Encryption.HasKey() {
+			return nil, cerr.BadRequest(errors.New(
+				"secret settings require an encryption key-file",
+			))
+		}
+		sealed, err := confs.Encryption.Seal(key)
+		if err != nil {
+			return nil, fmt.Errorf("sealing api key: %w", err)
+		}
+		secrets.Cars.APIKeyOfOPM = sealed
+	}
+	return secrets, nil
+}
+
+// adapterToModelVisible extracts the visible settings and boundary
+// values of the `confs` configuration instance and converts them into
+// their version-independent model layer counterparts.
+func adapterToModelVisible(confs *cfg3.Config) (
+	vs *model.VisibleSettings, minb, maxb *model.Settings,
+) {
 	v := confs.Visible()
 	vs = &model.VisibleSettings{
 		ImmutableSettings: &model.ImmutableSettings{
@@ -172,7 +386,87 @@ func Update(
 		t := time.Duration(*doo)
 		vs.ParkingMethod.Delay = &t
 	}
+	lb, ub := confs.Bounds()
 	minb = adapterToModelSettings(lb)
 	maxb = adapterToModelSettings(ub)
-	return confs, vs, minb, maxb, nil
+	return vs, minb, maxb
+}
+
+// boundsViolations converts the version-dependent `e` error into a list
+// of version-independent violations, one item per out of range setting,
+// which refer to those settings by their json path in model.Settings.
+func boundsViolations(
+	e *cfg3.OutOfBoundsSettingsError,
+) []model.SettingsViolation {
+	var violations []model.SettingsViolation
+	if oore := e.Cars.DelayOfOPM; oore != nil {
+		violations = append(violations, model.SettingsViolation{
+			Field:   "parking_method.delay",
+			Message: oore.Error(),
+		})
+	}
+	return violations
+}
+
+// modelFields maps the paths of settings in the cfg3.Serializable struct
+// (as reported by the settings.Violation instances) to their paths in
+// the version-independent model.Settings struct.
+var modelFields = map[string]string{
+	cfg3.FieldDelayOfOPM:  "parking_method.delay",
+	cfg3.FieldAPIKeyOfOPM: "secrets.parking_method.api_key",
+}
+
+// modelField converts the `f` path of a setting in the cfg3.Serializable
+// struct into its path in the model.Settings struct. Unknown paths are
+// returned as-is, so a newly added setting will not be masked silently.
+func modelField(f string) string {
+	if mf, ok := modelFields[f]; ok {
+		return mf
+	}
+	return f
+}
+
+// modelViolations converts the version-dependent `vs` violations into
+// a list of version-independent violations, which refer to the settings
+// by their json path in model.Settings.
+func modelViolations(vs []settings.Violation) []model.SettingsViolation {
+	violations := make([]model.SettingsViolation, 0, len(vs))
+	for _, v := range vs {
+		violations = append(violations, model.SettingsViolation{
+			Field:   modelField(v.Field),
+			Message: v.Err.Error(),
+		})
+	}
+	return violations
+}
+
+// constraintsBadRequest converts the version-dependent `e` error into
+// an error which is marked by cerr.BadRequest, so it can be reported to
+// end-users. The violating settings are referred to by their json path
+// in the model.Settings struct.
+func constraintsBadRequest(e *settings.ConstraintsError) error {
+	vs := make([]settings.Violation, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		vs = append(vs, settings.Violation{
+			Field: modelField(v.Field), Err: v.Err,
+		})
+	}
+	return cerr.BadRequest(&settings.ConstraintsError{Violations: vs})
+}
+
+// enforceAPIPolicy applies the API policy of `confs` to the `err` error
+// which is returned by its Mutate method. Rejected settings are marked
+// by cerr.BadRequest since end-users may fix them.
+func enforceAPIPolicy(
+	ctx context.Context, confs *cfg3.Config, err error,
+) error {
+	err = confs.Policies.API.Enforce(
+		ctx, model.AppComponent, err,
+		"settings written by end-users are out of range",
+	)
+	var rejectedErr *settings.RejectedSettingsError
+	if errors.As(err, &rejectedErr) {
+		return cerr.BadRequest(err)
+	}
+	return err
 }
@@ -10,8 +10,10 @@ package settingsrp
 
 import (
 	"context"
+	"time"
 
-	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/google/uuid"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres"
 	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
@@ -20,14 +22,14 @@ import (
 
 // Repo represents the settings repository instance.
 type Repo struct {
-	baseConfs *cfg2.Config
+	baseConfs *cfg3.Config
 }
 
 // New instantiates a settings Repo struct. Created instance wraps
 // the given configuration instance as its base configuration items, so
 // whenever it needs to update the mutable settings or reload them from
 // the database, it can apply them on a fresh clone of this base confs.
-func New(c *cfg2.Config) *Repo {
+func New(c *cfg3.Config) *Repo {
 	return &Repo{
 		baseConfs: c,
 	}
@@ -35,7 +37,7 @@ func New(c *cfg2.Config) *Repo {
 
 type connQueryer struct {
 	*postgres.Conn
-	baseConfs *cfg2.Config
+	baseConfs *cfg3.Config
 }
 
 // Conn takes a Conn interface instance, unwraps it as required,
@@ -50,11 +52,12 @@ func (settings *Repo) Conn(c repo.Conn) appuc.SettingsConnQueryer {
 	return connQueryer{Conn: cc, baseConfs: settings.baseConfs}
 }
 
-// Fetch queries the mutable settings from the settings repository,
-// deserializes them, merges them into a clone of the base settings
-// (representing the configuration file and environment variables state
-// when the settings repository instance was created), and returns the
-// fresh configuration instance as an appuc.Builder interface in
+// Fetch queries the mutable settings from the settings repository
+// (one row per registered component), deserializes them, merges
+// them into a clone of the base settings (representing the
+// configuration file and environment variables state when the
+// settings repository instance was created), and returns the fresh
+// configuration instance as an appuc.Builder interface in
 // addition to its visible settings (as an instance of the
 // version-independent model.VisibleSettings struct).
 //
@@ -70,18 +73,54 @@ func (settings *Repo) Conn(c repo.Conn) appuc.SettingsConnQueryer {
 // If the database settings were out of the acceptable range of
 // values, they will take the nearest (minimum or maximum) boundary
 // value and that adjustment will be logged as a warning.
+// The current revision of the settings is returned as `rev` too.
 func (cq connQueryer) Fetch(ctx context.Context) (
 	b appuc.Builder,
 	vs *model.VisibleSettings,
 	minb, maxb *model.Settings,
+	rev int64,
 	err error,
 ) {
 	return Fetch(ctx, cq.Conn, cq.baseConfs)
 }
 
+// ScheduleChange stores the `s` settings as a pending scheduled change
+// which should be applied at the `effectiveAt` time, after ensuring that
+// they fall in the acceptable range of values.
+// This method calls a generic function, so the actual implementation
+// can be coded at one place for both of the connection and transaction
+// receiving methods.
+func (cq connQueryer) ScheduleChange(
+	ctx context.Context, s *model.Settings, effectiveAt time.Time,
+) (*model.ScheduledChange, error) {
+	return ScheduleChange(ctx, cq.Conn, cq.baseConfs, s, effectiveAt)
+}
+
+// ScheduledChanges lists all scheduled changes, ordered by their
+// effective time.
+// This method calls a generic function, so the actual implementation
+// can be coded at one place for both of the connection and transaction
+// receiving methods.
+func (cq connQueryer) ScheduledChanges(ctx context.Context) (
+	[]model.ScheduledChange, error,
+) {
+	return ScheduledChanges(ctx, cq.Conn)
+}
+
+// CancelScheduledChange cancels the `id` pending scheduled change and
+// returns its updated instance.
+// This method calls a generic function, so the actual implementation
+// can be coded at one place for both of the connection and transaction
+// receiving methods.
+func (cq connQueryer) CancelScheduledChange(
+	ctx context.Context, id uuid.UUID,
+) (*model.ScheduledChange, error) {
+	return CancelScheduledChange(ctx, cq.Conn, id)
+}
+
 type txQueryer struct {
 	*postgres.Tx
-	baseConfs *cfg2.Config
+	baseConfs *cfg3.Config
 }
 
 // Tx takes a Tx interface instance, unwraps it as required,
@@ -113,11 +152,96 @@ func (settings *Repo) Tx(tx repo.Tx) appuc.SettingsTxQueryer {
 // will be returned and settings will be kept unchanged.
 // When updating the database with new settings, the boundary values
 // will be serialized and stored alongside them too.
-func (tq txQueryer) Update(ctx context.Context, s *model.Settings) (
+//
+// If the `expected` revision is non-nil, settings are only updated if
+// their current revision is equal to *expected, otherwise, an error
+// wrapping the repo.ErrSettingsRevisionMismatch is returned. The new
+// revision of the settings is returned as `rev`.
+func (tq txQueryer) Update(
+	ctx context.Context, s *model.Settings, expected *int64,
+) (
+	b appuc.Builder,
+	vs *model.VisibleSettings,
+	minb, maxb *model.Settings,
+	rev int64,
+	err error,
+) {
+	return Update(ctx, tq.Tx, tq.baseConfs, s, expected)
+}
+
+// Validate performs the same steps as the Update method, including the
+// conversion of the `s` settings into their version-dependent format,
+// mutation of a clone of the base settings, and storing them in the
+// settings repository. However, the boundary values violations do not
+// cause an error and are reported as a list of `violations` instead.
+// The wrapped transaction must be rolled back by the caller in order
+// to discard the stored settings.
+func (tq txQueryer) Validate(ctx context.Context, s *model.Settings) (
 	b appuc.Builder,
 	vs *model.VisibleSettings,
 	minb, maxb *model.Settings,
+	violations []model.SettingsViolation,
 	err error,
 ) {
-	return Update(ctx, tq.Tx, tq.baseConfs, s)
+	return Validate(ctx, tq.Tx, tq.baseConfs, s)
+}
+
+// ScheduleChange stores the `s` settings as a pending scheduled change
+// which should be applied at the `effectiveAt` time, after ensuring that
+// they fall in the acceptable range of values.
+// This method calls a generic function, so the actual implementation
+// can be coded at one place for both of the connection and transaction
+// receiving methods.
+func (tq txQueryer) ScheduleChange(
+	ctx context.Context, s *model.Settings, effectiveAt time.Time,
+) (*model.ScheduledChange, error) {
+	return ScheduleChange(ctx, tq.Tx, tq.baseConfs, s, effectiveAt)
+}
+
+// ScheduledChanges lists all scheduled changes, ordered by their
+// effective time.
+// This method calls a generic function, so the actual implementation
+// can be coded at one place for both of the connection and transaction
+// receiving methods.
+func (tq txQueryer) ScheduledChanges(ctx context.Context) (
+	[]model.ScheduledChange, error,
+) {
+	return ScheduledChanges(ctx, tq.Tx)
+}
+
+// CancelScheduledChange cancels the `id` pending scheduled change and
+// returns its updated instance.
+// This method calls a generic function, so the actual implementation
+// can be coded at one place for both of the connection and transaction
+// receiving methods.
+func (tq txQueryer) CancelScheduledChange(
+	ctx context.Context, id uuid.UUID,
+) (*model.ScheduledChange, error) {
+	return CancelScheduledChange(ctx, tq.Tx, id)
+}
+
+// LockScheduler tries to obtain the scheduler advisory lock until the
+// end of the wrapped transaction, without blocking.
+func (tq txQueryer) LockScheduler(ctx context.Context) (bool, error) {
+	return LockScheduler(ctx, tq.Tx)
+}
+
+// DueScheduledChanges queries and locks the pending scheduled changes
+// which their effective time has arrived.
+func (tq txQueryer) DueScheduledChanges(ctx context.Context) (
+	[]model.ScheduledChange, error,
+) {
+	return DueScheduledChanges(ctx, tq.Tx)
+}
+
+// FinishScheduledChange records the outcome of applying the `id`
+// scheduled change, moving it to the given final `state`.
+func (tq txQueryer) FinishScheduledChange(
+	ctx context.Context,
+	id uuid.UUID,
+	state model.ScheduledChangeState,
+	outcome *string,
+	rev *int64,
+) error {
+	return FinishScheduledChange(ctx, tq.Tx, id, state, outcome, rev)
 }
@@ -0,0 +1,262 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package settingsrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
+	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
+	"github.com/momeni/clean-arch/pkg/adapter/db/postgres"
+	"github.com/momeni/clean-arch/pkg/core/cerr"
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"gorm.io/gorm/clause"
+)
+
+// schedulerLockKey is the key of the transaction-level advisory lock
+// which is obtained by the LockScheduler function. It is an arbitrary
+// constant (the "cawebsch" ASCII bytes) which must be shared by all
+// instances of the application.
+const schedulerLockKey int64 = 0x63617765_62736368
+
+// gormNow is the database clock expression, so the finished_at column
+// of all instances agree with the effective_at comparisons.
+var gormNow = clause.Expr{SQL: "now()"}
+
+type gScheduledChange struct {
+	SCID        uuid.UUID `gorm:"primaryKey;type:uuid;column:scid"`
+	Config      []byte    `gorm:"type:json"`
+	EffectiveAt time.Time
+	CreatedAt   time.Time
+	State       string
+	FinishedAt  *time.Time
+	Outcome     *string
+	Revision    *int64
+}
+
+func (gsc *gScheduledChange) TableName() string {
+	return "scheduled_changes"
+}
+
+// Model converts the `gsc` row into a model.ScheduledChange instance.
+// The settings are only converted if they were serialized using the
+// latest supported configuration version, otherwise, the Settings field
+// is left nil, so the change may not be applied. If the settings of
+// the `gsc` row cannot be deserialized, the Settings field is left nil
+// too and the Outcome field of a pending change describes the reason,
+// so one corrupted row does not prevent the other rows from being
+// listed or applied, while the scheduler marks it as failed.
+func (gsc *gScheduledChange) Model() *model.ScheduledChange {
+	sc := &model.ScheduledChange{
+		ID:          gsc.SCID,
+		EffectiveAt: gsc.EffectiveAt,
+		CreatedAt:   gsc.CreatedAt,
+		State:       model.ScheduledChangeState(gsc.State),
+		FinishedAt:  gsc.FinishedAt,
+		Outcome:     gsc.Outcome,
+		Revision:    gsc.Revision,
+	}
+	ser := &cfg3.Serializable{}
+	if err := json.Unmarshal(gsc.Config, ser); err != nil {
+		if sc.State == model.ScheduledChangePending {
+			reason := fmt.Sprintf("unmarshalling settings: %v", err)
+			sc.Outcome = &reason
+		}
+		return sc
+	}
+	if ser.Version == cfg3.Version {
+		sc.Settings = adapterToModelSettings(ser)
+	}
+	return sc
+}
+
+func modelScheduledChanges(
+	gscs []gScheduledChange,
+) []model.ScheduledChange {
+	scs := make([]model.ScheduledChange, 0, len(gscs))
+	for i := range gscs {
+		scs = append(scs, *gscs[i].Model())
+	}
+	return scs
+}
+
+// ScheduleChange converts the version-independent `s` settings into
+// the serializable settings of the latest supported version, ensures
+// that they satisfy their declared constraints and fall in the
+// acceptable range of values (or may be accepted by the API policy) as
+// indicated by the baseConfs, and stores them as a pending scheduled
+// change which should be applied at the `effectiveAt` time.
+// Secret settings may not be scheduled because they would be stored
+// in plaintext until their effective time, so an error which is marked
+// by cerr.BadRequest is returned if `s` contains secret settings.
+// This generic function allows a unified implementation to be used
+// for both of the connection and transaction receiving methods.
+func ScheduleChange[Q postgres.Queryer](
+	ctx context.Context,
+	q Q,
+	baseConfs *cfg3.Config,
+	s *model.Settings,
+	effectiveAt time.Time,
+) (*model.ScheduledChange, error) {
+	if s.Secrets != nil {
+		return nil, cerr.BadRequest(
+			errors.New("secret settings may not be scheduled"),
+		)
+	}
+	ser := modelToSerializable(s)
+	confs := baseConfs.Clone()
+	if err := enforceAPIPolicy(ctx, confs, confs.Mutate(ser)); err != nil {
+		var consErr *settings.ConstraintsError
+		if errors.As(err, &consErr) {
+			return nil, constraintsBadRequest(consErr)
+		}
+		return nil, fmt.Errorf("confs.Mutate(%#v): %w", ser, err)
+	}
+	b, err := json.Marshal(ser)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling settings: %w", err)
+	}
+	gsc := &gScheduledChange{
+		SCID:        uuid.New(),
+		Config:      b,
+		EffectiveAt: effectiveAt,
+		State:       string(model.ScheduledChangePending),
+	}
+	gdb := q.GORM(ctx).Clauses(clause.Returning{}).Create(gsc)
+	if err := gdb.Error; err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return gsc.Model(), nil
+}
+
+// ScheduledChanges lists all scheduled changes, ordered by their
+// effective time (and their creation time for equal effective times).
+// This generic function allows a unified implementation to be used
+// for both of the connection and transaction receiving methods.
+func ScheduledChanges[Q postgres.Queryer](
+	ctx context.Context, q Q,
+) ([]model.ScheduledChange, error) {
+	var gscs []gScheduledChange
+	gdb := q.GORM(ctx).Order("effective_at, created_at").Find(&gscs)
+	if err := gdb.Error; err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return modelScheduledChanges(gscs), nil
+}
+
+// CancelScheduledChange cancels the `id` pending scheduled change and
+// returns its updated instance. If the `id` change does not exist, an
+// error which is marked by cerr.NotFound is returned and if it is not
+// pending anymore, an error which is marked by cerr.Conflict is returned.
+// This generic function allows a unified implementation to be used
+// for both of the connection and transaction receiving methods.
+func CancelScheduledChange[Q postgres.Queryer](
+	ctx context.Context, q Q, id uuid.UUID,
+) (*model.ScheduledChange, error) {
+	var gscs []gScheduledChange
+	gdb := q.GORM(ctx).Model(&gscs).Clauses(clause.Returning{}).Where(
+		"scid=? AND state=?", id, string(model.ScheduledChangePending),
+	).Updates(map[string]any{
+		"state":       string(model.ScheduledChangeCanceled),
+		"finished_at": gormNow,
+	})
+	if err := gdb.Error; err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	if len(gscs) == 1 {
+		return gscs[0].Model(), nil
+	}
+	gsc := &gScheduledChange{}
+	gdb = q.GORM(ctx).Where("scid=?", id).Limit(1).Find(gsc)
+	switch {
+	case gdb.Error != nil:
+		return nil, fmt.Errorf("query: %w", gdb.Error)
+	case gdb.RowsAffected == 0:
+		return nil, cerr.NotFound(
+			fmt.Errorf("scheduled change %v does not exist", id),
+		)
+	}
+	return nil, cerr.Conflict(
+		fmt.Errorf("scheduled change %v is already %s", id, gsc.State),
+	)
+}
+
+// LockScheduler tries to obtain the transaction-level advisory lock of
+// the scheduler using the `tx` transaction. It does not block and
+// reports false if another transaction (probably, from another instance
+// of the application) holds that lock.
+func LockScheduler(ctx context.Context, tx *postgres.Tx) (bool, error) {
+	rs, err := tx.Query(
+		ctx, "SELECT pg_try_advisory_xact_lock($1)", schedulerLockKey,
+	)
+	if err != nil {
+		return false, fmt.Errorf("querying advisory lock: %w", err)
+	}
+	defer rs.Close()
+	locked := false
+	for rs.Next() {
+		if err := rs.Scan(&locked); err != nil {
+			return false, fmt.Errorf("scanning lock status: %w", err)
+		}
+	}
+	if err := rs.Err(); err != nil {
+		return false, fmt.Errorf("closing result set: %w", err)
+	}
+	return locked, nil
+}
+
+// DueScheduledChanges queries the pending scheduled changes which their
+// effective time (according to the database clock) has arrived, ordered
+// by their effective time, and locks them until the end of the `tx`
+// transaction. Rows which are locked by other transactions are skipped.
+func DueScheduledChanges(
+	ctx context.Context, tx *postgres.Tx,
+) ([]model.ScheduledChange, error) {
+	var gscs []gScheduledChange
+	gdb := tx.GORM(ctx).Clauses(
+		clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"},
+	).Where(
+		"state=? AND effective_at<=now()",
+		string(model.ScheduledChangePending),
+	).Order("effective_at, created_at").Find(&gscs)
+	if err := gdb.Error; err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return modelScheduledChanges(gscs), nil
+}
+
+// FinishScheduledChange records the outcome of applying the `id`
+// scheduled change using the `tx` transaction, moving it to the given
+// final `state` and setting its `outcome` and `rev` revision columns.
+func FinishScheduledChange(
+	ctx context.Context,
+	tx *postgres.Tx,
+	id uuid.UUID,
+	state model.ScheduledChangeState,
+	outcome *string,
+	rev *int64,
+) error {
+	gdb := tx.GORM(ctx).Model(&gScheduledChange{}).Where(
+		"scid=?", id,
+	).Updates(map[string]any{
+		"state":       string(state),
+		"finished_at": gormNow,
+		"outcome":     outcome,
+		"revision":    rev,
+	})
+	if err := gdb.Error; err != nil {
+		return fmt.Errorf("query: %w", err)
+	}
+	if n := gdb.RowsAffected; n != 1 {
+		return fmt.Errorf("expected one row, but got %d", n)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package settingsrp
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelOfUndecodableScheduledChanges(t *testing.T) {
+	gscs := []gScheduledChange{
+		{
+			SCID:   uuid.New(),
+			Config: []byte(`{"version":`),
+			State:  string(model.ScheduledChangePending),
+		},
+		{
+			SCID:   uuid.New(),
+			Config: []byte(`[]`),
+			State:  string(model.ScheduledChangeCanceled),
+		},
+	}
+	scs := modelScheduledChanges(gscs)
+	require.Len(t, scs, 2, "corrupted rows must be listed")
+	pending, canceled := scs[0], scs[1]
+	require.Nil(t, pending.Settings)
+	require.NotNil(t, pending.Outcome, "pending change needs a reason")
+	require.Contains(t, *pending.Outcome, "unmarshalling settings")
+	require.Nil(t, canceled.Settings)
+	require.Nil(t, canceled.Outcome, "finished outcome must be kept")
+}
@@ -7,7 +7,12 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
 
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 	"gorm.io/gorm"
 )
@@ -25,6 +30,11 @@ import (
 // the repository packages (which can depend on frameworks).
 type Tx struct {
 	*gorm.DB
+
+	// conn is the connection which has begun this transaction, so its
+	// underlying pgx connection may be used by the CopyFrom method.
+	// It is nil if the transaction was not begun by a Conn.
+	conn *sql.Conn
 }
 
 // Exec runs SQL statements with given args given ctx context.
@@ -70,6 +80,34 @@ func (tx *Tx) Query(ctx context.Context, sql string, args ...any) (repo.Rows, er
 	return rowsAdapter{rows}, err
 }
 
+// CopyFrom runs the `query` COPY ... FROM STDIN statement in this
+// transaction, streaming the rows from the `r` reader (which must
+// follow the format which is specified by the `query`, e.g., the text
+// format by default), and returns the number of copied rows.
+// The COPY statement is not supported by the database/sql package, so
+// the underlying pgx connection of this transaction is used directly.
+// Therefore, the transaction must be begun by the Conn.Tx method.
+func (tx *Tx) CopyFrom(
+	ctx context.Context, r io.Reader, query string,
+) (n int64, err error) {
+	if tx.conn == nil {
+		return 0, errors.New("connection of the transaction is unknown")
+	}
+	err = tx.conn.Raw(func(driverConn any) error {
+		c, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver conn type %T", driverConn)
+		}
+		tag, err := c.Conn().PgConn().CopyFrom(ctx, r, query)
+		if err != nil {
+			return err
+		}
+		n = tag.RowsAffected()
+		return nil
+	})
+	return n, err
+}
+
 // IsTx method prevents a non-Tx object (such as a Conn) to
 // mistakenly implement the Tx interface.
 func (tx *Tx) IsTx() {
@@ -0,0 +1,35 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package proc presents an implementation of the processes Checker
+// which examines the processes of the current host by sending them
+// the null signal.
+package proc
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Checker implements the github.com/momeni/clean-arch/pkg/core/proc.Checker
+// interface, so the use cases layer may check if a process is running
+// without any dependency on the system calls. Its zero value is ready
+// to be used.
+type Checker struct{}
+
+// IsRunning returns false if the `pid` process is known not to be
+// running. The null signal is sent to that process, so its existence
+// (and the permission to signal it) is checked without affecting it.
+// If it cannot be checked, it is assumed to be running.
+func (Checker) IsRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return !errors.Is(err, os.ErrProcessDone) &&
+		!errors.Is(err, syscall.ESRCH)
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package proc_test
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/momeni/clean-arch/pkg/adapter/proc"
+	coreproc "github.com/momeni/clean-arch/pkg/core/proc"
+)
+
+var _ coreproc.Checker = proc.Checker{}
+
+func TestCheckerIsRunning(t *testing.T) {
+	c := proc.Checker{}
+	if !c.IsRunning(os.Getpid()) {
+		t.Error("current process is not reported as running")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("running a child process: %v", err)
+	}
+	if c.IsRunning(cmd.Process.Pid) {
+		t.Errorf("exited pid %d is reported as running", cmd.Process.Pid)
+	}
+}
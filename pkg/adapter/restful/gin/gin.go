@@ -9,6 +9,7 @@
 package gin
 
 import (
+	"fmt"
 	"log/slog"
 
 	ginlogger "github.com/FabienMht/ginslog/logger"
@@ -28,12 +29,47 @@ type HandlerFunc = gin.HandlerFunc
 type Engine = gin.Engine
 
 // New creates a new gin Engine instance, registering the given
-// middleware functions (if any).
+// middleware functions (if any). Only the 127.0.0.1 address is trusted
+// as a reverse proxy (see NewWithTrustedProxies).
 func New(middlewares ...HandlerFunc) *Engine {
+	e, _ := NewWithTrustedProxies([]string{"127.0.0.1"}, middlewares...)
+	return e
+}
+
+// NewWithTrustedProxies creates a new gin Engine instance, registering
+// the given middleware functions (if any), and trusting the given
+// `proxies` IP addresses or CIDR ranges for computation of the client
+// IP addresses (using the X-Forwarded-For like headers). An empty (or
+// nil) `proxies` slice disables this feature, so no proxy is trusted.
+// An error is returned if a proxy cannot be parsed.
+func NewWithTrustedProxies(
+	proxies []string, middlewares ...HandlerFunc,
+) (*Engine, error) {
 	e := gin.New()
-	e.SetTrustedProxies([]string{"127.0.0.1"})
+	if len(proxies) == 0 {
+		proxies = nil
+	}
+	if err := e.SetTrustedProxies(proxies); err != nil {
+		return nil, fmt.Errorf("setting trusted proxies: %w", err)
+	}
 	e.Use(middlewares...)
-	return e
+	return e, nil
+}
+
+// These constants list the gin-gonic modes which may be passed to the
+// SetMode function.
+const (
+	DebugMode   = gin.DebugMode
+	ReleaseMode = gin.ReleaseMode
+	TestMode    = gin.TestMode
+)
+
+// SetMode changes the gin-gonic mode globally. It must be called before
+// creation of the Engine instances, so they can respect the new mode.
+// The default mode is taken from the GIN_MODE environment variable and
+// is DebugMode if that variable is not set.
+func SetMode(mode string) {
+	gin.SetMode(mode)
 }
 
 // Logger middleware logs incoming requests and their responses
@@ -8,12 +8,14 @@ package gin_test
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -23,14 +25,17 @@ import (
 	"github.com/google/uuid"
 	"github.com/momeni/clean-arch/internal/test/dbcontainer"
 	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
 	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
 	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres"
+	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/sch1v3"
 	"github.com/momeni/clean-arch/pkg/adapter/restful/gin"
 	"github.com/momeni/clean-arch/pkg/adapter/restful/gin/routes"
 	"github.com/momeni/clean-arch/pkg/adapter/restful/gin/settingsrs"
 	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
+	"github.com/momeni/clean-arch/pkg/core/usecase/appuc"
 	"github.com/momeni/clean-arch/pkg/core/usecase/migrationuc"
 	"github.com/stretchr/testify/suite"
 )
@@ -87,18 +92,33 @@ func (igts *IntegrationGinTestSuite) SetupSuite() {
 	minDelay := settings.Duration(1 * time.Second)
 	delay := settings.Duration(2 * time.Second)
 	maxDelay := settings.Duration(10 * time.Second)
-	c := &cfg2.Config{
+	keyFile := filepath.Join(igts.T().TempDir(), "settings.key")
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	err = os.WriteFile(keyFile, []byte(key), 0o600)
+	igts.Require().NoError(err, "failed to write the key file")
+	keyPattern := &settings.Pattern{}
+	err = keyPattern.UnmarshalText([]byte("[A-Za-z-]+"))
+	igts.Require().NoError(err, "failed to compile the key pattern")
+	keyMinLength := 4
+	c := &cfg3.Config{
+		Encryption: cfg2.Encryption{
+			KeyFile: keyFile,
+		},
 		Usecases: cfg2.Usecases{
 			Cars: cfg2.Cars{
 				DelayOfOPM:    &delay,
 				MinDelayOfOPM: &minDelay,
 				MaxDelayOfOPM: &maxDelay,
+				APIKeyOfOPMConstraints: &settings.Constraints[settings.Secret]{
+					Pattern:   keyPattern,
+					MinLength: &keyMinLength,
+				},
 			},
 		},
 		Vers: vers.Config{
 			Versions: vers.Versions{
 				Database: postgres.Version,
-				Config:   cfg2.Version,
+				Config:   cfg3.Version,
 			},
 		},
 	}
@@ -464,6 +484,196 @@ func (igts *IntegrationGinTestSuite) TestSettings() {
 	)
 }
 
+func (igts *IntegrationGinTestSuite) TestScheduledChanges() {
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(
+			method, "/api/caweb/v2/scheduled-settings"+path, r,
+		)
+		igts.Require().NoError(err, "cannot create %s request", method)
+		w := httptest.NewRecorder()
+		igts.Gin.ServeHTTP(w, req)
+		return w
+	}
+	schedule := func(at time.Time, d time.Duration) *model.ScheduledChange {
+		body := fmt.Sprintf(
+			`{"effective_at":%q,"settings":{"parking_method":{"delay":%d}}}`,
+			at.Format(time.RFC3339Nano), d,
+		)
+		w := send(http.MethodPost, "", body)
+		igts.Require().Equal(201, w.Code, "scheduling a change")
+		sc := &model.ScheduledChange{}
+		err := json.Unmarshal(w.Body.Bytes(), sc)
+		igts.Require().NoError(err, "scheduled change is not json")
+		igts.Equal(model.ScheduledChangePending, sc.State)
+		return sc
+	}
+	// 2s is the default delay (see testdata/dev.sql), so applying the
+	// due change does not affect the timing of other tests
+	due := schedule(time.Now().Add(-time.Second), 2*time.Second)
+	later := schedule(time.Now().Add(time.Hour), 3*time.Second)
+
+	w := send(http.MethodPost, "", fmt.Sprintf(
+		`{"effective_at":%q,"settings":{"parking_method":{"delay":%d}}}`,
+		time.Now().Format(time.RFC3339), time.Hour,
+	))
+	igts.Equal(400, w.Code, "scheduling an out of range delay")
+	w = send(http.MethodPost, "", `{"settings":{}}`)
+	igts.Equal(400, w.Code, "scheduling without effective_at")
+
+	w = send(http.MethodDelete, "/"+later.ID.String(), "")
+	igts.Require().Equal(200, w.Code, "canceling a pending change")
+	sc := &model.ScheduledChange{}
+	igts.Require().NoError(json.Unmarshal(w.Body.Bytes(), sc))
+	igts.Equal(model.ScheduledChangeCanceled, sc.State)
+	igts.NotNil(sc.FinishedAt, "missing finished_at")
+	w = send(http.MethodDelete, "/"+later.ID.String(), "")
+	igts.Equal(409, w.Code, "canceling a canceled change")
+	w = send(http.MethodDelete, "/"+uuid.NewString(), "")
+	igts.Equal(404, w.Code, "canceling a missing change")
+	w = send(http.MethodDelete, "/not-a-uuid", "")
+	igts.Equal(400, w.Code, "canceling with an invalid id")
+
+	states := func() map[uuid.UUID]model.ScheduledChange {
+		w := send(http.MethodGet, "", "")
+		igts.Require().Equal(200, w.Code, "listing scheduled changes")
+		var scs []model.ScheduledChange
+		igts.Require().NoError(json.Unmarshal(w.Body.Bytes(), &scs))
+		m := make(map[uuid.UUID]model.ScheduledChange, len(scs))
+		for _, sc := range scs {
+			m[sc.ID] = sc
+		}
+		return m
+	}
+	deadline := time.Now().Add(3 * appuc.DefaultSchedulingInterval)
+	for time.Now().Before(deadline) {
+		if states()[due.ID].State != model.ScheduledChangePending {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	m := states()
+	igts.Equal(model.ScheduledChangeApplied, m[due.ID].State)
+	igts.NotNil(m[due.ID].Revision, "missing applied revision")
+	igts.Nil(m[due.ID].Outcome, "unexpected failure reason")
+	igts.Equal(model.ScheduledChangeCanceled, m[later.ID].State)
+}
+
+func (igts *IntegrationGinTestSuite) TestSecretSettings() {
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, "/api/caweb/v2/"+path, r)
+		igts.Require().NoError(err, "cannot create %s request", method)
+		w := httptest.NewRecorder()
+		igts.Gin.ServeHTTP(w, req)
+		return w
+	}
+	stored := func() string {
+		var cfg []byte
+		err := igts.Pool.Conn(
+			igts.Ctx, func(ctx context.Context, c repo.Conn) (err error) {
+				cfg, _, err = sch1v3.LoadRevisedSettings(
+					ctx, c, model.AppComponent,
+				)
+				return err
+			},
+		)
+		igts.Require().NoError(err, "cannot load settings row")
+		return string(cfg)
+	}
+	const apiKey = "tHe-ApI-kEy"
+	// 2s is the default delay (see testdata/dev.sql), so updating the
+	// settings does not affect the timing of other tests
+	body := func(secrets string) string {
+		return fmt.Sprintf(
+			`{"parking_method":{"delay":%d}%s}`, 2*time.Second, secrets,
+		)
+	}
+
+	w := send(http.MethodPut, "settings", body(fmt.Sprintf(
+		`,"secrets":{"parking_method":{"api_key":%q}}`, apiKey,
+	)))
+	igts.Require().Equal(200, w.Code, "putting a secret setting")
+	igts.NotContains(w.Body.String(), apiKey, "secret is reported")
+	w = send(http.MethodGet, "settings", "")
+	igts.Require().Equal(200, w.Code)
+	igts.NotContains(w.Body.String(), apiKey, "secret is reported")
+	igts.NotContains(w.Body.String(), "secrets", "secrets are reported")
+	cfg := stored()
+	igts.NotContains(cfg, apiKey, "secret is stored in plaintext")
+	igts.Contains(cfg, `"api_key_of_opm":"aes256gcm:`, "missing secret")
+
+	w = send(http.MethodPut, "settings", body(""))
+	igts.Require().Equal(200, w.Code, "putting without secrets")
+	igts.Contains(stored(), `"api_key_of_opm":"aes256gcm:`, "lost secret")
+
+	w = send(http.MethodPost, "scheduled-settings", fmt.Sprintf(
+		`{"effective_at":%q,"settings":%s}`,
+		time.Now().Add(time.Hour).Format(time.RFC3339),
+		body(`,"secrets":{"parking_method":{"api_key":"k"}}`),
+	))
+	igts.Equal(400, w.Code, "scheduling a secret setting")
+
+	w = send(http.MethodPut, "settings", body(
+		`,"secrets":{"parking_method":{"api_key":""}}`,
+	))
+	igts.Require().Equal(200, w.Code, "clearing a secret setting")
+	igts.Contains(stored(), `"api_key_of_opm":null`, "secret not cleared")
+}
+
+func (igts *IntegrationGinTestSuite) TestSettingsRevision() {
+	send := func(method, ifMatch, body string) *httptest.ResponseRecorder {
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, "/api/caweb/v2/settings", r)
+		igts.Require().NoError(err, "cannot create %s request", method)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		igts.Gin.ServeHTTP(w, req)
+		return w
+	}
+	w := send(http.MethodGet, "", "")
+	igts.Require().Equal(200, w.Code)
+	etag := w.Header().Get("ETag")
+	igts.Require().NotEmpty(etag, "missing ETag header")
+	s := &settingsrs.SettingsResp{}
+	err := json.Unmarshal(w.Body.Bytes(), s)
+	igts.Require().NoError(err, "settings is not json")
+	delay := *s.Settings.ParkingMethod.Delay
+
+	w = send(http.MethodPatch, etag, `{"parking_method":{}}`)
+	igts.Require().Equal(200, w.Code, "patching with matching revision")
+	newETag := w.Header().Get("ETag")
+	igts.NotEqual(etag, newETag, "revision was not incremented")
+	s = &settingsrs.SettingsResp{}
+	err = json.Unmarshal(w.Body.Bytes(), s)
+	igts.Require().NoError(err, "patch response is not json")
+	igts.Equal(&delay, s.Settings.ParkingMethod.Delay, "delay changed")
+
+	w = send(http.MethodGet, "", "")
+	igts.Equal(newETag, w.Header().Get("ETag"), "stale ETag header")
+
+	body := fmt.Sprintf(`{"parking_method":{"delay":%d}}`, delay)
+	w = send(http.MethodPut, etag, body)
+	igts.Equal(412, w.Code, "putting with outdated revision")
+	w = send(http.MethodPatch, "W/"+newETag, body)
+	igts.Equal(412, w.Code, "weak ETags may not match")
+	w = send(http.MethodPut, etag+", "+newETag, body)
+	igts.Equal(200, w.Code, "putting with a list of revisions")
+	w = send(http.MethodPut, "*", body)
+	igts.Equal(200, w.Code, "putting unconditionally")
+}
+
 func (igts *IntegrationGinTestSuite) timedParking(
 	d time.Duration,
 ) func() {
@@ -477,3 +687,120 @@ func (igts *IntegrationGinTestSuite) timedParking(
 		igts.Greater(d+e, duration, "too slow parking")
 	}
 }
+
+func (igts *IntegrationGinTestSuite) TestValidateSettings() {
+	fetch := func() *settingsrs.SettingsResp {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(
+			http.MethodGet, "/api/caweb/v2/settings", nil,
+		)
+		igts.Require().NoError(err, "cannot create GET request")
+		igts.Gin.ServeHTTP(w, req)
+		igts.Require().Equal(200, w.Code)
+		s := &settingsrs.SettingsResp{}
+		err = json.Unmarshal(w.Body.Bytes(), s)
+		igts.Require().NoError(err, "settings is not json")
+		return s
+	}
+	before := fetch()
+	for _, tc := range []struct {
+		name       string
+		delay      time.Duration
+		valid      bool
+		validDelay time.Duration
+	}{
+		{"in range delay", 3 * time.Second, true, 3 * time.Second},
+		{"too large delay", 20 * time.Second, false, 10 * time.Second},
+		{"too small delay", 10 * time.Millisecond, false, time.Second},
+	} {
+		igts.Run(tc.name, func() {
+			body := model.Settings{
+				VisibleSettings: model.VisibleSettings{
+					ParkingMethod: model.ParkingMethodSettings{
+						Delay: &tc.delay,
+					},
+				},
+			}
+			b, err := json.Marshal(body)
+			igts.Require().NoError(err, "cannot serialize req body")
+			req, err := http.NewRequest(
+				http.MethodPost,
+				"/api/caweb/v2/settings:validate",
+				bytes.NewReader(b),
+			)
+			igts.Require().NoError(err, "cannot create POST request")
+			w := httptest.NewRecorder()
+			igts.Gin.ServeHTTP(w, req)
+			igts.Require().Equal(200, w.Code)
+			res := &settingsrs.ValidateSettingsResp{}
+			err = json.Unmarshal(w.Body.Bytes(), res)
+			igts.Require().NoError(err, "response is not json")
+			igts.Equal(tc.valid, res.Valid, "wrong validity")
+			if tc.valid {
+				igts.Empty(res.Violations, "unexpected violations")
+			} else if igts.Len(res.Violations, 1, "violations count") {
+				igts.Equal(
+					"parking_method.delay", res.Violations[0].Field,
+				)
+			}
+			igts.Equal(
+				&tc.validDelay, res.Settings.ParkingMethod.Delay,
+				"wrong resulting delay",
+			)
+		})
+	}
+	igts.Run("constraint violations", func() {
+		b := `{"secrets":{"parking_method":{"api_key":"k1"}}}`
+		req, err := http.NewRequest(
+			http.MethodPost,
+			"/api/caweb/v2/settings:validate",
+			strings.NewReader(b),
+		)
+		igts.Require().NoError(err, "cannot create POST request")
+		w := httptest.NewRecorder()
+		igts.Gin.ServeHTTP(w, req)
+		igts.Require().Equal(200, w.Code)
+		res := &settingsrs.ValidateSettingsResp{}
+		err = json.Unmarshal(w.Body.Bytes(), res)
+		igts.Require().NoError(err, "response is not json")
+		igts.False(res.Valid, "wrong validity")
+		if igts.Len(res.Violations, 1, "violations count") {
+			igts.Equal(
+				"secrets.parking_method.api_key",
+				res.Violations[0].Field,
+			)
+			igts.NotContains(res.Violations[0].Message, "k1")
+		}
+
+		req, err = http.NewRequest(
+			http.MethodPut, "/api/caweb/v2/settings", strings.NewReader(b),
+		)
+		igts.Require().NoError(err, "cannot create PUT request")
+		w = httptest.NewRecorder()
+		igts.Gin.ServeHTTP(w, req)
+		igts.Equal(400, w.Code, "updating with violated constraints")
+		igts.Contains(w.Body.String(), "secrets.parking_method.api_key")
+	})
+	igts.Run("rejected out of range delay", func() {
+		req, err := http.NewRequest(
+			http.MethodPut,
+			"/api/caweb/v2/settings",
+			strings.NewReader(fmt.Sprintf(
+				`{"parking_method":{"delay":%d}}`, 20*time.Second,
+			)),
+		)
+		igts.Require().NoError(err, "cannot create PUT request")
+		w := httptest.NewRecorder()
+		igts.Gin.ServeHTTP(w, req)
+		igts.Equal(400, w.Code, "reject is the default api policy")
+		igts.Contains(w.Body.String(), "cars.delay_of_opm")
+	})
+	igts.Equal(before, fetch(), "validation changed settings")
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(
+		http.MethodPost, "/api/caweb/v2/settings:apply", nil,
+	)
+	igts.Require().NoError(err, "cannot create POST request")
+	igts.Gin.ServeHTTP(w, req)
+	igts.Equal(404, w.Code, "unknown settings action")
+}
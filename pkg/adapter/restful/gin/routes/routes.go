@@ -13,12 +13,13 @@ import (
 	"fmt"
 
 	"github.com/gin-gonic/gin"
-	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/carsrp"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/settingsrp"
 	"github.com/momeni/clean-arch/pkg/adapter/restful/gin/carsrs"
 	"github.com/momeni/clean-arch/pkg/adapter/restful/gin/settingsrs"
 	"github.com/momeni/clean-arch/pkg/core/repo"
+	"github.com/momeni/clean-arch/pkg/core/usecase/appuc"
 )
 
 // Register instantiates relevant repositories and use cases based on
@@ -35,8 +36,11 @@ import (
 // Possible errors will be returned after possible wrapping.
 // Actual instantiation of use case objects are delegated to the
 // c Config instance and the appuc use case.
+// The scheduler of the appuc use case is also started in a goroutine,
+// applying the due scheduled settings changes, until the ctx context
+// is canceled.
 func Register(
-	ctx context.Context, e *gin.Engine, p repo.Pool, c *cfg2.Config,
+	ctx context.Context, e *gin.Engine, p repo.Pool, c *cfg3.Config,
 ) error {
 	settingsRepo := settingsrp.New(c)
 	carsRepo := carsrp.New()
@@ -49,6 +53,7 @@ func Register(
 	if err != nil {
 		return fmt.Errorf("reloading use cases based on DB: %w", err)
 	}
+	go appUseCase.RunScheduler(ctx, appuc.DefaultSchedulingInterval)
 	r1 := e.Group("/api/caweb/v1")
 	r2 := e.Group("/api/caweb/v2")
 	settingsrs.Register(r1, r2, appUseCase)
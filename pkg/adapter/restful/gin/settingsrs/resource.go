@@ -9,10 +9,12 @@
 package settingsrs
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/momeni/clean-arch/pkg/adapter/restful/gin/serdser"
+	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/usecase/appuc"
 )
 
@@ -26,15 +28,114 @@ type resource struct {
 //     in order to update the mutable settings and reload the caweb.
 //  2. GET request to /api/caweb/(v1|v2)/settings
 //     in order to fetch the current visible settings.
+//  3. PATCH request to /api/caweb/(v1|v2)/settings
+//     in order to update a subset of the mutable settings (keeping the
+//     omitted settings unchanged) and reload the caweb.
+//  4. POST request to /api/caweb/v2/settings:validate
+//     in order to check a settings update request without applying it.
+//  5. POST request to /api/caweb/v2/scheduled-settings
+//     in order to schedule a settings change for a future time.
+//  6. GET request to /api/caweb/v2/scheduled-settings
+//     in order to list the pending and finished scheduled changes.
+//  7. DELETE request to /api/caweb/v2/scheduled-settings/:id
+//     in order to cancel a pending scheduled change.
 //
 // The v1 endpoints only deal with mutable settings themselves.
 // The v2 endpoints also support the boundary values reporting.
+//
+// The GET, PUT, and PATCH endpoints report the settings revision in
+// the ETag header. The PUT and PATCH endpoints accept an optional
+// If-Match header and respond with 412 (Precondition Failed) if the
+// settings revision does not match it anymore, so concurrent updates
+// (possibly, by other caweb instances) may not be overwritten silently.
+//
+// The gin router does not support a colon in the middle of a static
+// path segment, so the custom methods of the settings resource (e.g.,
+// the :validate suffix) are registered as a "settings:action" path
+// which captures the ":validate" suffix as its action parameter and
+// dispatches it to the relevant handler.
 func Register(r1, r2 *gin.RouterGroup, app *appuc.UseCase) {
 	rs := &resource{app: app}
 	r1.PUT("settings", rs.UpdateSettingsV1)
 	r1.GET("settings", rs.FetchSettingsV1)
+	r1.PATCH("settings", rs.PatchSettingsV1)
 	r2.PUT("settings", rs.UpdateSettingsV2)
 	r2.GET("settings", rs.FetchSettingsV2)
+	r2.PATCH("settings", rs.PatchSettingsV2)
+	r2.POST("settings:action", rs.SettingsActionV2)
+	r2.POST("scheduled-settings", rs.ScheduleSettings)
+	r2.GET("scheduled-settings", rs.ScheduledChanges)
+	r2.DELETE("scheduled-settings/:id", rs.CancelScheduledChange)
+}
+
+func (rs *resource) ScheduleSettings(c *gin.Context) {
+	req, ok := rs.DserScheduleSettingsReq(c)
+	if !ok {
+		return
+	}
+	sc, err := rs.app.ScheduleSettings(c, &req.Settings, *req.EffectiveAt)
+	if err != nil {
+		serdser.SerErr(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, sc)
+}
+
+func (rs *resource) ScheduledChanges(c *gin.Context) {
+	scs, err := rs.app.ScheduledChanges(c)
+	if err != nil {
+		serdser.SerErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, scs)
+}
+
+func (rs *resource) CancelScheduledChange(c *gin.Context) {
+	id, ok := rs.DserScheduledChangeID(c)
+	if !ok {
+		return
+	}
+	sc, err := rs.app.CancelScheduledChange(c, id)
+	if err != nil {
+		serdser.SerErr(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sc)
+}
+
+func (rs *resource) SettingsActionV2(c *gin.Context) {
+	switch action := c.Param("action"); action {
+	case ":validate":
+		rs.ValidateSettings(c)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{
+			"detail": fmt.Sprintf("unknown settings action: %q", action),
+		})
+	}
+}
+
+func (rs *resource) ValidateSettings(c *gin.Context) {
+	req, ok := rs.DserUpdateSettingsReq(c)
+	if !ok {
+		return
+	}
+	vs, minb, maxb, violations, err := rs.app.ValidateSettings(c, req)
+	if err != nil {
+		serdser.SerErr(c, err)
+		return
+	}
+	if violations == nil {
+		violations = []model.SettingsViolation{}
+	}
+	c.JSON(http.StatusOK, ValidateSettingsResp{
+		Valid:      len(violations) == 0,
+		Violations: violations,
+		SettingsResp: SettingsResp{
+			Settings:  vs,
+			MinBounds: minb,
+			MaxBounds: maxb,
+		},
+	})
 }
 
 func (rs *resource) UpdateSettingsV1(c *gin.Context) {
@@ -46,15 +147,48 @@ func (rs *resource) UpdateSettingsV2(c *gin.Context) {
 }
 
 func (rs *resource) UpdateSettings(c *gin.Context, full bool) {
+	_, _, _, rev := rs.app.Settings()
+	expected, ok := rs.DserIfMatch(c, rev)
+	if !ok {
+		return
+	}
 	req, ok := rs.DserUpdateSettingsReq(c)
 	if !ok {
 		return
 	}
-	vs, minb, maxb, err := rs.app.UpdateSettings(c, req)
+	rs.updateSettings(c, req, expected, full)
+}
+
+func (rs *resource) PatchSettingsV1(c *gin.Context) {
+	rs.PatchSettings(c, false)
+}
+
+func (rs *resource) PatchSettingsV2(c *gin.Context) {
+	rs.PatchSettings(c, true)
+}
+
+func (rs *resource) PatchSettings(c *gin.Context, full bool) {
+	vs, _, _, rev := rs.app.Settings()
+	expected, ok := rs.DserIfMatch(c, rev)
+	if !ok {
+		return
+	}
+	req, ok := rs.DserPatchSettingsReq(c, vs)
+	if !ok {
+		return
+	}
+	rs.updateSettings(c, req, expected, full)
+}
+
+func (rs *resource) updateSettings(
+	c *gin.Context, req *model.Settings, expected *int64, full bool,
+) {
+	vs, minb, maxb, rev, err := rs.app.UpdateSettings(c, req, expected)
 	if err != nil {
 		serdser.SerErr(c, err)
 		return
 	}
+	SerETag(c, rev)
 	if !full {
 		c.JSON(http.StatusOK, vs)
 		return
@@ -75,7 +209,8 @@ func (rs *resource) FetchSettingsV2(c *gin.Context) {
 }
 
 func (rs *resource) FetchSettings(c *gin.Context, full bool) {
-	vs, minb, maxb := rs.app.Settings()
+	vs, minb, maxb, rev := rs.app.Settings()
+	SerETag(c, rev)
 	if !full {
 		c.JSON(http.StatusOK, vs)
 		return
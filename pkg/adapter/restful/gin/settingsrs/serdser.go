@@ -6,8 +6,15 @@
 package settingsrs
 
 import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
 	"github.com/momeni/clean-arch/pkg/adapter/restful/gin/serdser"
 	"github.com/momeni/clean-arch/pkg/core/model"
 )
@@ -22,6 +29,104 @@ func (rs *resource) DserUpdateSettingsReq(
 	return req, true
 }
 
+// ScheduleSettingsReq contains the fields of a settings change
+// scheduling request, namely, the effective_at time which is formatted
+// according to RFC 3339 and the settings field which follows the same
+// format as a settings update request body.
+type ScheduleSettingsReq struct {
+	EffectiveAt *time.Time     `json:"effective_at" binding:"required"`
+	Settings    model.Settings `json:"settings"`
+}
+
+func (rs *resource) DserScheduleSettingsReq(
+	c *gin.Context,
+) (*ScheduleSettingsReq, bool) {
+	req := &ScheduleSettingsReq{}
+	if ok := serdser.Bind(c, req, binding.JSON); !ok {
+		return nil, false
+	}
+	return req, true
+}
+
+func (rs *resource) DserScheduledChangeID(
+	c *gin.Context,
+) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		var errs map[string][]string
+		serdser.AddErr(&errs, "id", "Path param id is not UUID.")
+		c.JSON(http.StatusBadRequest, errs)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// DserPatchSettingsReq deserializes a partial settings update request
+// by decoding its JSON body on top of a copy of the `vs` visible
+// settings, so omitted settings keep their current values. The
+// immutable settings are excluded from that copy, hence, a request
+// which tries to modify them will be rejected just like PUT requests.
+func (rs *resource) DserPatchSettingsReq(
+	c *gin.Context, vs *model.VisibleSettings,
+) (*model.Settings, bool) {
+	req := &model.Settings{}
+	if d := vs.ParkingMethod.Delay; d != nil {
+		t := *d
+		req.ParkingMethod.Delay = &t
+	}
+	if ok := serdser.Bind(c, req, binding.JSON); !ok {
+		return nil, false
+	}
+	return req, true
+}
+
+// DserIfMatch parses the If-Match header of the request, if any, and
+// returns the settings revision which is expected by the client. When
+// the header is missing or is equal to "*", a nil revision is returned
+// which indicates an unconditional update. The ETag values which are
+// produced by SerETag (i.e., quoted decimal revisions) are recognized
+// and if one of the listed ETags matches the `current` revision, it is
+// returned. Otherwise, the first recognized ETag is returned, so the
+// update will fail with a revision mismatch. If no ETag could be
+// recognized (e.g., all of them were weak ETags which never match an
+// If-Match header), a 412 response will be sent and false is returned.
+func (rs *resource) DserIfMatch(
+	c *gin.Context, current int64,
+) (expected *int64, ok bool) {
+	h := strings.TrimSpace(c.GetHeader("If-Match"))
+	if h == "" || h == "*" {
+		return nil, true
+	}
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimSpace(tag)
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		rev, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if rev == current {
+			return &rev, true
+		}
+		if expected == nil {
+			expected = &rev
+		}
+	}
+	if expected == nil {
+		c.JSON(http.StatusPreconditionFailed, gin.H{
+			"detail": fmt.Sprintf("unrecognized If-Match header: %q", h),
+		})
+		return nil, false
+	}
+	return expected, true
+}
+
+// SerETag reports the `rev` settings revision as a strong ETag header.
+func SerETag(c *gin.Context, rev int64) {
+	c.Header("ETag", strconv.Quote(strconv.FormatInt(rev, 10)))
+}
+
 // SettingsResp publishes three fields in order to be serialized as
 // JSON fields and reported to the frontend as follows:
 //  1. The settings field for reporting of visible settings which may
@@ -37,3 +142,17 @@ type SettingsResp struct {
 	MinBounds *model.Settings        `json:"min_bounds"`
 	MaxBounds *model.Settings        `json:"max_bounds"`
 }
+
+// ValidateSettingsResp reports the outcome of a settings validation
+// request. It embeds the SettingsResp struct in order to report the
+// settings and their boundary values as they would be published if
+// the validated settings were applied, in addition to two fields:
+//  1. The valid field which is true if and only if no violation was
+//     detected and so settings could be applied as-is,
+//  2. The violations field listing the detected violations, each one
+//     having a field path (in the settings field format) and message.
+type ValidateSettingsResp struct {
+	Valid      bool                      `json:"valid"`
+	Violations []model.SettingsViolation `json:"violations"`
+	SettingsResp
+}
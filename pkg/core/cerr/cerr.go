@@ -68,3 +68,10 @@ func NotFound(err error) *Error {
 func Conflict(err error) *Error {
 	return &Error{Err: err, HTTPStatusCode: http.StatusConflict}
 }
+
+// PreconditionFailed wraps the err error and marks it as a failed
+// precondition, that is, the requested operation was conditioned on
+// some state (e.g., a revision number) which did not hold anymore.
+func PreconditionFailed(err error) *Error {
+	return &Error{Err: err, HTTPStatusCode: http.StatusPreconditionFailed}
+}
@@ -23,3 +23,8 @@ func Err(key string, value error) slog.Attr {
 	}
 	return slog.String(key, value.Error())
 }
+
+// String returns an Attr for the given string value.
+func String(key, value string) slog.Attr {
+	return slog.String(key, value)
+}
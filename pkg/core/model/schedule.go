@@ -0,0 +1,70 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ScheduledChangeState indicates the lifecycle state of a scheduled
+// settings change. A change is created in the ScheduledChangePending
+// state and moves to one of the other (final) states exactly once.
+type ScheduledChangeState string
+
+// These constants list the acceptable ScheduledChangeState values.
+const (
+	// ScheduledChangePending is the state of a change which is waiting
+	// for its effective time, so it may be applied or canceled later.
+	ScheduledChangePending ScheduledChangeState = "pending"
+
+	// ScheduledChangeApplied is the state of a change which is applied
+	// successfully by the scheduler.
+	ScheduledChangeApplied ScheduledChangeState = "applied"
+
+	// ScheduledChangeFailed is the state of a change which could not be
+	// applied by the scheduler (e.g., because its settings were out of
+	// their acceptable range of values when it became effective).
+	ScheduledChangeFailed ScheduledChangeState = "failed"
+
+	// ScheduledChangeCanceled is the state of a change which is canceled
+	// by end-users before its effective time.
+	ScheduledChangeCanceled ScheduledChangeState = "canceled"
+)
+
+// ScheduledChange represents a settings change which should be applied
+// at (or as soon as possible after) its EffectiveAt time, allowing the
+// mutable settings to be updated without an operator staying up.
+// The outcome of applying (or canceling) the change is recorded in it
+// too, so end-users may find out what happened afterwards.
+type ScheduledChange struct {
+	// ID uniquely identifies this scheduled change.
+	ID uuid.UUID `json:"id"`
+
+	// Settings are the mutable settings which should be applied.
+	Settings *Settings `json:"settings"`
+
+	// EffectiveAt is the time that Settings should be applied.
+	EffectiveAt time.Time `json:"effective_at"`
+
+	// CreatedAt is the time that this change was scheduled.
+	CreatedAt time.Time `json:"created_at"`
+
+	// State is the current lifecycle state of this change.
+	State ScheduledChangeState `json:"state"`
+
+	// FinishedAt is the time that this change left the pending state,
+	// or nil if it is still pending.
+	FinishedAt *time.Time `json:"finished_at"`
+
+	// Outcome describes why this change has failed, if it has failed.
+	Outcome *string `json:"outcome"`
+
+	// Revision is the settings revision which was produced by applying
+	// this change, or nil if it is not applied.
+	Revision *int64 `json:"revision"`
+}
@@ -30,10 +30,42 @@ import "time"
 // accept nil as an unrestricted boundary. A non-pointer type is only
 // justified if a setting and its minimum/maximum boundary values are
 // always required.
+//
+// The Secrets pointer is nil when Settings represents the boundary
+// values or when settings are reported to end-users, so the secret
+// settings are never disclosed. When settings are taken from end-users,
+// a nil Secrets (or a nil field in it) asks to keep the current secret
+// settings unchanged.
 type Settings struct {
+	// Secrets contains the mutable & invisible (write-only) settings.
+	// It precedes the embedded VisibleSettings struct because the
+	// go-json encoder fails to omit a nil field which follows the
+	// embedded ImmutableSettings pointer.
+	Secrets *SecretSettings `json:"secrets,omitempty"`
+
 	VisibleSettings
 }
 
+// SecretSettings contains settings which are mutable & invisible, that
+// is, write-only settings. They may be set by end-users, but they are
+// never reported back and are stored in an encrypted form.
+//
+// Since secret settings may not be queried, end-users cannot send
+// their older values in order to keep them unchanged. Therefore, and
+// in contrast to other settings, a nil field asks to keep the current
+// value of that secret setting, while an empty string asks to clear it.
+type SecretSettings struct {
+	// ParkingMethod contains the old parking method secret settings.
+	ParkingMethod SecretParkingMethodSettings `json:"parking_method"`
+}
+
+// SecretParkingMethodSettings represents the old parking method related
+// secret settings.
+type SecretParkingMethodSettings struct {
+	// APIKey represents the old parking method API key.
+	APIKey *string `json:"api_key"`
+}
+
 // VisibleSettings contains settings which are visible by end-users.
 // These settings may be mutable or immutable. The immutable & visible
 // settings are managed by the embedded ImmutableSettings struct.
@@ -82,3 +114,49 @@ type ImmutableSettings struct {
 	// boundary values.
 	Logger *bool `json:"logger"`
 }
+
+// SettingsViolation describes why a setting value is not acceptable.
+// It is used in order to report the outcome of a settings validation
+// request, without updating the settings, so end-users may find out if
+// a set of settings can be applied as-is before asking to update them.
+type SettingsViolation struct {
+	// Field is the path of the violating setting in the Settings
+	// struct, having the json names of nested fields separated by a
+	// dot character, e.g., "parking_method.delay".
+	Field string `json:"field"`
+
+	// Message is a human-readable description of the violation.
+	Message string `json:"message"`
+}
+
+// Component identifies an independently configured subsystem which
+// keeps its mutable settings (and their boundary values) in a distinct
+// row of the settings repository. Each component serializes its own
+// settings with its own format version, so adding a new component or
+// changing the format of one component does not require rewriting the
+// settings of other components.
+//
+// The set of components which are known by each configuration format
+// version is reported by its registry (see the Components method of
+// the pkg/core/usecase/migrationuc.Settings interface) and rows which
+// belong to other (e.g., newer or retired) components are carried
+// untouched by the migration use cases.
+type Component string
+
+// AppComponent is the component which holds the application-wide
+// mutable settings, including the settings of those use cases which
+// are managed by the application use case and have not been given a
+// separate component yet. Its row exists since the first database
+// schema version, so its name must be kept unchanged.
+const AppComponent Component = "caweb"
+
+// ByteSize is a number of bytes which is used by the size settings.
+// It is represented as a plain json number (of bytes) in order to be
+// independent of the adapters layer textual representations, such as
+// 10MiB, which are managed by the settings.ByteSize type.
+type ByteSize int64
+
+// Percentage is a ratio which is expressed in percents, so 12.5 means
+// 12.5%. It is represented as a plain json number and is converted to
+// and from the settings.Percentage type by the repository packages.
+type Percentage float64
@@ -0,0 +1,23 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package proc exports the expected interfaces for examining the
+// operating system processes. For the corresponding implementation,
+// check the adapter layer.
+//
+// See the Checker interface for the expected features. This interface
+// is used by the migrationuc package in order to detect the stale lock
+// files which were left by crashed processes, so the use cases do not
+// depend on the operating system specific system calls.
+package proc
+
+// Checker examines the processes of the current host.
+type Checker interface {
+	// IsRunning returns false if the `pid` process is known not to be
+	// running on the current host. If it cannot be checked (e.g., due
+	// to the lack of permissions), true is returned, so a running
+	// process may not be mistaken for a crashed one.
+	IsRunning(pid int) bool
+}
@@ -156,6 +156,19 @@ type Migrator[S any] interface {
 	DownMigrator(ctx context.Context) (DownMigrator[S], error)
 }
 
+// Prober interface may be implemented by a Migrator[S] instance which
+// can load its source version resource without any side effects. For
+// example, loading the source configuration settings may need to
+// connect to the source database, and it should not accept a temporary
+// password (and move its file) if the loading is performed in order to
+// plan a migration alone.
+type Prober interface {
+	// Probe is similar to the Load method of Migrator[S] interface,
+	// but it creates, changes, or removes no file or database object.
+	// Either Load or Probe method should be called (not both of them).
+	Probe(ctx context.Context) error
+}
+
 // UpMigrator of S interface specifies the upwards migrator objects
 // requirements for a resource with S settler type. It embeds the
 // Settler[S] interface and has one main method, the MigrateUp method.
@@ -5,7 +5,14 @@
 
 package repo
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/momeni/clean-arch/pkg/core/model"
+)
 
 // SchemaSettler interface specifies the expectations from the settler
 // objects for a database schema migration operation.
@@ -35,6 +42,10 @@ type SchemaSettler interface {
 	// independently migrated mutable settings in the database.
 	SettingsPersister
 
+	// JournalAppender interface indicates that each SchemaSettler can
+	// record the migration in the journal of the settled schema.
+	JournalAppender
+
 	// SettleSchema settles the database schema migration operation.
 	//
 	// If the migration operation was implemented by creating a set of
@@ -47,6 +58,56 @@ type SchemaSettler interface {
 	// transaction (not the SchemaSettler interface).
 	SettleSchema(ctx context.Context) error
 
+	// VerifySchema compares the settled tables with the views (or
+	// tables) which were used for filling them by the SettleSchema
+	// method, reporting the number of rows and an order-independent
+	// hash of the rows contents of both sides for each table.
+	// It must be called after SettleSchema (or CommitStagedSchema) and
+	// before any other change in the settled tables (e.g., persisting
+	// the mutable settings), otherwise, those changes will be reported
+	// as mismatches.
+	// A non-nil error indicates that the comparison could not be
+	// performed, while mismatching tables are only reported by the
+	// returned TableVerification items (see TableVerification.Matched).
+	VerifySchema(ctx context.Context) ([]TableVerification, error)
+
+	// StageSchema is an alternative to the SettleSchema method which
+	// creates the expected database tables (without filling them) in
+	// the staging schema, e.g., stgN for the cawebN schema, so they can
+	// be filled by the SettleTableBatch method in distinct transactions
+	// (possibly in parallel), without exposing a partially filled table
+	// in the settled schema. Caller is responsible to commit the current
+	// transaction, so other transactions may see the staged tables.
+	StageSchema(ctx context.Context) error
+
+	// SettledTables returns the names of the tables which are filled
+	// by the SettleSchema or SettleTableBatch methods.
+	SettledTables() []string
+
+	// SettleTableBatch copies at most `limit` rows of the `table` view
+	// (or table) into its corresponding staged table (as created by the
+	// StageSchema method). Rows are copied in the order of the primary
+	// key of that table, starting after the `after` key (or from the
+	// first row if `after` is nil), so a large table can be copied by
+	// a series of batches, each one in its own transaction, and each
+	// batch reads its rows by a key range scan (instead of skipping the
+	// previously copied rows).
+	// The number of copied rows and the key of the last copied row are
+	// returned, so the last key can be passed to the next call. Keys
+	// are represented as text and the returned key is nil if no row
+	// was copied. Copying fewer than `limit` rows indicates that the
+	// whole table is copied.
+	SettleTableBatch(
+		ctx context.Context, table string, after *string, limit int,
+	) (n int64, last *string, err error)
+
+	// CommitStagedSchema moves the staged tables (as filled by the
+	// SettleTableBatch method) into the settled schema, e.g., cawebN.
+	// Moving tables only updates the catalog, so it is fast and when
+	// the current transaction commits, all tables become visible in the
+	// settled schema atomically.
+	CommitStagedSchema(ctx context.Context) error
+
 	// MajorVersion returns the major semantic version of this schema
 	// settler instance. Each schema settler supports exactly one major
 	// version which is also included in its corresponding schema name.
@@ -55,6 +116,148 @@ type SchemaSettler interface {
 	MajorVersion() uint
 }
 
+// TableVerification describes the comparison of a settled table with
+// its source view (or table) of a database schema migration, as reported
+// by the SchemaSettler.VerifySchema method. Hashes are computed over the
+// text representation of rows, independent of their order, so they
+// match if and only if both sides have the same rows (with a negligible
+// probability of hash collisions).
+type TableVerification struct {
+	// Table is the name of the settled table (without schema name).
+	Table string `json:"table"`
+
+	// SourceRows and SettledRows are the number of rows of the source
+	// view and the settled table respectively.
+	SourceRows  int64 `json:"source_rows"`
+	SettledRows int64 `json:"settled_rows"`
+
+	// SourceHash and SettledHash are the hex encoded hashes of the
+	// rows of the source view and the settled table respectively.
+	SourceHash  string `json:"source_hash"`
+	SettledHash string `json:"settled_hash"`
+}
+
+// Matched returns true if the source view and the settled table have
+// the same number of rows and the same rows contents hash.
+func (tv TableVerification) Matched() bool {
+	return tv.SourceRows == tv.SettledRows &&
+		tv.SourceHash == tv.SettledHash
+}
+
+// LoadMode specifies how the source database tables are made accessible
+// in the destination database during the loading phase of a database
+// schema migration, see the Migrator.Load method.
+type LoadMode string
+
+// These constants list the supported LoadMode values.
+const (
+	// FDWLoad imports the source schema as foreign tables, using the
+	// postgres_fdw extension, so the destination database server must
+	// be able to connect to the source database server. Data items are
+	// transferred by the destination server when they are queried.
+	FDWLoad LoadMode = "fdw"
+
+	// CopyLoad creates real tables in the destination database and
+	// fills them by streaming the source tables contents through the
+	// migrating process using the COPY command. It does not need the
+	// postgres_fdw extension, nor a network path from the destination
+	// server to the source server.
+	CopyLoad LoadMode = "copy"
+
+	// AutoLoad tries the FDWLoad mode and falls back to the CopyLoad
+	// mode if the foreign tables could not be imported (e.g., because
+	// the postgres_fdw extension is not available).
+	AutoLoad LoadMode = "auto"
+)
+
+// LoadOptions specifies how the source database tables are loaded in
+// the destination database during a database schema migration.
+type LoadOptions struct {
+	// Mode selects the loader, see the LoadMode constants.
+	Mode LoadMode
+
+	// FetchSize is the number of rows which are fetched by each round
+	// trip of the postgres_fdw foreign tables (when Mode uses them),
+	// as set by the fetch_size option of the foreign server. Zero keeps
+	// the postgres_fdw default value (i.e., 100 rows).
+	FetchSize int
+}
+
+// ErrJournalNotFound indicates that the schema migrations journal table
+// does not exist, e.g., because the database was initialized before the
+// introduction of the journal and has not been migrated since then.
+var ErrJournalNotFound = errors.New("schema migrations journal not found")
+
+// JournalStep describes the duration of one step of an initialization
+// or migration operation, as recorded in a JournalEntry.
+type JournalStep struct {
+	// Name is the step name, e.g., load or settle.
+	Name string `json:"name"`
+
+	// Seconds is the step duration in seconds.
+	Seconds float64 `json:"seconds"`
+}
+
+// JournalEntry describes one initialization or migration operation,
+// as recorded in the schema migrations journal of a database schema.
+type JournalEntry struct {
+	// Operation is the recorded operation, e.g., init-dev, init-prod,
+	// or migrate.
+	Operation string
+
+	// SrcConfigVersion and SrcSchemaVersion are the configuration file
+	// and the database schema versions of the source of a migration.
+	// They are nil for initialization operations.
+	SrcConfigVersion *model.SemVer
+	SrcSchemaVersion *model.SemVer
+
+	// DstConfigVersion and DstSchemaVersion are the configuration file
+	// and the database schema versions of the initialized or migrated
+	// destination.
+	DstConfigVersion model.SemVer
+	DstSchemaVersion model.SemVer
+
+	// Steps lists the durations of the performed steps in order.
+	Steps []JournalStep
+
+	// OSUser is the name of the operating system user which has run
+	// the operation.
+	OSUser string
+
+	// StartedAt and FinishedAt are the operation start and end times.
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// Error is the error message of a failed operation, or an empty
+	// string if the operation has succeeded.
+	Error string
+}
+
+// JournalAppender interface specifies how an initialization or migration
+// operation may be recorded in the schema migrations journal, which is
+// kept in a table of the database schema (and is carried across later
+// migrations). Each instance of this interface shall embed the relevant
+// database connection or transaction, so appended entries are persisted
+// alongside the initialized or migrated tables.
+type JournalAppender interface {
+	// AppendJournal appends the `e` entry to the journal. If the journal
+	// table does not exist, an error wrapping the ErrJournalNotFound
+	// will be returned.
+	AppendJournal(ctx context.Context, e *JournalEntry) error
+}
+
+// ErrSettingsNotFound indicates that no serialized settings row could
+// be found for an asked model.Component. It can be wrapped by the
+// settings loading functions, so callers may distinguish a component
+// which has never persisted its settings (e.g., because it is newer
+// than the database contents) from other querying errors.
+var ErrSettingsNotFound = errors.New("settings row not found")
+
+// ErrSettingsRevisionMismatch indicates that a settings row could not
+// be updated because its revision was changed since it was fetched,
+// so the update request may be based on outdated settings.
+var ErrSettingsRevisionMismatch = errors.New("settings revision mismatch")
+
 // SettingsPersister interface specifies that how mutable settings may
 // be persisted in a database, after being serialized as a byte slice.
 // Each instance of this interface shall embed the relevant database
@@ -80,9 +283,19 @@ type SettingsPersister interface {
 	// persistence applies whenever the caller commits its transaction.
 	// The minimum and maximum boundary values, i.e., minb and maxb,
 	// which are serialized with the same configuration format will be
-	// persisted alongside the mutableSettings too.
+	// persisted alongside the mutableSettings too. Similarly, the
+	// serialized constraints of those settings (if any) are persisted
+	// as `cons`, while a nil `cons` indicates that no constraint is
+	// declared by that configuration format.
+	//
+	// Each model.Component keeps its settings in a distinct row, so
+	// the `c` argument selects the row which should be updated (or
+	// created if it did not exist yet). Rows of other components are
+	// kept unchanged.
 	PersistSettings(
-		ctx context.Context, mutableSettings, minb, maxb []byte,
+		ctx context.Context,
+		c model.Component,
+		mutableSettings, minb, maxb, cons []byte,
 	) error
 }
 
@@ -101,6 +314,10 @@ type SchemaInitializer interface {
 	// the independently serialized mutable settings in the database.
 	SettingsPersister
 
+	// JournalAppender interface indicates that SchemaInitializer can
+	// record the initialization in the journal of the created schema.
+	JournalAppender
+
 	// InitDevSchema creates tables in an existing database schema
 	// and fills them with the development suitable initial data.
 	// The database connection, target schema name, tables format, and
@@ -161,6 +378,59 @@ type SchemaConnQueryer interface {
 	//
 	// Caller is responsible to pass a trusted serverName string.
 	DropServerIfExists(ctx context.Context, serverName string) error
+
+	// TryLock tries to acquire the `name` advisory lock of the database
+	// without waiting. The lock is held by the current connection until
+	// it is released by the Unlock method (or the connection is closed)
+	// and is not affected by the commitment or rollback of transactions.
+	// If another connection holds the lock, false will be returned.
+	// Locks of distinct databases are independent, so each database
+	// may be used by one lock holder at a time.
+	TryLock(ctx context.Context, name string) (bool, error)
+
+	// Unlock releases the `name` advisory lock which was acquired by
+	// the TryLock method using this connection.
+	Unlock(ctx context.Context, name string) error
+
+	// LockHolders lists the sessions which hold the `name` advisory
+	// lock of the database, so a failed TryLock may be reported with
+	// the holder details. The list may be empty if the lock is released
+	// concurrently (or the holder session is not visible).
+	LockHolders(ctx context.Context, name string) ([]LockHolder, error)
+}
+
+// LockHolder describes a process which holds a lock, such as a database
+// session which holds an advisory lock or a process which has created
+// a lock file.
+type LockHolder struct {
+	// PID is the process ID of the holder. For database sessions, it is
+	// the PID of the database server backend process.
+	PID int `json:"pid"`
+
+	// User is the name of the database role or the operating system
+	// user which holds the lock.
+	User string `json:"user"`
+
+	// Host is the client address of a database session or the host
+	// name of a lock file creator.
+	Host string `json:"host"`
+
+	// Application is the application name of a database session or the
+	// executable path of a lock file creator.
+	Application string `json:"application,omitempty"`
+
+	// Since is the time of starting the holder session or process.
+	Since time.Time `json:"since"`
+}
+
+// String formats the `lh` holder as a human readable text, so it can be
+// included in the lock acquisition errors.
+func (lh LockHolder) String() string {
+	s := fmt.Sprintf("pid %d of %s@%s", lh.PID, lh.User, lh.Host)
+	if lh.Application != "" {
+		s += fmt.Sprintf(" (%s)", lh.Application)
+	}
+	return s + " since " + lh.Since.Format(time.RFC3339)
 }
 
 // SchemaTxQueryer interface lists all operations which may be taken
@@ -256,4 +526,45 @@ type SchemaQueryer interface {
 	// The `role` role name may be suffixed automatically based on
 	// this schema queryer settings.
 	GrantFDWUsage(ctx context.Context, role Role) error
+
+	// ListSchemas lists the names of all schema of the database in
+	// their ascending order, excluding the system schema (i.e., the
+	// information_schema and those schema which start with "pg_").
+	ListSchemas(ctx context.Context) ([]string, error)
+
+	// ListServers lists the names of all foreign servers of the
+	// database in their ascending order.
+	ListServers(ctx context.Context) ([]string, error)
+
+	// ListRoles lists the database roles which their names end with
+	// the role name suffix of this schema queryer (or all non-system
+	// roles if there is no suffix), in their ascending order.
+	// The returned role names do not include that suffix, so they may
+	// be compared with the AdminRole and NormalRole constants.
+	ListRoles(ctx context.Context) ([]Role, error)
+
+	// DetectSchemaVersion examines the tables and columns of the
+	// `schema` schema and returns the database schema version which
+	// they conform with. The `major` version is not detected and must
+	// be known from the schema name (e.g., cawebN for version N).
+	// An error is returned if the `schema` has none of the expected
+	// tables (e.g., because it is empty) or `major` is not supported.
+	// Since the patch version does not change tables, the latest known
+	// patch version of the detected minor version is reported.
+	//
+	// Caller is responsible to pass a trusted schema name string.
+	DetectSchemaVersion(
+		ctx context.Context, schema string, major uint,
+	) (model.SemVer, error)
+
+	// SettingsVersion returns the format version of the mutable
+	// settings which are stored (by the `comp` component) in the
+	// settings table of the `schema` schema. If the `comp` component
+	// has no settings row, an error wrapping the ErrSettingsNotFound
+	// will be returned.
+	//
+	// Caller is responsible to pass a trusted schema name string.
+	SettingsVersion(
+		ctx context.Context, schema string, comp model.Component,
+	) (model.SemVer, error)
 }
@@ -51,6 +51,7 @@ type UseCase struct {
 
 	settings   *model.VisibleSettings // cached visible settings
 	minb, maxb *model.Settings        // cached boundary values
+	rev        int64                  // cached settings revision
 
 	managedUseCases // all use cases, but the appuc itself
 }
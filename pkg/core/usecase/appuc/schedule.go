@@ -0,0 +1,261 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package appuc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/momeni/clean-arch/pkg/core/log"
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+)
+
+// DefaultSchedulingInterval is the default interval between two
+// consecutive checks of the scheduler for the due scheduled changes.
+const DefaultSchedulingInterval = 5 * time.Second
+
+// ScheduleSettings schedules the given `s` settings to be applied at
+// the `effectiveAt` time by the scheduler (see RunScheduler). The `s`
+// settings are checked against their boundary values immediately, so
+// an out of range value is reported as a bad request error. However,
+// the boundary values may change before the effective time, so the
+// change may still fail when it is applied. The outcome of applying
+// the change is recorded and may be queried by ScheduledChanges.
+func (app *UseCase) ScheduleSettings(
+	ctx context.Context, s *model.Settings, effectiveAt time.Time,
+) (sc *model.ScheduledChange, err error) {
+	err = app.pool.Conn(
+		ctx, func(ctx context.Context, c repo.Conn) error {
+			q := app.settingsRepo.Conn(c)
+			sc, err = q.ScheduleChange(ctx, s, effectiveAt)
+			return err
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("scheduling settings change: %w", err)
+	}
+	return sc, nil
+}
+
+// ScheduledChanges lists all pending and finished scheduled changes,
+// ordered by their effective time.
+func (app *UseCase) ScheduledChanges(
+	ctx context.Context,
+) (scs []model.ScheduledChange, err error) {
+	err = app.pool.Conn(
+		ctx, func(ctx context.Context, c repo.Conn) error {
+			q := app.settingsRepo.Conn(c)
+			scs, err = q.ScheduledChanges(ctx)
+			return err
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing scheduled changes: %w", err)
+	}
+	return scs, nil
+}
+
+// CancelScheduledChange cancels the `id` pending scheduled change, so
+// it will not be applied by the scheduler, and returns its updated
+// instance. If the change is being applied concurrently, this method
+// waits for it and then fails because the change is not pending.
+func (app *UseCase) CancelScheduledChange(
+	ctx context.Context, id uuid.UUID,
+) (sc *model.ScheduledChange, err error) {
+	err = app.pool.Conn(
+		ctx, func(ctx context.Context, c repo.Conn) error {
+			q := app.settingsRepo.Conn(c)
+			sc, err = q.CancelScheduledChange(ctx, id)
+			return err
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("canceling scheduled change: %w", err)
+	}
+	return sc, nil
+}
+
+// RunScheduler checks for the due scheduled changes every `interval`
+// and applies them using the ApplyDueChanges method, until the `ctx`
+// context is canceled. Errors are logged and do not stop the scheduler.
+// It is supposed to be run in a dedicated goroutine by all instances
+// of the application, while only one of them applies each due change.
+func (app *UseCase) RunScheduler(
+	ctx context.Context, interval time.Duration,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := app.ApplyDueChanges(ctx); err != nil {
+			log.Error(
+				ctx, "applying scheduled settings changes failed",
+				log.Err("err", err),
+			)
+		}
+	}
+}
+
+// ApplyDueChanges applies the pending scheduled changes which their
+// effective time has arrived, ordered by their effective time, and
+// returns the number of processed changes.
+//
+// The due changes are queried in a transaction which holds an advisory
+// lock, so when multiple instances of the application share a database,
+// only one of them processes the due changes at any time and others
+// return immediately (reporting zero processed changes). Each change is
+// applied (unconditionally, without an expected revision) in the same
+// locking transaction, within a savepoint, and its outcome is recorded
+// in that transaction too, including the failure reason or the produced
+// settings revision. A failed change is rolled back to its savepoint
+// and does not stop the processing of next ones. Since all changes and
+// their outcomes are committed together, a crash may not apply a change
+// without recording its outcome. The use case objects are updated
+// based on the last applied change after the commit.
+//
+// Using one transaction (and so one connection) for the whole process
+// ensures that a small connection pool is not exhausted while the lock
+// is held. Like the UpdateSettings method, concurrent settings updates
+// of this instance are blocked until the due changes are processed.
+func (app *UseCase) ApplyDueChanges(ctx context.Context) (int, error) {
+	app.mutex.Lock()
+	defer app.mutex.Unlock()
+	n := 0
+	var last *appliedChange
+	err := app.pool.Conn(
+		ctx, func(ctx context.Context, c repo.Conn) error {
+			return c.Tx(
+				ctx, func(ctx context.Context, tx repo.Tx) error {
+					q := app.settingsRepo.Tx(tx)
+					locked, err := q.LockScheduler(ctx)
+					if err != nil {
+						return fmt.Errorf("locking scheduler: %w", err)
+					}
+					if !locked {
+						return nil
+					}
+					scs, err := q.DueScheduledChanges(ctx)
+					if err != nil {
+						return fmt.Errorf("querying due changes: %w", err)
+					}
+					for _, sc := range scs {
+						ac, outcome, err := app.applyChange(ctx, tx, q, sc)
+						if err != nil {
+							return err
+						}
+						state := model.ScheduledChangeFailed
+						var rev *int64
+						if ac != nil {
+							state = model.ScheduledChangeApplied
+							rev, last = &ac.rev, ac
+						}
+						err = q.FinishScheduledChange(
+							ctx, sc.ID, state, outcome, rev,
+						)
+						if err != nil {
+							return fmt.Errorf(
+								"recording outcome of %v: %w", sc.ID, err,
+							)
+						}
+						n++
+					}
+					return nil
+				},
+			)
+		},
+	)
+	if err != nil {
+		return 0, fmt.Errorf("applying due scheduled changes: %w", err)
+	}
+	if last != nil {
+		app.updateAll(last.vs, last.minb, last.maxb, last.rev, last.managed)
+	}
+	return n, nil
+}
+
+// appliedChange keeps the results of a successfully applied scheduled
+// change, so they may be published after the commit of its transaction.
+type appliedChange struct {
+	vs         *model.VisibleSettings
+	minb, maxb *model.Settings
+	rev        int64
+	managed    managedUseCases
+}
+
+// scheduledChangeSavepoint is the name of the savepoint which is
+// created in the scheduler transaction before applying each change.
+const scheduledChangeSavepoint = "caweb_scheduled_change"
+
+// applyChange applies the `sc` scheduled change using the `q` settings
+// queryer of the `tx` transaction (similar to the UpdateSettings
+// method, but without publishing the new settings), within a savepoint.
+// If the change is applied, its results are returned. Otherwise, the
+// savepoint is rolled back and the failure reason is returned as the
+// `outcome`. A non-nil error indicates that the savepoint could not be
+// managed, so the `tx` transaction may not be used anymore.
+func (app *UseCase) applyChange(
+	ctx context.Context,
+	tx repo.Tx,
+	q SettingsTxQueryer,
+	sc model.ScheduledChange,
+) (ac *appliedChange, outcome *string, err error) {
+	if sc.Settings == nil {
+		if sc.Outcome != nil {
+			return nil, sc.Outcome, nil // settings were not decodable
+		}
+		reason := "settings format is not supported anymore"
+		return nil, &reason, nil
+	}
+	const sp = scheduledChangeSavepoint
+	if _, err := tx.Exec(ctx, "SAVEPOINT "+sp); err != nil {
+		return nil, nil, fmt.Errorf("creating %q savepoint: %w", sp, err)
+	}
+	ac, err = app.updateInTx(ctx, q, sc.Settings)
+	if err != nil {
+		log.Warn(
+			ctx, "scheduled settings change failed",
+			log.String("id", sc.ID.String()),
+			log.Err("err", err),
+		)
+		_, err2 := tx.Exec(ctx, "ROLLBACK TO SAVEPOINT "+sp)
+		if err2 != nil {
+			return nil, nil, fmt.Errorf(
+				"rolling back to %q savepoint: %w", sp, err2,
+			)
+		}
+		reason := err.Error()
+		return nil, &reason, nil
+	}
+	if _, err := tx.Exec(ctx, "RELEASE SAVEPOINT "+sp); err != nil {
+		return nil, nil, fmt.Errorf("releasing %q savepoint: %w", sp, err)
+	}
+	return ac, nil, nil
+}
+
+// updateInTx stores the `s` settings using the `q` settings queryer
+// and creates the use case objects which are based on them.
+func (app *UseCase) updateInTx(
+	ctx context.Context, q SettingsTxQueryer, s *model.Settings,
+) (*appliedChange, error) {
+	b, vs, minb, maxb, rev, err := q.Update(ctx, s, nil)
+	if err != nil {
+		return nil, fmt.Errorf("database update: %w", err)
+	}
+	managed, err := app.newManagedUseCases(b)
+	if err != nil {
+		return nil, fmt.Errorf("creating use cases: %w", err)
+	}
+	return &appliedChange{
+		vs: vs, minb: minb, maxb: maxb, rev: rev, managed: managed,
+	}, nil
+}
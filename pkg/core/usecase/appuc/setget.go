@@ -14,17 +14,20 @@ import (
 // which are currently in effect, in addition to the settings boundary
 // values which indicate the minimum/maximum acceptable values. If
 // caller needs to modify them, those structs must be deeply cloned.
+// The `rev` revision of those settings is returned too, so it may be
+// passed to the UpdateSettings method later in order to ensure that
+// settings are not changed concurrently in the meantime.
 // The effective settings and use case objects which are built
 // based on them (and other invisible settings) may be updated
 // atomically, while they are exposed by a series of getter methods. At
 // least one of Reload or UpdateSettings methods must be called before
 // this (and other use case objects getter methods) may be called.
 func (app *UseCase) Settings() (
-	vs *model.VisibleSettings, minb, maxb *model.Settings,
+	vs *model.VisibleSettings, minb, maxb *model.Settings, rev int64,
 ) {
 	app.rwlock.RLock()
 	defer app.rwlock.RUnlock()
-	return app.settings, app.minb, app.maxb
+	return app.settings, app.minb, app.maxb, app.rev
 }
 
 // updateAll atomically updates the visible settings and all other use
@@ -34,6 +37,7 @@ func (app *UseCase) Settings() (
 func (app *UseCase) updateAll(
 	vs *model.VisibleSettings,
 	minb, maxb *model.Settings,
+	rev int64,
 	managed managedUseCases,
 ) {
 	app.rwlock.Lock()
@@ -41,6 +45,7 @@ func (app *UseCase) updateAll(
 	app.settings = vs
 	app.minb = minb
 	app.maxb = maxb
+	app.rev = rev
 	app.managedUseCases = managed
 }
 
@@ -7,8 +7,10 @@ package appuc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
+	"github.com/momeni/clean-arch/pkg/core/cerr"
 	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 )
@@ -41,9 +43,26 @@ import (
 // minimum/maximum boundary settings values are pointers to the shared
 // structs. If caller needs to modify them, those structs must be deeply
 // cloned beforehand.
+//
+// The mutex only serializes the updates within this process. Therefore,
+// the `expected` revision may be passed in order to detect concurrent
+// updates which are performed by other instances of the application.
+// If `expected` is non-nil and the current revision of the settings in
+// the database is not equal to *expected, no change will be applied
+// and an error wrapping the repo.ErrSettingsRevisionMismatch will be
+// returned, which is also marked by the cerr.PreconditionFailed. A nil
+// `expected` updates the settings unconditionally. The new revision of
+// settings is returned as `rev`. In case of a revision mismatch, the
+// cached settings are reloaded from the database too, so they (and
+// their revision) reflect the concurrent changes afterwards.
 func (app *UseCase) UpdateSettings(
-	ctx context.Context, s *model.Settings,
-) (vs *model.VisibleSettings, minb, maxb *model.Settings, err error) {
+	ctx context.Context, s *model.Settings, expected *int64,
+) (
+	vs *model.VisibleSettings,
+	minb, maxb *model.Settings,
+	rev int64,
+	err error,
+) {
 	app.mutex.Lock()
 	defer app.mutex.Unlock()
 	var managed managedUseCases
@@ -53,7 +72,9 @@ func (app *UseCase) UpdateSettings(
 				ctx, func(ctx context.Context, tx repo.Tx) error {
 					q := app.settingsRepo.Tx(tx)
 					var b Builder
-					b, vs, minb, maxb, err = q.Update(ctx, s)
+					b, vs, minb, maxb, rev, err = q.Update(
+						ctx, s, expected,
+					)
 					if err != nil {
 						return fmt.Errorf("database update: %w", err)
 					}
@@ -68,10 +89,80 @@ func (app *UseCase) UpdateSettings(
 	)
 	if err != nil {
 		err = fmt.Errorf("delegating update to settings repo: %w", err)
-		return nil, nil, nil, err
+		if errors.Is(err, repo.ErrSettingsRevisionMismatch) {
+			if rerr := app.reload(ctx); rerr != nil {
+				err = fmt.Errorf("%w (reloading: %v)", err, rerr)
+			}
+			err = cerr.PreconditionFailed(err)
+		}
+		return nil, nil, nil, 0, err
+	}
+	app.updateAll(vs, minb, maxb, rev, managed)
+	return vs, minb, maxb, rev, nil
+}
+
+// errDryRun is returned by the ValidateSettings transaction handler,
+// so its transaction will be rolled back unconditionally.
+var errDryRun = errors.New("dry-run settings validation")
+
+// ValidateSettings checks if the given `s` settings can be applied
+// without applying them. It runs the same steps as the UpdateSettings
+// method, including the conversion and storage of the mutable settings
+// in the database and creation of fresh use case objects using the
+// obtained Builder instance, but all database changes are performed in
+// a transaction which is always rolled back and the created use case
+// objects are discarded, so the published settings and use cases are
+// not affected.
+//
+// The returned `violations` list the settings which were out of their
+// acceptable range of values (and so, would be adjusted to the nearest
+// boundary value by the UpdateSettings method) or which prevented the
+// creation of fresh use case objects. The latter items have an empty
+// Field, since they may not be attributed to a specific setting. The
+// `vs` visible settings and `minb` and `maxb` boundary values report
+// the settings which would be published if `s` was applied (after the
+// out of range values adjustment). A non-nil error indicates that the
+// validation could not be performed, e.g., due to a database error.
+func (app *UseCase) ValidateSettings(
+	ctx context.Context, s *model.Settings,
+) (
+	vs *model.VisibleSettings,
+	minb, maxb *model.Settings,
+	violations []model.SettingsViolation,
+	err error,
+) {
+	err = app.pool.Conn(
+		ctx, func(ctx context.Context, c repo.Conn) error {
+			return c.Tx(
+				ctx, func(ctx context.Context, tx repo.Tx) error {
+					q := app.settingsRepo.Tx(tx)
+					var b Builder
+					b, vs, minb, maxb, violations, err = q.Validate(
+						ctx, s,
+					)
+					if err != nil {
+						return fmt.Errorf("database validation: %w", err)
+					}
+					_, err = app.newManagedUseCases(b)
+					if err != nil {
+						violations = append(
+							violations, model.SettingsViolation{
+								Message: fmt.Sprintf(
+									"creating use cases: %v", err,
+								),
+							},
+						)
+					}
+					return errDryRun
+				},
+			)
+		},
+	)
+	if !errors.Is(err, errDryRun) {
+		err = fmt.Errorf("delegating validation to settings repo: %w", err)
+		return nil, nil, nil, nil, err
 	}
-	app.updateAll(vs, minb, maxb, managed)
-	return vs, minb, maxb, nil
+	return vs, minb, maxb, violations, nil
 }
 
 // Reload queries the settings repository in order to fetch the current
@@ -102,16 +193,23 @@ func (app *UseCase) UpdateSettings(
 func (app *UseCase) Reload(ctx context.Context) error {
 	app.mutex.Lock()
 	defer app.mutex.Unlock()
+	return app.reload(ctx)
+}
+
+// reload implements the Reload method, expecting its caller to hold
+// the app.mutex lock.
+func (app *UseCase) reload(ctx context.Context) error {
 	var (
 		b          Builder
 		vs         *model.VisibleSettings
 		minb, maxb *model.Settings
+		rev        int64
 		err        error
 	)
 	err = app.pool.Conn(
 		ctx, func(ctx context.Context, c repo.Conn) error {
 			q := app.settingsRepo.Conn(c)
-			b, vs, minb, maxb, err = q.Fetch(ctx)
+			b, vs, minb, maxb, rev, err = q.Fetch(ctx)
 			return err
 		},
 	)
@@ -122,7 +220,7 @@ func (app *UseCase) Reload(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("creating use cases: %w", err)
 	}
-	app.updateAll(vs, minb, maxb, managed)
+	app.updateAll(vs, minb, maxb, rev, managed)
 	return nil
 }
 
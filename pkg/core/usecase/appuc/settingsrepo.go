@@ -7,7 +7,9 @@ package appuc
 
 import (
 	"context"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 )
@@ -26,6 +28,15 @@ import (
 // in-database mutable settings, result is returned to the use cases
 // layer as an instance of the Builder interface, so they may be used
 // for creation of new use case objects.
+//
+// The mutable settings are kept per component (see model.Component),
+// so each managed use case may own a distinct row in the database with
+// its own serialization format, boundary values, and format version.
+// The version-independent model.Settings struct covers all components
+// and it is the responsibility of the settings repository to split it
+// among the registered components when updating them and to merge all
+// components rows when fetching them. Rows of those components which
+// are not known by the current configuration version are ignored.
 type SettingsRepo interface {
 	// Conn wraps the provided connection instance and creates a new
 	// settings repository connection-based queryer.
@@ -46,11 +57,12 @@ type SettingsRepo interface {
 type SettingsConnQueryer interface {
 	SettingsQueryer
 
-	// Fetch queries the mutable settings from the settings repository,
-	// deserializes them, merges them into a clone of the base settings
-	// (representing the configuration file and environment variables
-	// state when the settings repository instance was created), and
-	// returns the fresh configuration instance as a Builder interface
+	// Fetch queries the mutable settings from the settings repository
+	// (one row per registered component), deserializes them, merges
+	// them into a clone of the base settings (representing the
+	// configuration file and environment variables state when the
+	// settings repository instance was created), and returns the fresh
+	// configuration instance as a Builder interface
 	// in addition to its visible settings (as an instance of the
 	// version-independent model.VisibleSettings struct).
 	//
@@ -78,6 +90,7 @@ type SettingsConnQueryer interface {
 		b Builder,
 		vs *model.VisibleSettings,
 		minb, maxb *model.Settings,
+		rev int64,
 		err error,
 	)
 }
@@ -110,23 +123,108 @@ type SettingsTxQueryer interface {
 	// When updating the database with new settings, the boundary values
 	// will be serialized and stored alongside them too.
 	//
+	// If the `expected` revision is non-nil, settings are updated using
+	// a compare and swap operation, so they are only updated if their
+	// current revision is equal to *expected. Otherwise, an error which
+	// wraps the repo.ErrSettingsRevisionMismatch will be returned and
+	// settings will be kept unchanged. This allows multiple instances
+	// of the application to update settings concurrently without
+	// overwriting each other changes silently. The new revision of the
+	// settings is returned as `rev`.
+	//
 	// Update requires an open transaction because it is supposed to
 	// be reified by some major-version specific schema migration
 	// settler object (i.e., some stlmigN package) which expects a
 	// transaction instance (as they are used for the last phase of
 	// a multi-database migration operation).
-	Update(ctx context.Context, s *model.Settings) (
+	Update(ctx context.Context, s *model.Settings, expected *int64) (
+		b Builder,
+		vs *model.VisibleSettings,
+		minb, maxb *model.Settings,
+		rev int64,
+		err error,
+	)
+
+	// Validate performs the same steps as the Update method, including
+	// the conversion of the `s` settings into their version-dependent
+	// format, mutation of a clone of the base settings, and storing
+	// them in the settings repository. However, the settings which are
+	// out of their acceptable range of values do not cause an error
+	// and are reported as a list of `violations` instead (while the
+	// returned Builder and visible settings reflect the adjusted values
+	// as they are replaced by their nearest boundary values).
+	//
+	// Validate is supposed to be called within a transaction which
+	// will be rolled back by the caller unconditionally, so it can be
+	// used for the dry-run checking of a settings update request.
+	Validate(ctx context.Context, s *model.Settings) (
 		b Builder,
 		vs *model.VisibleSettings,
 		minb, maxb *model.Settings,
+		violations []model.SettingsViolation,
 		err error,
 	)
+
+	// LockScheduler tries to obtain an exclusive lock, which will be
+	// released automatically at the end of the ongoing transaction,
+	// in order to ensure that at most one instance of the application
+	// may apply the due scheduled changes at any time. It returns false
+	// (without blocking) if the lock is held by another instance.
+	LockScheduler(ctx context.Context) (locked bool, err error)
+
+	// DueScheduledChanges queries the pending scheduled changes which
+	// their effective time has arrived (according to the database
+	// clock, so all instances agree on it), ordered by their effective
+	// time, and locks them until the end of the ongoing transaction,
+	// so they may not be canceled while they are being applied.
+	// If the settings of a change were serialized with a format which
+	// is not supported anymore (e.g., due to a configuration format
+	// migration), its Settings field will be nil. If they could not be
+	// deserialized at all, its Outcome field describes the reason too.
+	DueScheduledChanges(ctx context.Context) (
+		[]model.ScheduledChange, error,
+	)
+
+	// FinishScheduledChange records the outcome of applying the `id`
+	// scheduled change, moving it to the given final `state`. The
+	// `outcome` describes the failure reason of a failed change and the
+	// `rev` is the settings revision which is produced by an applied
+	// change. Both of them may be nil.
+	FinishScheduledChange(
+		ctx context.Context,
+		id uuid.UUID,
+		state model.ScheduledChangeState,
+		outcome *string,
+		rev *int64,
+	) error
 }
 
 // SettingsQueryer interface indicates queries which can be executed
 // on a settings repository either with a connection or an ongoing
 // transaction. This interface is embedded by both of SettingsTxQueryer
 // and SettingsConnQueryer interfaces.
-// There is no such methods in this version.
 type SettingsQueryer interface {
+	// ScheduleChange converts the `s` settings into the version
+	// dependent format of the last supported version and stores them
+	// as a pending scheduled change which should be applied at the
+	// `effectiveAt` time. The `s` settings must fall in the acceptable
+	// range of values (as indicated by the base settings), otherwise,
+	// an error will be returned. The created change is returned.
+	ScheduleChange(
+		ctx context.Context, s *model.Settings, effectiveAt time.Time,
+	) (*model.ScheduledChange, error)
+
+	// ScheduledChanges lists all scheduled changes, including the
+	// pending changes and the finished changes (with their recorded
+	// outcome), ordered by their effective time.
+	ScheduledChanges(ctx context.Context) (
+		[]model.ScheduledChange, error,
+	)
+
+	// CancelScheduledChange cancels the `id` pending scheduled change
+	// and returns its updated instance. If the `id` change does not
+	// exist, or it is not pending anymore, an error will be returned.
+	CancelScheduledChange(ctx context.Context, id uuid.UUID) (
+		*model.ScheduledChange, error,
+	)
 }
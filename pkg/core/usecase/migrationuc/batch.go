@@ -0,0 +1,207 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrationuc
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/momeni/clean-arch/pkg/core/log"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+)
+
+// EnableBatching asks the Migrate method to settle the destination
+// tables in batches of at most `batchSize` rows, copying up to
+// `parallelism` tables concurrently (each one using its own database
+// connection), instead of filling each table by one statement in the
+// migration transaction. Each batch is copied in its own transaction
+// and reads the rows after the last copied key (in the primary key
+// order), so large tables are copied without a long transaction and
+// the progress of each table is logged after each batch.
+//
+// The atomicity of the migration is preserved by filling the tables in
+// the staging schema (see StagingSchemaName) and moving them into the
+// destination schema in the last transaction, alongside the mutable
+// settings persistence. However, the migration views and the staged
+// tables have to be committed before they can be used by the parallel
+// transactions, so if the batched settlement fails, the intermediate
+// schema are dropped before returning the error (so the migration may
+// be tried again). Since batches are read in distinct transactions,
+// the source database should not be changed during the migration, as
+// can be checked by the EnableVerification method.
+//
+// A zero `batchSize` disables the batched settlement, while the
+// `parallelism` is at least one. The `mduc` is returned in order to
+// simplify chaining of calls.
+func (mduc *MigrateDBUseCase) EnableBatching(
+	batchSize, parallelism int,
+) *MigrateDBUseCase {
+	mduc.batchSize = batchSize
+	mduc.parallelism = max(parallelism, 1)
+	return mduc
+}
+
+// fillMigPathSchemaBatched is the batched counterpart of the
+// fillMigPathSchema method, which creates a connection pool for the
+// destination database using the repo.NormalRole and proceeds in three
+// steps. First, a transaction loads the source database and migrates
+// it using the prepareSettler method, creates the destination tables
+// in the staging schema using the StageSchema method of the obtained
+// SchemaSettler, and commits. Second, the staged tables are filled in
+// batches by the settleTables method. Third, a transaction moves the
+// staged tables into the destination schema using CommitStagedSchema,
+// verifies them (if the verification is enabled), and persists the
+// mutable settings and the .migrated file (just like the last steps of
+// the fillMigPathSchema method) before committing.
+func (mduc *MigrateDBUseCase) fillMigPathSchemaBatched(
+	ctx context.Context,
+) error {
+	p, err := mduc.dstSettings.ConnectionPool(ctx, repo.NormalRole)
+	if err != nil {
+		return fmt.Errorf("creating DB pool for normal role: %w", err)
+	}
+	defer p.Close()
+	var tables []string
+	err = p.Conn(ctx, func(ctx context.Context, c repo.Conn) error {
+		return c.Tx(ctx, func(ctx context.Context, tx repo.Tx) error {
+			ss, err := mduc.prepareSettler(ctx, tx)
+			if err != nil {
+				return err
+			}
+			err = mduc.journal.measure("stage", func() error {
+				return ss.StageSchema(ctx)
+			})
+			if err != nil {
+				return fmt.Errorf("StageSchema(): %w", err)
+			}
+			tables = ss.SettledTables()
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("normal connection: %w", err)
+	}
+	err = mduc.journal.measure("settle", func() error {
+		return mduc.settleTables(ctx, p, tables)
+	})
+	if err != nil {
+		return fmt.Errorf("settling tables in batches: %w", err)
+	}
+	err = p.Conn(ctx, func(ctx context.Context, c repo.Conn) error {
+		return c.Tx(ctx, func(ctx context.Context, tx repo.Tx) error {
+			ss, err := mduc.dstSettings.SchemaSettler(tx)
+			if err != nil {
+				return fmt.Errorf("creating SchemaSettler: %w", err)
+			}
+			err = mduc.journal.measure("commit-staged", func() error {
+				return ss.CommitStagedSchema(ctx)
+			})
+			if err != nil {
+				return fmt.Errorf("CommitStagedSchema(): %w", err)
+			}
+			err = mduc.verifySettledSchema(ctx, ss)
+			if err != nil {
+				return fmt.Errorf("verifying migrated data: %w", err)
+			}
+			err = mduc.persistSettingsInDBAndFile(ctx, ss)
+			if err != nil {
+				return fmt.Errorf("persisting target settings: %w", err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("normal connection: %w", err)
+	}
+	return nil
+}
+
+// settleTables fills the staged `tables` using the settleTable method,
+// running up to mduc.parallelism of them concurrently, each one with
+// its own connection from the `p` pool. As soon as one table fails,
+// the others are canceled and the first error is returned.
+func (mduc *MigrateDBUseCase) settleTables(
+	ctx context.Context, p repo.Pool, tables []string,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, mduc.parallelism)
+	for _, table := range tables {
+		wg.Add(1)
+		go func(table string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				return // another table has failed
+			}
+			if err := mduc.settleTable(ctx, p, table); err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("settling %q: %w", table, err)
+					cancel()
+				})
+			}
+		}(table)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// settleTable copies the rows of the `table` view into its staged table
+// in batches of mduc.batchSize rows, using the SettleTableBatch method
+// of a SchemaSettler in a distinct transaction for each batch (but all
+// in one connection of the `p` pool), logging the number of copied
+// rows and the elapsed time after each batch.
+func (mduc *MigrateDBUseCase) settleTable(
+	ctx context.Context, p repo.Pool, table string,
+) error {
+	return p.Conn(ctx, func(ctx context.Context, c repo.Conn) error {
+		var after *string
+		var total int64
+		start := time.Now()
+		for {
+			var n int64
+			err := c.Tx(ctx, func(ctx context.Context, tx repo.Tx) error {
+				ss, err := mduc.dstSettings.SchemaSettler(tx)
+				if err != nil {
+					return fmt.Errorf("creating SchemaSettler: %w", err)
+				}
+				var last *string
+				n, last, err = ss.SettleTableBatch(
+					ctx, table, after, mduc.batchSize,
+				)
+				if err != nil {
+					return fmt.Errorf(
+						"SettleTableBatch(after=%d rows): %w", total, err,
+					)
+				}
+				after = last
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			total += n
+			log.Info(
+				ctx, "settled a batch of rows",
+				log.String("table", table),
+				log.String("rows", strconv.FormatInt(total, 10)),
+				log.String("elapsed", time.Since(start).String()),
+			)
+			if n < int64(mduc.batchSize) {
+				return nil
+			}
+		}
+	})
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrationuc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/momeni/clean-arch/pkg/core/repo"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePool passes a fakeConn to its connection handlers. Other methods
+// of the embedded repo.Pool are not implemented.
+type fakePool struct {
+	repo.Pool
+}
+
+func (fakePool) Conn(ctx context.Context, f repo.ConnHandler) error {
+	return f(ctx, fakeConn{})
+}
+
+// fakeConn passes a fakeTx to its transaction handlers.
+type fakeConn struct {
+	repo.Conn
+}
+
+func (fakeConn) Tx(ctx context.Context, f repo.TxHandler) error {
+	return f(ctx, fakeTx{})
+}
+
+type fakeTx struct {
+	repo.Tx
+}
+
+// fakeSettings returns the same fakeSettler for all transactions.
+type fakeSettings struct {
+	Settings
+	ss *fakeSettler
+}
+
+func (fs fakeSettings) SchemaSettler(repo.Tx) (repo.SchemaSettler, error) {
+	return fs.ss, nil
+}
+
+// fakeSettler simulates tables with integer keys. The settle function
+// of each table decides about the number of copied rows of a batch
+// (given the number of its previously copied rows) and the `after`
+// keys of all calls are recorded.
+type fakeSettler struct {
+	repo.SchemaSettler
+	settle map[string]func(ctx context.Context, copied, limit int) (
+		int, error,
+	)
+
+	mu     sync.Mutex
+	copied map[string]int
+	afters map[string][]string
+}
+
+func (fs *fakeSettler) SettleTableBatch(
+	ctx context.Context, table string, after *string, limit int,
+) (n int64, last *string, err error) {
+	fs.mu.Lock()
+	a := "<nil>"
+	if after != nil {
+		a = *after
+	}
+	fs.afters[table] = append(fs.afters[table], a)
+	copied := fs.copied[table]
+	fs.mu.Unlock()
+	c, err := fs.settle[table](ctx, copied, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	fs.mu.Lock()
+	fs.copied[table] += c
+	copied = fs.copied[table]
+	fs.mu.Unlock()
+	if c > 0 {
+		s := strconv.Itoa(copied)
+		last = &s
+	}
+	return int64(c), last, nil
+}
+
+// rowsSettler returns a settle function of a table with `rows` rows.
+func rowsSettler(rows int) func(context.Context, int, int) (int, error) {
+	return func(_ context.Context, copied, limit int) (int, error) {
+		return min(rows-copied, limit), nil
+	}
+}
+
+func newBatchedUseCase(
+	fs *fakeSettler, batchSize, parallelism int,
+) *MigrateDBUseCase {
+	fs.copied = make(map[string]int)
+	fs.afters = make(map[string][]string)
+	mduc := &MigrateDBUseCase{dstSettings: fakeSettings{ss: fs}}
+	return mduc.EnableBatching(batchSize, parallelism)
+}
+
+func TestSettleTable(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		rows   int
+		afters []string
+	}{
+		{"empty", 0, []string{"<nil>"}},
+		{"partial last batch", 5, []string{"<nil>", "2", "4"}},
+		{"exact multiple", 4, []string{"<nil>", "2", "4"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := &fakeSettler{settle: map[string]func(
+				context.Context, int, int,
+			) (int, error){
+				"cars": rowsSettler(tc.rows),
+			}}
+			mduc := newBatchedUseCase(fs, 2, 1)
+			err := mduc.settleTable(
+				context.Background(), fakePool{}, "cars",
+			)
+			require.NoError(t, err)
+			require.Equal(t, tc.rows, fs.copied["cars"])
+			require.Equal(t, tc.afters, fs.afters["cars"])
+		})
+	}
+}
+
+func TestSettleTablesCancelsOthersOnError(t *testing.T) {
+	errBad := errors.New("bad batch")
+	started := make(chan struct{})
+	var once sync.Once
+	canceled := false
+	fs := &fakeSettler{settle: map[string]func(
+		context.Context, int, int,
+	) (int, error){
+		"bad": func(context.Context, int, int) (int, error) {
+			<-started // fail after the slow table has started
+			return 0, errBad
+		},
+		"slow": func(ctx context.Context, _, limit int) (int, error) {
+			once.Do(func() { close(started) })
+			if err := ctx.Err(); err != nil {
+				canceled = true
+				return 0, err
+			}
+			return limit, nil // infinite table, until canceled
+		},
+	}}
+	mduc := newBatchedUseCase(fs, 2, 2)
+	err := mduc.settleTables(
+		context.Background(), fakePool{}, []string{"bad", "slow"},
+	)
+	require.ErrorIs(t, err, errBad)
+	require.ErrorContains(t, err, `settling "bad"`)
+	require.True(t, canceled, "slow table must observe the cancellation")
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrationuc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/momeni/clean-arch/pkg/core/proc"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+)
+
+// CleanupPlan lists the leftovers of failed migration attempts which
+// are (or would be) removed by the CleanupUseCase.
+type CleanupPlan struct {
+	// Schemas lists the fdwX_Y, migN, and stgN schema (see
+	// ForeignSchemaName, MigrationSchemaName, and StagingSchemaName)
+	// which are dropped with cascade.
+	Schemas []string `json:"schemas,omitempty"`
+
+	// Servers lists the fpsX_Y foreign servers (see ForeignServerName)
+	// which are dropped with cascade, so their user mappings are
+	// dropped too.
+	Servers []string `json:"servers,omitempty"`
+
+	// Files lists the `.migrated` file and the temporary passwords files
+	// (e.g., the `.pgpass.new` file) which are removed.
+	Files []string `json:"files,omitempty"`
+}
+
+// ErrResumableMigration indicates that a `.migrated` file describes the
+// cleaned database and its main schema is filled, so an interrupted
+// migration may be resumed (by running it again) and its leftovers may
+// not be removed.
+var ErrResumableMigration = errors.New("migration may be resumed")
+
+// CleanupUseCase represents the cleanup use case which removes the
+// leftovers of failed migration attempts from a database and the file
+// system. For details, see the NewCleanup function.
+type CleanupUseCase struct {
+	settings      Settings         // settings of the cleaned database
+	targetCfgPath string           // target config file path
+	schemaRepo    repo.Schema      // schema management repo
+	loader        ConfigFileLoader // loads the .migrated file
+	procChecker   proc.Checker     // detects stale lock files, or nil
+}
+
+// NewCleanup creates a CleanupUseCase instance, using the `ss` settings
+// in order to find the database connection information and the files
+// which keep its temporary passwords. The `targetCfgPath` indicates the
+// config file path which was (or was going to be) overwritten by a
+// migration, so its `.migrated` file can be found. The `loader` is used
+// for loading that `.migrated` file, so it can be compared with `ss`.
+//
+// The `repo.Schema` repository is taken from `ss` in order to list and
+// drop the leftover schema and foreign servers.
+func NewCleanup(
+	ss Settings, targetCfgPath string, loader ConfigFileLoader,
+) *CleanupUseCase {
+	return &CleanupUseCase{
+		settings:      ss,
+		targetCfgPath: targetCfgPath,
+		schemaRepo:    ss.NewSchemaRepo(),
+		loader:        loader,
+	}
+}
+
+// SetProcChecker sets the `pc` processes checker which is used for
+// detecting a stale `.lock` file of the target config file, see the
+// MigrateDBUseCase.SetProcChecker method. The cuc itself is returned
+// for chaining.
+func (cuc *CleanupUseCase) SetProcChecker(pc proc.Checker) *CleanupUseCase {
+	cuc.procChecker = pc
+	return cuc
+}
+
+// Plan finds the leftovers of failed migration attempts, as Cleanup
+// does, without removing them. Plan changes no file (except for the
+// `.lock` file which is created and removed while finding them), so
+// the admin role connects without accepting its temporary password.
+func (cuc *CleanupUseCase) Plan(ctx context.Context) (*CleanupPlan, error) {
+	return cuc.cleanup(ctx, false)
+}
+
+// Cleanup finds and removes the leftovers of failed migration attempts.
+// It connects to the database using the admin role, acquires the
+// MigrationLock and the `.lock` file of the target config file (so it
+// fails with ErrMigrationLocked while a migration is running), and
+// finds the fdwX_Y, migN, and stgN schema and fpsX_Y foreign servers
+// of that database, in addition to the `.migrated` file of the target
+// config file and the temporary passwords files of the admin and normal
+// roles. The schema are dropped with cascade, then the foreign
+// servers are dropped with cascade (dropping their user mappings too),
+// and finally the files are removed. The removed items are returned.
+//
+// A migration writes its `.migrated` file before committing the main
+// schema of the destination database and drops its intermediate schema
+// afterwards, so if the `.migrated` file describes the cleaned database
+// and that main schema is filled, the migration may be resumed and its
+// leftovers are required. In this case, nothing is removed and an error
+// wrapping ErrResumableMigration is returned. A `.migrated` file which
+// describes another database is kept too (but other leftovers are
+// removed).
+//
+// The temporary passwords files are only removed if connections can be
+// established for both of the admin and normal roles using their main
+// passwords. Connecting as the admin role accepts its temporary password
+// (after an interrupted renewal), moving it over the main passwords.
+// Thereafter, remaining temporary passwords are stale.
+func (cuc *CleanupUseCase) Cleanup(
+	ctx context.Context,
+) (*CleanupPlan, error) {
+	return cuc.cleanup(ctx, true)
+}
+
+func (cuc *CleanupUseCase) cleanup(
+	ctx context.Context, remove bool,
+) (*CleanupPlan, error) {
+	plan := &CleanupPlan{}
+	err := withMigrationLock(
+		ctx, cuc.settings, cuc.schemaRepo,
+		&lockFile{cuc.targetCfgPath + ".lock", cuc.procChecker}, !remove,
+		func(ctx context.Context, q repo.SchemaConnQueryer) error {
+			if err := cuc.findLeftovers(ctx, q, plan); err != nil {
+				return err
+			}
+			if !remove {
+				return nil
+			}
+			return dropLeftovers(ctx, q, plan)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if !remove {
+		return plan, nil
+	}
+	for _, path := range plan.Files {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing %q file: %w", path, err)
+		}
+	}
+	return plan, nil
+}
+
+// findLeftovers fills the `plan` with the leftover schema, foreign
+// servers, and files, using the `q` connection queryer.
+func (cuc *CleanupUseCase) findLeftovers(
+	ctx context.Context, q repo.SchemaConnQueryer, plan *CleanupPlan,
+) error {
+	names, err := q.ListSchemas(ctx)
+	if err != nil {
+		return fmt.Errorf("listing schema: %w", err)
+	}
+	for _, name := range names {
+		if leftoverSchemaRegexp.MatchString(name) {
+			plan.Schemas = append(plan.Schemas, name)
+		}
+	}
+	servers, err := q.ListServers(ctx)
+	if err != nil {
+		return fmt.Errorf("listing foreign servers: %w", err)
+	}
+	for _, s := range servers {
+		if foreignServerRegexp.MatchString(s) {
+			plan.Servers = append(plan.Servers, s)
+		}
+	}
+	migrated, err := cuc.staleMigratedFile(ctx, q)
+	if err != nil {
+		return fmt.Errorf("examining .migrated file: %w", err)
+	}
+	if migrated != "" {
+		plan.Files = append(plan.Files, migrated)
+	}
+	staged, err := cuc.stalePasswordFiles(ctx)
+	if err != nil {
+		return fmt.Errorf("examining temporary passwords: %w", err)
+	}
+	plan.Files = append(plan.Files, staged...)
+	return nil
+}
+
+// staleMigratedFile returns the path of the `.migrated` file if it
+// exists and may be removed, or an empty string if it does not exist or
+// describes another database. If it describes the cleaned database and
+// its main schema is filled, an error wrapping ErrResumableMigration
+// will be returned.
+func (cuc *CleanupUseCase) staleMigratedFile(
+	ctx context.Context, q repo.SchemaQueryer,
+) (string, error) {
+	path := cuc.targetCfgPath + ".migrated"
+	_, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "", nil
+	case err != nil:
+		return "", err
+	}
+	ms, err := cuc.loader(ctx, path)
+	if err != nil {
+		return "", fmt.Errorf("loading %q: %w", path, err)
+	}
+	n1, h1, p1 := ms.ConnectionInfo()
+	n2, h2, p2 := cuc.settings.ConnectionInfo()
+	if n1 != n2 || h1 != h2 || p1 != p2 {
+		return "", nil
+	}
+	v := ms.SchemaVersion()
+	sn := SchemaName(v[0])
+	if _, err := q.DetectSchemaVersion(ctx, sn, v[0]); err == nil {
+		return "", fmt.Errorf(
+			"%q describes the filled %q schema: %w",
+			path, sn, ErrResumableMigration,
+		)
+	}
+	return path, nil
+}
+
+// stalePasswordFiles returns the paths of the existing temporary
+// passwords files of the admin and normal roles, if both roles can
+// connect to the database using their main passwords. Otherwise, no
+// file is returned. Connections are only probed, so no temporary
+// password is accepted and this method changes no file (as required
+// by the Plan method).
+func (cuc *CleanupUseCase) stalePasswordFiles(
+	ctx context.Context,
+) ([]string, error) {
+	roles := []repo.Role{repo.AdminRole, repo.NormalRole}
+	for _, r := range roles {
+		p, staged, err := cuc.settings.ProbeConnectionPool(ctx, r)
+		if err != nil {
+			return nil, nil // temporary passwords may be required
+		}
+		p.Close()
+		if staged {
+			return nil, nil // temporary passwords are not stale
+		}
+	}
+	var paths []string
+	seen := make(map[string]bool)
+	for _, path := range cuc.settings.StagedPasswordFiles(roles...) {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		_, err := os.Stat(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			continue
+		case err != nil:
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// dropLeftovers drops the schema and then the foreign servers of the
+// `plan` with cascade, using the `q` connection queryer.
+func dropLeftovers(
+	ctx context.Context, q repo.SchemaConnQueryer, plan *CleanupPlan,
+) error {
+	for _, sn := range plan.Schemas {
+		if err := q.DropCascade(ctx, sn); err != nil {
+			return fmt.Errorf("dropping %q schema: %w", sn, err)
+		}
+	}
+	for _, s := range plan.Servers {
+		if err := q.DropServerIfExists(ctx, s); err != nil {
+			return fmt.Errorf(
+				"dropping %q foreign server (with cascade): %w", s, err,
+			)
+		}
+	}
+	return nil
+}
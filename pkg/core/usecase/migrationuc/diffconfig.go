@@ -0,0 +1,299 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrationuc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/momeni/clean-arch/pkg/core/repo"
+	"gopkg.in/yaml.v3"
+)
+
+// ChangeKind indicates how a setting differs between two configuration
+// files, as reported by the DiffConfigUseCase.
+type ChangeKind string
+
+// These constants list the possible kinds of a ConfigChange.
+const (
+	// Added indicates that a setting is only present in the second
+	// configuration file.
+	Added ChangeKind = "+"
+
+	// Removed indicates that a setting is only present in the first
+	// configuration file.
+	Removed ChangeKind = "-"
+
+	// Changed indicates that a setting is present in both files, but
+	// has different values.
+	Changed ChangeKind = "~"
+
+	// LostFirst indicates that a setting of the first configuration
+	// file could not be represented in the common version (because it
+	// had to be migrated downwards), so it is not compared.
+	LostFirst ChangeKind = "!1"
+
+	// LostSecond is similar to LostFirst, but for the second file.
+	LostSecond ChangeKind = "!2"
+)
+
+// ConfigChange describes one difference between two configuration files.
+type ConfigChange struct {
+	// Kind indicates the kind of this difference.
+	Kind ChangeKind
+
+	// Path is the path of the setting (having its yaml keys separated
+	// by a dot character, e.g., "database.port") in the common version.
+	// For the LostFirst and LostSecond kinds, it is the path of the
+	// setting in the version of its own file.
+	Path string
+
+	// Old is the value of the setting in the first file (or the value
+	// which is lost for the LostFirst and LostSecond kinds).
+	Old string
+
+	// New is the value of the setting in the second file (or the value
+	// which was obtained after migrating the lost setting downwards and
+	// upwards again, for the LostFirst and LostSecond kinds). It is
+	// empty if a lost setting was dropped altogether.
+	New string
+}
+
+// MigratorLoader is a version-independent function type which accepts
+// the contents of a configuration file, detects its version, and
+// creates a migrator object for it.
+type MigratorLoader func(data []byte) (repo.Migrator[Settings], error)
+
+// DiffConfigUseCase represents the semantic comparison of configuration
+// files use case. For details, see the NewDiffConfig function.
+type DiffConfigUseCase struct {
+	migrators [2]repo.Migrator[Settings] // first and second configs
+	major     uint                       // common major version
+	loader    MigratorLoader             // for the round-trip checks
+}
+
+// NewDiffConfig creates a new DiffConfigUseCase instance which compares
+// the configuration settings of the `mig1` and `mig2` migrators after
+// migrating both of them to the `major` common major version. If the
+// `major` argument is zero, the newer major version of the two files
+// is used, so no setting needs to be migrated downwards.
+// The `loader` function is used for detection of the lost settings, by
+// loading the downwards migrated settings and migrating them upwards
+// again. See the Diff method for more details.
+//
+// NewDiffConfig only creates the use case object and performs no
+// actual operation, hence, it may not return an error.
+func NewDiffConfig(
+	mig1, mig2 repo.Migrator[Settings],
+	major uint,
+	loader MigratorLoader,
+) *DiffConfigUseCase {
+	if major == 0 {
+		major = max(mig1.MajorVersion(), mig2.MajorVersion())
+	}
+	return &DiffConfigUseCase{
+		migrators: [2]repo.Migrator[Settings]{mig1, mig2},
+		major:     major,
+		loader:    loader,
+	}
+}
+
+// Diff loads both configuration files, migrates them to the common
+// major version (with no database connection and without merging them
+// with any default settings), and compares their settings one by one.
+// The versions settings are reported as they were written in the files
+// (not their migrated values), so the version changes are visible too.
+// Secret settings are not compared because they are not marshalled.
+//
+// If a file had to be migrated downwards, its settings may not have a
+// counterpart in the common version. Those settings are detected by
+// migrating the downwards migrated settings upwards again (to their
+// original major version) and comparing them with the original values.
+// Settings which are dropped or changed during this round-trip are
+// reported as LostFirst or LostSecond changes, before other changes.
+//
+// The returned changes are sorted by their paths (for each kind).
+func (dcuc *DiffConfigUseCase) Diff(
+	ctx context.Context,
+) ([]ConfigChange, error) {
+	var changes []ConfigChange
+	var flats [2]map[string]string
+	for i, mig := range dcuc.migrators {
+		srcMajor := mig.MajorVersion()
+		orig, err := obtainSettler(ctx, mig, srcMajor, srcMajor)
+		if err != nil {
+			return nil, fmt.Errorf("loading config %d: %w", i+1, err)
+		}
+		origFlat, err := flatten(orig)
+		if err != nil {
+			return nil, fmt.Errorf("flattening config %d: %w", i+1, err)
+		}
+		s, err := obtainSettler(ctx, mig, srcMajor, dcuc.major)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"migrating config %d from %d to %d major version: %w",
+				i+1, srcMajor, dcuc.major, err,
+			)
+		}
+		if srcMajor > dcuc.major {
+			lost, err := dcuc.lostSettings(ctx, s, srcMajor, origFlat)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"checking lost settings of config %d: %w", i+1, err,
+				)
+			}
+			kind := LostFirst
+			if i == 1 {
+				kind = LostSecond
+			}
+			for _, p := range sortedKeys(lost) {
+				changes = append(changes, ConfigChange{
+					Kind: kind, Path: p, Old: origFlat[p], New: lost[p],
+				})
+			}
+		}
+		flats[i], err = flatten(s)
+		if err != nil {
+			return nil, fmt.Errorf("flattening config %d: %w", i+1, err)
+		}
+		for p, v := range origFlat {
+			if strings.HasPrefix(p, "versions.") {
+				flats[i][p] = v
+			}
+		}
+	}
+	return append(changes, compare(flats[0], flats[1])...), nil
+}
+
+// lostSettings migrates the `s` settings (which were migrated downwards
+// to the common major version) upwards to their `srcMajor` original
+// major version, and returns those settings of `orig` which are missing
+// or have different values after this round-trip, mapped to their new
+// values (or an empty string if they were missing).
+func (dcuc *DiffConfigUseCase) lostSettings(
+	ctx context.Context,
+	s Settings,
+	srcMajor uint,
+	orig map[string]string,
+) (map[string]string, error) {
+	if dcuc.loader == nil {
+		return nil, errors.New("no migrator loader is provided")
+	}
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling to YAML: %w", err)
+	}
+	mig, err := dcuc.loader(b)
+	if err != nil {
+		return nil, fmt.Errorf("loading migrated settings: %w", err)
+	}
+	back, err := obtainSettler(ctx, mig, dcuc.major, srcMajor)
+	if err != nil {
+		return nil, fmt.Errorf("migrating upwards again: %w", err)
+	}
+	flat, err := flatten(back)
+	if err != nil {
+		return nil, fmt.Errorf("flattening: %w", err)
+	}
+	lost := make(map[string]string)
+	for p, v := range orig {
+		if strings.HasPrefix(p, "versions.") {
+			continue
+		}
+		if v2, found := flat[p]; !found || v2 != v {
+			lost[p] = v2
+		}
+	}
+	return lost, nil
+}
+
+// compare returns the settings which are added, removed, or changed
+// from the `a` flattened settings to the `b` flattened settings.
+func compare(a, b map[string]string) []ConfigChange {
+	paths := sortedKeys(a)
+	for p := range b {
+		if _, found := a[p]; !found {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	var changes []ConfigChange
+	for _, p := range paths {
+		va, inA := a[p]
+		vb, inB := b[p]
+		switch {
+		case !inA:
+			changes = append(changes, ConfigChange{
+				Kind: Added, Path: p, New: vb,
+			})
+		case !inB:
+			changes = append(changes, ConfigChange{
+				Kind: Removed, Path: p, Old: va,
+			})
+		case va != vb:
+			changes = append(changes, ConfigChange{
+				Kind: Changed, Path: p, Old: va, New: vb,
+			})
+		}
+	}
+	return changes
+}
+
+// flatten marshals the `s` settings and returns their scalar values,
+// mapped from their dot separated paths. Sequences are formatted using
+// the YAML flow syntax (e.g., "[1s, 2s]") and are kept as one value,
+// while null values are skipped (as if those settings were missing).
+func flatten(s Settings) (map[string]string, error) {
+	n := &yaml.Node{}
+	if err := n.Encode(s); err != nil {
+		return nil, fmt.Errorf("encoding settings: %w", err)
+	}
+	flat := make(map[string]string)
+	if err := flattenNode(n, "", flat); err != nil {
+		return nil, err
+	}
+	return flat, nil
+}
+
+func flattenNode(n *yaml.Node, prefix string, flat map[string]string) error {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			p := n.Content[i].Value
+			if prefix != "" {
+				p = prefix + "." + p
+			}
+			if err := flattenNode(n.Content[i+1], p, flat); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		seq := *n
+		seq.Style = yaml.FlowStyle
+		b, err := yaml.Marshal(&seq)
+		if err != nil {
+			return fmt.Errorf("marshaling %q sequence: %w", prefix, err)
+		}
+		flat[prefix] = strings.TrimSpace(string(b))
+	default:
+		if n.ShortTag() != "!!null" {
+			flat[prefix] = n.Value
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -49,7 +49,7 @@ func NewInitDB(ss Settings) *InitDBUseCase {
 // relevant tables and filling them with the production suitable data.
 func (iduc *InitDBUseCase) InitProd(ctx context.Context) error {
 	return iduc.initDB(
-		ctx,
+		ctx, JournalInitProd,
 		func(ctx context.Context, si repo.SchemaInitializer) error {
 			return si.InitProdSchema(ctx)
 		},
@@ -70,18 +70,47 @@ func (iduc *InitDBUseCase) InitProd(ctx context.Context) error {
 // relevant tables and filling them with the development suitable data.
 func (iduc *InitDBUseCase) InitDev(ctx context.Context) error {
 	return iduc.initDB(
-		ctx,
+		ctx, JournalInitDev,
 		func(ctx context.Context, si repo.SchemaInitializer) error {
 			return si.InitDevSchema(ctx)
 		},
 	)
 }
 
+// initDB holds the MigrationLock advisory lock in the target database
+// (so concurrent initializations and migrations of that database fail
+// with an error wrapping ErrMigrationLocked instead of racing on its
+// schema and roles) and initializes it by the `dbi` function.
+// Initialization does not write a config file, so no lock file is held.
+//
+// The initialization is recorded as an `op` operation in the schema
+// migrations journal of the created schema (in the same transaction
+// which fills its tables). A failed initialization is recorded in the
+// journal of the existing schema (if any) in a best-effort manner.
 func (iduc *InitDBUseCase) initDB(
 	ctx context.Context,
+	op string,
 	dbi func(ctx context.Context, si repo.SchemaInitializer) error,
 ) error {
-	if err := iduc.dropAndCreateAgain(ctx); err != nil {
+	jr := newJournalRecorder(op, iduc.settings)
+	return withMigrationLock(
+		ctx, iduc.settings, iduc.schemaRepo, nil, false,
+		func(ctx context.Context, _ repo.SchemaConnQueryer) error {
+			err := iduc.initDBLocked(ctx, jr, dbi)
+			return jr.appendFailure(ctx, iduc.settings, err)
+		},
+	)
+}
+
+func (iduc *InitDBUseCase) initDBLocked(
+	ctx context.Context,
+	jr *journalRecorder,
+	dbi func(ctx context.Context, si repo.SchemaInitializer) error,
+) error {
+	err := jr.measure("drop-and-create", func() error {
+		return iduc.dropAndCreateAgain(ctx)
+	})
+	if err != nil {
 		return fmt.Errorf("dropping/recreating schema: %w", err)
 	}
 	p, err := iduc.settings.ConnectionPool(ctx, repo.NormalRole)
@@ -91,21 +120,25 @@ func (iduc *InitDBUseCase) initDB(
 	defer p.Close()
 	err = p.Conn(ctx, func(ctx context.Context, c repo.Conn) error {
 		return c.Tx(ctx, func(ctx context.Context, tx repo.Tx) error {
-			ms, minb, maxb, err := iduc.settings.Serialize()
-			if err != nil {
-				return fmt.Errorf("obtaining mutable settings: %w", err)
-			}
 			si, err := iduc.settings.SchemaInitializer(tx)
 			if err != nil {
 				return fmt.Errorf("creating SchemaInitializer: %w", err)
 			}
-			if err := dbi(ctx, si); err != nil {
+			err = jr.measure("init-schema", func() error {
+				return dbi(ctx, si)
+			})
+			if err != nil {
 				return fmt.Errorf("initializing schema: %w", err)
 			}
-			err = si.PersistSettings(ctx, ms, minb, maxb)
+			err = jr.measure("persist-settings", func() error {
+				return persistSettings(ctx, iduc.settings, si)
+			})
 			if err != nil {
 				return fmt.Errorf("saving mutable settings: %w", err)
 			}
+			if err := jr.append(ctx, si, nil); err != nil {
+				return fmt.Errorf("appending to journal: %w", err)
+			}
 			return nil
 		})
 	})
@@ -0,0 +1,138 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrationuc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/user"
+	"time"
+
+	"github.com/momeni/clean-arch/pkg/core/repo"
+)
+
+// These constants list the operations which are recorded in the schema
+// migrations journal, see repo.JournalEntry.
+const (
+	JournalInitDev  = "init-dev"
+	JournalInitProd = "init-prod"
+	JournalMigrate  = "migrate"
+)
+
+// journalRecorder collects the information of an initialization or
+// migration operation while it is running, so they can be appended to
+// the schema migrations journal at the end of that operation.
+// Methods of a nil journalRecorder only run the given steps, so it can
+// be passed to functions which are also used without journaling.
+type journalRecorder struct {
+	entry repo.JournalEntry
+}
+
+// newJournalRecorder creates a journalRecorder for the `op` operation,
+// recording the current time as its start time and the current OS user
+// name. The destination versions are taken from the `ss` settings.
+func newJournalRecorder(op string, ss Settings) *journalRecorder {
+	return &journalRecorder{
+		entry: repo.JournalEntry{
+			Operation:        op,
+			DstConfigVersion: ss.Version(),
+			DstSchemaVersion: ss.SchemaVersion(),
+			OSUser:           osUserName(),
+			StartedAt:        time.Now(),
+		},
+	}
+}
+
+// measure calls `f` and records its duration as the `name` step.
+func (jr *journalRecorder) measure(name string, f func() error) error {
+	start := time.Now()
+	err := f()
+	if jr != nil {
+		jr.entry.Steps = append(jr.entry.Steps, repo.JournalStep{
+			Name:    name,
+			Seconds: time.Since(start).Seconds(),
+		})
+	}
+	return err
+}
+
+// append records the end of the operation and appends the collected
+// entry to the journal using the `ja` appender. A non-nil `opErr`
+// marks the operation as failed, recording its error message.
+func (jr *journalRecorder) append(
+	ctx context.Context, ja repo.JournalAppender, opErr error,
+) error {
+	if jr == nil {
+		return nil
+	}
+	e := jr.entry
+	e.FinishedAt = time.Now()
+	if opErr != nil {
+		e.Error = opErr.Error()
+	}
+	return ja.AppendJournal(ctx, &e)
+}
+
+// appendFailure tries to record the `opErr` failure of the operation
+// in the journal of the database which is described by `ss` settings,
+// in a new transaction of the normal role. Failures often leave no
+// journal behind (e.g., because their transaction is rolled back), so
+// this is a best-effort recording and its own errors are ignored (in
+// favor of the `opErr` which is returned as is).
+func (jr *journalRecorder) appendFailure(
+	ctx context.Context, ss Settings, opErr error,
+) error {
+	if jr == nil || opErr == nil {
+		return opErr
+	}
+	p, err := ss.ConnectionPool(ctx, repo.NormalRole)
+	if err != nil {
+		return opErr
+	}
+	defer p.Close()
+	_ = p.Conn(ctx, func(ctx context.Context, c repo.Conn) error {
+		return c.Tx(ctx, func(ctx context.Context, tx repo.Tx) error {
+			sp, err := ss.SettingsPersister(tx)
+			if err != nil {
+				return err
+			}
+			ja, ok := sp.(repo.JournalAppender)
+			if !ok {
+				return errors.New("journal is not supported")
+			}
+			return jr.append(ctx, ja, opErr)
+		})
+	})
+	return opErr
+}
+
+// appendIfExists appends the collected entry as a successful operation
+// using `sp` if it implements the repo.JournalAppender interface and
+// the journal table exists.
+func (jr *journalRecorder) appendIfExists(
+	ctx context.Context, sp repo.SettingsPersister,
+) error {
+	ja, ok := sp.(repo.JournalAppender)
+	if !ok {
+		return nil
+	}
+	err := jr.append(ctx, ja, nil)
+	if err != nil && !errors.Is(err, repo.ErrJournalNotFound) {
+		return fmt.Errorf("appending to journal: %w", err)
+	}
+	return nil
+}
+
+// osUserName returns the name of the current operating system user, or
+// an empty string if it cannot be found.
+func osUserName() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
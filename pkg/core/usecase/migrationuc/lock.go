@@ -0,0 +1,224 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrationuc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/momeni/clean-arch/pkg/core/proc"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+)
+
+// MigrationLock is the name of the advisory lock which is held in the
+// destination database by the use cases which create or drop schema
+// and foreign servers of a migration, so they may not run concurrently.
+const MigrationLock = "caweb-migration"
+
+// ErrMigrationLocked indicates that the MigrationLock advisory lock is
+// held by another connection (e.g., because a migration is running) or
+// the lock file of a target config file is held by another process.
+// The wrapping errors name the held lock and its holders.
+var ErrMigrationLocked = errors.New("migration is locked")
+
+// lockFile describes the lock file which is held next to a target
+// config file, see the acquire method.
+type lockFile struct {
+	path    string       // lock file path
+	checker proc.Checker // checks the holder process, may be nil
+}
+
+// withMigrationLock connects to the database which is described by the
+// `ss` settings using the admin role, acquires the MigrationLock in that
+// connection, and calls `f` while holding the lock. The `q` connection
+// queryer (which is created by `schemaRepo`) is passed to `f`, so it may
+// use the same connection too. The lock is released after `f` returns.
+// If the lock is held by another connection, `f` will not be called and
+// an error wrapping ErrMigrationLocked (and naming the holder sessions)
+// will be returned.
+//
+// If `lf` is not nil, its lock file is acquired (see lockFile.acquire)
+// before connecting to the database and is released after releasing the
+// MigrationLock. It protects the files which are written next to a
+// target config file (e.g., the .migrated file) from concurrent
+// processes, even if they use distinct databases.
+//
+// If `probe` is true, the admin role connects using the
+// ProbeConnectionPool method of `ss`, so its temporary password is not
+// accepted and no passwords file is changed.
+func withMigrationLock(
+	ctx context.Context,
+	ss SchemaSettings,
+	schemaRepo repo.Schema,
+	lf *lockFile,
+	probe bool,
+	f func(ctx context.Context, q repo.SchemaConnQueryer) error,
+) (err error) {
+	if lf != nil {
+		release, err := lf.acquire()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err2 := release(); err2 != nil {
+				err = errors.Join(err, fmt.Errorf(
+					"releasing %q lock file: %w", lf.path, err2,
+				))
+			}
+		}()
+	}
+	var p repo.Pool
+	if probe {
+		p, _, err = ss.ProbeConnectionPool(ctx, repo.AdminRole)
+	} else {
+		p, err = ss.ConnectionPool(ctx, repo.AdminRole)
+	}
+	if err != nil {
+		return fmt.Errorf("creating DB pool for admin: %w", err)
+	}
+	defer p.Close()
+	return p.Conn(ctx, func(ctx context.Context, c repo.Conn) (err error) {
+		q := schemaRepo.Conn(c)
+		locked, err := q.TryLock(ctx, MigrationLock)
+		switch {
+		case err != nil:
+			return fmt.Errorf("acquiring %q lock: %w", MigrationLock, err)
+		case !locked:
+			return lockedError(ctx, q)
+		}
+		defer func() {
+			if err2 := q.Unlock(ctx, MigrationLock); err2 != nil {
+				err = errors.Join(err, fmt.Errorf(
+					"releasing %q lock: %w", MigrationLock, err2,
+				))
+			}
+		}()
+		return f(ctx, q)
+	})
+}
+
+// lockedError creates an error wrapping ErrMigrationLocked which names
+// the sessions holding the MigrationLock, as listed by the `q` queryer.
+func lockedError(ctx context.Context, q repo.SchemaConnQueryer) error {
+	lhs, err := q.LockHolders(ctx, MigrationLock)
+	if err != nil {
+		return errors.Join(
+			fmt.Errorf(
+				"%q advisory lock is held by another session: %w",
+				MigrationLock, ErrMigrationLocked,
+			),
+			fmt.Errorf("listing lock holders: %w", err),
+		)
+	}
+	if len(lhs) == 0 {
+		return fmt.Errorf(
+			"%q advisory lock is held by another session: %w",
+			MigrationLock, ErrMigrationLocked,
+		)
+	}
+	holders := make([]string, len(lhs))
+	for i, lh := range lhs {
+		holders[i] = lh.String()
+	}
+	return fmt.Errorf(
+		"%q advisory lock is held by %q: %w",
+		MigrationLock, holders, ErrMigrationLocked,
+	)
+}
+
+// acquire creates the `lf.path` lock file exclusively and writes the
+// current process information (as a JSON serialized repo.LockHolder)
+// in it. The returned release function removes that file.
+//
+// If the lock file exists, it is read and an error wrapping the
+// ErrMigrationLocked (and naming its holder) will be returned. However,
+// if its holder process was running on this host and `lf.checker`
+// reports that it is not running anymore (e.g., it was killed), the
+// lock file is stale and it will be removed and created again. Lock
+// files of crashed processes of other hosts (or all lock files if
+// `lf.checker` is nil) are not removed automatically (and must be
+// removed manually).
+func (lf *lockFile) acquire() (release func() error, err error) {
+	path := lf.path
+	lh := currentLockHolder()
+	b, err := json.Marshal(lh)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling lock holder: %w", err)
+	}
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, err = f.Write(b)
+			err = errors.Join(err, f.Close())
+			if err != nil {
+				_ = os.Remove(path)
+				return nil, fmt.Errorf("writing %q lock file: %w", path, err)
+			}
+			return func() error {
+				return os.Remove(path)
+			}, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("creating %q lock file: %w", path, err)
+		}
+		holder, err := readLockFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && attempt == 0:
+			continue // released concurrently
+		case err != nil:
+			return nil, err
+		case attempt == 0 && lf.checker != nil &&
+			holder.Host == lh.Host && !lf.checker.IsRunning(holder.PID):
+			if err := os.Remove(path); err != nil {
+				return nil, fmt.Errorf(
+					"removing stale %q lock file: %w", path, err,
+				)
+			}
+			continue
+		}
+		return nil, fmt.Errorf(
+			"%q lock file is held by %s (remove it if that process "+
+				"is not running): %w",
+			path, holder, ErrMigrationLocked,
+		)
+	}
+}
+
+// readLockFile reads the `path` lock file and returns its holder.
+func readLockFile(path string) (*repo.LockHolder, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %q lock file: %w", path, err)
+	}
+	lh := &repo.LockHolder{}
+	if err := json.Unmarshal(b, lh); err != nil {
+		return nil, fmt.Errorf("parsing %q lock file: %w", path, err)
+	}
+	return lh, nil
+}
+
+// currentLockHolder describes the current process as a lock holder.
+// Unknown fields (e.g., if the host name cannot be found) are left
+// empty.
+func currentLockHolder() repo.LockHolder {
+	lh := repo.LockHolder{
+		PID:   os.Getpid(),
+		User:  osUserName(),
+		Since: time.Now(),
+	}
+	if h, err := os.Hostname(); err == nil {
+		lh.Host = h
+	}
+	if exe, err := os.Executable(); err == nil {
+		lh.Application = exe
+	}
+	return lh
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrationuc
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+	"gopkg.in/yaml.v3"
+)
+
+// MigrateConfigUseCase represents the offline configuration file
+// migration use case. In contrast to the MigrateDBUseCase, it only
+// converts the format of a configuration file from one version to
+// another version and does not connect to any database. Therefore, the
+// database settings (and the mutable settings which are stored in the
+// database) are kept as they are written in the source file.
+// For details, see the NewMigrateConfig function.
+type MigrateConfigUseCase struct {
+	migrator      repo.Migrator[Settings] // source configs migrator
+	dstVer        model.SemVer            // target config version
+	refSettings   Settings                // optional target defaults
+	targetCfgPath string                  // target config file path
+}
+
+// NewMigrateConfig creates a new MigrateConfigUseCase instance which
+// uses the `mig` migrator in order to load the source configuration
+// file settings and migrate them upwards or downwards until they match
+// with the `dstVer` format version. The optional `refSettings` (which
+// may be nil) should have the `dstVer` version too. If it is given,
+// the migrated settings are merged with it (using the MergeSettings
+// method), so settings which had no counterpart in the source version
+// take their default values from `refSettings` (see the Settings
+// interface for the settings which are taken unconditionally).
+// Otherwise, the migrated settings are written as they are and their
+// comments are carried from the source file (for those settings which
+// kept their yaml keys).
+//
+// The `targetCfgPath` indicates the path which should be written. It
+// is written atomically by writing a `targetCfgPath + ".migrated"` file
+// and moving it over the `targetCfgPath` file.
+//
+// NewMigrateConfig only creates the migrator and performs no actual
+// operation, hence, it may not return an error.
+func NewMigrateConfig(
+	mig repo.Migrator[Settings],
+	dstVer model.SemVer,
+	refSettings Settings,
+	targetCfgPath string,
+) *MigrateConfigUseCase {
+	return &MigrateConfigUseCase{
+		migrator:      mig,
+		dstVer:        dstVer,
+		refSettings:   refSettings,
+		targetCfgPath: targetCfgPath,
+	}
+}
+
+// Migrate loads the source configuration settings, migrates them to the
+// target major version (one major version at a time), merges them with
+// the reference settings (if any), and writes them into the target
+// configuration file. Since the migrators settle on the latest known
+// minor and patch versions of the target major version, `mcuc.dstVer`
+// must be equal to that version (and to the reference settings version
+// when it is given), otherwise, an error will be returned.
+func (mcuc *MigrateConfigUseCase) Migrate(ctx context.Context) error {
+	mig := mcuc.migrator
+	srcMajorVer := mig.MajorVersion()
+	ss, err := obtainSettler(ctx, mig, srcMajorVer, mcuc.dstVer[0])
+	if err != nil {
+		return fmt.Errorf(
+			"migrating from %d to %d major version: %w",
+			srcMajorVer, mcuc.dstVer[0], err,
+		)
+	}
+	ts := ss.Clone()
+	if rs := mcuc.refSettings; rs != nil {
+		if v := rs.Version(); v != mcuc.dstVer {
+			return fmt.Errorf(
+				"reference settings version is %s instead of %s",
+				v.String(), mcuc.dstVer.String(),
+			)
+		}
+		if err := ts.MergeSettings(ctx, rs); err != nil {
+			return fmt.Errorf("merging reference settings: %w", err)
+		}
+	}
+	if v := ts.Version(); v != mcuc.dstVer {
+		return fmt.Errorf(
+			"settings are migrated to %s, but %s was asked",
+			v.String(), mcuc.dstVer.String(),
+		)
+	}
+	b, err := yaml.Marshal(ts)
+	if err != nil {
+		return fmt.Errorf("marshaling to YAML: %w", err)
+	}
+	p := mcuc.targetCfgPath + ".migrated"
+	if err := os.WriteFile(p, b, 0o644); err != nil {
+		return fmt.Errorf("writing to %q file: %w", p, err)
+	}
+	if err := os.Rename(p, mcuc.targetCfgPath); err != nil {
+		return fmt.Errorf("moving %q file: %w", p, err)
+	}
+	return nil
+}
@@ -12,7 +12,9 @@ import (
 	"os"
 
 	"github.com/momeni/clean-arch/pkg/core/cerr"
+	"github.com/momeni/clean-arch/pkg/core/log"
 	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/momeni/clean-arch/pkg/core/proc"
 	"github.com/momeni/clean-arch/pkg/core/repo"
 	"gopkg.in/yaml.v3"
 )
@@ -33,6 +35,11 @@ type MigrateDBUseCase struct {
 	// match with the major version of the dstSettings field.
 	srcSettings Settings
 
+	// srcConfigVersion is the version of the source settings before
+	// their migration, as captured by the migrateSettings method while
+	// loading them. It is nil if they were not loaded.
+	srcConfigVersion *model.SemVer
+
 	// targetSettings is a clone of srcSettings where some of its
 	// entires are overridden by the dstSettings and should be written
 	// into targetCfgPath file (and parts of it may be required to be
@@ -48,6 +55,28 @@ type MigrateDBUseCase struct {
 	// database), so it can be completed by dropping extra schema and
 	// moving the target configuration file.
 	loader ConfigFileLoader
+
+	// verifyReportPath is the path of the data verification report
+	// file. An empty string disables the verification, see the
+	// EnableVerification method.
+	verifyReportPath string
+
+	// journal collects the schema migrations journal entry of the
+	// running migration, see the Migrate method.
+	journal *journalRecorder
+
+	// load specifies how the source database schema is loaded into
+	// the destination database, see the SetLoadMode and SetFetchSize
+	// methods.
+	load repo.LoadOptions
+
+	// batchSize and parallelism configure the batched settlement, see
+	// the EnableBatching method. A zero batchSize disables it.
+	batchSize, parallelism int
+
+	// procChecker detects the stale lock files of crashed processes,
+	// see the SetProcChecker method. It may be nil.
+	procChecker proc.Checker
 }
 
 // ConfigFileLoader is a version-independent function type which accepts
@@ -93,6 +122,9 @@ type ConfigFileLoader func(
 // can conclude that database is filled by a previous migration attempt
 // and we can resume it from the commitment step.
 //
+// The source schema is loaded using the repo.AutoLoad mode by default,
+// see the SetLoadMode method.
+//
 // NewMigrateDB only creates the migrator and performs no actual
 // operation, hence, it may not return an error.
 func NewMigrateDB(
@@ -107,17 +139,95 @@ func NewMigrateDB(
 		targetCfgPath: targetCfgPath,
 		schemaRepo:    dstSettings.NewSchemaRepo(),
 		loader:        loader,
+		load:          repo.LoadOptions{Mode: repo.AutoLoad},
 	}
 }
 
+// SetLoadMode sets the `lm` load mode which selects how the source
+// database schema is loaded into the destination database. The
+// repo.FDWLoad requires the postgres_fdw extension and a network path
+// from the destination database server to the source server, while
+// the repo.CopyLoad streams the source tables through this process.
+// The repo.AutoLoad (which is the default mode) tries the postgres_fdw
+// and falls back to the COPY streaming if it is not usable.
+// The mduc itself is returned for chaining.
+func (mduc *MigrateDBUseCase) SetLoadMode(
+	lm repo.LoadMode,
+) *MigrateDBUseCase {
+	mduc.load.Mode = lm
+	return mduc
+}
+
+// SetProcChecker sets the `pc` processes checker which is used for
+// detecting a stale `.lock` file of the target config file (i.e., a lock
+// file which was left by a crashed process of the current host), so it
+// may be removed and acquired again. Without a checker (which is the
+// default), the holder process of a lock file is assumed to be running.
+// The mduc itself is returned for chaining.
+func (mduc *MigrateDBUseCase) SetProcChecker(
+	pc proc.Checker,
+) *MigrateDBUseCase {
+	mduc.procChecker = pc
+	return mduc
+}
+
+// SetFetchSize sets the number of rows which are fetched in each round
+// trip from the source database when it is loaded using postgres_fdw
+// (see the SetLoadMode method). Larger values reduce the round trips
+// while reading large tables, in exchange for more memory. Zero keeps
+// the postgres_fdw default value. The mduc itself is returned for
+// chaining.
+func (mduc *MigrateDBUseCase) SetFetchSize(n int) *MigrateDBUseCase {
+	mduc.load.FetchSize = n
+	return mduc
+}
+
 // Migrate runs the configuration settings and database schema migration
 // determining the migration direction by the settings versions and
 // schema versions as recorded in the source and destination configs.
+//
+// The MigrationLock advisory lock is held in the destination database
+// (using the admin role) during the migration, so a concurrent migration
+// or cleanup of that database fails instead of racing on its schema
+// and foreign servers. Also, the `targetCfgPath + ".lock"` lock file is
+// held, so concurrent migrations of the same target config file fail
+// instead of racing on its `.migrated` file (even if they use distinct
+// destination databases). If either lock is held by another process, an
+// error wrapping ErrMigrationLocked (and naming the holder) is returned.
+//
+// The migration is recorded in the schema migrations journal of the
+// destination schema, alongside the migrated tables (or the migrated
+// settings for a uni-database migration, if that database has a journal)
+// and in the same transaction. The journal of the source database is
+// carried by the settlement, so it keeps the history of the database.
+// A failed migration is recorded in the journal of the destination
+// database (if it has one) in a best-effort manner.
 func (mduc *MigrateDBUseCase) Migrate(ctx context.Context) error {
-	resumed, err := mduc.migrateSettings(ctx)
+	mduc.journal = newJournalRecorder(JournalMigrate, mduc.dstSettings)
+	return withMigrationLock(
+		ctx, mduc.dstSettings, mduc.schemaRepo,
+		&lockFile{mduc.targetCfgPath + ".lock", mduc.procChecker}, false,
+		func(ctx context.Context, _ repo.SchemaConnQueryer) error {
+			err := mduc.migrate(ctx)
+			return mduc.journal.appendFailure(ctx, mduc.dstSettings, err)
+		},
+	)
+}
+
+func (mduc *MigrateDBUseCase) migrate(ctx context.Context) error {
+	var resumed bool
+	err := mduc.journal.measure("migrate-settings", func() (err error) {
+		resumed, err = mduc.migrateSettings(ctx)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("migrating settings: %w", err)
 	}
+	if !resumed && mduc.journal != nil {
+		sv := mduc.srcSettings.SchemaVersion()
+		mduc.journal.entry.SrcSchemaVersion = &sv
+		mduc.journal.entry.SrcConfigVersion = mduc.srcConfigVersion
+	}
 	if !resumed {
 		if err := mduc.migrateDBAndSaveConfigFile(ctx); err != nil {
 			return fmt.Errorf("migrating db & saving settings: %w", err)
@@ -153,14 +263,17 @@ func (mduc *MigrateDBUseCase) Migrate(ctx context.Context) error {
 // the target mutable settings in the database too. That is, resumed
 // boolean informs caller that only the configuration file commitment
 // step is remaining for completion of the migration.
+//
+// The `opts` options are passed to the obtainSettler function, e.g.,
+// the withProbing option may be used in order to plan a migration.
 func (mduc *MigrateDBUseCase) migrateSettings(
-	ctx context.Context,
+	ctx context.Context, opts ...settlerOption,
 ) (resumed bool, err error) {
 	mig := mduc.migrator
 	srcMajorVer := mig.MajorVersion()
 	dstCfgVer := mduc.dstSettings.Version()
 	dstMajorVer := dstCfgVer[0]
-	ss, err := obtainSettler(ctx, mig, srcMajorVer, dstMajorVer)
+	ss, err := obtainSettler(ctx, mig, srcMajorVer, dstMajorVer, opts...)
 	if err != nil {
 		var msve *cerr.MismatchingSemVerError
 		if ss == nil || !errors.As(err, &msve) {
@@ -179,6 +292,12 @@ func (mduc *MigrateDBUseCase) migrateSettings(
 		return true, nil
 	}
 	mduc.srcSettings = ss
+	// mig is loaded by obtainSettler, so its Settler method only wraps
+	// the loaded source settings (without loading them again)
+	if orig, err := mig.Settler(ctx); err == nil {
+		v := orig.Version()
+		mduc.srcConfigVersion = &v
+	}
 	ts := ss.Clone()
 	if err := ts.MergeSettings(ctx, mduc.dstSettings); err != nil {
 		return false, fmt.Errorf("merging src/dst settings: %w", err)
@@ -275,13 +394,28 @@ func (mduc *MigrateDBUseCase) resumeUniDBMigration(
 // method which performs its settlement operation by creating the
 // relevant tables and filling them using the views of other schema
 // versions.
+//
+// The durations of the loading, migrator creation, major version
+// migration, and settler creation steps are recorded by the journal
+// recorder which may be passed by the withJournal option.
 func obtainSettler[S any](
 	ctx context.Context,
 	mig repo.Migrator[S],
 	srcMajorVer, dstMajorVer uint,
+	opts ...settlerOption,
 ) (S, error) {
+	var o settlerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	jr := o.journal
 	var snil S
-	if err := mig.Load(ctx); err != nil {
+	if err := jr.measure("load", func() error {
+		if pr, ok := mig.(repo.Prober); ok && o.probe {
+			return pr.Probe(ctx)
+		}
+		return mig.Load(ctx)
+	}); err != nil {
 		// If the Load method could succeed partially, the Settler
 		// method may return a settler object (and a nil error), so
 		// it can be returned alongside the wrapped err.
@@ -292,12 +426,20 @@ func obtainSettler[S any](
 		return snil, fmt.Errorf("Load(): %w", err)
 	}
 	if srcMajorVer > dstMajorVer {
-		dm, err := mig.DownMigrator(ctx)
+		var dm repo.DownMigrator[S]
+		err := jr.measure("down-migrator", func() (err error) {
+			dm, err = mig.DownMigrator(ctx)
+			return err
+		})
 		if err != nil {
 			return snil, fmt.Errorf("DownMigrator(): %w", err)
 		}
 		for srcMajorVer != dstMajorVer {
-			dm, err = dm.MigrateDown(ctx)
+			step := fmt.Sprintf("migrate-down-from-%d", srcMajorVer)
+			err = jr.measure(step, func() (err error) {
+				dm, err = dm.MigrateDown(ctx)
+				return err
+			})
 			if err != nil {
 				return snil, fmt.Errorf(
 					"migrating downwards from major version %d: %w",
@@ -306,14 +448,22 @@ func obtainSettler[S any](
 			}
 			srcMajorVer--
 		}
-		return dm.Settler(), nil
+		return settle(dm, jr), nil
 	}
-	um, err := mig.UpMigrator(ctx)
+	var um repo.UpMigrator[S]
+	err := jr.measure("up-migrator", func() (err error) {
+		um, err = mig.UpMigrator(ctx)
+		return err
+	})
 	if err != nil {
 		return snil, fmt.Errorf("UpMigrator(): %w", err)
 	}
 	for srcMajorVer != dstMajorVer {
-		um, err = um.MigrateUp(ctx)
+		step := fmt.Sprintf("migrate-up-from-%d", srcMajorVer)
+		err = jr.measure(step, func() (err error) {
+			um, err = um.MigrateUp(ctx)
+			return err
+		})
 		if err != nil {
 			return snil, fmt.Errorf(
 				"migrating upwards from major version %d: %w",
@@ -322,7 +472,43 @@ func obtainSettler[S any](
 		}
 		srcMajorVer++
 	}
-	return um.Settler(), nil
+	return settle(um, jr), nil
+}
+
+// settlerOptions holds the optional arguments of obtainSettler.
+type settlerOptions struct {
+	journal *journalRecorder // records the steps durations, or nil
+	probe   bool             // loads the migrator using repo.Prober
+}
+
+// settlerOption customizes the obtainSettler function.
+type settlerOption func(o *settlerOptions)
+
+// withJournal asks obtainSettler to record the durations of its steps
+// by the `jr` journal recorder.
+func withJournal(jr *journalRecorder) settlerOption {
+	return func(o *settlerOptions) {
+		o.journal = jr
+	}
+}
+
+// withProbing asks obtainSettler to load the migrator using its Probe
+// method (if it implements the repo.Prober interface) instead of its
+// Load method, so no file is changed while loading it.
+func withProbing() settlerOption {
+	return func(o *settlerOptions) {
+		o.probe = true
+	}
+}
+
+// settle obtains the settler object of the `sg` migrator, recording the
+// duration of this step by the `jr` journal recorder (which may be nil).
+func settle[S any](sg repo.Settler[S], jr *journalRecorder) (s S) {
+	_ = jr.measure("settler", func() error {
+		s = sg.Settler()
+		return nil
+	})
+	return s
 }
 
 // migrateDBAndSaveConfigFile checks the source and destination database
@@ -362,7 +548,7 @@ func (mduc *MigrateDBUseCase) migrateDBAndSaveConfigFile(
 		return nil
 	}
 	serverName := ForeignServerName(srcVer[0], srcVer[1])
-	schemaNames := listSchemaNames(srcVer, dstVer)
+	schemaNames := listSchemaNames(srcVer, dstVer, mduc.batchSize > 0)
 	if err := mduc.migrateDB(ctx, serverName, schemaNames); err != nil {
 		return fmt.Errorf("migrating database schema: %w", err)
 	}
@@ -377,9 +563,10 @@ func (mduc *MigrateDBUseCase) migrateDBAndSaveConfigFile(
 }
 
 // persistSettingsInDBAndFile examines the mduc.targetSettings instance,
-// serializes its mutable settings, and uses the persister argument for
-// persisting it in the database before trying to save the complete
-// mduc.targetSettings in a configuration file.
+// serializes the mutable settings of all of its components, and uses
+// the persister argument for persisting them in the database before
+// trying to save the complete mduc.targetSettings in a configuration
+// file.
 // If the persister argument is nil, a new connection will be
 // established (using the repo.NormalRole) and a new persister object
 // will be created using the mduc.targetSettings.SettingsPersister
@@ -388,13 +575,15 @@ func (mduc *MigrateDBUseCase) persistSettingsInDBAndFile(
 	ctx context.Context, persister repo.SettingsPersister,
 ) error {
 	if persister != nil {
-		ms, minb, maxb, err := mduc.targetSettings.Serialize()
+		err := mduc.journal.measure("persist-settings", func() error {
+			return persistSettings(ctx, mduc.targetSettings, persister)
+		})
 		if err != nil {
-			return fmt.Errorf("serializing target settings: %w", err)
+			return fmt.Errorf("saving mutable settings in DB: %w", err)
 		}
-		err = persister.PersistSettings(ctx, ms, minb, maxb)
+		err = mduc.journal.appendIfExists(ctx, persister)
 		if err != nil {
-			return fmt.Errorf("saving mutable settings in DB: %w", err)
+			return err
 		}
 		err = mduc.saveTargetSettings()
 		if err != nil {
@@ -428,12 +617,13 @@ func (mduc *MigrateDBUseCase) persistSettingsInDBAndFile(
 // that they either did not exist or were empty and so we are not going
 // to overwrite some non-empty database contents mistakenly, and
 // creates those schema in their usage order. If the database schema
-// could be renewed successfully, fillMigPathSchema method will be used
-// for filling them and performing the actual database migration. If
-// renewal failed, we may have a committed destination database from an
-// old migration attempt. This scenario is verified by calling the
-// loadTargetSettings and comparing the .migrated file contents with
-// the dstSettings. Anyways, in absence of errors, this function ensures
+// could be renewed successfully, fillMigPathSchema method (or its
+// fillMigPathSchemaBatched counterpart if the batched settlement is
+// enabled) will be used for filling them and performing the actual
+// database migration. If renewal failed, we may have a committed
+// destination database from an old migration attempt. This scenario is
+// verified by calling the loadTargetSettings and comparing the
+// .migrated file contents with the dstSettings. Anyways, in absence of errors, this function ensures
 // that destination database contents are committed and .migrated file
 // is also written out (before committing the database contents). So,
 // caller is free to dropIntermediateSchema and continue by moving the
@@ -464,6 +654,28 @@ func (mduc *MigrateDBUseCase) migrateDB(
 		}
 		return nil // resuming an old incomplete migration
 	}
+	if mduc.batchSize > 0 {
+		err = mduc.fillMigPathSchemaBatched(ctx)
+		if err == nil {
+			return nil
+		}
+		// The migration views and staged tables were committed, so
+		// they must be dropped in order to allow the next attempts,
+		// even if the failure was caused by the ctx cancellation.
+		intermediate := schemaNames[:len(schemaNames)-1]
+		err2 := mduc.dropIntermediateSchema(
+			context.WithoutCancel(ctx), serverName, intermediate,
+		)
+		if err2 != nil {
+			return fmt.Errorf(
+				"filling migration-path schema in batches: %w, "+
+					"dropping intermediate schema: %w", err, err2,
+			)
+		}
+		return fmt.Errorf(
+			"filling migration-path schema in batches: %w", err,
+		)
+	}
 	err = mduc.fillMigPathSchema(ctx)
 	if err != nil {
 		return fmt.Errorf("filling migration-path schema: %w", err)
@@ -489,6 +701,9 @@ func (mduc *MigrateDBUseCase) migrateDB(
 // privileges are granted to the normal user in addition to the USAGE
 // privilege on the postgres_fdw extension, so the normal user can
 // create a foreign server and fill those schemaNames schema later.
+// The postgres_fdw extension is not installed in the repo.CopyLoad
+// mode and its installation failure is tolerated (skipping the USAGE
+// privilege grant) in the repo.AutoLoad mode.
 // This strategy minimizes queries which must be executed by the admin
 // role in the destination database. The foreign server and its user
 // mapping may be dropped by this method, but are not created here.
@@ -504,7 +719,8 @@ func (mduc *MigrateDBUseCase) dropAndCreateAgain(
 	renewed = true
 	err = p.Conn(ctx, func(ctx context.Context, c repo.Conn) error {
 		q := mduc.schemaRepo.Conn(c)
-		if err := q.InstallFDWExtensionIfMissing(ctx); err != nil {
+		fdw, err := mduc.installFDWExtension(ctx, q)
+		if err != nil {
 			return fmt.Errorf("installing FDW extension: %w", err)
 		}
 		for i := len(schemaNames) - 1; i >= 0; i-- {
@@ -549,10 +765,12 @@ func (mduc *MigrateDBUseCase) dropAndCreateAgain(
 				schemaNames[len(schemaNames)-1], err,
 			)
 		}
-		if err := q.GrantFDWUsage(ctx, repo.NormalRole); err != nil {
-			return fmt.Errorf(
-				"granting FDW USAGE priv to normal role: %w", err,
-			)
+		if fdw {
+			if err := q.GrantFDWUsage(ctx, repo.NormalRole); err != nil {
+				return fmt.Errorf(
+					"granting FDW USAGE priv to normal role: %w", err,
+				)
+			}
 		}
 		return c.Tx(ctx, func(ctx context.Context, tx repo.Tx) error {
 			q := mduc.schemaRepo.Tx(tx)
@@ -577,15 +795,47 @@ func (mduc *MigrateDBUseCase) dropAndCreateAgain(
 	return true, nil
 }
 
+// installFDWExtension installs the postgres_fdw extension (if it is
+// missing) using the `q` admin connection queryer, unless the load mode
+// does not need it. In the repo.AutoLoad mode, the installation error
+// is logged and ignored, so the source schema may be loaded using the
+// COPY statements. The `fdw` return value indicates if the extension
+// is available.
+func (mduc *MigrateDBUseCase) installFDWExtension(
+	ctx context.Context, q repo.SchemaConnQueryer,
+) (fdw bool, err error) {
+	switch mduc.load.Mode {
+	case repo.CopyLoad:
+		return false, nil
+	case repo.AutoLoad:
+		if err = q.InstallFDWExtensionIfMissing(ctx); err != nil {
+			log.Warn(
+				ctx, "postgres_fdw is not available, using COPY",
+				log.Err("err", err),
+			)
+			return false, nil
+		}
+		return true, nil
+	default:
+		if err = q.InstallFDWExtensionIfMissing(ctx); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+}
+
 // fillMigPathSchema creates a connection to the destination database
-// using the repo.NormalRole and in a transaction, creates a foreign
-// server representing the source database via creation of its
-// SchemaMigrator object and calling its Load method. It continues
-// the upwards/downwards migration using the obtainSettler method.
-// Finally, it settles the migration using the SettleSchema method of
-// the obtained SchemaSettler instance. In absence of errors and right
-// before committing the transaction, saveTargetSettings method is used
-// for writing the .migrated file (used for possible resumption).
+// using the repo.NormalRole and in a transaction, loads the source
+// database (e.g., by creating a foreign server which represents it)
+// and continues the upwards/downwards migration using the
+// prepareSettler method. Finally, it settles the migration using the
+// SettleSchema method of the obtained SchemaSettler instance. If the
+// verification is enabled, the settled tables are compared with their
+// sources by the verifySettledSchema method (before persisting the
+// mutable settings which change the settings table). In absence of
+// errors and right before committing the transaction,
+// saveTargetSettings method is used for writing the .migrated file
+// (used for possible resumption).
 func (mduc *MigrateDBUseCase) fillMigPathSchema(
 	ctx context.Context,
 ) error {
@@ -596,25 +846,19 @@ func (mduc *MigrateDBUseCase) fillMigPathSchema(
 	defer p.Close()
 	err = p.Conn(ctx, func(ctx context.Context, c repo.Conn) error {
 		return c.Tx(ctx, func(ctx context.Context, tx repo.Tx) error {
-			smig, err := mduc.srcSettings.SchemaMigrator(tx)
+			ss, err := mduc.prepareSettler(ctx, tx)
 			if err != nil {
-				return fmt.Errorf("creating schema migrator: %w", err)
+				return err
 			}
-			srcMajorVer := smig.MajorVersion()
-			dstSchemaVer := mduc.dstSettings.SchemaVersion()
-			dstMajorVer := dstSchemaVer[0]
-			ss, err := obtainSettler(
-				ctx, smig, srcMajorVer, dstMajorVer,
-			)
+			err = mduc.journal.measure("settle", func() error {
+				return ss.SettleSchema(ctx)
+			})
 			if err != nil {
-				return fmt.Errorf(
-					"migrating from %d to %v major version: %w",
-					srcMajorVer, dstMajorVer, err,
-				)
+				return fmt.Errorf("SettleSchema(): %w", err)
 			}
-			err = ss.SettleSchema(ctx)
+			err = mduc.verifySettledSchema(ctx, ss)
 			if err != nil {
-				return fmt.Errorf("SettleSchema(): %w", err)
+				return fmt.Errorf("verifying migrated data: %w", err)
 			}
 			err = mduc.persistSettingsInDBAndFile(ctx, ss)
 			if err != nil {
@@ -629,6 +873,32 @@ func (mduc *MigrateDBUseCase) fillMigPathSchema(
 	return nil
 }
 
+// prepareSettler creates a schema migrator for the source settings in
+// the `tx` transaction, loads the source database (see SetLoadMode),
+// and migrates it to the destination major version using the
+// obtainSettler function, returning the obtained SchemaSettler.
+func (mduc *MigrateDBUseCase) prepareSettler(
+	ctx context.Context, tx repo.Tx,
+) (repo.SchemaSettler, error) {
+	smig, err := mduc.srcSettings.SchemaMigrator(tx, mduc.load)
+	if err != nil {
+		return nil, fmt.Errorf("creating schema migrator: %w", err)
+	}
+	srcMajorVer := smig.MajorVersion()
+	dstSchemaVer := mduc.dstSettings.SchemaVersion()
+	dstMajorVer := dstSchemaVer[0]
+	ss, err := obtainSettler(
+		ctx, smig, srcMajorVer, dstMajorVer, withJournal(mduc.journal),
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"migrating from %d to %v major version: %w",
+			srcMajorVer, dstMajorVer, err,
+		)
+	}
+	return ss, nil
+}
+
 // dropIntermediateSchema drops schemaNames in the reversed order
 // using the repo.AdminRole from the destination database before
 // dropping the foreign server (if they exist).
@@ -719,8 +989,11 @@ func (mduc *MigrateDBUseCase) commitTargetSettings() error {
 // created in turn for importing source database schema, see the
 // ForeignSchemaName function, followed by intermediate schema for
 // converting the schema format one major version at a time, see
-// the MigrationSchemaName function, terminated by the destination
-// schema name itself, see SchemaName function.
+// the MigrationSchemaName function, followed by the staging schema of
+// the destination major version if the `staged` argument is true (so
+// it is only created by a batched settlement), see the
+// StagingSchemaName function, terminated by the destination schema
+// name itself, see SchemaName function.
 //
 // Migrating upwards may lead to creation of tables and columns which
 // had no corresponding data in previous versions, filled by default
@@ -731,19 +1004,22 @@ func (mduc *MigrateDBUseCase) commitTargetSettings() error {
 // Therefore, although migrating upwards or downwards provides the
 // most accurate information in the target version, but migrating
 // upwards/downwards in a cycle is not supposed to be lossless.
-func listSchemaNames(srcVer, dstVer model.SemVer) []string {
+func listSchemaNames(srcVer, dstVer model.SemVer, staged bool) []string {
 	s, d := srcVer[0], dstVer[0]
 	minor := srcVer[1]
 	step := uint(1)
 	if s > d {
 		step -= 2
 	}
-	names := make([]string, 0, step*(d-s)+3)
+	names := make([]string, 0, step*(d-s)+4)
 	names = append(names, ForeignSchemaName(s, minor))
 	for i := s; i != d; i += step {
 		names = append(names, MigrationSchemaName(i))
 	}
 	names = append(names, MigrationSchemaName(d))
+	if staged {
+		names = append(names, StagingSchemaName(d))
+	}
 	names = append(names, SchemaName(d))
 	return names
 }
@@ -786,3 +1062,14 @@ func ForeignSchemaName(major, minor uint) string {
 func MigrationSchemaName(major uint) string {
 	return fmt.Sprintf("mig%d", major)
 }
+
+// StagingSchemaName returns the schema name which is used for keeping
+// the tables of the destination schema with the given major version
+// while they are filled by a batched settlement (see the
+// EnableBatching method), so the destination schema, which its name is
+// computed by SchemaName function, remains empty until those tables
+// are filled completely and moved into it in one transaction.
+// The staging schema will be dropped at the end.
+func StagingSchemaName(major uint) string {
+	return fmt.Sprintf("stg%d", major)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrationuc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/momeni/clean-arch/pkg/core/repo"
+	"github.com/stretchr/testify/require"
+)
+
+// probingMigrator records if it was loaded by its Load or Probe method.
+// Other methods of the embedded repo.Migrator are not implemented.
+type probingMigrator struct {
+	repo.Migrator[string]
+	calls []string
+}
+
+func (pm *probingMigrator) Load(context.Context) error {
+	pm.calls = append(pm.calls, "load")
+	return nil
+}
+
+func (pm *probingMigrator) Probe(context.Context) error {
+	pm.calls = append(pm.calls, "probe")
+	return nil
+}
+
+func (pm *probingMigrator) UpMigrator(
+	context.Context,
+) (repo.UpMigrator[string], error) {
+	return settledUpMigrator{}, nil
+}
+
+// settledUpMigrator returns a fixed settler at its current version.
+type settledUpMigrator struct {
+	repo.UpMigrator[string]
+}
+
+func (settledUpMigrator) Settler() string {
+	return "settled"
+}
+
+func TestObtainSettlerProbing(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		opts  []settlerOption
+		calls []string
+	}{
+		{"load", nil, []string{"load"}},
+		{"probe", []settlerOption{withProbing()}, []string{"probe"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pm := &probingMigrator{}
+			s, err := obtainSettler[string](
+				context.Background(), pm, 1, 1, tc.opts...,
+			)
+			require.NoError(t, err)
+			require.Equal(t, "settled", s)
+			require.Equal(t, tc.calls, pm.calls)
+		})
+	}
+}
@@ -9,6 +9,8 @@
 // production environment) and MigrateDBUseCase for migrating from a
 // source database to a destination database while converting the schema
 // format from one version to another version (upwards or downwards).
+// The MigrateConfigUseCase converts a configuration file alone, with no
+// database connection, in an offline manner.
 // This package also exposes the Settings and SchemaSettings interfaces
 // which represent the version-independent expectations from any
 // configuration file representation type, so all configuration types
@@ -23,12 +23,14 @@ import (
 	"github.com/momeni/clean-arch/pkg/adapter/config"
 	"github.com/momeni/clean-arch/pkg/adapter/config/cfg1"
 	"github.com/momeni/clean-arch/pkg/adapter/config/cfg2"
+	"github.com/momeni/clean-arch/pkg/adapter/config/cfg3"
 	"github.com/momeni/clean-arch/pkg/adapter/config/settings"
 	"github.com/momeni/clean-arch/pkg/adapter/config/vers"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/sch1v0"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/sch1v1"
 	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/sch1v2"
+	"github.com/momeni/clean-arch/pkg/adapter/db/postgres/migration/sch1v3"
 	"github.com/momeni/clean-arch/pkg/adapter/hash/scram"
 	"github.com/momeni/clean-arch/pkg/core/model"
 	"github.com/momeni/clean-arch/pkg/core/repo"
@@ -112,6 +114,9 @@ func (migucts *MigrationUseCasesTestSuite) TestInitDB(
 	r := require.New(t)
 	dev := strings.HasSuffix(name, "dev")
 	migucts.initDBAndVerifySchema(t, r, s, dev, dbVer)
+	st, err := migrationuc.NewStatus(s).Status(migucts.Ctx)
+	r.NoError(err, "examining status of schema %v", dbVer)
+	r.True(st.Compatible, "initialized schema must match %v", dbVer)
 
 	b, err := yaml.Marshal(s)
 	require.NoError(t, err, "marshaling source settings; v=%v", cfgVer)
@@ -119,47 +124,122 @@ func (migucts *MigrationUseCasesTestSuite) TestInitDB(
 	err = os.WriteFile(srcCfgPath, b, 0o644)
 	require.NoError(t, err, "writing source settings; name=%q", name)
 
-	migucts.visitCfgDBVers(t, name, func(
-		t *testing.T,
-		dstSettings migrationuc.Settings,
-		dstCfgVer, dstDBVer model.SemVer,
-		dstName string,
-	) {
-		t.Parallel()
-		r := require.New(t)
-		mig, err := config.LoadSrcMigrator(srcCfgPath)
-		r.NoError(err, "config.LoadSrcMigrator(%q)", srcCfgPath)
-		targetCfgPath := filepath.Join(migucts.cfgDir, dstName)
-		mduc := migrationuc.NewMigrateDB(
-			mig, dstSettings, targetCfgPath, loader,
-		)
-		err = mduc.Migrate(migucts.Ctx)
-		r.NoError(err, "migrate from schema %v to %v", dbVer, dstDBVer)
-		targetSettings, err := loader(migucts.Ctx, targetCfgPath)
-		r.NoError(err, "loading target settings from %q", targetCfgPath)
-		same, err := migrationuc.HasTheSameConnectionInfo(
-			targetSettings, dstSettings,
-		)
-		r.NoError(
-			err,
-			"target/dst schema (version %v/%v) do not match",
-			targetSettings.SchemaVersion(), dstDBVer,
-		)
-		tin, tih, tip := targetSettings.ConnectionInfo()
-		din, dih, dip := dstSettings.ConnectionInfo()
-		r.True(
-			same,
-			"target (%s:%d/%s) and dst (%s:%d/%s) DBs are different",
-			tih, tip, tin,
-			dih, dip, din,
-		)
-		verifySchema(
-			migucts.Ctx, t, r, targetSettings, dstDBVer,
-			func(ctx context.Context, v schema.Verifier, t *testing.T) {
-				v.VerifySchema(ctx, t)
-			},
-		)
-	})
+	for _, mm := range migrationModes {
+		mm := mm
+		t.Run(mm.name, func(t *testing.T) {
+			t.Parallel()
+			migucts.visitCfgDBVers(t, name+"_"+mm.name, func(
+				t *testing.T,
+				dstSettings migrationuc.Settings,
+				dstCfgVer, dstDBVer model.SemVer,
+				dstName string,
+			) {
+				t.Parallel()
+				migucts.testMigrateDB(
+					t, mm, srcCfgPath, dbVer,
+					dstSettings, dstCfgVer, dstDBVer, dstName,
+				)
+			})
+		})
+	}
+}
+
+// migrationMode describes how a migration is performed by the
+// MigrateDBUseCase, so it can be tested with distinct options.
+type migrationMode struct {
+	name string
+
+	load      repo.LoadMode
+	fetchSize int
+	batchSize int // or zero, disabling the batched settlement
+	parallel  int
+	verify    bool
+}
+
+// migrationModes lists the tested migration modes. The fdw mode is the
+// default migration path which imports the source schema by
+// postgres_fdw and settles it by one transaction. The copy mode copies
+// and settles tables in batches of two rows (so the dev cars need
+// several batches, seeking the copied tables by their primary keys).
+var migrationModes = []migrationMode{
+	{name: "fdw", load: repo.FDWLoad},
+	{
+		name: "copy", load: repo.CopyLoad,
+		batchSize: 2, parallel: 2, verify: true,
+	},
+	{name: "auto", load: repo.AutoLoad, fetchSize: 10, verify: true},
+}
+
+func (migucts *MigrationUseCasesTestSuite) testMigrateDB(
+	t *testing.T,
+	mm migrationMode,
+	srcCfgPath string,
+	dbVer model.SemVer,
+	dstSettings migrationuc.Settings,
+	dstCfgVer, dstDBVer model.SemVer,
+	dstName string,
+) {
+	r := require.New(t)
+	mig, err := config.LoadSrcMigrator(srcCfgPath)
+	r.NoError(err, "config.LoadSrcMigrator(%q)", srcCfgPath)
+	targetCfgPath := filepath.Join(migucts.cfgDir, dstName)
+	mduc := migrationuc.NewMigrateDB(
+		mig, dstSettings, targetCfgPath, loader,
+	).SetLoadMode(mm.load).SetFetchSize(mm.fetchSize)
+	stgSchema := ""
+	if mm.batchSize > 0 {
+		stgSchema = migrationuc.StagingSchemaName(dstDBVer[0])
+		mduc.EnableBatching(mm.batchSize, mm.parallel)
+	}
+	reportPath := targetCfgPath + ".verification.json"
+	if mm.verify {
+		mduc.EnableVerification(reportPath)
+	}
+	plan, err := mduc.Plan(migucts.Ctx)
+	r.NoError(err, "plan from schema %v to %v", dbVer, dstDBVer)
+	r.Equal(mm.load, plan.LoadMode)
+	r.Equal(stgSchema, plan.StagingSchema)
+	r.Equal(dstCfgVer, plan.ConfigVersions[len(plan.ConfigVersions)-1])
+	r.Equal(migrationuc.NoResumption, plan.Resume)
+	r.True(plan.PersistSettings, "mutable settings must be stored")
+	r.NoFileExists(plan.MigratedFile, "plan may not write files")
+	err = mduc.Migrate(migucts.Ctx)
+	r.NoError(err, "migrate from schema %v to %v", dbVer, dstDBVer)
+	if mm.verify {
+		r.FileExists(reportPath, "verification report must be written")
+	} else {
+		r.NoFileExists(reportPath, "verification must be disabled")
+	}
+	r.NoFileExists(targetCfgPath+".lock", "lock file must be released")
+	targetSettings, err := loader(migucts.Ctx, targetCfgPath)
+	r.NoError(err, "loading target settings from %q", targetCfgPath)
+	cuc := migrationuc.NewCleanup(targetSettings, targetCfgPath, loader)
+	leftovers, err := cuc.Plan(migucts.Ctx)
+	r.NoError(err, "finding leftovers of the migration")
+	r.Empty(leftovers.Schemas, "intermediate schema must be dropped")
+	r.Empty(leftovers.Servers, "foreign server must be dropped")
+	same, err := migrationuc.HasTheSameConnectionInfo(
+		targetSettings, dstSettings,
+	)
+	r.NoError(
+		err,
+		"target/dst schema (version %v/%v) do not match",
+		targetSettings.SchemaVersion(), dstDBVer,
+	)
+	tin, tih, tip := targetSettings.ConnectionInfo()
+	din, dih, dip := dstSettings.ConnectionInfo()
+	r.True(
+		same,
+		"target (%s:%d/%s) and dst (%s:%d/%s) DBs are different",
+		tih, tip, tin,
+		dih, dip, din,
+	)
+	verifySchema(
+		migucts.Ctx, t, r, targetSettings, dstDBVer,
+		func(ctx context.Context, v schema.Verifier, t *testing.T) {
+			v.VerifySchema(ctx, t)
+		},
+	)
 }
 
 func loader(
@@ -245,6 +325,7 @@ func (migucts *MigrationUseCasesTestSuite) visitCfgDBVers(
 		{sch1v0.Major, sch1v0.Minor, sch1v0.Patch},
 		{sch1v1.Major, sch1v1.Minor, sch1v1.Patch},
 		{sch1v2.Major, sch1v2.Minor, sch1v2.Patch},
+		{sch1v3.Major, sch1v3.Minor, sch1v3.Patch},
 	} {
 		cfgVer := model.SemVer{cfg1.Major, cfg1.Minor, cfg1.Patch}
 		d, name, rs := migucts.createEmptyDB(a, cfgVer, dbVer, suffix)
@@ -294,6 +375,32 @@ func (migucts *MigrationUseCasesTestSuite) visitCfgDBVers(
 		t.Run(name, func(t *testing.T) {
 			visit(t, s, cfgVer, dbVer, name)
 		})
+
+		cfgVer = model.SemVer{cfg3.Major, cfg3.Minor, cfg3.Patch}
+		d, name, rs = migucts.createEmptyDB(a, cfgVer, dbVer, suffix)
+		c3 := &cfg3.Config{
+			Database: cfg3.Database{
+				Database: cfg1.Database{
+					Host:       "127.0.0.1",
+					Port:       migucts.Port,
+					Name:       name,
+					PassDir:    d,
+					RoleSuffix: rs,
+				},
+			},
+			Vers: vers.Config{
+				Versions: vers.Versions{
+					Database: dbVer,
+					Config:   cfg3.Version,
+				},
+			},
+		}
+		err = c3.ValidateAndNormalize()
+		a.NoError(err, "validating *cfg3.Config instance")
+		s = settings.Adapter[*cfg3.Config, cfg3.Serializable]{c3}
+		t.Run(name, func(t *testing.T) {
+			visit(t, s, cfgVer, dbVer, name)
+		})
 	}
 }
 
@@ -0,0 +1,281 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrationuc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+)
+
+// Resumption indicates how a migration would treat the `.migrated` file
+// of an old incomplete migration attempt, as reported by MigrationPlan.
+type Resumption string
+
+// These constants list the possible resumption states of a migration.
+const (
+	// NoResumption indicates that there is no `.migrated` file, so a
+	// migration starts from scratch.
+	NoResumption Resumption = "none"
+
+	// ResumeIfFilled indicates that a `.migrated` file describes the
+	// destination database. If the destination schema is not empty,
+	// the migration assumes that an old attempt has filled it, so it
+	// skips the database migration and commits that file. Otherwise,
+	// the migration starts from scratch and overwrites that file.
+	ResumeIfFilled Resumption = "if-filled"
+
+	// ResumeCommit indicates that the source and destination databases
+	// are the same and an old attempt has already stored the migrated
+	// settings in that database, so only its `.migrated` file has to be
+	// moved over the target config file.
+	ResumeCommit Resumption = "commit"
+
+	// IrrelevantFile indicates that a `.migrated` file exists, but it
+	// describes another database, so it cannot be resumed. Such a file
+	// is overwritten if the destination database is empty. Otherwise,
+	// the migration fails.
+	IrrelevantFile Resumption = "irrelevant"
+)
+
+// PlannedRole describes a database role which is used by a migration.
+type PlannedRole struct {
+	// Role is the role name (without its possible suffix).
+	Role repo.Role `json:"role"`
+
+	// Create is true if the role is created when it is missing, and is
+	// false if it must exist beforehand.
+	Create bool `json:"create"`
+}
+
+// MigrationPlan describes the steps which a MigrateDBUseCase would take
+// for migrating the configuration settings and database schema, so they
+// may be reviewed before running the actual migration. It is returned
+// by the MigrateDBUseCase.Plan method.
+type MigrationPlan struct {
+	// ConfigVersions lists the configuration settings versions, from
+	// the source config file version to the target config version,
+	// including one version for each traversed major version. It is
+	// empty if only an old attempt has to be committed (see Resume).
+	ConfigVersions []model.SemVer `json:"config_versions,omitempty"`
+
+	// SrcSchemaVersion and DstSchemaVersion are the source and the
+	// destination database schema versions.
+	SrcSchemaVersion model.SemVer `json:"src_schema_version"`
+	DstSchemaVersion model.SemVer `json:"dst_schema_version"`
+
+	// SameDatabase indicates that the source and destination config
+	// files describe the same database, so only the settings (and not
+	// the database schema) are migrated. In this case, the ForeignServer,
+	// ForeignSchema, LoadMode, FetchSize, BatchSize, Parallelism,
+	// MigrationSchemas, StagingSchema, FinalSchema, and Passwords fields
+	// are left empty and Roles only lists the normal role which stores
+	// the mutable settings (see PersistSettings).
+	SameDatabase bool `json:"same_database"`
+
+	// PersistSettings indicates that the mutable settings of the target
+	// settings are stored in the destination database (in the same
+	// transaction which settles the FinalSchema, or using the normal
+	// role for a uni-database migration) before the MigratedFile is
+	// written. It is false if an old attempt has already stored them,
+	// so only the MigratedFile has to be committed (see ResumeCommit).
+	PersistSettings bool `json:"persist_settings"`
+
+	// ForeignServer is the name of the foreign server which represents
+	// the source database within the destination database.
+	ForeignServer string `json:"foreign_server,omitempty"`
+
+	// ForeignSchema is the schema which imports the source tables.
+	ForeignSchema string `json:"foreign_schema,omitempty"`
+
+	// LoadMode describes how the source tables are loaded into the
+	// ForeignSchema, see the MigrateDBUseCase.SetLoadMode method.
+	LoadMode repo.LoadMode `json:"load_mode,omitempty"`
+
+	// FetchSize is the fetch_size option of the foreign server, or zero
+	// if the postgres_fdw default value is kept.
+	FetchSize int `json:"fetch_size,omitempty"`
+
+	// BatchSize and Parallelism describe the batched settlement of the
+	// FinalSchema tables (via the StagingSchema), or they are zero if
+	// all tables are settled by one transaction.
+	// See the MigrateDBUseCase.EnableBatching method.
+	BatchSize   int `json:"batch_size,omitempty"`
+	Parallelism int `json:"parallelism,omitempty"`
+
+	// MigrationSchemas lists the intermediate schema which are filled
+	// (and then dropped) in their usage order.
+	MigrationSchemas []string `json:"migration_schemas,omitempty"`
+
+	// StagingSchema is the schema which keeps the FinalSchema tables
+	// during a batched settlement (and then dropped), or it is empty
+	// if the batched settlement is disabled.
+	StagingSchema string `json:"staging_schema,omitempty"`
+
+	// FinalSchema is the destination schema which is kept.
+	FinalSchema string `json:"final_schema,omitempty"`
+
+	// Roles lists the database roles which are used (or created) by
+	// the migration in the destination database.
+	Roles []PlannedRole `json:"roles,omitempty"`
+
+	// Passwords lists the roles whose passwords are renewed, using the
+	// secret provider of the destination settings.
+	Passwords []repo.Role `json:"passwords,omitempty"`
+
+	// MigratedFile is the path of the `.migrated` file which keeps the
+	// target settings before they are committed, and Resume describes
+	// how an existing `.migrated` file would be treated.
+	MigratedFile string     `json:"migrated_file"`
+	Resume       Resumption `json:"resume"`
+
+	// TargetConfigFile is the config file path which is overwritten at
+	// the end of the migration.
+	TargetConfigFile string `json:"target_config_file"`
+}
+
+// Plan gathers the steps of the migration which would be performed by
+// the Migrate method, without performing any of them. The source
+// settings are loaded (possibly reading the mutable settings from the
+// source database) and migrated in memory, and the `.migrated` file is
+// read if it exists, but no database object or file is created,
+// changed, or removed. For this purpose, the source settings migrator
+// is loaded by its Probe method if it implements the repo.Prober
+// interface, so a temporary password of the source database is not
+// accepted (e.g., the .pgpass.new file is not moved to .pgpass).
+//
+// Since the destination database is not examined, Plan cannot know if
+// it is empty. Therefore, a `.migrated` file which describes the
+// destination database is reported by the ResumeIfFilled resumption.
+func (mduc *MigrateDBUseCase) Plan(
+	ctx context.Context,
+) (*MigrationPlan, error) {
+	plan := &MigrationPlan{
+		MigratedFile:     mduc.targetCfgPath + ".migrated",
+		TargetConfigFile: mduc.targetCfgPath,
+		Resume:           NoResumption,
+	}
+	resumed, err := mduc.migrateSettings(ctx, withProbing())
+	if err != nil {
+		return nil, fmt.Errorf("migrating settings: %w", err)
+	}
+	if resumed {
+		plan.SameDatabase = true
+		plan.Resume = ResumeCommit
+		plan.DstSchemaVersion = mduc.dstSettings.SchemaVersion()
+		plan.SrcSchemaVersion = plan.DstSchemaVersion
+		return plan, nil
+	}
+	if err := mduc.planConfigVersions(ctx, plan); err != nil {
+		return nil, fmt.Errorf("listing config versions: %w", err)
+	}
+	plan.SameDatabase, err = HasTheSameConnectionInfo(
+		mduc.srcSettings, mduc.dstSettings,
+	)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"comparing src/dst connection info: %w", err,
+		)
+	}
+	srcVer := mduc.srcSettings.SchemaVersion()
+	dstVer := mduc.dstSettings.SchemaVersion()
+	plan.SrcSchemaVersion, plan.DstSchemaVersion = srcVer, dstVer
+	plan.PersistSettings = true
+	if plan.SameDatabase {
+		plan.Roles = []PlannedRole{{Role: repo.NormalRole, Create: false}}
+		return plan, nil
+	}
+	staged := mduc.batchSize > 0
+	schemaNames := listSchemaNames(srcVer, dstVer, staged)
+	last := len(schemaNames) - 1
+	plan.ForeignServer = ForeignServerName(srcVer[0], srcVer[1])
+	plan.ForeignSchema = schemaNames[0]
+	plan.LoadMode = mduc.load.Mode
+	plan.FetchSize = mduc.load.FetchSize
+	plan.BatchSize = mduc.batchSize
+	plan.Parallelism = mduc.parallelism
+	plan.MigrationSchemas = schemaNames[1:last]
+	if staged {
+		plan.MigrationSchemas = schemaNames[1 : last-1]
+		plan.StagingSchema = schemaNames[last-1]
+	}
+	plan.FinalSchema = schemaNames[last]
+	plan.Roles = []PlannedRole{
+		{Role: repo.AdminRole, Create: false},
+		{Role: repo.NormalRole, Create: true},
+	}
+	plan.Passwords = []repo.Role{repo.AdminRole, repo.NormalRole}
+	plan.Resume, err = mduc.planResumption(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("examining .migrated file: %w", err)
+	}
+	return plan, nil
+}
+
+// planConfigVersions fills the ConfigVersions of the `plan` by loading
+// the source settings and migrating them one major version at a time
+// towards the destination major version, recording their versions.
+// The target settings version is appended if it differs from the last
+// migrated version (e.g., because of its patch version).
+func (mduc *MigrateDBUseCase) planConfigVersions(
+	ctx context.Context, plan *MigrationPlan,
+) error {
+	mig := mduc.migrator
+	src := mig.MajorVersion()
+	dst := mduc.dstSettings.Version()[0]
+	step := 1
+	if src > dst {
+		step = -1
+	}
+	for m := src; ; m = uint(int(m) + step) {
+		s, err := obtainSettler(ctx, mig, src, m)
+		if err != nil {
+			return fmt.Errorf("migrating to %d major version: %w", m, err)
+		}
+		plan.ConfigVersions = append(plan.ConfigVersions, s.Version())
+		if m == dst {
+			break
+		}
+	}
+	last := plan.ConfigVersions[len(plan.ConfigVersions)-1]
+	if v := mduc.targetSettings.Version(); v != last {
+		plan.ConfigVersions = append(plan.ConfigVersions, v)
+	}
+	return nil
+}
+
+// planResumption reads the `.migrated` file (if it exists) and checks
+// if it describes the destination database, as the migrateDB method
+// does when the destination schema could not be renewed.
+func (mduc *MigrateDBUseCase) planResumption(
+	ctx context.Context,
+) (Resumption, error) {
+	_, err := os.Stat(mduc.targetCfgPath + ".migrated")
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return NoResumption, nil
+	case err != nil:
+		return "", err
+	}
+	ms, err := mduc.loadTargetSettings(ctx)
+	if err != nil {
+		return "", fmt.Errorf("loading .migrated config: %w", err)
+	}
+	sameDBs, err := HasTheSameConnectionInfo(ms, mduc.dstSettings)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("checking .migrated config: %w", err)
+	case !sameDBs:
+		return IrrelevantFile, nil
+	default:
+		return ResumeIfFilled, nil
+	}
+}
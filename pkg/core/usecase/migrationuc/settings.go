@@ -7,6 +7,7 @@ package migrationuc
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/momeni/clean-arch/pkg/core/cerr"
 	"github.com/momeni/clean-arch/pkg/core/model"
@@ -51,6 +52,17 @@ type SchemaSettings interface {
 	// overwritten safely by the subsequent migration operations).
 	ConnectionPool(ctx context.Context, r repo.Role) (repo.Pool, error)
 
+	// ProbeConnectionPool creates a database connection pool similar to
+	// the ConnectionPool method, but it never moves a temporary
+	// passwords file over the main passwords file, so it may be used by
+	// operations which must not change any file (e.g., a cleanup plan).
+	// The staged return value is true if the connection pool could only
+	// be established by a temporary password (which ConnectionPool
+	// would have accepted).
+	ProbeConnectionPool(
+		ctx context.Context, r repo.Role,
+	) (p repo.Pool, staged bool, err error)
+
 	// ConnectionInfo returns the database name, host, and port of the
 	// connection information which are kept in this SchemaSettings
 	// instance. The ConnectionPool method can be used to employ these
@@ -85,7 +97,9 @@ type SchemaSettings interface {
 	// major version, so it can persist the target schema version by
 	// creating tables and filling them with contents of corresponding
 	// views.
-	SchemaMigrator(tx repo.Tx) (
+	// The `lo` argument specifies how the source tables are loaded in
+	// step (1), see repo.LoadOptions.
+	SchemaMigrator(tx repo.Tx, lo repo.LoadOptions) (
 		repo.Migrator[repo.SchemaSettler], error,
 	)
 
@@ -103,6 +117,15 @@ type SchemaSettings interface {
 	// must be performed usually in the same transaction.
 	SettingsPersister(tx repo.Tx) (repo.SettingsPersister, error)
 
+	// SchemaSettler instantiates a repo.SchemaSettler for the database
+	// schema version (see SchemaVersion method), wrapping the given `tx`
+	// transaction argument. In contrast to the settler which is obtained
+	// by migrating a source schema (see SchemaMigrator method), it does
+	// not prepare any migration view, so it may be used only after they
+	// are prepared (and committed) by another transaction. It depends on
+	// the schema major version alone, just like the SettingsPersister.
+	SchemaSettler(tx repo.Tx) (repo.SchemaSettler, error)
+
 	// SchemaInitializer creates a repo.SchemaInitializer instance
 	// which wraps the given transaction argument and can be used to
 	// initialize the database with development or production suitable
@@ -134,6 +157,15 @@ type SchemaSettings interface {
 		roles ...repo.Role,
 	) (finalizer func() error, err error)
 
+	// StagedPasswordFiles returns the paths of the temporary files
+	// which keep the renewed passwords of the given `roles` until the
+	// finalizer of the RenewPasswords method moves them over the main
+	// passwords files, whether or not they exist right now. Those files
+	// which are left by an interrupted renewal may be removed after
+	// a successful connection with the main passwords. If the renewed
+	// passwords are not kept in files, nil is returned.
+	StagedPasswordFiles(roles ...repo.Role) []string
+
 	// SchemaVersion returns the semantic version of the database schema
 	// which its connection information are kept by this SchemaSettings.
 	SchemaVersion() model.SemVer
@@ -187,18 +219,29 @@ type Settings interface {
 	// adjustment as a warning.
 	MergeSettings(ctx context.Context, s Settings) error
 
-	// Serialize finds out about the mutable settings of this Settings
-	// instance and tries to serialize them as a json string, returning
-	// the resulting byte slice and any possible error. Returned error
-	// (if any) belongs to the json serialization phase.
+	// Components returns the names of all components which keep
+	// their mutable settings in distinct rows of the settings table,
+	// as known by this Settings format version. The returned slice
+	// always includes the model.AppComponent.
+	Components() []model.Component
+
+	// Serialize finds out about the mutable settings of the `c`
+	// component in this Settings instance and tries to serialize them
+	// as a json string, returning the resulting byte slice and any
+	// possible error. Returned error (if any) belongs to the json
+	// serialization phase or indicates that `c` is not a component
+	// of this Settings instance (see the Components method).
 	// It also serializes the minimum and maximum boundary values for
-	// all mutable and immutable settings as two other json strings with
-	// the same format (if a setting has no lower/upper restrictive
-	// value, it will have no corresponding field in the boundary
-	// values version).
+	// all mutable and immutable settings of that component as two
+	// other json strings with the same format (if a setting has no
+	// lower/upper restrictive value, it will have no corresponding
+	// field in the boundary values version).
+	// The declared constraints of those settings (e.g., acceptable
+	// values or patterns) are serialized as the `cons` json string,
+	// or it is nil if that component declares no constraints.
 	// This method helps to decouple the configuration settings format
 	// versions from the database schema format versions.
-	Serialize() (ms, minb, maxb []byte, err error)
+	Serialize(c model.Component) (ms, minb, maxb, cons []byte, err error)
 
 	// Version returns the semantic version of this Settings format.
 	Version() model.SemVer
@@ -236,3 +279,24 @@ func HasTheSameConnectionInfo(s1, s2 SchemaSettings) (bool, error) {
 func AreVersionsCompatible(v1, v2 model.SemVer) bool {
 	return v1[0] == v2[0] && v1[1] >= v2[1]
 }
+
+// persistSettings serializes the mutable settings of all components
+// of the `s` settings (see the Settings.Components method) and uses
+// the `persister` in order to store each one of them in its own row.
+// Rows of other components (which are not known by `s` version) are
+// not touched, so they may be carried by the migration operations.
+func persistSettings(
+	ctx context.Context, s Settings, persister repo.SettingsPersister,
+) error {
+	for _, c := range s.Components() {
+		ms, minb, maxb, cons, err := s.Serialize(c)
+		if err != nil {
+			return fmt.Errorf("serializing %q settings: %w", c, err)
+		}
+		err = persister.PersistSettings(ctx, c, ms, minb, maxb, cons)
+		if err != nil {
+			return fmt.Errorf("persisting %q settings: %w", c, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrationuc
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+)
+
+// These regular expressions match the names of the main schema (see
+// SchemaName), the leftover schema of migrations (see the
+// ForeignSchemaName, MigrationSchemaName, and StagingSchemaName), and
+// foreign servers (see ForeignServerName).
+var (
+	mainSchemaRegexp     = regexp.MustCompile(`^caweb([0-9]+)$`)
+	leftoverSchemaRegexp = regexp.MustCompile(
+		`^(fdw[0-9]+_[0-9]+|(mig|stg)[0-9]+)$`,
+	)
+	foreignServerRegexp = regexp.MustCompile(`^fps[0-9]+_[0-9]+$`)
+)
+
+// SchemaStatus describes one of the cawebN main schema of a database.
+type SchemaStatus struct {
+	// Name is the schema name, i.e., cawebN for the N major version.
+	Name string `json:"name"`
+
+	// Version is the detected database schema version of the tables
+	// which are found in this schema, or nil if it was not detected
+	// (e.g., because the schema is empty). VersionError describes the
+	// reason of a failed detection.
+	Version      *model.SemVer `json:"version,omitempty"`
+	VersionError string        `json:"version_error,omitempty"`
+
+	// SettingsVersion is the format version of the mutable settings
+	// which are stored in the settings table of this schema, or nil if
+	// they could not be read. SettingsError describes the reason.
+	SettingsVersion *model.SemVer `json:"settings_version,omitempty"`
+	SettingsError   string        `json:"settings_error,omitempty"`
+}
+
+// DBStatus describes the state of a database deployment, as reported by
+// the StatusUseCase.Status method.
+type DBStatus struct {
+	// ConfigVersion and ConfigSchemaVersion are the versions of the
+	// configuration file and the database schema which it expects.
+	ConfigVersion       model.SemVer `json:"config_version"`
+	ConfigSchemaVersion model.SemVer `json:"config_schema_version"`
+
+	// Schemas lists the cawebN main schema of the database.
+	Schemas []SchemaStatus `json:"schemas"`
+
+	// LeftoverSchemas lists the fdwX_Y, migN, and stgN schema, which are
+	// dropped by successful migrations, so they are leftovers of
+	// failed migration attempts.
+	LeftoverSchemas []string `json:"leftover_schemas,omitempty"`
+
+	// LeftoverServers lists the fpsX_Y foreign servers, which are
+	// dropped by successful migrations, so they are leftovers of
+	// failed migration attempts.
+	LeftoverServers []string `json:"leftover_servers,omitempty"`
+
+	// Roles lists the database roles having the configured role name
+	// suffix (reported without that suffix).
+	Roles []repo.Role `json:"roles"`
+
+	// Compatible is true if the schema which is expected by the config
+	// file (i.e., cawebN for the N major version of ConfigSchemaVersion)
+	// exists and its detected version is compatible with the config
+	// file schema version, as checked by AreVersionsCompatible.
+	Compatible bool `json:"compatible"`
+}
+
+// StatusUseCase represents the database status introspection use case.
+// It connects to the database which is described by a configuration
+// file and reports its schema, roles, and leftovers of old migrations,
+// without changing the database.
+type StatusUseCase struct {
+	settings   Settings    // settings of the examined database
+	schemaRepo repo.Schema // schema management repo
+}
+
+// NewStatus creates a StatusUseCase instance, using the `ss` settings
+// in order to find the database connection information, the expected
+// database schema version, and the role name suffix (as used by the
+// repo.Schema which is taken from `ss`).
+func NewStatus(ss Settings) *StatusUseCase {
+	return &StatusUseCase{
+		settings:   ss,
+		schemaRepo: ss.NewSchemaRepo(),
+	}
+}
+
+// Status connects to the database using the admin role and reports
+// its cawebN main schema (with their detected versions and the version
+// of their stored settings), the leftover fdwX_Y, migN, and stgN
+// schema and fpsX_Y foreign servers of failed migrations, the roles
+// having the configured role name suffix, and if the config file and
+// database agree on the schema version.
+//
+// Failing to detect the version of a main schema or to read its stored
+// settings is reported in the returned DBStatus (so other schema may be
+// reported), while failing to list the schema, servers, or roles is
+// returned as an error.
+func (suc *StatusUseCase) Status(ctx context.Context) (*DBStatus, error) {
+	st := &DBStatus{
+		ConfigVersion:       suc.settings.Version(),
+		ConfigSchemaVersion: suc.settings.SchemaVersion(),
+	}
+	p, err := suc.settings.ConnectionPool(ctx, repo.AdminRole)
+	if err != nil {
+		return nil, fmt.Errorf("creating DB pool for admin: %w", err)
+	}
+	defer p.Close()
+	err = p.Conn(ctx, func(ctx context.Context, c repo.Conn) error {
+		return suc.fillStatus(ctx, suc.schemaRepo.Conn(c), st)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("admin connection: %w", err)
+	}
+	return st, nil
+}
+
+func (suc *StatusUseCase) fillStatus(
+	ctx context.Context, q repo.SchemaConnQueryer, st *DBStatus,
+) error {
+	names, err := q.ListSchemas(ctx)
+	if err != nil {
+		return fmt.Errorf("listing schema: %w", err)
+	}
+	expected := SchemaName(st.ConfigSchemaVersion[0])
+	for _, name := range names {
+		if leftoverSchemaRegexp.MatchString(name) {
+			st.LeftoverSchemas = append(st.LeftoverSchemas, name)
+			continue
+		}
+		m := mainSchemaRegexp.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		major, err := strconv.ParseUint(m[1], 10, 0)
+		if err != nil {
+			return fmt.Errorf("parsing %q major version: %w", name, err)
+		}
+		ss := schemaStatus(ctx, q, name, uint(major))
+		if name == expected && ss.Version != nil {
+			st.Compatible = AreVersionsCompatible(
+				*ss.Version, st.ConfigSchemaVersion,
+			)
+		}
+		st.Schemas = append(st.Schemas, ss)
+	}
+	servers, err := q.ListServers(ctx)
+	if err != nil {
+		return fmt.Errorf("listing foreign servers: %w", err)
+	}
+	for _, s := range servers {
+		if foreignServerRegexp.MatchString(s) {
+			st.LeftoverServers = append(st.LeftoverServers, s)
+		}
+	}
+	st.Roles, err = q.ListRoles(ctx)
+	if err != nil {
+		return fmt.Errorf("listing roles: %w", err)
+	}
+	return nil
+}
+
+// schemaStatus detects the version of the `name` main schema (having
+// the `major` major version) and reads the version of its settings.
+// The settings are not read if the schema version is not detected,
+// because its settings table may be missing too.
+func schemaStatus(
+	ctx context.Context, q repo.SchemaQueryer, name string, major uint,
+) SchemaStatus {
+	ss := SchemaStatus{Name: name}
+	v, err := q.DetectSchemaVersion(ctx, name, major)
+	if err != nil {
+		ss.VersionError = err.Error()
+		return ss
+	}
+	ss.Version = &v
+	sv, err := q.SettingsVersion(ctx, name, model.AppComponent)
+	if err != nil {
+		ss.SettingsError = err.Error()
+		return ss
+	}
+	ss.SettingsVersion = &sv
+	return ss
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2024 Behnam Momeni
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package migrationuc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/momeni/clean-arch/pkg/core/model"
+	"github.com/momeni/clean-arch/pkg/core/repo"
+)
+
+// ErrVerificationMismatch indicates that the post-migration verification
+// found a settled table which does not match with its source view, so
+// the migration transaction was rolled back.
+var ErrVerificationMismatch = errors.New("migrated data mismatch")
+
+// VerificationReport describes the results of the post-migration data
+// verification, as written by the MigrateDBUseCase.Migrate method when
+// the verification is enabled by the EnableVerification method.
+type VerificationReport struct {
+	// SrcSchemaVersion and DstSchemaVersion are the source and the
+	// destination database schema versions of the verified migration.
+	SrcSchemaVersion model.SemVer `json:"src_schema_version"`
+	DstSchemaVersion model.SemVer `json:"dst_schema_version"`
+
+	// Tables lists the comparison results of the settled tables.
+	Tables []repo.TableVerification `json:"tables"`
+
+	// Matched is true if all tables have matched with their sources.
+	Matched bool `json:"matched"`
+}
+
+// EnableVerification asks the Migrate method to verify the migrated
+// data before committing the destination database transaction and to
+// write the verification report into the `reportPath` file as JSON.
+// For each settled table, the number of rows and an order-independent
+// hash of its contents are compared with its source view (which is
+// obtained by migrating the source database tables), see the
+// repo.SchemaSettler.VerifySchema method. If any table mismatches, the
+// report is written and the transaction is rolled back, returning an
+// error which wraps ErrVerificationMismatch.
+//
+// Verification is only performed when the database contents are
+// migrated, i.e., it is skipped if the source and destination databases
+// are the same or an old migration attempt is resumed.
+// The `mduc` is returned in order to simplify chaining of calls.
+func (mduc *MigrateDBUseCase) EnableVerification(
+	reportPath string,
+) *MigrateDBUseCase {
+	mduc.verifyReportPath = reportPath
+	return mduc
+}
+
+// verifySettledSchema verifies the settled tables using the `ss` schema
+// settler if the verification is enabled, writes the report file, and
+// returns an error wrapping ErrVerificationMismatch if some table did
+// not match with its source.
+func (mduc *MigrateDBUseCase) verifySettledSchema(
+	ctx context.Context, ss repo.SchemaSettler,
+) error {
+	if mduc.verifyReportPath == "" {
+		return nil
+	}
+	var tvs []repo.TableVerification
+	err := mduc.journal.measure("verify", func() (err error) {
+		tvs, err = ss.VerifySchema(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("VerifySchema(): %w", err)
+	}
+	report := VerificationReport{
+		SrcSchemaVersion: mduc.srcSettings.SchemaVersion(),
+		DstSchemaVersion: mduc.dstSettings.SchemaVersion(),
+		Tables:           tvs,
+		Matched:          true,
+	}
+	var mismatched []string
+	for _, tv := range tvs {
+		if !tv.Matched() {
+			report.Matched = false
+			mismatched = append(mismatched, tv.Table)
+		}
+	}
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling verification report: %w", err)
+	}
+	err = os.WriteFile(mduc.verifyReportPath, b, 0o644)
+	if err != nil {
+		return fmt.Errorf(
+			"writing to %q file: %w", mduc.verifyReportPath, err,
+		)
+	}
+	if !report.Matched {
+		return fmt.Errorf(
+			"tables %q (see %q): %w",
+			mismatched, mduc.verifyReportPath, ErrVerificationMismatch,
+		)
+	}
+	return nil
+}